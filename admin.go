@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dominant-strategies/go-quai-stratum/api"
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+
+	"github.com/dominant-strategies/go-quai/log"
+)
+
+// Admin tools, e.g. "go-quai-stratum admin ban -reason abuse 10.0.0.1". They
+// work on the storage backend, so proxies pick up changes to bans on their
+// next policy refresh.
+var adminTools = map[string]func(flags *flag.FlagSet, args []string){
+	"bans":    bansTool,
+	"ban":     banTool,
+	"unban":   unbanTool,
+	"balance": balanceTool,
+}
+
+func adminCommand(args []string) {
+	names := make([]string, 0, len(adminTools))
+	for name := range adminTools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Missing admin tool, available: %v\n", names)
+		os.Exit(2)
+	}
+	tool, ok := adminTools[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown admin tool %q, available: %v\n", args[0], names)
+		os.Exit(2)
+	}
+	flags := flag.NewFlagSet("admin "+args[0], flag.ExitOnError)
+	tool(flags, args[1:])
+}
+
+// adminStorage loads the config and opens its backend, which must be shared
+// with the pool for changes to have any effect.
+func adminStorage(flags *flag.FlagSet, args []string) {
+	configPath := flags.String("config", "config/config.json", "Path to config file")
+	flags.Parse(args)
+
+	loadConfig(*configPath, &cfg)
+	if !cfg.Redis.Enabled {
+		log.Global.Fatal("Admin tools need redis to be enabled")
+	}
+	openBackend()
+	checkSchema()
+}
+
+// adminAudit records a change made from the command line in the audit log
+// of the admin API.
+func adminAudit(actor, action, target, entry, reason string) {
+	host, _ := os.Hostname()
+	record := &storage.AuditEntry{
+		Timestamp:  util.MakeTimestamp(),
+		Actor:      actor,
+		RemoteAddr: host,
+		Action:     action,
+		Target:     target,
+		Entry:      entry,
+		Reason:     reason,
+	}
+	if err := backend.WriteAudit(record, cfg.Api.Admin.AuditLogSize); err != nil {
+		log.Global.WithField("err", err).Error("Failed to write audit log")
+	}
+}
+
+func actorFlag(flags *flag.FlagSet) *string {
+	actor := os.Getenv("USER")
+	if len(actor) == 0 {
+		actor = "cli"
+	}
+	return flags.String("actor", actor, "Name recorded in the audit log")
+}
+
+func adminIP(flags *flag.FlagSet) string {
+	if flags.NArg() != 1 {
+		log.Global.Fatalf("Usage: %s [flags] <ip>", flags.Name())
+	}
+	ip := net.ParseIP(flags.Arg(0))
+	if ip == nil {
+		log.Global.Fatalf("Invalid ip %q", flags.Arg(0))
+	}
+	return ip.String()
+}
+
+func bansTool(flags *flag.FlagSet, args []string) {
+	adminStorage(flags, args)
+	bans, err := backend.GetBans()
+	if err != nil {
+		log.Global.Fatal("Storage error: ", err.Error())
+	}
+	for _, ban := range bans {
+		fmt.Printf("%-40s %-20s banned %s expires %s\n", ban.IP, ban.Reason,
+			time.UnixMilli(ban.BannedAt).UTC().Format(time.RFC3339),
+			time.UnixMilli(ban.ExpiresAt).UTC().Format(time.RFC3339))
+	}
+}
+
+// banTool bans an IP for the banning timeout of the policy, after which the
+// proxies drop it like any other ban.
+func banTool(flags *flag.FlagSet, args []string) {
+	reason := flags.String("reason", "admin", "Reason of the ban")
+	actor := actorFlag(flags)
+	adminStorage(flags, args)
+	ip := adminIP(flags)
+
+	timeout := cfg.Proxy.Policy.Banning.Timeout
+	if timeout <= 0 {
+		log.Global.Fatal("Config error: proxy.policy.banning.timeout must be positive")
+	}
+	now := util.MakeTimestamp()
+	ban := &storage.Ban{IP: ip, Reason: *reason, BannedAt: now, ExpiresAt: now + timeout*1000}
+	if err := backend.WriteBan(ban); err != nil {
+		log.Global.Fatal("Storage error: ", err.Error())
+	}
+	adminAudit(*actor, api.AuditBan, "bans", ip, *reason)
+	log.Global.WithFields(log.Fields{
+		"ip":      ip,
+		"expires": time.UnixMilli(ban.ExpiresAt).UTC().Format(time.RFC3339),
+	}).Info("Banned")
+}
+
+func unbanTool(flags *flag.FlagSet, args []string) {
+	reason := flags.String("reason", "", "Reason recorded in the audit log")
+	actor := actorFlag(flags)
+	adminStorage(flags, args)
+	ip := adminIP(flags)
+
+	removed, err := backend.DeleteBan(ip)
+	if err != nil {
+		log.Global.Fatal("Storage error: ", err.Error())
+	}
+	if !removed {
+		log.Global.Fatalf("No ban of %s", ip)
+	}
+	adminAudit(*actor, api.AuditUnban, "bans", ip, *reason)
+	log.Global.WithField("ip", ip).Info("Ban lifted")
+}
+
+// balanceTool prints the account counters of a miner in Shannon.
+func balanceTool(flags *flag.FlagSet, args []string) {
+	adminStorage(flags, args)
+	if flags.NArg() != 1 {
+		log.Global.Fatal("Usage: admin balance [flags] <login>")
+	}
+	login := strings.ToLower(flags.Arg(0))
+	stats, err := backend.GetMinerStats(login, 1)
+	if err != nil {
+		log.Global.Fatal("Storage error: ", err.Error())
+	}
+	fmt.Printf("balance  %d\nimmature %d\npending  %d\npaid     %d\npayments %d\n",
+		stats.Stats.Balance, stats.Stats.Immature, stats.Stats.Pending, stats.Stats.Paid, stats.PaymentsTotal)
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+type AdminConfig struct {
+	Enabled bool `json:"enabled"`
+	// Sent by clients as "Authorization: Bearer <token>"
+	Token string `json:"token"`
+	// Number of audit log entries kept in the backend
+	AuditLogSize int64 `json:"auditLogSize"`
+}
+
+// Audit log actions
+const (
+	AuditAdd    = "add"
+	AuditRemove = "remove"
+	AuditUnban  = "unban"
+	// Bans are only placed by the policy server and the admin command
+	AuditBan    = "ban"
+	AuditReload = "reload"
+)
+
+const auditPageSize = 100
+
+var addressPattern = regexp.MustCompile("^0x[0-9a-f]{40}$")
+
+type listEntryRequest struct {
+	Entry  string `json:"entry"`
+	Reason string `json:"reason"`
+}
+
+func (s *ApiServer) registerAdminRoutes(r *mux.Router) {
+	admin := r.PathPrefix("/api/admin").Subrouter()
+	admin.Use(util.BearerAuth(s.config.Admin.Token))
+	for _, list := range []string{storage.Blacklist, storage.Whitelist} {
+		admin.HandleFunc("/"+list, s.listEntriesIndex(list)).Methods("GET")
+		admin.HandleFunc("/"+list, s.addListEntry(list)).Methods("POST")
+		admin.HandleFunc("/"+list+"/{entry}", s.removeListEntry(list)).Methods("DELETE")
+	}
+	admin.HandleFunc("/bans", s.BansIndex).Methods("GET")
+	admin.HandleFunc("/bans/{ip}", s.Unban).Methods("DELETE")
+	admin.HandleFunc("/audit", s.AuditIndex).Methods("GET")
+	admin.HandleFunc("/reconciliation", s.ReconciliationIndex).Methods("GET")
+	admin.HandleFunc("/reload", s.ReloadConfig).Methods("POST")
+	log.Printf("Admin API enabled on %v", s.config.Listen)
+}
+
+func (s *ApiServer) listEntriesIndex(list string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var entries []string
+		var err error
+		if list == storage.Blacklist {
+			entries, err = s.backend.WithContext(r.Context()).GetBlacklist()
+		} else {
+			entries, err = s.backend.WithContext(r.Context()).GetWhitelist()
+		}
+		if err != nil {
+			log.Printf("Failed to get %v from backend: %v", list, err)
+			writeJSON(w, http.StatusInternalServerError, &ErrorResponse{Error: "backend error"})
+			return
+		}
+		writeJSON(w, http.StatusOK, &ListEntriesResponse{Entries: entries, Total: len(entries)})
+	}
+}
+
+func (s *ApiServer) addListEntry(list string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req listEntryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, &ErrorResponse{Error: "malformed request"})
+			return
+		}
+		entry, ok := normalizeListEntry(list, req.Entry)
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, &ErrorResponse{Error: "invalid " + list + " entry"})
+			return
+		}
+		added, err := s.backend.WithContext(r.Context()).AddListEntry(list, entry)
+		if err != nil {
+			log.Printf("Failed to add %v to %v: %v", entry, list, err)
+			writeJSON(w, http.StatusInternalServerError, &ErrorResponse{Error: "backend error"})
+			return
+		}
+		if added {
+			s.audit(r, AuditAdd, list, entry, req.Reason)
+		}
+		writeJSON(w, http.StatusOK, &ListChangeResponse{Entry: entry, Changed: added})
+	}
+}
+
+func (s *ApiServer) removeListEntry(list string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry, ok := normalizeListEntry(list, mux.Vars(r)["entry"])
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, &ErrorResponse{Error: "invalid " + list + " entry"})
+			return
+		}
+		removed, err := s.backend.WithContext(r.Context()).RemoveListEntry(list, entry)
+		if err != nil {
+			log.Printf("Failed to remove %v from %v: %v", entry, list, err)
+			writeJSON(w, http.StatusInternalServerError, &ErrorResponse{Error: "backend error"})
+			return
+		}
+		if !removed {
+			writeJSON(w, http.StatusNotFound, &ErrorResponse{Error: "entry not found"})
+			return
+		}
+		s.audit(r, AuditRemove, list, entry, r.URL.Query().Get("reason"))
+		writeJSON(w, http.StatusOK, &ListChangeResponse{Entry: entry, Changed: true})
+	}
+}
+
+// BansIndex lists bans currently enforced by the policy server.
+func (s *ApiServer) BansIndex(w http.ResponseWriter, r *http.Request) {
+	bans, err := s.backend.WithContext(r.Context()).GetBans()
+	if err != nil {
+		log.Printf("Failed to get bans from backend: %v", err)
+		writeJSON(w, http.StatusInternalServerError, &ErrorResponse{Error: "backend error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, &BansResponse{Bans: bans, Total: len(bans)})
+}
+
+// Unban lifts a ban. The policy server picks it up on its next refresh.
+func (s *ApiServer) Unban(w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(mux.Vars(r)["ip"])
+	if ip == nil {
+		writeJSON(w, http.StatusBadRequest, &ErrorResponse{Error: "invalid ip"})
+		return
+	}
+	removed, err := s.backend.WithContext(r.Context()).DeleteBan(ip.String())
+	if err != nil {
+		log.Printf("Failed to unban %v: %v", ip, err)
+		writeJSON(w, http.StatusInternalServerError, &ErrorResponse{Error: "backend error"})
+		return
+	}
+	if !removed {
+		writeJSON(w, http.StatusNotFound, &ErrorResponse{Error: "ban not found"})
+		return
+	}
+	s.audit(r, AuditUnban, "bans", ip.String(), r.URL.Query().Get("reason"))
+	writeJSON(w, http.StatusOK, &UnbanResponse{IP: ip.String(), Changed: true})
+}
+
+func (s *ApiServer) AuditIndex(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64)
+	if err != nil || limit <= 0 {
+		limit = auditPageSize
+	}
+	entries, err := s.backend.WithContext(r.Context()).GetAuditLog(limit)
+	if err != nil {
+		log.Printf("Failed to get audit log from backend: %v", err)
+		writeJSON(w, http.StatusInternalServerError, &ErrorResponse{Error: "backend error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, &AuditResponse{Entries: entries, Total: len(entries)})
+}
+
+// LedgerReporter hands out the result of the last ledger reconciliation,
+// nil until there is one.
+type LedgerReporter interface {
+	Last() *storage.Reconciliation
+}
+
+// SetLedgerReporter publishes the reconciliations of a job running in the
+// same process.
+func (s *ApiServer) SetLedgerReporter(reporter LedgerReporter) {
+	s.ledger = reporter
+}
+
+func (s *ApiServer) ReconciliationIndex(w http.ResponseWriter, r *http.Request) {
+	if s.ledger == nil {
+		writeJSON(w, http.StatusNotFound, &ErrorResponse{Error: "reconciler not running"})
+		return
+	}
+	result := s.ledger.Last()
+	if result == nil {
+		writeJSON(w, http.StatusNotFound, &ErrorResponse{Error: "no reconciliation yet"})
+		return
+	}
+	writeJSON(w, http.StatusOK, &ReconciliationResponse{Reconciliation: *result, OK: result.OK()})
+}
+
+// ConfigReloader reloads the config file of the process. It returns the
+// changed settings which were applied and those needing a restart, or an
+// error when the file can't be read or is invalid.
+type ConfigReloader interface {
+	ReloadConfig() (applied, restartRequired []string, err error)
+}
+
+// SetConfigReloader enables reloads through the admin API.
+func (s *ApiServer) SetConfigReloader(reloader ConfigReloader) {
+	s.reloader = reloader
+}
+
+func (s *ApiServer) ReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if s.reloader == nil {
+		writeJSON(w, http.StatusNotFound, &ErrorResponse{Error: "reload not available"})
+		return
+	}
+	applied, restartRequired, err := s.reloader.ReloadConfig()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &ErrorResponse{Error: err.Error()})
+		return
+	}
+	s.audit(r, AuditReload, "config", strings.Join(applied, ","), r.URL.Query().Get("reason"))
+	writeJSON(w, http.StatusOK, &ReloadResponse{Applied: applied, RestartRequired: restartRequired})
+}
+
+// audit records a change, the actor is taken from the X-Admin-User header.
+func (s *ApiServer) audit(r *http.Request, action, target, entry, reason string) {
+	actor := r.Header.Get("X-Admin-User")
+	if len(actor) == 0 {
+		actor = "admin"
+	}
+	remoteAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteAddr = r.RemoteAddr
+	}
+	record := &storage.AuditEntry{
+		Timestamp:  util.MakeTimestamp(),
+		Actor:      actor,
+		RemoteAddr: remoteAddr,
+		Action:     action,
+		Target:     target,
+		Entry:      entry,
+		Reason:     reason,
+	}
+	log.Printf("Admin %v from %v: %v %v %v", actor, remoteAddr, action, target, entry)
+	// Not bound to the request, the change is made already
+	if err := s.backend.WriteAudit(record, s.config.Admin.AuditLogSize); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
+
+// normalizeListEntry validates entry against the list it goes to: the
+// blacklist holds miner addresses and the whitelist holds IPs.
+func normalizeListEntry(list, entry string) (string, bool) {
+	entry = strings.TrimSpace(entry)
+	if list == storage.Blacklist {
+		entry = strings.ToLower(entry)
+		return entry, addressPattern.MatchString(entry)
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return "", false
+	}
+	return ip.String(), true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+)
+
+func newAdminTestServer(t *testing.T) (*ApiServer, http.Handler) {
+	backend := storage.NewMemoryBackend()
+
+	s := &ApiServer{
+		config:  &ApiConfig{Admin: AdminConfig{Enabled: true, Token: "secret", AuditLogSize: 100}},
+		backend: backend,
+	}
+	r := mux.NewRouter()
+	s.registerAdminRoutes(r)
+	return s, r
+}
+
+func adminRequest(h http.Handler, method, url, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Admin-User", "ops")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminAuth(t *testing.T) {
+	_, h := newAdminTestServer(t)
+
+	req := httptest.NewRequest("GET", "/api/admin/blacklist", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Must reject missing token, got %v", w.Code)
+	}
+}
+
+func TestAdminListEntries(t *testing.T) {
+	s, h := newAdminTestServer(t)
+
+	w := adminRequest(h, "POST", "/api/admin/blacklist", `{"entry": "0xABCDEF0123456789abcdef0123456789ABCDEF01", "reason": "abuse"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Must add blacklist entry, got %v", w.Code)
+	}
+	blacklist, _ := s.backend.GetBlacklist()
+	if len(blacklist) != 1 || blacklist[0] != "0xabcdef0123456789abcdef0123456789abcdef01" {
+		t.Errorf("Must store lowercased address: %v", blacklist)
+	}
+	if w := adminRequest(h, "POST", "/api/admin/blacklist", `{"entry": "10.0.0.1"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Must reject IP on blacklist, got %v", w.Code)
+	}
+	if w := adminRequest(h, "POST", "/api/admin/whitelist", `{"entry": "10.0.0.1"}`); w.Code != http.StatusOK {
+		t.Errorf("Must add whitelist entry, got %v", w.Code)
+	}
+	if w := adminRequest(h, "DELETE", "/api/admin/whitelist/10.0.0.1", ""); w.Code != http.StatusOK {
+		t.Errorf("Must remove whitelist entry, got %v", w.Code)
+	}
+	if w := adminRequest(h, "DELETE", "/api/admin/whitelist/10.0.0.1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Must return 404 for missing entry, got %v", w.Code)
+	}
+
+	var reply struct {
+		Entries []*storage.AuditEntry `json:"entries"`
+	}
+	json.NewDecoder(adminRequest(h, "GET", "/api/admin/audit", "").Body).Decode(&reply)
+	if len(reply.Entries) != 3 {
+		t.Fatalf("Must audit every change: %+v", reply.Entries)
+	}
+	last := reply.Entries[2]
+	if last.Actor != "ops" || last.Action != AuditAdd || last.Target != storage.Blacklist || last.Reason != "abuse" {
+		t.Errorf("Invalid audit entry: %+v", last)
+	}
+}
+
+func TestAdminUnban(t *testing.T) {
+	s, h := newAdminTestServer(t)
+
+	s.backend.WriteBan(&storage.Ban{IP: "10.0.0.1", Reason: "malformed", BannedAt: 1})
+	if w := adminRequest(h, "DELETE", "/api/admin/bans/10.0.0.1", ""); w.Code != http.StatusOK {
+		t.Fatalf("Must unban, got %v", w.Code)
+	}
+	bans, _ := s.backend.GetBans()
+	if len(bans) != 0 {
+		t.Errorf("Must remove ban: %+v", bans)
+	}
+	entries, _ := s.backend.GetAuditLog(10)
+	if len(entries) != 1 || entries[0].Action != AuditUnban {
+		t.Errorf("Must audit unban: %+v", entries)
+	}
+}
+
+func TestAdminReconciliation(t *testing.T) {
+	s, h := newAdminTestServer(t)
+	if w := adminRequest(h, "GET", "/api/admin/reconciliation", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Must return 404 without reconciler, got %v", w.Code)
+	}
+	ledger := &staticLedger{}
+	s.SetLedgerReporter(ledger)
+	if w := adminRequest(h, "GET", "/api/admin/reconciliation", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Must return 404 before the first run, got %v", w.Code)
+	}
+
+	ledger.result = (&storage.Ledger{Finances: storage.LedgerTotals{Balance: 10}}).Reconcile(100, 0)
+	var reply struct {
+		OK     bool                   `json:"ok"`
+		Checks []*storage.LedgerCheck `json:"checks"`
+	}
+	w := adminRequest(h, "GET", "/api/admin/reconciliation", "")
+	json.NewDecoder(w.Body).Decode(&reply)
+	if w.Code != http.StatusOK || reply.OK || len(reply.Checks) == 0 || reply.Checks[0].Drift() != 10 {
+		t.Errorf("Must report balance drift: %v %+v", w.Code, reply)
+	}
+}
+
+func TestAdminReload(t *testing.T) {
+	s, h := newAdminTestServer(t)
+	if w := adminRequest(h, "POST", "/api/admin/reload", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Must return 404 without reloader, got %v", w.Code)
+	}
+	reloader := &staticReloader{err: errors.New("api.listen: must be set")}
+	s.SetConfigReloader(reloader)
+	if w := adminRequest(h, "POST", "/api/admin/reload", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Must reject invalid config, got %v", w.Code)
+	}
+
+	reloader.applied, reloader.err = []string{"api.hashrateWindow", "api.luckWindow"}, nil
+	var reply ReloadResponse
+	w := adminRequest(h, "POST", "/api/admin/reload?reason=tuning", "")
+	json.NewDecoder(w.Body).Decode(&reply)
+	if w.Code != http.StatusOK || len(reply.Applied) != 2 {
+		t.Errorf("Must report applied settings: %v %+v", w.Code, reply)
+	}
+	entries, _ := s.backend.GetAuditLog(10)
+	if len(entries) != 1 || entries[0].Action != AuditReload || entries[0].Reason != "tuning" {
+		t.Errorf("Must audit reload: %+v", entries)
+	}
+}
@@ -0,0 +1,435 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// apiParam is a path or query parameter of an operation.
+type apiParam struct {
+	Name        string
+	In          string
+	Type        string
+	Pattern     string
+	Description string
+}
+
+// apiResponse is a documented reply. Body is a zero value of the type sent,
+// nil for replies without a body. Alternatives lists further bodies sent
+// under the same status, such as paged replies.
+type apiResponse struct {
+	Description  string
+	Body         interface{}
+	Alternatives []interface{}
+	// Additional media types served instead of JSON
+	Media []string
+}
+
+type apiOperation struct {
+	Method    string
+	Path      string
+	Summary   string
+	Params    []apiParam
+	Responses map[int]apiResponse
+	Admin     bool
+}
+
+var (
+	loginParam  = apiParam{Name: "login", In: "path", Type: "string", Pattern: "^0x[0-9a-fA-F]{40}$", Description: "Miner address"}
+	workerParam = apiParam{Name: "worker", In: "path", Type: "string", Pattern: "^[0-9a-zA-Z-_]{1,8}$", Description: "Worker name"}
+	pageParams  = []apiParam{
+		{Name: "cursor", In: "query", Type: "string", Description: "nextCursor of the previous page"},
+		{Name: "limit", In: "query", Type: "integer", Description: "Page size, 1 to 1000, defaults to 50"},
+		{Name: "from", In: "query", Type: "integer", Description: "Inclusive lower bound, unix seconds"},
+		{Name: "to", In: "query", Type: "integer", Description: "Inclusive upper bound, unix seconds"},
+	}
+	errorReply = func(description string) apiResponse {
+		return apiResponse{Description: description, Body: ErrorResponse{}}
+	}
+)
+
+func withParams(params []apiParam, extra ...apiParam) []apiParam {
+	return append(append([]apiParam{}, params...), extra...)
+}
+
+// apiOperations documents every route served by the API. Routes and this
+// table are checked against each other by the tests.
+var apiOperations = []apiOperation{
+	{
+		Method: "GET", Path: "/api/stats", Summary: "Pool and node stats",
+		Responses: map[int]apiResponse{200: {Description: "Pool stats", Body: StatsResponse{}}},
+	},
+	{
+		Method: "GET", Path: "/api/miners", Summary: "Miners active within the hashrate window",
+		Responses: map[int]apiResponse{200: {Description: "Active miners", Body: MinersResponse{}}},
+	},
+	{
+		Method: "GET", Path: "/api/blocks", Summary: "Recent blocks, paged when any page parameter is given",
+		Params: withParams(pageParams, apiParam{Name: "status", In: "query", Type: "string", Description: "candidates, immature or matured, defaults to matured"}),
+		Responses: map[int]apiResponse{
+			200: {Description: "Recent blocks or a page of blocks", Body: BlocksResponse{}, Alternatives: []interface{}{BlocksPageResponse{}}},
+			400: errorReply("Invalid page parameters"),
+			500: errorReply("Backend error"),
+		},
+	},
+	{
+		Method: "GET", Path: "/api/payments", Summary: "Recent payments, paged when any page parameter is given",
+		Params: pageParams,
+		Responses: map[int]apiResponse{
+			200: {Description: "Recent payments or a page of payments", Body: PaymentsResponse{}, Alternatives: []interface{}{PaymentsPageResponse{}}},
+			400: errorReply("Invalid page parameters"),
+			500: errorReply("Backend error"),
+		},
+	},
+	{
+		Method: "GET", Path: "/api/settings", Summary: "Public pool settings",
+		Responses: map[int]apiResponse{200: {Description: "Pool settings", Body: Settings{}}},
+	},
+	{
+		Method: "GET", Path: "/api/openapi.json", Summary: "This document",
+		Responses: map[int]apiResponse{200: {Description: "OpenAPI document"}},
+	},
+	{
+		Method: "GET", Path: "/api/accounts/{login}", Summary: "Account stats and workers",
+		Params: []apiParam{loginParam},
+		Responses: map[int]apiResponse{
+			200: {Description: "Account stats", Body: AccountResponse{}},
+			404: {Description: "Unknown miner"},
+			500: {Description: "Backend error"},
+		},
+	},
+	{
+		Method: "GET", Path: "/api/accounts/{login}/payments", Summary: "Page of payments sent to an account",
+		Params: withParams([]apiParam{loginParam}, pageParams...),
+		Responses: map[int]apiResponse{
+			200: {Description: "Page of payments", Body: PaymentsPageResponse{}},
+			400: errorReply("Invalid page parameters"),
+			500: errorReply("Backend error"),
+		},
+	},
+	{
+		Method: "GET", Path: "/api/accounts/{login}/credits", Summary: "Page of block rewards and fees credited to an account",
+		Params: withParams([]apiParam{loginParam}, pageParams...),
+		Responses: map[int]apiResponse{
+			200: {Description: "Page of credits", Body: CreditsPageResponse{}},
+			400: errorReply("Invalid page parameters"),
+			500: errorReply("Backend error"),
+		},
+	},
+	{
+		Method: "GET", Path: "/api/accounts/{login}/statement", Summary: "Earnings statement of an account",
+		Params: []apiParam{
+			loginParam,
+			{Name: "from", In: "query", Type: "integer", Description: "Inclusive lower bound, unix seconds"},
+			{Name: "to", In: "query", Type: "integer", Description: "Inclusive upper bound, unix seconds"},
+			{Name: "format", In: "query", Type: "string", Description: "json or csv, defaults to json"},
+		},
+		Responses: map[int]apiResponse{
+			200: {Description: "Statement, streamed", Body: StatementResponse{}, Media: []string{"text/csv"}},
+			400: errorReply("Invalid parameters"),
+		},
+	},
+	{
+		Method: "GET", Path: "/api/accounts/{login}/workers/{worker}", Summary: "Worker stats",
+		Params: []apiParam{loginParam, workerParam},
+		Responses: map[int]apiResponse{
+			200: {Description: "Worker stats", Body: WorkerResponse{}},
+			404: {Description: "Unknown worker"},
+			500: {Description: "Backend error"},
+		},
+	},
+	{
+		Method: "GET", Path: "/api/ws", Summary: "WebSocket push of stats, blocks and account updates, when enabled",
+		Responses: map[int]apiResponse{101: {Description: "Switching to the WebSocket protocol"}},
+	},
+	{
+		Method: "GET", Path: "/api/admin/blacklist", Summary: "Blacklisted miner addresses", Admin: true,
+		Responses: map[int]apiResponse{200: {Description: "Blacklist", Body: ListEntriesResponse{}}, 500: errorReply("Backend error")},
+	},
+	{
+		Method: "POST", Path: "/api/admin/blacklist", Summary: "Blacklist a miner address", Admin: true,
+		Responses: map[int]apiResponse{200: {Description: "Entry and whether it was added", Body: ListChangeResponse{}}, 400: errorReply("Invalid entry"), 500: errorReply("Backend error")},
+	},
+	{
+		Method: "DELETE", Path: "/api/admin/blacklist/{entry}", Summary: "Remove a miner address from the blacklist", Admin: true,
+		Params:    []apiParam{{Name: "entry", In: "path", Type: "string", Description: "Miner address"}, {Name: "reason", In: "query", Type: "string", Description: "Recorded in the audit log"}},
+		Responses: map[int]apiResponse{200: {Description: "Removed entry", Body: ListChangeResponse{}}, 400: errorReply("Invalid entry"), 404: errorReply("Entry not found"), 500: errorReply("Backend error")},
+	},
+	{
+		Method: "GET", Path: "/api/admin/whitelist", Summary: "Whitelisted IPs", Admin: true,
+		Responses: map[int]apiResponse{200: {Description: "Whitelist", Body: ListEntriesResponse{}}, 500: errorReply("Backend error")},
+	},
+	{
+		Method: "POST", Path: "/api/admin/whitelist", Summary: "Whitelist an IP", Admin: true,
+		Responses: map[int]apiResponse{200: {Description: "Entry and whether it was added", Body: ListChangeResponse{}}, 400: errorReply("Invalid entry"), 500: errorReply("Backend error")},
+	},
+	{
+		Method: "DELETE", Path: "/api/admin/whitelist/{entry}", Summary: "Remove an IP from the whitelist", Admin: true,
+		Params:    []apiParam{{Name: "entry", In: "path", Type: "string", Description: "IP address"}, {Name: "reason", In: "query", Type: "string", Description: "Recorded in the audit log"}},
+		Responses: map[int]apiResponse{200: {Description: "Removed entry", Body: ListChangeResponse{}}, 400: errorReply("Invalid entry"), 404: errorReply("Entry not found"), 500: errorReply("Backend error")},
+	},
+	{
+		Method: "GET", Path: "/api/admin/bans", Summary: "Bans enforced by the policy server", Admin: true,
+		Responses: map[int]apiResponse{200: {Description: "Active bans", Body: BansResponse{}}, 500: errorReply("Backend error")},
+	},
+	{
+		Method: "DELETE", Path: "/api/admin/bans/{ip}", Summary: "Lift a ban", Admin: true,
+		Params:    []apiParam{{Name: "ip", In: "path", Type: "string", Description: "Banned IP"}, {Name: "reason", In: "query", Type: "string", Description: "Recorded in the audit log"}},
+		Responses: map[int]apiResponse{200: {Description: "Lifted ban", Body: UnbanResponse{}}, 400: errorReply("Invalid IP"), 404: errorReply("Ban not found"), 500: errorReply("Backend error")},
+	},
+	{
+		Method: "GET", Path: "/api/admin/audit", Summary: "Newest admin audit log entries", Admin: true,
+		Params:    []apiParam{{Name: "limit", In: "query", Type: "integer", Description: "Number of entries, defaults to 100"}},
+		Responses: map[int]apiResponse{200: {Description: "Audit log", Body: AuditResponse{}}, 500: errorReply("Backend error")},
+	},
+	{
+		Method: "GET", Path: "/api/admin/reconciliation", Summary: "Result of the last ledger reconciliation", Admin: true,
+		Responses: map[int]apiResponse{200: {Description: "Checks and flagged records", Body: ReconciliationResponse{}}, 404: errorReply("Reconciler not running or not done yet")},
+	},
+	{
+		Method: "POST", Path: "/api/admin/reload", Summary: "Reload the config file of the API process", Admin: true,
+		Params:    []apiParam{{Name: "reason", In: "query", Type: "string", Description: "Recorded in the audit log"}},
+		Responses: map[int]apiResponse{200: {Description: "Changed settings", Body: ReloadResponse{}}, 400: errorReply("Config can't be read or is invalid"), 404: errorReply("Reload not available")},
+	},
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIData []byte
+)
+
+// OpenAPIIndex serves the OpenAPI document generated from apiOperations
+// and the response types.
+func (s *ApiServer) OpenAPIIndex(w http.ResponseWriter, r *http.Request) {
+	openAPIOnce.Do(func() {
+		var err error
+		openAPIData, err = json.Marshal(buildOpenAPI(apiOperations))
+		if err != nil {
+			log.Fatalf("Failed to build OpenAPI document: %v", err)
+		}
+	})
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "max-age=600")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPIData)
+}
+
+func buildOpenAPI(ops []apiOperation) map[string]interface{} {
+	gen := &schemaGen{schemas: make(map[string]interface{}), names: make(map[reflect.Type]string)}
+	paths := make(map[string]interface{})
+	for _, op := range ops {
+		item, ok := paths[op.Path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = gen.operation(&op)
+	}
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":   "go-quai-stratum pool API",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": gen.schemas,
+			"securitySchemes": map[string]interface{}{
+				"adminToken": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// schemaGen derives JSON schemas from Go types the way encoding/json
+// serializes them. Named structs become shared components.
+type schemaGen struct {
+	schemas map[string]interface{}
+	names   map[reflect.Type]string
+}
+
+func (g *schemaGen) operation(op *apiOperation) map[string]interface{} {
+	result := map[string]interface{}{"summary": op.Summary}
+	var params []interface{}
+	for _, p := range op.Params {
+		schema := map[string]interface{}{"type": p.Type}
+		if len(p.Pattern) > 0 {
+			schema["pattern"] = p.Pattern
+		}
+		params = append(params, map[string]interface{}{
+			"name":        p.Name,
+			"in":          p.In,
+			"required":    p.In == "path",
+			"description": p.Description,
+			"schema":      schema,
+		})
+	}
+	if len(params) > 0 {
+		result["parameters"] = params
+	}
+	if op.Method == "POST" {
+		result["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(listEntryRequest{}))},
+			},
+		}
+	}
+	replies := make(map[int]apiResponse, len(op.Responses)+1)
+	for status, resp := range op.Responses {
+		replies[status] = resp
+	}
+	if op.Admin {
+		result["security"] = []interface{}{map[string]interface{}{"adminToken": []string{}}}
+		replies[http.StatusUnauthorized] = errorReply("Missing or wrong admin token")
+	}
+
+	responses := make(map[string]interface{})
+	for status, resp := range replies {
+		reply := map[string]interface{}{"description": resp.Description}
+		if resp.Body != nil {
+			schema := g.schema(reflect.TypeOf(resp.Body))
+			if len(resp.Alternatives) > 0 {
+				alternatives := []interface{}{schema}
+				for _, body := range resp.Alternatives {
+					alternatives = append(alternatives, g.schema(reflect.TypeOf(body)))
+				}
+				schema = map[string]interface{}{"anyOf": alternatives}
+			}
+			content := map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+			for _, media := range resp.Media {
+				content[media] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+			}
+			reply["content"] = content
+		}
+		responses[strconv.Itoa(status)] = reply
+	}
+	result["responses"] = responses
+	return result
+}
+
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return map[string]interface{}{"anyOf": []interface{}{g.schema(t.Elem()), map[string]interface{}{"type": "null"}}}
+	case reflect.Struct:
+		return g.ref(t)
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": []string{"array", "null"}, "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": []string{"object", "null"}, "additionalProperties": g.schema(t.Elem())}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	}
+	// Interfaces may hold anything
+	return map[string]interface{}{}
+}
+
+// ref registers the component of a struct and refers to it. Names are
+// qualified by package only when two packages use the same one.
+func (g *schemaGen) ref(t reflect.Type) map[string]interface{} {
+	name, ok := g.names[t]
+	if !ok {
+		name = t.Name()
+		for _, taken := range g.names {
+			if taken == name {
+				pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+				name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+				break
+			}
+		}
+		g.names[t] = name
+		// Registered before descending so recursive types terminate
+		g.schemas[name] = nil
+		properties := make(map[string]interface{})
+		var required []string
+		g.fields(t, properties, &required)
+		sort.Strings(required)
+		object := map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+		if len(required) > 0 {
+			object["required"] = required
+		}
+		g.schemas[name] = object
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// fields collects the serialized fields of t, flattening embedded structs.
+func (g *schemaGen) fields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+		if field.Anonymous && len(name) == 0 && field.Type.Kind() == reflect.Struct {
+			g.fields(field.Type, properties, required)
+			continue
+		}
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// openAPIPath turns a mux path template into an OpenAPI path by dropping
+// the patterns of its variables.
+func openAPIPath(template string) string {
+	var b strings.Builder
+	depth := 0
+	skip := false
+	for _, c := range template {
+		switch {
+		case c == '{':
+			depth++
+			if depth == 1 {
+				b.WriteRune(c)
+				continue
+			}
+		case c == '}':
+			depth--
+			if depth == 0 {
+				skip = false
+				b.WriteRune(c)
+				continue
+			}
+		case c == ':' && depth == 1:
+			skip = true
+			continue
+		}
+		if !skip {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
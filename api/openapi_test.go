@@ -0,0 +1,322 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+const contractLogin = "0x00000000000000000000000000000000000000bb"
+
+func loadOpenAPI(t *testing.T) map[string]interface{} {
+	data, err := json.Marshal(buildOpenAPI(apiOperations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func newContractTestServer(t *testing.T) *mux.Router {
+	backend := storage.NewMemoryBackend()
+
+	backend.WriteNodeState("main", 1010, big.NewInt(1000), 12.5)
+	backend.WriteShare(contractLogin, "rig", []string{"0x1", "0xa"}, 10, 1008, time.Minute)
+	backend.WriteBlock(contractLogin, "rig", []string{"0x2", "0xb", "1"}, 20, 500, 1009, time.Minute)
+	backend.WriteWorkerShares([]*storage.WorkerShares{{Login: contractLogin, Worker: "rig", Valid: 1, LastShare: util.MakeTimestamp() / 1000}})
+	block := &storage.BlockData{Height: 1000, RoundHeight: 1000, Hash: "0x0", Nonce: "0x1", Reward: util.String2Big("2000000000000000000")}
+	backend.WriteImmatureBlock(block, map[string]int64{contractLogin: 1500000000})
+	backend.WriteMaturedBlock(block, map[string]int64{contractLogin: 1500000000}, nil)
+	backend.WritePayment(contractLogin, "0xtx", 1000000000)
+	backend.WriteBan(&storage.Ban{IP: "10.0.0.1", Reason: "test", BannedAt: 1, ExpiresAt: util.MakeTimestamp() + 60000})
+
+	cfg := &ApiConfig{
+		HashrateWindow:      "30m",
+		HashrateLargeWindow: "3h",
+		LuckWindow:          []int{64},
+		Payments:            30,
+		Blocks:              50,
+		PoolChartsNum:       10,
+		MinerChartsNum:      10,
+		Admin:               AdminConfig{Enabled: true, Token: "secret", AuditLogSize: 100},
+		Websocket:           WebsocketConfig{Enabled: true},
+	}
+	s := NewApiServer(cfg, &Settings{Difficulty: "0x10", RewardScheme: "prop", PoolFee: 1}, backend)
+	s.collectStats()
+	ledger, err := backend.GetLedger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetLedgerReporter(&staticLedger{ledger.Reconcile(util.MakeTimestamp()/1000, 0)})
+	s.SetConfigReloader(&staticReloader{applied: []string{"api.hashrateWindow"}})
+	return s.router()
+}
+
+type staticLedger struct {
+	result *storage.Reconciliation
+}
+
+func (l *staticLedger) Last() *storage.Reconciliation {
+	return l.result
+}
+
+type staticReloader struct {
+	applied []string
+	err     error
+}
+
+func (r *staticReloader) ReloadConfig() ([]string, []string, error) {
+	return r.applied, []string{}, r.err
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	paths := doc["paths"].(map[string]interface{})
+
+	served := make(map[string]bool)
+	err := newContractTestServer(t).Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{"GET"}
+		}
+		for _, method := range methods {
+			path := openAPIPath(template)
+			served[method+" "+path] = true
+			item, _ := paths[path].(map[string]interface{})
+			if _, ok := item[strings.ToLower(method)]; !ok {
+				t.Errorf("Route %v %v is not documented", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range apiOperations {
+		if !served[op.Method+" "+op.Path] {
+			t.Errorf("Documented operation %v %v is not served", op.Method, op.Path)
+		}
+	}
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	got := openAPIPath("/api/accounts/{login:0x[0-9a-fA-F]{40}}/workers/{worker:[0-9a-zA-Z-_]{1,8}}")
+	if got != "/api/accounts/{login}/workers/{worker}" {
+		t.Errorf("Invalid path %v", got)
+	}
+}
+
+func TestOpenAPIContract(t *testing.T) {
+	doc := loadOpenAPI(t)
+	router := newContractTestServer(t)
+	account := "/api/accounts/" + contractLogin
+	unknown := "/api/accounts/0x00000000000000000000000000000000000000cc"
+
+	requests := []struct {
+		method, url, body string
+		status            int
+	}{
+		{"GET", "/api/stats", "", 200},
+		{"GET", "/api/miners", "", 200},
+		{"GET", "/api/blocks", "", 200},
+		{"GET", "/api/blocks?status=immature&limit=1", "", 200},
+		{"GET", "/api/blocks?limit=0", "", 400},
+		{"GET", "/api/payments", "", 200},
+		{"GET", "/api/payments?limit=1", "", 200},
+		{"GET", "/api/settings", "", 200},
+		{"GET", "/api/openapi.json", "", 200},
+		{"GET", account, "", 200},
+		{"GET", unknown, "", 404},
+		{"GET", account + "/payments", "", 200},
+		{"GET", account + "/credits?from=1", "", 200},
+		{"GET", account + "/statement", "", 200},
+		{"GET", account + "/statement?format=xml", "", 400},
+		{"GET", account + "/workers/rig", "", 200},
+		{"GET", account + "/workers/none", "", 404},
+		{"POST", "/api/admin/blacklist", `{"entry":"` + contractLogin + `"}`, 200},
+		{"POST", "/api/admin/blacklist", `{"entry":"nope"}`, 400},
+		{"GET", "/api/admin/blacklist", "", 200},
+		{"DELETE", "/api/admin/blacklist/" + contractLogin, "", 200},
+		{"DELETE", "/api/admin/whitelist/10.0.0.9", "", 404},
+		{"GET", "/api/admin/whitelist", "", 200},
+		{"GET", "/api/admin/bans", "", 200},
+		{"DELETE", "/api/admin/bans/10.0.0.1", "", 200},
+		{"GET", "/api/admin/audit", "", 200},
+		{"GET", "/api/admin/reconciliation", "", 200},
+		{"POST", "/api/admin/reload", "", 200},
+	}
+	for _, req := range requests {
+		r := httptest.NewRequest(req.method, req.url, strings.NewReader(req.body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != req.status {
+			t.Errorf("%v %v: expected status %v, got %v", req.method, req.url, req.status, w.Code)
+			continue
+		}
+		if err := checkContract(doc, router, r, w); err != nil {
+			t.Errorf("%v %v: %v", req.method, req.url, err)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/api/admin/bans", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if err := checkContract(doc, router, r, w); w.Code != 401 || err != nil {
+		t.Errorf("Unauthorized reply must match the document: %v %v", w.Code, err)
+	}
+}
+
+// checkContract validates a reply against the documented responses of the
+// operation serving it.
+func checkContract(doc map[string]interface{}, router *mux.Router, r *http.Request, w *httptest.ResponseRecorder) error {
+	var match mux.RouteMatch
+	if !router.Match(r, &match) {
+		return fmt.Errorf("no route")
+	}
+	template, _ := match.Route.GetPathTemplate()
+	item, _ := doc["paths"].(map[string]interface{})[openAPIPath(template)].(map[string]interface{})
+	op, ok := item[strings.ToLower(r.Method)].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("operation is not documented")
+	}
+	reply, ok := op["responses"].(map[string]interface{})[strconv.Itoa(w.Code)].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("status %v is not documented", w.Code)
+	}
+	var body interface{}
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			return err
+		}
+	}
+	content, ok := reply["content"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if w.Body.Len() == 0 {
+		return fmt.Errorf("documented body is missing")
+	}
+	schema := content["application/json"].(map[string]interface{})["schema"]
+	components := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	return validateSchema(components, schema, body, "$")
+}
+
+// validateSchema checks value against the subset of JSON schema emitted by
+// the generator.
+func validateSchema(components map[string]interface{}, schema, value interface{}, path string) error {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%v: invalid schema %v", path, schema)
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		return validateSchema(components, components[strings.TrimPrefix(ref, "#/components/schemas/")], value, path)
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok {
+		var errs []string
+		for _, alternative := range anyOf {
+			err := validateSchema(components, alternative, value, path)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return fmt.Errorf("no alternative matches: %v", strings.Join(errs, "; "))
+	}
+	if t, ok := s["type"]; ok {
+		types, ok := t.([]interface{})
+		if !ok {
+			types = []interface{}{t}
+		}
+		matched := false
+		for _, t := range types {
+			if jsonType(t.(string), value) {
+				matched = true
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%v: expected %v, got %T", path, t, value)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := s["properties"].(map[string]interface{})
+		required, _ := s["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok {
+				return fmt.Errorf("%v: missing %v", path, name)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			property, ok := properties[key]
+			if !ok {
+				property = s["additionalProperties"]
+			}
+			if property == false {
+				return fmt.Errorf("%v: unexpected property %v", path, key)
+			}
+			if property == nil || property == true {
+				continue
+			}
+			if err := validateSchema(components, property, v[key], path+"."+key); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if items, ok := s["items"]; ok {
+			for i, item := range v {
+				if err := validateSchema(components, items, item, fmt.Sprintf("%v[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func jsonType(t string, value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case float64:
+		return t == "number" || (t == "integer" && v == math.Trunc(v))
+	case []interface{}:
+		return t == "array"
+	case map[string]interface{}:
+		return t == "object"
+	}
+	return false
+}
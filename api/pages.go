@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+var (
+	errInvalidLimit  = errors.New("limit must be between 1 and 1000")
+	errInvalidRange  = errors.New("from and to must be unix timestamps with from <= to")
+	errInvalidStatus = errors.New("status must be one of candidates, immature or matured")
+)
+
+// isPageRequest tells paged queries from requests for the cached snapshot.
+func isPageRequest(r *http.Request) bool {
+	query := r.URL.Query()
+	for _, param := range []string{"cursor", "limit", "from", "to", "status"} {
+		if _, ok := query[param]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePageQuery(r *http.Request) (*storage.PageQuery, error) {
+	query := r.URL.Query()
+	page := &storage.PageQuery{Cursor: query.Get("cursor"), Limit: defaultPageSize}
+
+	var err error
+	if limit := query.Get("limit"); len(limit) > 0 {
+		page.Limit, err = strconv.ParseInt(limit, 10, 64)
+		if err != nil || page.Limit < 1 || page.Limit > maxPageSize {
+			return nil, errInvalidLimit
+		}
+	}
+	if from := query.Get("from"); len(from) > 0 {
+		if page.From, err = strconv.ParseInt(from, 10, 64); err != nil || page.From < 0 {
+			return nil, errInvalidRange
+		}
+	}
+	if to := query.Get("to"); len(to) > 0 {
+		if page.To, err = strconv.ParseInt(to, 10, 64); err != nil || page.To < 0 {
+			return nil, errInvalidRange
+		}
+	}
+	if page.To > 0 && page.From > page.To {
+		return nil, errInvalidRange
+	}
+	return page, nil
+}
+
+func writePage(w http.ResponseWriter, reply interface{}) {
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(reply)
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
+
+func writePageError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch err {
+	case errInvalidLimit, errInvalidRange, errInvalidStatus, storage.ErrInvalidCursor:
+	default:
+		log.Printf("Failed to fetch page from backend: %v", err)
+		status = http.StatusInternalServerError
+		err = errors.New("backend error")
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&ErrorResponse{Error: err.Error()})
+}
@@ -15,68 +15,109 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/robfig/cron"
 
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
 	"github.com/dominant-strategies/go-quai-stratum/rpc"
 	"github.com/dominant-strategies/go-quai-stratum/storage"
 	"github.com/dominant-strategies/go-quai-stratum/util"
 )
 
 type ApiConfig struct {
-	Enabled              bool   `json:"enabled"`
-	Listen               string `json:"listen"`
-	PoolCharts           string `json:"poolCharts"`
-	PoolChartsNum        int64  `json:"poolChartsNum"`
-	MinerChartsNum       int64  `json:"minerChartsNum"`
-	MinerCharts          string `json:"minerCharts"`
-	StatsCollectInterval string `json:"statsCollectInterval"`
-	HashrateWindow       string `json:"hashrateWindow"`
-	HashrateLargeWindow  string `json:"hashrateLargeWindow"`
-	LuckWindow           []int  `json:"luckWindow"`
-	Payments             int64  `json:"payments"`
-	Blocks               int64  `json:"blocks"`
-	PurgeOnly            bool   `json:"purgeOnly"`
-	PurgeInterval        string `json:"purgeInterval"`
+	Enabled              bool            `json:"enabled"`
+	Listen               string          `json:"listen"`
+	PoolCharts           string          `json:"poolCharts"`
+	PoolChartsNum        int64           `json:"poolChartsNum"`
+	MinerChartsNum       int64           `json:"minerChartsNum"`
+	MinerCharts          string          `json:"minerCharts"`
+	StatsCollectInterval string          `json:"statsCollectInterval"`
+	HashrateWindow       string          `json:"hashrateWindow"`
+	HashrateLargeWindow  string          `json:"hashrateLargeWindow"`
+	LuckWindow           []int           `json:"luckWindow"`
+	Payments             int64           `json:"payments"`
+	Blocks               int64           `json:"blocks"`
+	PurgeOnly            bool            `json:"purgeOnly"`
+	PurgeInterval        string          `json:"purgeInterval"`
+	Admin                AdminConfig     `json:"admin"`
+	Websocket            WebsocketConfig `json:"websocket"`
 }
 
 type ApiServer struct {
-	settings            map[string]interface{}
-	config              *ApiConfig
-	backend             *storage.RedisClient
-	hashrateWindow      time.Duration
-	hashrateLargeWindow time.Duration
-	stats               atomic.Value
-	miners              map[string]*Entry
-	minersMu            sync.RWMutex
-	statsIntv           time.Duration
-	rpc                 [common.HierarchyDepth]*rpc.RPCClient
+	// Holds *Settings, replaced on reload
+	settings atomic.Value
+	config   *ApiConfig
+	backend  storage.Backend
+	// Holds *statsWindows, replaced on reload
+	windows   atomic.Value
+	stats     atomic.Value
+	miners    map[string]*Entry
+	minersMu  sync.RWMutex
+	statsIntv time.Duration
+	rpc       [common.HierarchyDepth]*rpc.RPCClient
+	hub       *Hub
+	// Block statuses seen by the previous collector run
+	blocks   map[string]string
+	ledger   LedgerReporter
+	reloader ConfigReloader
 }
 
 type Entry struct {
-	stats     map[string]interface{}
+	stats     *AccountResponse
 	updatedAt int64
 }
 
-func NewApiServer(cfg *ApiConfig, settings map[string]interface{}, backend *storage.RedisClient) *ApiServer {
+func NewApiServer(cfg *ApiConfig, settings *Settings, backend storage.Backend) *ApiServer {
 	rpcDaemons := [common.HierarchyDepth]*rpc.RPCClient{}
 
-	for level := 0; level < common.HierarchyDepth; level++ {
-		rpcConfig := settings["Upstream"].([]interface{})[level].(map[string]interface{})
-		rpcDaemons[level] = rpc.NewRPCClient(
-			rpcConfig["Name"].(string),
-			rpcConfig["Url"].(string),
-			rpcConfig["Timeout"].(string))
+	for level := 0; level < common.HierarchyDepth && level < len(settings.Upstream); level++ {
+		upstream := settings.Upstream[level]
+		rpcDaemons[level] = rpc.NewRPCClient(upstream.Name, upstream.Url, upstream.Timeout)
 	}
 
-	hashrateWindow := util.MustParseDuration(cfg.HashrateWindow)
-	hashrateLargeWindow := util.MustParseDuration(cfg.HashrateLargeWindow)
-	return &ApiServer{
-		settings:            settings,
-		config:              cfg,
-		backend:             backend,
-		hashrateWindow:      hashrateWindow,
-		hashrateLargeWindow: hashrateLargeWindow,
-		miners:              make(map[string]*Entry),
-		rpc:                 rpcDaemons,
+	s := &ApiServer{
+		config:  cfg,
+		backend: backend,
+		miners:  make(map[string]*Entry),
+		rpc:     rpcDaemons,
 	}
+	s.settings.Store(settings)
+	s.windows.Store(newStatsWindows(cfg))
+	if cfg.Websocket.Enabled {
+		s.hub = NewHub(&cfg.Websocket)
+	}
+	return s
+}
+
+// statsWindows are the periods stats are collected over.
+type statsWindows struct {
+	hashrate      time.Duration
+	hashrateLarge time.Duration
+	// Sorted, the first one is charted
+	luck []int
+}
+
+func newStatsWindows(cfg *ApiConfig) *statsWindows {
+	luck := append([]int{}, cfg.LuckWindow...)
+	sort.Ints(luck)
+	return &statsWindows{
+		hashrate:      util.MustParseDuration(cfg.HashrateWindow),
+		hashrateLarge: util.MustParseDuration(cfg.HashrateLargeWindow),
+		luck:          luck,
+	}
+}
+
+func (s *ApiServer) currentWindows() *statsWindows {
+	return s.windows.Load().(*statsWindows)
+}
+
+func (s *ApiServer) currentSettings() *Settings {
+	return s.settings.Load().(*Settings)
+}
+
+// Reload applies the stats windows of cfg and publishes settings. Other
+// settings are kept until restart.
+func (s *ApiServer) Reload(cfg *ApiConfig, settings *Settings) {
+	s.windows.Store(newStatsWindows(cfg))
+	s.settings.Store(settings)
+	log.Printf("Reloaded API stats windows and settings")
 }
 
 func (s *ApiServer) Start() {
@@ -94,8 +135,6 @@ func (s *ApiServer) Start() {
 	purgeTimer := time.NewTimer(purgeIntv)
 	log.Printf("Set purge interval to %v", purgeIntv)
 
-	sort.Ints(s.config.LuckWindow)
-
 	if s.config.PurgeOnly {
 		s.purgeStale()
 	} else {
@@ -136,8 +175,15 @@ func (s *ApiServer) Start() {
 				log.Println("Get all miners account error: ", err)
 			}
 			for _, login := range miners {
-				miner, _ := s.backend.CollectWorkersStats(s.hashrateWindow, s.hashrateLargeWindow, login, 0)
-				s.collectMinerCharts(login, miner["currentHashrate"].(int64), miner["hashrate"].(int64), miner["workersOnline"].(int64))
+				windows := s.currentWindows()
+				miner, _ := s.backend.CollectWorkersStats(windows.hashrate, windows.hashrateLarge, login, 0)
+				if miner == nil {
+					continue
+				}
+				s.collectMinerCharts(login, miner.CurrentHashrate, miner.Hashrate, miner.WorkersOnline)
+				for id, worker := range miner.Workers {
+					s.collectWorkerCharts(login, id, worker.HR, worker.TotalHR)
+				}
 			}
 		})
 
@@ -156,7 +202,10 @@ func (s *ApiServer) collectPoolCharts() {
 	hour, min, _ := now.Clock()
 	t2 := fmt.Sprintf("%d-%02d-%02d %02d_%02d", year, month, day, hour, min)
 	stats := s.getStats()
-	hash := fmt.Sprint(stats["hashrate"])
+	if stats == nil {
+		return
+	}
+	hash := fmt.Sprint(stats.Hashrate)
 	log.Println("Pool Hash is ", ts, t2, hash)
 	err := s.backend.WritePoolCharts(ts, t2, hash)
 	if err != nil {
@@ -179,19 +228,57 @@ func (s *ApiServer) collectMinerCharts(login string, hash int64, largeHash int64
 	}
 }
 
+func (s *ApiServer) collectWorkerCharts(login, worker string, hash int64, largeHash int64) {
+	ts := util.MakeTimestamp() / 1000
+	now := time.Now()
+	year, month, day := now.Date()
+	hour, min, _ := now.Clock()
+	t2 := fmt.Sprintf("%d-%02d-%02d %02d_%02d", year, month, day, hour, min)
+
+	err := s.backend.WriteWorkerCharts(ts, t2, login, worker, hash, largeHash)
+	if err != nil {
+		log.Printf("Failed to write worker %v.%v charts to backend: %v", login, worker, err)
+	}
+}
+
 func (s *ApiServer) listen() {
+	err := http.ListenAndServe(s.config.Listen, s.router())
+	if err != nil {
+		log.Fatalf("Failed to start API: %v", err)
+	}
+}
+
+func (s *ApiServer) router() *mux.Router {
 	r := mux.NewRouter()
 	r.HandleFunc("/api/stats", s.StatsIndex)
 	r.HandleFunc("/api/miners", s.MinersIndex)
 	r.HandleFunc("/api/blocks", s.BlocksIndex)
 	r.HandleFunc("/api/payments", s.PaymentsIndex)
 	r.HandleFunc("/api/settings", s.Settings)
+	r.HandleFunc("/api/openapi.json", s.OpenAPIIndex)
 	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}", s.AccountIndex)
-	r.NotFoundHandler = http.HandlerFunc(notFound)
-	err := http.ListenAndServe(s.config.Listen, r)
-	if err != nil {
-		log.Fatalf("Failed to start API: %v", err)
+	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}/payments", s.AccountPaymentsIndex)
+	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}/credits", s.AccountCreditsIndex)
+	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}/statement", s.StatementIndex)
+	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}/workers/{worker:[0-9a-zA-Z-_]{1,8}}", s.WorkerIndex)
+	if s.hub != nil {
+		r.HandleFunc("/api/ws", s.ServeWs)
 	}
+	if s.config.Admin.Enabled {
+		s.registerAdminRoutes(r)
+	}
+	r.NotFoundHandler = http.HandlerFunc(notFound)
+	r.Use(observeLatency)
+	return r
+}
+
+func observeLatency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		route, _ := mux.CurrentRoute(r).GetPathTemplate()
+		metrics.ApiLatency.WithLabelValues(route).Observe(time.Since(start).Seconds())
+	})
 }
 
 func notFound(w http.ResponseWriter, r *http.Request) {
@@ -203,7 +290,8 @@ func notFound(w http.ResponseWriter, r *http.Request) {
 
 func (s *ApiServer) purgeStale() {
 	start := time.Now()
-	total, err := s.backend.FlushStaleStats(s.hashrateWindow, s.hashrateLargeWindow)
+	windows := s.currentWindows()
+	total, err := s.backend.FlushStaleStats(windows.hashrate, windows.hashrateLarge)
 	if err != nil {
 		log.Println("Failed to purge stale data from backend:", err)
 	} else {
@@ -212,21 +300,31 @@ func (s *ApiServer) purgeStale() {
 }
 
 func (s *ApiServer) collectStats() {
-	stats, err := s.backend.CollectStats(s.hashrateWindow, s.config.Blocks, s.config.Payments)
+	windows := s.currentWindows()
+	stats, err := s.backend.CollectStats(windows.hashrate, s.config.Blocks, s.config.Payments)
 	if err != nil {
 		log.Printf("Failed to fetch stats from backend: %v", err)
 		return
 	}
-	if len(s.config.LuckWindow) > 0 {
-		stats["luck"], err = s.backend.CollectLuckStats(s.config.LuckWindow)
-		stats["luckCharts"], err = s.backend.CollectLuckCharts(s.config.LuckWindow[0])
+	snapshot := &poolSnapshot{PoolStats: stats}
+	if len(windows.luck) > 0 {
+		snapshot.Luck, err = s.backend.CollectLuckStats(windows.luck)
+		if err == nil {
+			snapshot.LuckCharts, err = s.backend.CollectLuckCharts(windows.luck[0])
+		}
 		if err != nil {
 			log.Printf("Failed to fetch luck stats from backend: %v", err)
 			return
 		}
 	}
-	stats["poolCharts"], err = s.backend.GetPoolCharts(s.config.PoolChartsNum)
-	s.stats.Store(stats)
+	snapshot.PoolCharts, err = s.backend.GetPoolCharts(s.config.PoolChartsNum)
+	if err != nil {
+		log.Printf("Failed to fetch pool charts from backend: %v", err)
+	}
+	s.stats.Store(snapshot)
+	if s.hub != nil {
+		s.pushUpdates(snapshot)
+	}
 }
 
 func (s *ApiServer) StatsIndex(w http.ResponseWriter, r *http.Request) {
@@ -235,29 +333,31 @@ func (s *ApiServer) StatsIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-cache")
 	w.WriteHeader(http.StatusOK)
 
-	reply := make(map[string]interface{})
-	nodes, err := s.backend.GetNodeStates()
+	err := json.NewEncoder(w).Encode(s.statsReply(s.backend.WithContext(r.Context()), s.getStats()))
 	if err != nil {
-		log.Printf("Failed to get nodes stats from backend: %v", err)
+		log.Println("Error serializing API response: ", err)
 	}
-	reply["nodes"] = nodes
+}
 
-	stats := s.getStats()
-	if stats != nil {
-		reply["now"] = util.MakeTimestamp()
-		reply["stats"] = stats["stats"]
-		reply["poolCharts"] = stats["poolCharts"]
-		reply["hashrate"] = stats["hashrate"]
-		reply["minersTotal"] = stats["minersTotal"]
-		reply["maturedTotal"] = stats["maturedTotal"]
-		reply["immatureTotal"] = stats["immatureTotal"]
-		reply["candidatesTotal"] = stats["candidatesTotal"]
+func (s *ApiServer) statsReply(backend storage.Backend, stats *poolSnapshot) *StatsResponse {
+	reply := &StatsResponse{}
+	nodes, err := backend.GetNodeStates()
+	if err != nil {
+		log.Printf("Failed to get nodes stats from backend: %v", err)
 	}
+	reply.Nodes = nodes
 
-	err = json.NewEncoder(w).Encode(reply)
-	if err != nil {
-		log.Println("Error serializing API response: ", err)
+	if stats != nil {
+		reply.Now = util.MakeTimestamp()
+		reply.Stats = stats.Stats
+		reply.PoolCharts = stats.PoolCharts
+		reply.Hashrate = stats.Hashrate
+		reply.MinersTotal = stats.MinersTotal
+		reply.MaturedTotal = stats.MaturedTotal
+		reply.ImmatureTotal = stats.ImmatureTotal
+		reply.CandidatesTotal = stats.CandidatesTotal
 	}
+	return reply
 }
 
 func (s *ApiServer) MinersIndex(w http.ResponseWriter, r *http.Request) {
@@ -266,13 +366,13 @@ func (s *ApiServer) MinersIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-cache")
 	w.WriteHeader(http.StatusOK)
 
-	reply := make(map[string]interface{})
+	reply := &MinersResponse{}
 	stats := s.getStats()
 	if stats != nil {
-		reply["now"] = util.MakeTimestamp()
-		reply["miners"] = stats["miners"]
-		reply["hashrate"] = stats["hashrate"]
-		reply["minersTotal"] = stats["minersTotal"]
+		reply.Now = util.MakeTimestamp()
+		reply.Miners = stats.Miners
+		reply.Hashrate = stats.Hashrate
+		reply.MinersTotal = stats.MinersTotal
 	}
 
 	err := json.NewEncoder(w).Encode(reply)
@@ -285,19 +385,24 @@ func (s *ApiServer) BlocksIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Cache-Control", "no-cache")
+
+	if isPageRequest(r) {
+		s.blocksPage(w, r)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 
-	reply := make(map[string]interface{})
+	reply := &BlocksResponse{}
 	stats := s.getStats()
 	if stats != nil {
-		reply["matured"] = stats["matured"]
-		reply["maturedTotal"] = stats["maturedTotal"]
-		reply["immature"] = stats["immature"]
-		reply["immatureTotal"] = stats["immatureTotal"]
-		reply["candidates"] = stats["candidates"]
-		reply["candidatesTotal"] = stats["candidatesTotal"]
-		reply["luck"] = stats["luck"]
-		reply["luckCharts"] = stats["luckCharts"]
+		reply.Matured = stats.Matured
+		reply.MaturedTotal = stats.MaturedTotal
+		reply.Immature = stats.Immature
+		reply.ImmatureTotal = stats.ImmatureTotal
+		reply.Candidates = stats.Candidates
+		reply.CandidatesTotal = stats.CandidatesTotal
+		reply.Luck = stats.Luck
+		reply.LuckCharts = stats.LuckCharts
 	}
 
 	err := json.NewEncoder(w).Encode(reply)
@@ -310,13 +415,18 @@ func (s *ApiServer) PaymentsIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Cache-Control", "no-cache")
+
+	if isPageRequest(r) {
+		s.paymentsPage(w, r, "")
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 
-	reply := make(map[string]interface{})
+	reply := &PaymentsResponse{}
 	stats := s.getStats()
 	if stats != nil {
-		reply["payments"] = stats["payments"]
-		reply["paymentsTotal"] = stats["paymentsTotal"]
+		reply.Payments = stats.Payments
+		reply.PaymentsTotal = stats.PaymentsTotal
 	}
 
 	err := json.NewEncoder(w).Encode(reply)
@@ -331,6 +441,27 @@ func (s *ApiServer) AccountIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-cache")
 
 	login := strings.ToLower(mux.Vars(r)["login"])
+	stats, err := s.accountStats(s.backend.WithContext(r.Context()), login, false)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to fetch stats from backend: %v", err)
+		return
+	}
+	if stats == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(stats)
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
+
+// accountStats returns cached stats of login, refreshing them when stale or
+// forced. It returns nil for unknown miners.
+func (s *ApiServer) accountStats(backend storage.Backend, login string, force bool) (*AccountResponse, error) {
 	s.minersMu.Lock()
 	defer s.minersMu.Unlock()
 
@@ -338,73 +469,168 @@ func (s *ApiServer) AccountIndex(w http.ResponseWriter, r *http.Request) {
 	now := util.MakeTimestamp()
 	cacheIntv := int64(s.statsIntv / time.Millisecond)
 	// Refresh stats if stale
-	if !ok || reply.updatedAt < now-cacheIntv {
-		exist, err := s.backend.IsMinerExists(login)
+	if force || !ok || reply.updatedAt < now-cacheIntv {
+		exist, err := backend.IsMinerExists(login)
+		if err != nil {
+			return nil, err
+		}
 		if !exist {
-			w.WriteHeader(http.StatusNotFound)
-			return
+			return nil, nil
 		}
+
+		stats, err := backend.GetMinerStats(login, s.config.Payments)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Failed to fetch stats from backend: %v", err)
-			return
+			return nil, err
 		}
-
-		stats, err := s.backend.GetMinerStats(login, s.config.Payments)
+		windows := s.currentWindows()
+		workers, err := backend.CollectWorkersStats(windows.hashrate, windows.hashrateLarge, login, s.config.Blocks)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Failed to fetch stats from backend: %v", err)
-			return
+			return nil, err
+		}
+		account := &AccountResponse{
+			MinerStats:   *stats,
+			WorkersStats: *workers,
+			PageSize:     s.config.Payments,
+			Fee:          s.minerFee(login),
 		}
-		workers, err := s.backend.CollectWorkersStats(s.hashrateWindow, s.hashrateLargeWindow, login, s.config.Blocks)
+		account.MinerCharts, err = backend.GetMinerCharts(s.config.MinerChartsNum, login)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Failed to fetch stats from backend: %v", err)
-			return
+			return nil, err
 		}
-		for key, value := range workers {
-			stats[key] = value
+		account.PaymentCharts, err = backend.GetPaymentCharts(login)
+		if err != nil {
+			return nil, err
 		}
-		stats["pageSize"] = s.config.Payments
-		stats["minerCharts"], err = s.backend.GetMinerCharts(s.config.MinerChartsNum, login)
-		stats["paymentCharts"], err = s.backend.GetPaymentCharts(login)
-		reply = &Entry{stats: stats, updatedAt: now}
+		reply = &Entry{stats: account, updatedAt: now}
 		s.miners[login] = reply
 	}
+	return reply.stats, nil
+}
+
+func (s *ApiServer) WorkerIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login := strings.ToLower(mux.Vars(r)["login"])
+	worker := strings.ToLower(mux.Vars(r)["worker"])
+
+	backend := s.backend.WithContext(r.Context())
+	windows := s.currentWindows()
+	stats, err := backend.GetWorkerStats(windows.hashrate, windows.hashrateLarge, login, worker)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to fetch stats from backend: %v", err)
+		return
+	}
+	if stats == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	reply := &WorkerResponse{WorkerStats: *stats}
+	reply.WorkerCharts, err = backend.GetWorkerCharts(s.config.MinerChartsNum, login, worker)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to fetch worker charts from backend: %v", err)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
-	err := json.NewEncoder(w).Encode(reply.stats)
+	err = json.NewEncoder(w).Encode(reply)
 	if err != nil {
 		log.Println("Error serializing API response: ", err)
 	}
 }
 
-func (s *ApiServer) Settings(w http.ResponseWriter, r *http.Request) {
+func (s *ApiServer) AccountPaymentsIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Cache-Control", "max-age=600")
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Cache-Control", "no-cache")
 
-	reply := make(map[string]interface{})
+	s.paymentsPage(w, r, strings.ToLower(mux.Vars(r)["login"]))
+}
 
-	reply["HashLimit"] = s.settings["Proxy"].(map[string]interface{})["HashLimit"]
-	reply["Difficulty"] = s.settings["Proxy"].(map[string]interface{})["Difficulty"]
+func (s *ApiServer) AccountCreditsIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache")
 
-	reply["EthProxy"] = s.settings["Proxy"].(map[string]interface{})["Enabled"]
-	reply["EthProxyPool"] = s.settings["Proxy"].(map[string]interface{})["Listen"]
-	reply["Stratum"] = s.settings["Proxy"].(map[string]interface{})["Stratum"].(map[string]interface{})["Enabled"]
-	reply["StratumPool"] = s.settings["Proxy"].(map[string]interface{})["Stratum"].(map[string]interface{})["Listen"]
+	query, err := parsePageQuery(r)
+	if err != nil {
+		writePageError(w, err)
+		return
+	}
+	credits, cursor, err := s.backend.WithContext(r.Context()).GetCreditsPage(strings.ToLower(mux.Vars(r)["login"]), query)
+	if err != nil {
+		writePageError(w, err)
+		return
+	}
+	writePage(w, &CreditsPageResponse{Credits: credits, NextCursor: cursor})
+}
 
-	err := json.NewEncoder(w).Encode(reply)
+func (s *ApiServer) blocksPage(w http.ResponseWriter, r *http.Request) {
+	query, err := parsePageQuery(r)
+	if err != nil {
+		writePageError(w, err)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if len(status) == 0 {
+		status = storage.BlocksMatured
+	}
+	if status != storage.BlocksCandidates && status != storage.BlocksImmature && status != storage.BlocksMatured {
+		writePageError(w, errInvalidStatus)
+		return
+	}
+	blocks, cursor, err := s.backend.WithContext(r.Context()).GetBlocksPage(status, query)
+	if err != nil {
+		writePageError(w, err)
+		return
+	}
+	writePage(w, &BlocksPageResponse{Blocks: blocks, Status: status, NextCursor: cursor})
+}
+
+func (s *ApiServer) paymentsPage(w http.ResponseWriter, r *http.Request, login string) {
+	query, err := parsePageQuery(r)
+	if err != nil {
+		writePageError(w, err)
+		return
+	}
+	payments, cursor, err := s.backend.WithContext(r.Context()).GetPaymentsPage(login, query)
+	if err != nil {
+		writePageError(w, err)
+		return
+	}
+	writePage(w, &PaymentsPageResponse{Payments: payments, NextCursor: cursor})
+}
+
+func (s *ApiServer) Settings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "max-age=600")
+	w.WriteHeader(http.StatusOK)
+
+	err := json.NewEncoder(w).Encode(s.currentSettings())
 	if err != nil {
 		log.Println("Error serializing API response: ", err)
 	}
 }
 
-func (s *ApiServer) getStats() map[string]interface{} {
+// minerFee reports the pool fee in percent charged on rewards of login.
+func (s *ApiServer) minerFee(login string) float64 {
+	settings := s.currentSettings()
+	for miner, fee := range settings.FeeOverrides {
+		if strings.EqualFold(miner, login) {
+			return fee
+		}
+	}
+	return settings.PoolFee
+}
+
+func (s *ApiServer) getStats() *poolSnapshot {
 	stats := s.stats.Load()
 	if stats != nil {
-		return stats.(map[string]interface{})
+		return stats.(*poolSnapshot)
 	}
 	return nil
 }
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+)
+
+// Entries fetched from each sorted set per round trip
+const statementBatch = 500
+
+// Statement entry types
+const (
+	StatementCredit  = "credit"
+	StatementPayment = "payment"
+)
+
+type StatementEntry struct {
+	Type          string `json:"type"`
+	Timestamp     int64  `json:"timestamp"`
+	Height        int64  `json:"height,omitempty"`
+	Hash          string `json:"hash,omitempty"`
+	Tx            string `json:"tx,omitempty"`
+	Amount        int64  `json:"amount"`
+	TotalCredited int64  `json:"totalCredited"`
+	TotalPaid     int64  `json:"totalPaid"`
+}
+
+type StatementTotals struct {
+	Credits       int64 `json:"credits"`
+	Payments      int64 `json:"payments"`
+	TotalCredited int64 `json:"totalCredited"`
+	TotalPaid     int64 `json:"totalPaid"`
+}
+
+// statementSource pages through one sorted set oldest first.
+type statementSource struct {
+	fetch  func(q *storage.PageQuery) ([]*StatementEntry, string, error)
+	query  storage.PageQuery
+	buf    []*StatementEntry
+	done   bool
+	failed error
+}
+
+func (src *statementSource) peek() *StatementEntry {
+	for len(src.buf) == 0 && !src.done {
+		entries, cursor, err := src.fetch(&src.query)
+		if err != nil {
+			src.failed = err
+			src.done = true
+			break
+		}
+		src.buf = entries
+		src.query.Cursor = cursor
+		src.done = len(cursor) == 0
+	}
+	if len(src.buf) == 0 {
+		return nil
+	}
+	return src.buf[0]
+}
+
+func (src *statementSource) pop() *StatementEntry {
+	entry := src.buf[0]
+	src.buf = src.buf[1:]
+	return entry
+}
+
+type statementWriter interface {
+	writeEntry(*StatementEntry) error
+	finish(*StatementTotals, error) error
+}
+
+// StatementIndex streams credits and payments of an account in time order
+// with running totals, as JSON or CSV. Credits are logged since the pool
+// started recording them per account.
+func (s *ApiServer) StatementIndex(w http.ResponseWriter, r *http.Request) {
+	login := strings.ToLower(mux.Vars(r)["login"])
+	query, err := parsePageQuery(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		writePageError(w, err)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&ErrorResponse{Error: "format must be csv or json"})
+		return
+	}
+
+	backend := s.backend.WithContext(r.Context())
+	base := storage.PageQuery{Limit: statementBatch, From: query.From, To: query.To, Ascending: true}
+	credits := &statementSource{query: base, fetch: func(q *storage.PageQuery) ([]*StatementEntry, string, error) {
+		page, cursor, err := backend.GetCreditsPage(login, q)
+		entries := make([]*StatementEntry, 0, len(page))
+		for _, c := range page {
+			entries = append(entries, &StatementEntry{Type: StatementCredit, Timestamp: c.Timestamp, Height: c.Height, Hash: c.Hash, Amount: c.Amount})
+		}
+		return entries, cursor, err
+	}}
+	payments := &statementSource{query: base, fetch: func(q *storage.PageQuery) ([]*StatementEntry, string, error) {
+		page, cursor, err := backend.GetPaymentsPage(login, q)
+		entries := make([]*StatementEntry, 0, len(page))
+		for _, p := range page {
+			entries = append(entries, &StatementEntry{Type: StatementPayment, Timestamp: p.Timestamp, Tx: p.Tx, Amount: p.Amount})
+		}
+		return entries, cursor, err
+	}}
+
+	filename := fmt.Sprintf("statement-%s-%d-%d.%s", login, query.From, query.To, format)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	var out statementWriter
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		out = newCSVStatement(w)
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		out = newJSONStatement(w, login, query)
+	}
+
+	flusher, _ := w.(http.Flusher)
+	totals := &StatementTotals{}
+	for rows := 1; ; rows++ {
+		credit, payment := credits.peek(), payments.peek()
+		var entry *StatementEntry
+		// Credits go first within the same second
+		if credit != nil && (payment == nil || credit.Timestamp <= payment.Timestamp) {
+			entry = credits.pop()
+			totals.Credits++
+			totals.TotalCredited += entry.Amount
+		} else if payment != nil {
+			entry = payments.pop()
+			totals.Payments++
+			totals.TotalPaid += entry.Amount
+		} else {
+			break
+		}
+		entry.TotalCredited = totals.TotalCredited
+		entry.TotalPaid = totals.TotalPaid
+		if err := out.writeEntry(entry); err != nil {
+			return
+		}
+		if flusher != nil && rows%statementBatch == 0 {
+			flusher.Flush()
+		}
+	}
+
+	failed := credits.failed
+	if failed == nil {
+		failed = payments.failed
+	}
+	if failed != nil {
+		log.Printf("Failed to build statement of %v: %v", login, failed)
+	}
+	if err := out.finish(totals, failed); err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
+
+type csvStatement struct {
+	w *csv.Writer
+}
+
+func newCSVStatement(w http.ResponseWriter) *csvStatement {
+	out := &csvStatement{w: csv.NewWriter(w)}
+	out.w.Write([]string{"date", "timestamp", "type", "height", "block_hash", "tx_hash", "amount", "amount_shannon", "total_credited", "total_paid"})
+	return out
+}
+
+func (c *csvStatement) writeEntry(e *StatementEntry) error {
+	height := ""
+	if e.Height > 0 {
+		height = strconv.FormatInt(e.Height, 10)
+	}
+	return c.w.Write([]string{
+		time.Unix(e.Timestamp, 0).UTC().Format(time.RFC3339),
+		strconv.FormatInt(e.Timestamp, 10),
+		e.Type,
+		height,
+		e.Hash,
+		e.Tx,
+		formatShannon(e.Amount),
+		strconv.FormatInt(e.Amount, 10),
+		strconv.FormatInt(e.TotalCredited, 10),
+		strconv.FormatInt(e.TotalPaid, 10),
+	})
+}
+
+// finish can't change the status of a streamed CSV, a failure shows up as
+// a trailing error row.
+func (c *csvStatement) finish(totals *StatementTotals, failed error) error {
+	if failed != nil {
+		c.w.Write([]string{"error", "", "statement is incomplete"})
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonStatement struct {
+	w       http.ResponseWriter
+	written int
+}
+
+func newJSONStatement(w http.ResponseWriter, login string, query *storage.PageQuery) *jsonStatement {
+	fmt.Fprintf(w, `{"login":%q,"from":%d,"to":%d,"entries":[`, login, query.From, query.To)
+	return &jsonStatement{w: w}
+}
+
+func (j *jsonStatement) writeEntry(e *StatementEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if j.written > 0 {
+		j.w.Write([]byte{','})
+	}
+	j.written++
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonStatement) finish(totals *StatementTotals, failed error) error {
+	data, err := json.Marshal(totals)
+	if err != nil {
+		return err
+	}
+	if failed != nil {
+		_, err = fmt.Fprintf(j.w, `],"totals":%s,"error":"statement is incomplete"}`, data)
+	} else {
+		_, err = fmt.Fprintf(j.w, `],"totals":%s}`, data)
+	}
+	return err
+}
+
+// formatShannon renders an amount in Shannon as a decimal coin amount.
+func formatShannon(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%09d", sign, amount/1e9, amount%1e9)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+const statementLogin = "0x00000000000000000000000000000000000000aa"
+
+func newStatementTestServer(t *testing.T) http.Handler {
+	backend := storage.NewMemoryBackend()
+
+	for _, height := range []int64{1008, 1009} {
+		block := &storage.BlockData{Height: height, RoundHeight: height, Hash: "0x0", Nonce: "0x1", Reward: util.String2Big("2000000000000000000")}
+		backend.WriteImmatureBlock(block, map[string]int64{statementLogin: 1500000000})
+		backend.WriteMaturedBlock(block, map[string]int64{statementLogin: 1500000000}, nil)
+	}
+	backend.WritePayment(statementLogin, "0xtx", 1000000000)
+
+	s := &ApiServer{config: &ApiConfig{}, backend: backend}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}/statement", s.StatementIndex)
+	return r
+}
+
+func TestStatementJSON(t *testing.T) {
+	h := newStatementTestServer(t)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/accounts/"+statementLogin+"/statement", nil))
+
+	var reply struct {
+		Entries []*StatementEntry `json:"entries"`
+		Totals  *StatementTotals  `json:"totals"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Entries) != 3 || reply.Entries[0].Type != StatementCredit || reply.Entries[0].Height != 1008 {
+		t.Fatalf("Must list credits and payments in time order: %+v", reply.Entries)
+	}
+	last := reply.Entries[2]
+	if last.Type != StatementPayment || last.Tx != "0xtx" || last.TotalCredited != 3000000000 || last.TotalPaid != 1000000000 {
+		t.Errorf("Must keep running totals: %+v", last)
+	}
+	if reply.Totals.Credits != 2 || reply.Totals.Payments != 1 {
+		t.Errorf("Invalid totals: %+v", reply.Totals)
+	}
+}
+
+func TestStatementCSV(t *testing.T) {
+	h := newStatementTestServer(t)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/accounts/"+statementLogin+"/statement?format=csv", nil))
+	if w.Header().Get("Content-Type") != "text/csv; charset=UTF-8" {
+		t.Errorf("Invalid content type %v", w.Header().Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 || rows[1][6] != "1.500000000" || rows[3][5] != "0xtx" {
+		t.Errorf("Invalid statement: %v", rows)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/accounts/"+statementLogin+"/statement?format=xml", nil))
+	if w.Code != 400 {
+		t.Errorf("Must reject unknown format, got %v", w.Code)
+	}
+}
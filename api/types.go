@@ -0,0 +1,152 @@
+package api
+
+import "github.com/dominant-strategies/go-quai-stratum/storage"
+
+// Settings is the part of the pool config the API needs. It is served as is
+// by /api/settings, fields tagged "-" stay private.
+type Settings struct {
+	Difficulty   string  `json:"Difficulty"`
+	EthProxy     bool    `json:"EthProxy"`
+	EthProxyPool string  `json:"EthProxyPool"`
+	Stratum      bool    `json:"Stratum"`
+	StratumPool  string  `json:"StratumPool"`
+	RewardScheme string  `json:"RewardScheme"`
+	PPLNSWindow  int64   `json:"PPLNSWindow"`
+	AllowSolo    bool    `json:"AllowSolo"`
+	PoolFee      float64 `json:"PoolFee"`
+	DonationFee  float64 `json:"DonationFee"`
+
+	FeeOverrides map[string]float64 `json:"-"`
+	Upstream     []Upstream         `json:"-"`
+}
+
+type Upstream struct {
+	Name    string
+	Url     string
+	Timeout string
+}
+
+// poolSnapshot is what the collector caches between runs.
+type poolSnapshot struct {
+	*storage.PoolStats
+	Luck       map[string]*storage.LuckStats
+	LuckCharts []*storage.LuckCharts
+	PoolCharts []*storage.PoolCharts
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type StatsResponse struct {
+	Nodes           []*storage.NodeState  `json:"nodes"`
+	Now             int64                 `json:"now"`
+	Stats           storage.PoolCounters  `json:"stats"`
+	PoolCharts      []*storage.PoolCharts `json:"poolCharts"`
+	Hashrate        int64                 `json:"hashrate"`
+	MinersTotal     int                   `json:"minersTotal"`
+	MaturedTotal    int64                 `json:"maturedTotal"`
+	ImmatureTotal   int64                 `json:"immatureTotal"`
+	CandidatesTotal int64                 `json:"candidatesTotal"`
+}
+
+type MinersResponse struct {
+	Now         int64                    `json:"now"`
+	Miners      map[string]storage.Miner `json:"miners"`
+	Hashrate    int64                    `json:"hashrate"`
+	MinersTotal int                      `json:"minersTotal"`
+}
+
+type BlocksResponse struct {
+	Matured         []*storage.BlockData          `json:"matured"`
+	MaturedTotal    int64                         `json:"maturedTotal"`
+	Immature        []*storage.BlockData          `json:"immature"`
+	ImmatureTotal   int64                         `json:"immatureTotal"`
+	Candidates      []*storage.BlockData          `json:"candidates"`
+	CandidatesTotal int64                         `json:"candidatesTotal"`
+	Luck            map[string]*storage.LuckStats `json:"luck"`
+	LuckCharts      []*storage.LuckCharts         `json:"luckCharts"`
+}
+
+type BlocksPageResponse struct {
+	Blocks     []*storage.BlockData `json:"blocks"`
+	Status     string               `json:"status"`
+	NextCursor string               `json:"nextCursor"`
+}
+
+type PaymentsResponse struct {
+	Payments      []*storage.Payment `json:"payments"`
+	PaymentsTotal int64              `json:"paymentsTotal"`
+}
+
+type PaymentsPageResponse struct {
+	Payments   []*storage.Payment `json:"payments"`
+	NextCursor string             `json:"nextCursor"`
+}
+
+type CreditsPageResponse struct {
+	Credits    []*storage.Credit `json:"credits"`
+	NextCursor string            `json:"nextCursor"`
+}
+
+type AccountResponse struct {
+	storage.MinerStats
+	storage.WorkersStats
+	PageSize      int64                    `json:"pageSize"`
+	Fee           float64                  `json:"fee"`
+	MinerCharts   []*storage.MinerCharts   `json:"minerCharts"`
+	PaymentCharts []*storage.PaymentCharts `json:"paymentCharts"`
+}
+
+type WorkerResponse struct {
+	storage.WorkerStats
+	WorkerCharts []*storage.WorkerCharts `json:"workerCharts"`
+}
+
+// StatementResponse describes the JSON statement, which is streamed.
+type StatementResponse struct {
+	Login   string            `json:"login"`
+	From    int64             `json:"from"`
+	To      int64             `json:"to"`
+	Entries []*StatementEntry `json:"entries"`
+	Totals  StatementTotals   `json:"totals"`
+	// Set when the backend failed half way through
+	Error string `json:"error,omitempty"`
+}
+
+type ListEntriesResponse struct {
+	Entries []string `json:"entries"`
+	Total   int      `json:"total"`
+}
+
+type ListChangeResponse struct {
+	Entry   string `json:"entry"`
+	Changed bool   `json:"changed"`
+}
+
+type BansResponse struct {
+	Bans  []*storage.Ban `json:"bans"`
+	Total int            `json:"total"`
+}
+
+type UnbanResponse struct {
+	IP      string `json:"ip"`
+	Changed bool   `json:"changed"`
+}
+
+type AuditResponse struct {
+	Entries []*storage.AuditEntry `json:"entries"`
+	Total   int                   `json:"total"`
+}
+
+type ReconciliationResponse struct {
+	storage.Reconciliation
+	OK bool `json:"ok"`
+}
+
+type ReloadResponse struct {
+	// Changed settings now in effect
+	Applied []string `json:"applied"`
+	// Changed settings which only take effect after a restart
+	RestartRequired []string `json:"restartRequired"`
+}
@@ -0,0 +1,396 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+type WebsocketConfig struct {
+	Enabled    bool `json:"enabled"`
+	MaxClients int  `json:"maxClients"`
+	// Messages queued per client before it is dropped as too slow
+	SendBuffer int `json:"sendBuffer"`
+	// Any origin is accepted if empty
+	AllowedOrigins []string `json:"allowedOrigins"`
+}
+
+// Push channels, accounts are subscribed to as "account:<login>"
+const (
+	ChannelStats   = "stats"
+	ChannelBlocks  = "blocks"
+	ChannelAccount = "account:"
+)
+
+const (
+	wsWriteWait        = 10 * time.Second
+	wsPongWait         = 60 * time.Second
+	wsPingPeriod       = wsPongWait * 9 / 10
+	wsMaxMessageSize   = 512
+	wsMaxSubscriptions = 16
+)
+
+type wsRequest struct {
+	Op      string `json:"op"`
+	Channel string `json:"channel"`
+}
+
+type wsMessage struct {
+	Channel string      `json:"channel,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+type BlockEvent struct {
+	Status string             `json:"status"`
+	Block  *storage.BlockData `json:"block"`
+}
+
+type wsClient struct {
+	conn     *websocket.Conn
+	send     chan []byte
+	mu       sync.Mutex
+	closed   bool
+	channels map[string]struct{}
+}
+
+// Hub fans out collector updates to websocket clients so that every
+// update is fetched and serialized once no matter how many dashboards
+// are open.
+type Hub struct {
+	sync.RWMutex
+	config   *WebsocketConfig
+	clients  map[*wsClient]struct{}
+	last     map[string][]byte
+	upgrader websocket.Upgrader
+}
+
+func NewHub(cfg *WebsocketConfig) *Hub {
+	h := &Hub{
+		config:  cfg,
+		clients: make(map[*wsClient]struct{}),
+		last:    make(map[string][]byte),
+	}
+	h.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 4096,
+		CheckOrigin:     h.checkOrigin,
+	}
+	return h
+}
+
+func (h *Hub) checkOrigin(r *http.Request) bool {
+	if len(h.config.AllowedOrigins) == 0 {
+		return true
+	}
+	return util.StringInSlice(r.Header.Get("Origin"), h.config.AllowedOrigins)
+}
+
+func (h *Hub) register(c *wsClient) bool {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.config.MaxClients > 0 && len(h.clients) >= h.config.MaxClients {
+		return false
+	}
+	h.clients[c] = struct{}{}
+	return true
+}
+
+func (h *Hub) unregister(c *wsClient) {
+	h.Lock()
+	delete(h.clients, c)
+	h.Unlock()
+	c.close()
+}
+
+// Subscribers reports whether anyone listens on channel.
+func (h *Hub) Subscribers(channel string) bool {
+	h.RLock()
+	defer h.RUnlock()
+
+	for c := range h.clients {
+		if c.subscribed(channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Accounts returns logins with at least one subscriber.
+func (h *Hub) Accounts() []string {
+	h.RLock()
+	defer h.RUnlock()
+
+	logins := make(map[string]struct{})
+	for c := range h.clients {
+		c.mu.Lock()
+		for channel := range c.channels {
+			if strings.HasPrefix(channel, ChannelAccount) {
+				logins[strings.TrimPrefix(channel, ChannelAccount)] = struct{}{}
+			}
+		}
+		c.mu.Unlock()
+	}
+	result := make([]string, 0, len(logins))
+	for login := range logins {
+		result = append(result, login)
+	}
+	return result
+}
+
+// Publish sends data to subscribers of channel. Clients which can't keep
+// up are disconnected instead of blocking the collector.
+func (h *Hub) Publish(channel string, data interface{}) {
+	msg, err := json.Marshal(&wsMessage{Channel: channel, Data: data})
+	if err != nil {
+		log.Printf("Failed to serialize %v update: %v", channel, err)
+		return
+	}
+
+	var slow []*wsClient
+	h.Lock()
+	if channel == ChannelStats {
+		h.last[channel] = msg
+	}
+	for c := range h.clients {
+		if !c.subscribed(channel) {
+			continue
+		}
+		select {
+		case c.send <- msg:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	h.Unlock()
+
+	for _, c := range slow {
+		log.Printf("Dropping slow websocket client %v", c.conn.RemoteAddr())
+		h.unregister(c)
+	}
+}
+
+func (h *Hub) lastMessage(channel string) []byte {
+	h.RLock()
+	defer h.RUnlock()
+	return h.last[channel]
+}
+
+func (c *wsClient) subscribed(channel string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.channels[channel]
+	return ok
+}
+
+func (c *wsClient) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+// queue sends msg to this client only, dropping it when the buffer is full.
+func (c *wsClient) queue(msg []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+	}
+}
+
+func (c *wsClient) reply(msg *wsMessage) {
+	data, err := json.Marshal(msg)
+	if err == nil {
+		c.queue(data)
+	}
+}
+
+// ServeWs upgrades the connection and serves subscriptions until the
+// client goes away.
+func (s *ApiServer) ServeWs(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.hub.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Websocket upgrade failed: %v", err)
+		return
+	}
+	sendBuffer := s.config.Websocket.SendBuffer
+	if sendBuffer <= 0 {
+		sendBuffer = 16
+	}
+	c := &wsClient{
+		conn:     conn,
+		send:     make(chan []byte, sendBuffer),
+		channels: make(map[string]struct{}),
+	}
+	if !s.hub.register(c) {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too many clients"),
+			time.Now().Add(wsWriteWait))
+		conn.Close()
+		return
+	}
+
+	go c.writePump()
+	s.readPump(c)
+}
+
+func (s *ApiServer) readPump(c *wsClient) {
+	defer s.hub.unregister(c)
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var req wsRequest
+		if err := c.conn.ReadJSON(&req); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				c.reply(&wsMessage{Error: "malformed request"})
+				continue
+			}
+			return
+		}
+		s.handleWsRequest(c, &req)
+	}
+}
+
+func (s *ApiServer) handleWsRequest(c *wsClient, req *wsRequest) {
+	channel := strings.ToLower(req.Channel)
+	if channel != ChannelStats && channel != ChannelBlocks &&
+		!(strings.HasPrefix(channel, ChannelAccount) && addressPattern.MatchString(strings.TrimPrefix(channel, ChannelAccount))) {
+		c.reply(&wsMessage{Channel: req.Channel, Error: "unknown channel"})
+		return
+	}
+
+	switch req.Op {
+	case "subscribe":
+		c.mu.Lock()
+		_, ok := c.channels[channel]
+		full := !ok && len(c.channels) >= wsMaxSubscriptions
+		if !full {
+			c.channels[channel] = struct{}{}
+		}
+		c.mu.Unlock()
+		if full {
+			c.reply(&wsMessage{Channel: channel, Error: "too many subscriptions"})
+			return
+		}
+		s.sendSnapshot(c, channel)
+	case "unsubscribe":
+		c.mu.Lock()
+		delete(c.channels, channel)
+		c.mu.Unlock()
+	default:
+		c.reply(&wsMessage{Channel: channel, Error: "unknown op"})
+	}
+}
+
+// sendSnapshot gives a new subscriber the current state instead of making
+// it wait for the next collector run.
+func (s *ApiServer) sendSnapshot(c *wsClient, channel string) {
+	switch {
+	case channel == ChannelStats:
+		if msg := s.hub.lastMessage(channel); msg != nil {
+			c.queue(msg)
+		}
+	case strings.HasPrefix(channel, ChannelAccount):
+		stats, err := s.accountStats(s.backend, strings.TrimPrefix(channel, ChannelAccount), false)
+		if err != nil {
+			log.Printf("Failed to fetch stats from backend: %v", err)
+			return
+		}
+		if stats != nil {
+			c.reply(&wsMessage{Channel: channel, Data: stats})
+		}
+	}
+}
+
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// pushUpdates is run by the collector after each stats refresh.
+func (s *ApiServer) pushUpdates(stats *poolSnapshot) {
+	if s.hub.Subscribers(ChannelStats) {
+		s.hub.Publish(ChannelStats, s.statsReply(s.backend, stats))
+	}
+	for _, event := range s.blockEvents(stats) {
+		s.hub.Publish(ChannelBlocks, event)
+	}
+	for _, login := range s.hub.Accounts() {
+		account, err := s.accountStats(s.backend, login, true)
+		if err != nil {
+			log.Printf("Failed to fetch stats from backend: %v", err)
+			continue
+		}
+		if account != nil {
+			s.hub.Publish(ChannelAccount+login, account)
+		}
+	}
+}
+
+// blockEvents compares block lists with the previous run and reports new
+// candidates and blocks moving to immature or matured.
+func (s *ApiServer) blockEvents(stats *poolSnapshot) []*BlockEvent {
+	seen := make(map[string]string)
+	var events []*BlockEvent
+	lists := map[string][]*storage.BlockData{
+		storage.BlocksCandidates: stats.Candidates,
+		storage.BlocksImmature:   stats.Immature,
+		storage.BlocksMatured:    stats.Matured,
+	}
+	for _, status := range []string{storage.BlocksCandidates, storage.BlocksImmature, storage.BlocksMatured} {
+		for _, block := range lists[status] {
+			key := block.RoundKey()
+			seen[key] = status
+			if s.blocks != nil && s.blocks[key] != status {
+				events = append(events, &BlockEvent{Status: strings.TrimSuffix(status, "s"), Block: block})
+			}
+		}
+	}
+	s.blocks = seen
+	return events
+}
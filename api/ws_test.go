@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+)
+
+func dialTestHub(t *testing.T) (*ApiServer, *websocket.Conn) {
+	cfg := &ApiConfig{Websocket: WebsocketConfig{Enabled: true, MaxClients: 10}}
+	s := &ApiServer{config: cfg, hub: NewHub(&cfg.Websocket)}
+	r := mux.NewRouter()
+	r.HandleFunc("/api/ws", s.ServeWs)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return s, conn
+}
+
+func TestWsSubscribe(t *testing.T) {
+	s, conn := dialTestHub(t)
+
+	conn.WriteJSON(&wsRequest{Op: "subscribe", Channel: "pool"})
+	var reply wsMessage
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Error != "unknown channel" {
+		t.Errorf("Must reject unknown channel: %+v", reply)
+	}
+
+	conn.WriteJSON(&wsRequest{Op: "subscribe", Channel: ChannelStats})
+	for !s.hub.Subscribers(ChannelStats) {
+		time.Sleep(time.Millisecond)
+	}
+	s.hub.Publish(ChannelBlocks, &BlockEvent{Status: "candidate"})
+	s.hub.Publish(ChannelStats, map[string]interface{}{"hashrate": 42})
+
+	reply = wsMessage{}
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := reply.Data.(map[string]interface{})
+	if reply.Channel != ChannelStats || data["hashrate"] != float64(42) {
+		t.Errorf("Must receive subscribed channel only: %+v", reply)
+	}
+}
+
+func TestBlockEvents(t *testing.T) {
+	s := &ApiServer{}
+	candidate := &storage.BlockData{Height: 10, RoundHeight: 10, Hash: "0xa"}
+	next := &storage.BlockData{Height: 11, RoundHeight: 11, Hash: "0xb"}
+
+	if events := s.blockEvents(&poolSnapshot{PoolStats: &storage.PoolStats{Candidates: []*storage.BlockData{candidate}}}); len(events) != 0 {
+		t.Errorf("Must not report blocks on first run: %+v", events)
+	}
+	events := s.blockEvents(&poolSnapshot{PoolStats: &storage.PoolStats{
+		Candidates: []*storage.BlockData{next},
+		Immature:   []*storage.BlockData{candidate},
+	}})
+	if len(events) != 2 || events[0].Status != "candidate" || events[0].Block != next || events[1].Status != "immature" {
+		t.Errorf("Must report new and promoted blocks: %+v", events)
+	}
+	events = s.blockEvents(&poolSnapshot{PoolStats: &storage.PoolStats{
+		Candidates: []*storage.BlockData{next},
+		Immature:   []*storage.BlockData{candidate},
+	}})
+	if len(events) != 0 {
+		t.Errorf("Must not report unchanged blocks: %+v", events)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/dominant-strategies/go-quai-stratum/proxy"
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+
+	"github.com/dominant-strategies/go-quai/log"
+)
+
+// Subcommands run a single role of the pool or a tool instead of the whole
+// pool, e.g. "go-quai-stratum unlocker" or "go-quai-stratum backup -out pool.bak".
+var commands = map[string]func(args []string){
+	"proxy":    proxyRole.run,
+	"api":      apiRole.run,
+	"unlocker": unlockerRole.run,
+	"payer":    payerRole.run,
+	"admin":    adminCommand,
+	"backup":   backupCommand,
+	"restore":  restoreCommand,
+	"verify":   verifyCommand,
+	"migrate":  migrateCommand,
+}
+
+func runCommand(name string, args []string) {
+	command, ok := commands[name]
+	if !ok {
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(os.Stderr, "Unknown command %q, available: %v\n", name, names)
+		os.Exit(2)
+	}
+	command(args)
+}
+
+// commandStorage loads the config and connects to the Redis holding the
+// financial state. The tools don't cover the SQL ledger of hybrid storage,
+// which has tooling of its own.
+func commandStorage(configPath string) *storage.RedisClient {
+	var cfg proxy.Config
+	loadConfig(configPath, &cfg)
+	if cfg.Storage.Driver != "" && cfg.Storage.Driver != storage.DriverRedis {
+		log.Global.Fatalf("This command needs the redis storage driver, not %s", cfg.Storage.Driver)
+	}
+	if !cfg.Redis.Enabled {
+		log.Global.Fatal("This command needs redis to be enabled")
+	}
+	client := storage.NewRedisClient(&cfg.Redis, cfg.Coin)
+	if _, err := client.Check(); err != nil {
+		log.Global.Fatal("Can't establish connection to backend: ", err.Error())
+	}
+	return client
+}
+
+func backupCommand(args []string) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	configPath := flags.String("config", "config/config.json", "Path to config file")
+	out := flags.String("out", "", "Path of the archive to write")
+	bgsave := flags.Bool("bgsave", false, "Also ask Redis to save a snapshot to disk")
+	flags.Parse(args)
+	if *out == "" {
+		log.Global.Fatal("Missing -out")
+	}
+
+	client := commandStorage(*configPath)
+	defer client.Close()
+
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Global.Fatal("File error: ", err.Error())
+	}
+	keys, err := client.Backup(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*out)
+		log.Global.Fatal("Backup error: ", err.Error())
+	}
+	log.Global.WithFields(log.Fields{
+		"path": *out,
+		"keys": keys,
+	}).Info("Backup written")
+
+	if *bgsave {
+		reply, err := client.BgSave()
+		if err != nil {
+			log.Global.Fatal("Snapshot error: ", err.Error())
+		}
+		log.Global.WithField("reply", reply).Info("Redis snapshot started")
+	}
+}
+
+func restoreCommand(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	configPath := flags.String("config", "config/config.json", "Path to config file")
+	in := flags.String("in", "", "Path of the archive to restore")
+	flags.Parse(args)
+	if *in == "" {
+		log.Global.Fatal("Missing -in")
+	}
+
+	client := commandStorage(*configPath)
+	defer client.Close()
+
+	// Check the whole archive before writing any of it
+	file, err := os.Open(*in)
+	if err != nil {
+		log.Global.Fatal("File error: ", err.Error())
+	}
+	defer file.Close()
+	header, err := storage.ReadBackup(file, nil)
+	if err != nil {
+		log.Global.Fatal("Backup error: ", err.Error())
+	}
+	if header.Schema > storage.KeySchemaVersion {
+		log.Global.Fatalf("Backup has key schema %v, newer than %v", header.Schema, storage.KeySchemaVersion)
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		log.Global.Fatal("File error: ", err.Error())
+	}
+
+	keys, err := client.Restore(file)
+	if err != nil {
+		log.Global.Fatal("Restore error: ", err.Error())
+	}
+	log.Global.WithFields(log.Fields{
+		"path":   *in,
+		"keys":   keys,
+		"schema": header.Schema,
+	}).Info("Backup restored")
+	if keys > 0 && header.Schema < storage.KeySchemaVersion {
+		log.Global.Warn("Backup predates the current key schema, run the migrate command before starting the pool")
+	}
+}
+
+// migrateCommand upgrades the keys to the current schema. Every instance
+// sharing the database must be stopped first.
+func migrateCommand(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configPath := flags.String("config", "config/config.json", "Path to config file")
+	flags.Parse(args)
+
+	client := commandStorage(*configPath)
+	defer client.Close()
+
+	version, err := storage.MigrateSchema(client)
+	if err != nil {
+		log.Global.Fatal("Migration error: ", err.Error())
+	}
+	log.Global.WithField("version", version).Info("Storage schema is up to date")
+}
+
+func verifyCommand(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	configPath := flags.String("config", "config/config.json", "Path to config file")
+	in := flags.String("in", "", "Path of an archive to verify instead of the database")
+	flags.Parse(args)
+
+	var checks []*storage.FinanceCheck
+	if *in != "" {
+		file, err := os.Open(*in)
+		if err != nil {
+			log.Global.Fatal("File error: ", err.Error())
+		}
+		defer file.Close()
+		totals := storage.NewFinanceTotals()
+		_, err = storage.ReadBackup(file, func(entry *storage.BackupEntry) error {
+			totals.Add(entry)
+			return nil
+		})
+		if err != nil {
+			log.Global.Fatal("Backup error: ", err.Error())
+		}
+		checks = totals.Checks()
+	} else {
+		client := commandStorage(*configPath)
+		defer client.Close()
+		var err error
+		checks, err = client.VerifyFinances()
+		if err != nil {
+			log.Global.Fatal("Storage error: ", err.Error())
+		}
+	}
+
+	failed := false
+	for _, check := range checks {
+		status := "ok"
+		if !check.OK() {
+			status = "MISMATCH"
+			failed = true
+		}
+		fmt.Printf("%-10s finances %-22d miners %-22d %s\n", check.Field, check.Finances, check.Miners, status)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,127 @@
+package events
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+)
+
+const (
+	defaultBufferSize = 4096
+	maxBatchSize      = 256
+)
+
+const (
+	TypeShare = "share"
+	TypeBlock = "block"
+)
+
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Events buffered per sink before new ones are dropped
+	BufferSize int           `json:"bufferSize"`
+	File       FileConfig    `json:"file"`
+	Redis      RedisConfig   `json:"redis"`
+	Webhook    WebhookConfig `json:"webhook"`
+}
+
+// Event is a single share or block submitted over stratum.
+type Event struct {
+	Type       string   `json:"type"`
+	Timestamp  int64    `json:"timestamp"`
+	Login      string   `json:"login"`
+	Worker     string   `json:"worker"`
+	IP         string   `json:"ip"`
+	JobID      uint64   `json:"jobId"`
+	Height     []uint64 `json:"height,omitempty"`
+	SealHash   string   `json:"sealHash,omitempty"`
+	Difficulty int64    `json:"difficulty"`
+	Result     string   `json:"result"`
+	Reason     string   `json:"reason,omitempty"`
+	// Only set on blocks
+	Order *int `json:"order,omitempty"`
+}
+
+type Sink interface {
+	Name() string
+	Write(events []*Event) error
+}
+
+// Stream fans events out to every sink. Each sink has its own buffer and
+// worker so a slow sink never blocks the stratum submit path or the others.
+type Stream struct {
+	queues []chan *Event
+}
+
+func New(cfg *Config, backend storage.Backend) (*Stream, error) {
+	var sinks []Sink
+	if cfg.File.Enabled {
+		sink, err := NewFileSink(&cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if cfg.Redis.Enabled {
+		if backend == nil {
+			return nil, fmt.Errorf("redis event sink requires redis to be enabled")
+		}
+		sinks = append(sinks, NewRedisSink(&cfg.Redis, backend))
+	}
+	if cfg.Webhook.Enabled {
+		sinks = append(sinks, NewWebhookSink(&cfg.Webhook))
+	}
+
+	bufferSize := cfg.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+
+	s := &Stream{}
+	for _, sink := range sinks {
+		queue := make(chan *Event, bufferSize)
+		s.queues = append(s.queues, queue)
+		go run(sink, queue)
+		log.Printf("Streaming share events to %s sink", sink.Name())
+	}
+	return s, nil
+}
+
+// Emit never blocks. Events are dropped when a sink falls too far behind.
+func (s *Stream) Emit(e *Event) {
+	if s == nil {
+		return
+	}
+	if e.Timestamp == 0 {
+		e.Timestamp = time.Now().UnixMilli()
+	}
+	for _, queue := range s.queues {
+		select {
+		case queue <- e:
+		default:
+			metrics.EventsDropped.Inc()
+		}
+	}
+}
+
+func run(sink Sink, queue chan *Event) {
+	for e := range queue {
+		batch := []*Event{e}
+	drain:
+		for len(batch) < maxBatchSize {
+			select {
+			case e := <-queue:
+				batch = append(batch, e)
+			default:
+				break drain
+			}
+		}
+		err := sink.Write(batch)
+		if err != nil {
+			log.Printf("Failed to write %v events to %s sink: %v", len(batch), sink.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type blockingSink struct {
+	release chan struct{}
+}
+
+func (s *blockingSink) Name() string { return "blocking" }
+
+func (s *blockingSink) Write(events []*Event) error {
+	<-s.release
+	return nil
+}
+
+func TestEmitNeverBlocks(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	defer close(sink.release)
+
+	queue := make(chan *Event, 2)
+	go run(sink, queue)
+	s := &Stream{queues: []chan *Event{queue}}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			s.Emit(&Event{Type: TypeShare})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Emit must not block on a slow sink")
+	}
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shares.jsonl")
+	sink, err := NewFileSink(&FileConfig{Enabled: true, Path: path, MaxSize: 1, MaxBackups: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Event{Type: TypeShare, Login: "0x0", Result: "accepted", Height: []uint64{1, 2, 3}}
+	sink.Write([]*Event{e, e})
+
+	f, _ := os.Open(path)
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var decoded Event
+		if err := json.Unmarshal(scanner.Bytes(), &decoded); err != nil {
+			t.Fatalf("Must write one JSON event per line: %v", err)
+		}
+		lines++
+	}
+	f.Close()
+	if lines != 2 {
+		t.Errorf("Must append every event, got %v lines", lines)
+	}
+
+	// Force two rotations, only one backup is kept
+	sink.size = 1024 * 1024
+	sink.Write([]*Event{e})
+	time.Sleep(time.Millisecond)
+	sink.size = 1024 * 1024
+	sink.Write([]*Event{e})
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 1 {
+		t.Errorf("Must keep one rotated file, got %v", backups)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Error("Must reopen an empty file after rotation")
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	received := make(chan []*Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []*Event
+		json.NewDecoder(r.Body).Decode(&batch)
+		received <- batch
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(&WebhookConfig{Enabled: true, Url: server.URL, Timeout: "1s"})
+	order := 1
+	err := sink.Write([]*Event{{Type: TypeBlock, Order: &order}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := <-received
+	if len(batch) != 1 || batch[0].Order == nil || *batch[0].Order != 1 {
+		t.Errorf("Must post events as JSON array: %v", batch)
+	}
+}
@@ -0,0 +1,106 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type FileConfig struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"`
+	// Rotate once the file grows past this many megabytes
+	MaxSize int64 `json:"maxSize"`
+	// Rotated files to keep, 0 keeps all of them
+	MaxBackups int `json:"maxBackups"`
+}
+
+// FileSink appends events as JSON lines and rotates the file by size.
+type FileSink struct {
+	config *FileConfig
+	file   *os.File
+	size   int64
+}
+
+func NewFileSink(cfg *FileConfig) (*FileSink, error) {
+	if len(cfg.Path) == 0 {
+		return nil, fmt.Errorf("event file path is not set")
+	}
+	s := &FileSink{config: cfg}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) Name() string { return "file" }
+
+func (s *FileSink) Write(events []*Event) error {
+	w := bufio.NewWriter(s.file)
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	s.size += int64(w.Buffered())
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if s.config.MaxSize > 0 && s.size >= s.config.MaxSize*1024*1024 {
+		return s.rotate()
+	}
+	return nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	backup := fmt.Sprintf("%s.%s", s.config.Path, time.Now().UTC().Format("20060102T150405.000"))
+	if err := os.Rename(s.config.Path, backup); err != nil {
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	return s.pruneBackups()
+}
+
+func (s *FileSink) pruneBackups() error {
+	if s.config.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(s.config.Path + ".*")
+	if err != nil {
+		return err
+	}
+	// Timestamp suffixes sort chronologically
+	sort.Strings(backups)
+	for len(backups) > s.config.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package events
+
+import (
+	"encoding/json"
+
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+)
+
+type RedisConfig struct {
+	Enabled bool   `json:"enabled"`
+	Stream  string `json:"stream"`
+	// Approximate number of entries kept in the stream
+	MaxLen int64 `json:"maxLen"`
+}
+
+// RedisSink appends events to a Redis stream.
+type RedisSink struct {
+	config  *RedisConfig
+	backend storage.Backend
+}
+
+func NewRedisSink(cfg *RedisConfig, backend storage.Backend) *RedisSink {
+	return &RedisSink{config: cfg, backend: backend}
+}
+
+func (s *RedisSink) Name() string { return "redis" }
+
+func (s *RedisSink) Write(events []*Event) error {
+	entries := make([]string, 0, len(events))
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, string(data))
+	}
+	return s.backend.WriteEvents(s.config.Stream, s.config.MaxLen, entries)
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+type WebhookConfig struct {
+	Enabled bool   `json:"enabled"`
+	Url     string `json:"url"`
+	Timeout string `json:"timeout"`
+}
+
+// WebhookSink posts every batch of events as a JSON array.
+type WebhookSink struct {
+	config *WebhookConfig
+	client *http.Client
+}
+
+func NewWebhookSink(cfg *WebhookConfig) *WebhookSink {
+	timeout := util.MustParseDuration(cfg.Timeout)
+	return &WebhookSink{config: cfg, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *WebhookSink) Name() string { return "webhook" }
+
+func (s *WebhookSink) Write(events []*Event) error {
+	data, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.config.Url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook replied with %v", resp.Status)
+	}
+	return nil
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"os"
 	"runtime"
 	"strconv"
-
-	"github.com/J-A-M-P-S/structs"
+	"strings"
+	"sync"
 
 	"github.com/dominant-strategies/go-quai-stratum/api"
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
+	"github.com/dominant-strategies/go-quai-stratum/payouts"
 	"github.com/dominant-strategies/go-quai-stratum/proxy"
 	"github.com/dominant-strategies/go-quai-stratum/storage"
 	"github.com/dominant-strategies/go-quai-stratum/util"
@@ -20,59 +24,189 @@ import (
 )
 
 var cfg proxy.Config
-var backend *storage.RedisClient
+var backend storage.Backend
+var reconciler *payouts.Reconciler
+
+// shutdown is cancelled on SIGINT or SIGTERM. The unlocker and payer stop
+// then and are waited for before exiting.
+var (
+	shutdown, stopWorkers = context.WithCancel(context.Background())
+	workers               sync.WaitGroup
+)
+
+var migrateSchema = flag.Bool("migrate", false, "Upgrade storage keys to the current schema and exit")
 
 func startProxy() {
 	s := proxy.NewProxy(&cfg, backend)
+	s.SetConfigReloader(running)
+	running.setProxy(s)
 	s.Start()
 }
 
+func startBlockUnlocker() {
+	u := payouts.NewBlockUnlocker(&cfg.BlockUnlocker, backend)
+	goWorker(func() { u.Start(shutdown) })
+}
+
+func startPayoutsProcessor() {
+	u := payouts.NewPayoutsProcessor(&cfg.Payouts, backend)
+	goWorker(func() { u.Start(shutdown) })
+}
+
+func goWorker(run func()) {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		run()
+	}()
+}
+
+func startReconciler() {
+	reconciler = payouts.NewReconciler(&cfg.Reconciler, backend)
+	go reconciler.Start()
+}
+
 func startApi() {
-	settings := structs.Map(&cfg)
-	s := api.NewApiServer(&cfg.Api, settings, backend)
+	s := api.NewApiServer(&cfg.Api, apiSettings(&cfg), backend)
+	if reconciler != nil {
+		s.SetLedgerReporter(reconciler)
+	}
+	s.SetConfigReloader(running)
+	running.setApi(s)
 	s.Start()
 }
 
+// apiSettings picks the parts of the config published by the API.
+func apiSettings(cfg *proxy.Config) *api.Settings {
+	settings := &api.Settings{
+		EthProxy:     cfg.Proxy.Enabled,
+		EthProxyPool: cfg.Proxy.Listen,
+		Stratum:      cfg.Proxy.Stratum.Enabled,
+		StratumPool:  cfg.Proxy.Stratum.Listen,
+		RewardScheme: cfg.Rewards.Scheme,
+		PPLNSWindow:  cfg.Rewards.PPLNSWindow,
+		AllowSolo:    cfg.Rewards.AllowSolo,
+		PoolFee:      cfg.BlockUnlocker.PoolFee,
+		DonationFee:  cfg.BlockUnlocker.DonationFee,
+		FeeOverrides: cfg.BlockUnlocker.FeeOverrides,
+	}
+	if cfg.Proxy.Difficulty != nil {
+		settings.Difficulty = cfg.Proxy.Difficulty.String()
+	}
+	for _, upstream := range cfg.Upstream {
+		settings.Upstream = append(settings.Upstream, api.Upstream{
+			Name:    upstream.Name,
+			Url:     upstream.Url,
+			Timeout: upstream.Timeout,
+		})
+	}
+	return settings
+}
+
 func readConfig(cfg *proxy.Config) {
 	configPath := flag.String("config", "config/config.json", "Path to config file")
-	primePort := flag.String("prime", "", "Prime upstream port (overrides config)")
-	regionPort := flag.String("region", "", "Region upstream port (overrides config)")
-	zonePort := flag.String("zone", "", "Zone upstream port (overrides config)")
-
-	stratumPort := flag.Int("stratum", -1, "Stratum listen port (overrides config)")
+	upstream := upstreamFlags(flag.CommandLine)
 
 	flag.Parse()
 
+	loadConfig(*configPath, cfg)
+	upstream.apply(cfg)
+	validateConfig(cfg, cfg.EnabledRoles())
+	running.configure(*configPath, cfg.EnabledRoles(), upstream)
+}
+
+// upstreamOverrides are the command line settings taking precedence over the
+// upstreams and stratum port of the config.
+type upstreamOverrides struct {
+	prime   *string
+	region  *string
+	zone    *string
+	stratum *int
+}
+
+func upstreamFlags(flags *flag.FlagSet) *upstreamOverrides {
+	return &upstreamOverrides{
+		prime:   flags.String("prime", "", "Prime upstream port (overrides config)"),
+		region:  flags.String("region", "", "Region upstream port (overrides config)"),
+		zone:    flags.String("zone", "", "Zone upstream port (overrides config)"),
+		stratum: flags.Int("stratum", -1, "Stratum listen port (overrides config)"),
+	}
+}
+
+func (o *upstreamOverrides) apply(cfg *proxy.Config) {
+	// Left to validation to report
+	if len(cfg.Upstream) != common.HierarchyDepth {
+		return
+	}
+	// Perform custom overrides. Default means they weren't set on the command line.
+	if *o.prime != "" {
+		cfg.Upstream[common.PRIME_CTX].Name = *o.prime
+		cfg.Upstream[common.PRIME_CTX].Url = "ws://127.0.0.1:" + returnPortHelper(*o.prime)
+	}
+	if *o.region != "" {
+		cfg.Upstream[common.REGION_CTX].Name = *o.region
+		cfg.Upstream[common.REGION_CTX].Url = "ws://127.0.0.1:" + returnPortHelper(*o.region)
+	}
+	if *o.zone != "" {
+		cfg.Upstream[common.ZONE_CTX].Name = *o.zone
+		cfg.Upstream[common.ZONE_CTX].Url = "ws://127.0.0.1:" + returnPortHelper(*o.zone)
+	}
+	if *o.stratum != -1 {
+		cfg.Proxy.Stratum.Listen = "0.0.0.0:" + strconv.Itoa(*o.stratum)
+	}
+}
+
+func loadConfig(path string, cfg *proxy.Config) {
 	log.Global.WithField(
-		"path", *configPath,
+		"path", path,
 	).Info("Loading config")
 
-	// Read config file.
-	configFile, err := os.Open(*configPath)
+	if err := readConfigFile(path, cfg); err != nil {
+		fatalConfig(err)
+	}
+}
+
+// readConfigFile decodes the config at path, applies the environment
+// overrides and fills in defaults.
+func readConfigFile(path string, cfg *proxy.Config) error {
+	configFile, err := os.Open(path)
 	if err != nil {
-		log.Global.Fatal("File error: ", err.Error())
+		return err
 	}
 	defer configFile.Close()
 	jsonParser := json.NewDecoder(configFile)
 	if err := jsonParser.Decode(&cfg); err != nil {
-		log.Global.Fatal("Config error: ", err.Error())
+		return err
 	}
-
-	// Perform custom overrides. Default means they weren't set on the command line.
-	if primePort != nil && *primePort != "" {
-		cfg.Upstream[common.PRIME_CTX].Name = *primePort
-		cfg.Upstream[common.PRIME_CTX].Url = "ws://127.0.0.1:" + returnPortHelper(*primePort)
+	if err := cfg.ApplyEnv(os.Environ()); err != nil {
+		return err
 	}
-	if regionPort != nil && *regionPort != "" {
-		cfg.Upstream[common.REGION_CTX].Name = *regionPort
-		cfg.Upstream[common.REGION_CTX].Url = "ws://127.0.0.1:" + returnPortHelper(*regionPort)
+	cfg.SetDefaults()
+	return nil
+}
+
+// validateConfig checks the parts of the config used by roles and exits
+// listing every problem found.
+func validateConfig(cfg *proxy.Config, roles proxy.Roles) {
+	if err := cfg.Validate(roles); err != nil {
+		fatalConfig(err)
 	}
-	if zonePort != nil && *zonePort != "" {
-		cfg.Upstream[common.ZONE_CTX].Name = *zonePort
-		cfg.Upstream[common.ZONE_CTX].Url = "ws://127.0.0.1:" + returnPortHelper(*zonePort)
+}
+
+func fatalConfig(err error) {
+	logConfigError(err)
+	log.Global.Fatal("Invalid config")
+}
+
+// logConfigError logs every problem of a ValidationError on its own line.
+func logConfigError(err error) {
+	var invalid *proxy.ValidationError
+	if !errors.As(err, &invalid) {
+		log.Global.Error("Config error: ", err.Error())
+		return
 	}
-	if *stratumPort != -1 {
-		cfg.Proxy.Stratum.Listen = "0.0.0.0:" + strconv.Itoa(*stratumPort)
+	for _, problem := range invalid.Problems {
+		log.Global.Error("Config error: ", problem)
 	}
 }
 
@@ -92,23 +226,82 @@ func returnPortHelper(locName string) string {
 func init() {
 }
 
-func main() {
-	readConfig(&cfg)
-
+func setThreads() {
 	if cfg.Threads > 0 {
 		runtime.GOMAXPROCS(cfg.Threads)
 		log.Global.WithField(
 			"threads", cfg.Threads,
 		).Debug("Threads running")
 	}
+}
+
+func openBackend() {
+	var err error
+	backend, err = storage.NewBackend(&cfg.Storage, &cfg.Redis, cfg.Coin)
+	if err != nil {
+		log.Global.Fatal("Config error: ", err.Error())
+	}
+	scheme, err := storage.NewRewardScheme(&cfg.Rewards)
+	if err != nil {
+		log.Global.Fatal("Config error: ", err.Error())
+	}
+	backend.SetRewardScheme(scheme)
+	pong, err := backend.Check()
+	if err != nil {
+		log.Global.WithField("err", err).Error("Can't establish connection to backend")
+	} else {
+		log.Global.WithField("reply", pong).Info("Backend check reply")
+	}
+}
+
+func checkSchema() {
+	if err := storage.CheckSchema(backend); err != nil {
+		log.Global.Fatal("Storage error: ", err.Error())
+	}
+}
+
+func main() {
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+	readConfig(&cfg)
+	setLogLevel(cfg.LogLevel)
+	setThreads()
 
+	if *migrateSchema && !cfg.HasStorage() {
+		log.Global.Fatal("Unable to migrate, no storage is configured")
+	}
+	if cfg.HasStorage() {
+		openBackend()
+		if *migrateSchema {
+			version, err := storage.MigrateSchema(backend)
+			if err != nil {
+				log.Global.Fatal("Migration error: ", err.Error())
+			}
+			log.Global.WithField("version", version).Info("Storage schema is up to date")
+			return
+		}
+		checkSchema()
+	}
+
+	if cfg.Metrics.Enabled {
+		go metrics.Start(&cfg.Metrics)
+	}
+	if cfg.Reconciler.Enabled {
+		startReconciler()
+	}
 	if cfg.Proxy.Enabled {
 		go startProxy()
 	}
 	if cfg.Api.Enabled {
 		go startApi()
 	}
-
-	quit := make(chan bool)
-	<-quit
+	if cfg.BlockUnlocker.Enabled {
+		startBlockUnlocker()
+	}
+	if cfg.Payouts.Enabled {
+		startPayoutsProcessor()
+	}
+	wait()
 }
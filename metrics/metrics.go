@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "quai_stratum"
+
+type Config struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen"`
+}
+
+// Share outcomes
+const (
+	ShareAccepted = "accepted"
+	ShareRejected = "rejected"
+	ShareStale    = "stale"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	Sessions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "sessions",
+		Help:      "Number of connected stratum sessions.",
+	})
+
+	Shares = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "shares_total",
+		Help:      "Submitted shares by status and reason.",
+	}, []string{"status", "reason"})
+
+	BlocksFound = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "blocks_found_total",
+		Help:      "Blocks accepted by the nodes by order.",
+	}, []string{"order"})
+
+	BlocksRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "blocks_rejected_total",
+		Help:      "Headers meeting the block target that the nodes rejected.",
+	})
+
+	templateCreatedAt atomic.Int64
+
+	TemplateAge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "template_age_seconds",
+		Help:      "Time since the current block template was created.",
+	}, func() float64 {
+		createdAt := templateCreatedAt.Load()
+		if createdAt == 0 {
+			return 0
+		}
+		return time.Since(time.Unix(0, createdAt)).Seconds()
+	})
+
+	JobBroadcast = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "job_broadcast_seconds",
+		Help:      "Time from template creation until a job reached a miner.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	})
+
+	UpstreamLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "request_duration_seconds",
+		Help:      "Upstream node RPC latency by hierarchy level and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"level", "method"})
+
+	UpstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "errors_total",
+		Help:      "Failed upstream node RPCs by hierarchy level and method.",
+	}, []string{"level", "method"})
+
+	PolicyBans = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "policy",
+		Name:      "bans_total",
+		Help:      "Banned IP addresses by reason.",
+	}, []string{"reason"})
+
+	RedisLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "redis",
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of backend operations.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5},
+	}, []string{"op"})
+
+	EventsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "events",
+		Name:      "dropped_total",
+		Help:      "Share events dropped because a sink fell behind.",
+	})
+
+	ApiLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "api",
+		Name:      "request_duration_seconds",
+		Help:      "API request latency by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+
+	LedgerDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "ledger",
+		Name:      "drift_shannon",
+		Help:      "Totals recorded in finances minus the totals computed from the records, by check.",
+	}, []string{"check"})
+
+	LedgerProblems = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "ledger",
+		Name:      "problems",
+		Help:      "Problems found by the last reconciliation: failed checks, blocks and payments.",
+	}, []string{"kind"})
+
+	LedgerReconciled = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "ledger",
+		Name:      "reconciled_timestamp_seconds",
+		Help:      "Time of the last reconciliation.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Sessions,
+		Shares,
+		BlocksFound,
+		BlocksRejected,
+		TemplateAge,
+		JobBroadcast,
+		UpstreamLatency,
+		UpstreamErrors,
+		PolicyBans,
+		RedisLatency,
+		EventsDropped,
+		ApiLatency,
+		LedgerDrift,
+		LedgerProblems,
+		LedgerReconciled,
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+func Start(cfg *Config) {
+	log.Printf("Starting metrics on %v", cfg.Listen)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	err := http.ListenAndServe(cfg.Listen, mux)
+	if err != nil {
+		log.Fatalf("Failed to start metrics: %v", err)
+	}
+}
+
+func SetTemplateCreated(t time.Time) {
+	templateCreatedAt.Store(t.UnixNano())
+}
+
+// ObserveUpstream records the latency of an upstream RPC started at start.
+func ObserveUpstream(level, method string, start time.Time, err error) {
+	UpstreamLatency.WithLabelValues(level, method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		UpstreamErrors.WithLabelValues(level, method).Inc()
+	}
+}
+
+// ObserveRedis is meant to be deferred: defer metrics.ObserveRedis("op", time.Now())
+func ObserveRedis(op string, start time.Time) {
+	RedisLatency.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	Shares.WithLabelValues(ShareRejected, "duplicate").Inc()
+	ObserveUpstream("zone", "calcOrder", time.Now(), errors.New("timeout"))
+	SetTemplateCreated(time.Now())
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Result().Body)
+
+	for _, metric := range []string{
+		`quai_stratum_proxy_shares_total{reason="duplicate",status="rejected"} 1`,
+		`quai_stratum_upstream_errors_total{level="zone",method="calcOrder"} 1`,
+		`quai_stratum_proxy_template_age_seconds`,
+		`quai_stratum_proxy_sessions 0`,
+	} {
+		if !strings.Contains(string(body), metric) {
+			t.Errorf("Must expose %s", metric)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+const queueSize = 256
+
+// Used when the config leaves them empty
+const (
+	defaultTimeout     = 5 * time.Second
+	defaultRetryDelay  = 2 * time.Second
+	defaultDedupWindow = 10 * time.Minute
+)
+
+// Alert kinds
+const (
+	AlertBlock             = "block"
+	AlertUpstreamUnhealthy = "upstream_unhealthy"
+	AlertUpstreamRecovered = "upstream_recovered"
+	AlertSessionsDrop      = "sessions_drop"
+)
+
+type Config struct {
+	Enabled  bool            `json:"enabled"`
+	Webhooks []WebhookConfig `json:"webhooks"`
+	Timeout  string          `json:"timeout"`
+	// Failed deliveries are retried with exponential backoff
+	Retries    int    `json:"retries"`
+	RetryDelay string `json:"retryDelay"`
+	// Alerts with the same key are sent once per window
+	DedupWindow string            `json:"dedupWindow"`
+	SessionDrop SessionDropConfig `json:"sessionDrop"`
+}
+
+type WebhookConfig struct {
+	Url string `json:"url"`
+	// Go text/template rendered with the alert, JSON of the alert if empty
+	Template    string `json:"template"`
+	ContentType string `json:"contentType"`
+	// Alert kinds sent to this hook, all of them if empty
+	Kinds []string `json:"kinds"`
+}
+
+type Alert struct {
+	Kind      string                 `json:"kind"`
+	Message   string                 `json:"message"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+	Timestamp int64                  `json:"timestamp"`
+	// Dedup key, defaults to the kind
+	Key string `json:"-"`
+}
+
+type webhook struct {
+	config   *WebhookConfig
+	template *template.Template
+}
+
+type Notifier struct {
+	config      *Config
+	webhooks    []*webhook
+	client      *http.Client
+	queue       chan *Alert
+	retryDelay  time.Duration
+	dedupWindow time.Duration
+	seenMu      sync.Mutex
+	seen        map[string]time.Time
+}
+
+func New(cfg *Config) (*Notifier, error) {
+	timeout, err := parseDuration("timeout", cfg.Timeout, defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+	n := &Notifier{
+		config: cfg,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan *Alert, queueSize),
+		seen:   make(map[string]time.Time),
+	}
+	if n.retryDelay, err = parseDuration("retry delay", cfg.RetryDelay, defaultRetryDelay); err != nil {
+		return nil, err
+	}
+	if n.dedupWindow, err = parseDuration("dedup window", cfg.DedupWindow, defaultDedupWindow); err != nil {
+		return nil, err
+	}
+	for i := range cfg.Webhooks {
+		hook := &webhook{config: &cfg.Webhooks[i]}
+		if len(hook.config.Template) > 0 {
+			tmpl, err := template.New(hook.config.Url).Parse(hook.config.Template)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template for webhook %v: %v", hook.config.Url, err)
+			}
+			hook.template = tmpl
+		}
+		n.webhooks = append(n.webhooks, hook)
+	}
+
+	go func() {
+		for alert := range n.queue {
+			n.deliver(alert)
+		}
+	}()
+	log.Printf("Sending alerts to %v webhooks", len(n.webhooks))
+	return n, nil
+}
+
+func parseDuration(name, value string, fallback time.Duration) (time.Duration, error) {
+	if len(value) == 0 {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %v", name, value, err)
+	}
+	return d, nil
+}
+
+// Notify queues an alert unless one with the same key was sent within the
+// dedup window. It never blocks, alerts are dropped when the queue is full.
+func (n *Notifier) Notify(alert *Alert) {
+	if n == nil {
+		return
+	}
+	if alert.Timestamp == 0 {
+		alert.Timestamp = time.Now().Unix()
+	}
+	if len(alert.Key) == 0 {
+		alert.Key = alert.Kind
+	}
+	if n.isDuplicate(alert.Key) {
+		return
+	}
+	select {
+	case n.queue <- alert:
+	default:
+		log.Printf("Alert queue is full, dropping %s alert", alert.Kind)
+	}
+}
+
+func (n *Notifier) isDuplicate(key string) bool {
+	n.seenMu.Lock()
+	defer n.seenMu.Unlock()
+
+	now := time.Now()
+	for k, at := range n.seen {
+		if now.Sub(at) >= n.dedupWindow {
+			delete(n.seen, k)
+		}
+	}
+	if _, ok := n.seen[key]; ok {
+		return true
+	}
+	n.seen[key] = now
+	return false
+}
+
+func (n *Notifier) deliver(alert *Alert) {
+	for _, hook := range n.webhooks {
+		if !hook.accepts(alert.Kind) {
+			continue
+		}
+		body, err := hook.render(alert)
+		if err != nil {
+			log.Printf("Failed to render %s alert for %v: %v", alert.Kind, hook.config.Url, err)
+			continue
+		}
+		n.send(hook, alert.Kind, body, 0, n.retryDelay)
+	}
+}
+
+// send posts body to hook and schedules a retry with exponential backoff if
+// that fails, so a dead webhook doesn't hold up later alerts.
+func (n *Notifier) send(hook *webhook, kind string, body []byte, attempt int, delay time.Duration) {
+	err := n.post(hook, body)
+	if err == nil {
+		return
+	}
+	if attempt >= n.config.Retries {
+		log.Printf("Failed to send %s alert to %v after %v attempts: %v", kind, hook.config.Url, attempt+1, err)
+		return
+	}
+	time.AfterFunc(delay, func() {
+		n.send(hook, kind, body, attempt+1, delay*2)
+	})
+}
+
+func (n *Notifier) post(hook *webhook, body []byte) error {
+	contentType := hook.config.ContentType
+	if len(contentType) == 0 {
+		contentType = "application/json"
+	}
+	resp, err := n.client.Post(hook.config.Url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook replied with %v", resp.Status)
+	}
+	return nil
+}
+
+func (w *webhook) accepts(kind string) bool {
+	return len(w.config.Kinds) == 0 || util.StringInSlice(kind, w.config.Kinds)
+}
+
+func (w *webhook) render(alert *Alert) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(alert)
+	}
+	var buf bytes.Buffer
+	err := w.template.Execute(&buf, alert)
+	return buf.Bytes(), err
+}
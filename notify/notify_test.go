@@ -0,0 +1,156 @@
+package notify
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hookServer stands in for a webhook receiver failing the first fails requests.
+func hookServer(fails int32) (*httptest.Server, chan string, *int32) {
+	received := make(chan string, 16)
+	calls := new(int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(calls, 1) <= fails {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	return server, received, calls
+}
+
+func newTestNotifier(t *testing.T, hooks ...WebhookConfig) *Notifier {
+	n, err := New(&Config{
+		Enabled:     true,
+		Webhooks:    hooks,
+		Timeout:     "1s",
+		Retries:     2,
+		RetryDelay:  "1ms",
+		DedupWindow: "1m",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func receive(t *testing.T, received chan string) string {
+	select {
+	case body := <-received:
+		return body
+	case <-time.After(time.Second):
+		t.Fatal("Webhook was not called")
+	}
+	return ""
+}
+
+func TestNotifyRetry(t *testing.T) {
+	server, received, calls := hookServer(2)
+	defer server.Close()
+
+	n := newTestNotifier(t, WebhookConfig{Url: server.URL, Template: "{{.Kind}}: {{.Message}}"})
+	n.Notify(&Alert{Kind: AlertBlock, Key: "0x1", Message: "Found a prime block"})
+
+	if body := receive(t, received); body != "block: Found a prime block" {
+		t.Errorf("Must render template: %q", body)
+	}
+	if atomic.LoadInt32(calls) != 3 {
+		t.Errorf("Must retry failed deliveries, got %v calls", atomic.LoadInt32(calls))
+	}
+}
+
+func TestNotifyRetryInBackground(t *testing.T) {
+	dead, _, _ := hookServer(1000)
+	defer dead.Close()
+	server, received, _ := hookServer(0)
+	defer server.Close()
+
+	n, err := New(&Config{
+		Enabled: true,
+		Webhooks: []WebhookConfig{
+			{Url: dead.URL, Kinds: []string{AlertSessionsDrop}},
+			{Url: server.URL, Kinds: []string{AlertBlock}},
+		},
+		Retries:    2,
+		RetryDelay: "1h",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Notify(&Alert{Kind: AlertSessionsDrop})
+	n.Notify(&Alert{Kind: AlertBlock, Key: "0x4"})
+
+	// Must not wait for the retries of the dead hook
+	receive(t, received)
+}
+
+func TestNotifyDefaults(t *testing.T) {
+	n, err := New(&Config{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.client.Timeout != defaultTimeout || n.retryDelay != defaultRetryDelay || n.dedupWindow != defaultDedupWindow {
+		t.Errorf("Must use defaults for empty durations: %v %v %v", n.client.Timeout, n.retryDelay, n.dedupWindow)
+	}
+	if _, err := New(&Config{Enabled: true, Timeout: "soon"}); err == nil {
+		t.Error("Must reject invalid durations")
+	}
+}
+
+func TestNotifyDedup(t *testing.T) {
+	server, received, calls := hookServer(0)
+	defer server.Close()
+
+	n := newTestNotifier(t, WebhookConfig{Url: server.URL})
+	n.Notify(&Alert{Kind: AlertUpstreamUnhealthy})
+	n.Notify(&Alert{Kind: AlertUpstreamUnhealthy})
+	n.Notify(&Alert{Kind: AlertBlock, Key: "0x2"})
+
+	receive(t, received)
+	receive(t, received)
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(calls) != 2 {
+		t.Errorf("Must send duplicate alerts once, got %v calls", atomic.LoadInt32(calls))
+	}
+}
+
+func TestNotifyKinds(t *testing.T) {
+	server, received, calls := hookServer(0)
+	defer server.Close()
+
+	n := newTestNotifier(t, WebhookConfig{Url: server.URL, Kinds: []string{AlertBlock}})
+	n.Notify(&Alert{Kind: AlertSessionsDrop})
+	n.Notify(&Alert{Kind: AlertBlock, Key: "0x3"})
+
+	receive(t, received)
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(calls) != 1 {
+		t.Errorf("Must only send subscribed kinds, got %v calls", atomic.LoadInt32(calls))
+	}
+}
+
+func TestDropDetector(t *testing.T) {
+	d := NewDropDetector(&SessionDropConfig{Percent: 50, MinSessions: 10}, time.Minute)
+	now := time.Now()
+
+	d.Observe(100, now)
+	if _, dropped := d.Observe(60, now.Add(time.Second)); dropped {
+		t.Error("Must not alert below threshold")
+	}
+	peak, dropped := d.Observe(40, now.Add(2*time.Second))
+	if !dropped || peak != 100 {
+		t.Errorf("Must alert on drop from peak, got %v %v", peak, dropped)
+	}
+	if _, dropped := d.Observe(30, now.Add(3*time.Second)); dropped {
+		t.Error("Must alert once per drop")
+	}
+	d.Observe(100, now.Add(4*time.Second))
+	if _, dropped := d.Observe(10, now.Add(2*time.Minute)); dropped {
+		t.Error("Must forget peaks outside the window")
+	}
+}
@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"sync"
+	"time"
+)
+
+type SessionDropConfig struct {
+	Enabled bool `json:"enabled"`
+	// Alert when sessions fall this many percent below the recent peak
+	Percent float64 `json:"percent"`
+	// How long a peak is remembered
+	Window string `json:"window"`
+	// Ignore drops from a peak smaller than this
+	MinSessions int `json:"minSessions"`
+}
+
+// DropDetector tracks the peak session count over a window and reports
+// sharp drops from it.
+type DropDetector struct {
+	sync.Mutex
+	config *SessionDropConfig
+	window time.Duration
+	peak   int
+	peakAt time.Time
+}
+
+func NewDropDetector(cfg *SessionDropConfig, window time.Duration) *DropDetector {
+	return &DropDetector{config: cfg, window: window}
+}
+
+// Observe records the current session count and returns the peak it
+// dropped from when the drop crosses the threshold.
+func (d *DropDetector) Observe(n int, now time.Time) (int, bool) {
+	d.Lock()
+	defer d.Unlock()
+
+	if n >= d.peak || now.Sub(d.peakAt) > d.window {
+		d.peak = n
+		d.peakAt = now
+		return 0, false
+	}
+	if d.peak < d.config.MinSessions {
+		return 0, false
+	}
+	if float64(d.peak-n) < float64(d.peak)*d.config.Percent/100 {
+		return 0, false
+	}
+	// Start over from here so a single drop alerts once
+	peak := d.peak
+	d.peak = n
+	d.peakAt = now
+	return peak, true
+}
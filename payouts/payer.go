@@ -0,0 +1,488 @@
+package payouts
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common/hexutil"
+
+	"github.com/dominant-strategies/go-quai-stratum/rpc"
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+var (
+	txCheckInterval = 5 * time.Second
+	// A payout tx not mined by then was most likely dropped
+	txConfirmTimeout = 30 * time.Minute
+	// Longest a payer may take from locking a payout to logging it
+	payoutsLockTTL = 10 * time.Minute
+)
+
+type PayoutsConfig struct {
+	Enabled      bool   `json:"enabled"`
+	RequirePeers int64  `json:"requirePeers"`
+	Interval     string `json:"interval"`
+	Daemon       string `json:"daemon"`
+	Timeout      string `json:"timeout"`
+	Address      string `json:"address"`
+	Gas          string `json:"gas"`
+	GasPrice     string `json:"gasPrice"`
+	// In Shannon
+	Threshold int64 `json:"threshold"`
+	BgSave    bool  `json:"bgsave"`
+	// Walk through payees and query the node without sending anything
+	DryRun bool `json:"dryRun"`
+}
+
+func (self PayoutsConfig) GasHex() string {
+	x := util.String2Big(self.Gas)
+	return hexutil.EncodeBig(x)
+}
+
+func (self PayoutsConfig) GasPriceHex() string {
+	x := util.String2Big(self.GasPrice)
+	return hexutil.EncodeBig(x)
+}
+
+type PayoutsProcessor struct {
+	config  *PayoutsConfig
+	backend storage.Backend
+	rpc     *rpc.RPCClient
+	// Cancelled on shutdown
+	ctx context.Context
+	// Owner of the payouts lock taken by this process
+	id       string
+	halt     bool
+	lastFail error
+}
+
+func NewPayoutsProcessor(cfg *PayoutsConfig, backend storage.Backend) *PayoutsProcessor {
+	u := &PayoutsProcessor{config: cfg, backend: backend, ctx: context.Background(), id: payerID()}
+	u.rpc = rpc.NewRPCClient("PayoutsProcessor", cfg.Daemon, cfg.Timeout)
+	return u
+}
+
+// payerID tells payers apart in the payouts lock, even on the same host.
+func payerID() string {
+	host, _ := os.Hostname()
+	nonce := make([]byte, 4)
+	rand.Read(nonce)
+	return fmt.Sprintf("%s-%d-%x", strings.ReplaceAll(host, ":", "-"), os.Getpid(), nonce)
+}
+
+// heldByOther tells whether the payouts lock belongs to another payer which
+// may still be running.
+func (u *PayoutsProcessor) heldByOther(lock *storage.PayoutsLock) bool {
+	return lock != nil && lock.Owner != u.id && !lock.Expired(util.MakeTimestamp()/1000)
+}
+
+// sentPayment tells whether v is the payment whose tx hash was recorded in
+// the lock, i.e. the tx was accepted by the node.
+func sentPayment(lock *storage.PayoutsLock, v *storage.PendingPayment) bool {
+	return lock != nil && len(lock.TxHash) > 0 && v.Address == lock.Login && v.Amount == lock.Amount
+}
+
+// Start runs payouts until ctx is cancelled. A payment in progress is
+// finished first.
+func (u *PayoutsProcessor) Start(ctx context.Context) {
+	u.ctx = ctx
+	u.backend = u.backend.WithContext(ctx)
+	log.Println("Starting payouts")
+	if u.config.DryRun {
+		log.Println("Payouts are running in dry-run mode, no transactions will be sent")
+	}
+
+	if u.mustResolvePayout() {
+		log.Println("Running with env RESOLVE_PAYOUT=1, now trying to resolve locked payouts")
+		u.resolvePayouts()
+		log.Println("Now you have to restart payouts module with RESOLVE_PAYOUT=0 for normal run")
+		return
+	}
+
+	intv := util.MustParseDuration(u.config.Interval)
+	timer := time.NewTimer(intv)
+	log.Printf("Set payouts interval to %v", intv)
+
+	if !u.recoverPayouts() {
+		return
+	}
+
+	// Immediately process payouts after start
+	u.process()
+	timer.Reset(intv)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopped payouts")
+			return
+		case <-timer.C:
+			u.process()
+			timer.Reset(intv)
+		}
+	}
+}
+
+// recoverPayouts finishes payments that were sent before a crash and reports
+// whether payouts can be started. Nothing is touched while another payer
+// holds the lock.
+func (u *PayoutsProcessor) recoverPayouts() bool {
+	lock, err := u.backend.GetPayoutsLock()
+	if err != nil {
+		log.Println("Unable to start payouts:", err)
+		return false
+	}
+	if u.heldByOther(lock) {
+		log.Printf("Unable to start payouts, payer %s holds the lock for %s until %v",
+			lock.Owner, lock.Login, time.Unix(lock.ExpiresAt, 0))
+		return false
+	}
+
+	var unresolved []*storage.PendingPayment
+	for _, v := range u.backend.GetPendingPayments() {
+		if !sentPayment(lock, v) {
+			unresolved = append(unresolved, v)
+			continue
+		}
+		// The tx was accepted by the node, only the ledger update is missing.
+		err := u.backend.WritePayment(v.Address, lock.TxHash, v.Amount)
+		if err != nil {
+			log.Printf("Failed to recover payment for %s, %v Shannon, tx: %s: %v", v.Address, v.Amount, lock.TxHash, err)
+			return false
+		}
+		log.Printf("Recovered payment of %v Shannon to %v, TxHash: %v", v.Amount, v.Address, lock.TxHash)
+	}
+
+	if len(unresolved) > 0 {
+		log.Printf("Previous payout failed, you have to resolve it. List of failed payments:\n %v",
+			formatPendingPayments(unresolved))
+		return false
+	}
+
+	locked, err := u.backend.IsPayoutsLocked()
+	if err != nil {
+		log.Println("Unable to start payouts:", err)
+		return false
+	}
+	if locked {
+		// The lock expired or is ours and nothing was debited yet, so it
+		// is safe to drop.
+		log.Printf("Releasing stale payouts lock for %s, %v Shannon", lock.Login, lock.Amount)
+		err = u.backend.UnlockPayouts()
+		if err != nil {
+			log.Println("Failed to unlock payouts:", err)
+			return false
+		}
+	}
+	return true
+}
+
+func (u *PayoutsProcessor) process() {
+	if u.halt {
+		log.Println("Payments suspended due to last critical error:", u.lastFail)
+		return
+	}
+	mustPay := 0
+	minersPaid := 0
+	totalAmount := big.NewInt(0)
+	payees, err := u.backend.GetPayees()
+	if err != nil {
+		log.Println("Error while retrieving payees from backend:", err)
+		return
+	}
+
+	for _, login := range payees {
+		if u.ctx.Err() != nil {
+			log.Println("Shutting down, leaving further payouts to the next run")
+			break
+		}
+		amount, err := u.backend.GetBalance(login)
+		if err != nil {
+			log.Printf("Failed to get balance of %s, leaving further payouts to the next run: %v", login, err)
+			break
+		}
+		amountInShannon := big.NewInt(amount)
+
+		// Shannon^2 = Wei
+		amountInWei := new(big.Int).Mul(amountInShannon, util.Shannon)
+
+		if !u.reachedThreshold(amountInShannon) {
+			continue
+		}
+		mustPay++
+
+		// Require active peers before processing
+		if !u.checkPeers() {
+			break
+		}
+
+		// Check if we have enough funds
+		poolBalance, err := u.rpc.GetBalance(u.config.Address)
+		if err != nil {
+			u.halt = true
+			u.lastFail = err
+			break
+		}
+		if poolBalance.Cmp(amountInWei) < 0 {
+			err := fmt.Errorf("not enough balance for payment, need %s Wei, pool has %s Wei",
+				amountInWei.String(), poolBalance.String())
+			u.halt = true
+			u.lastFail = err
+			break
+		}
+
+		if u.config.DryRun {
+			minersPaid++
+			totalAmount.Add(totalAmount, big.NewInt(amount))
+			log.Printf("DRY RUN: would pay %v Shannon to %v", amount, login)
+			continue
+		}
+
+		// A payment once locked is seen through even when shutting down,
+		// cancelling it half way would leave it to manual resolution
+		pay := u.backend.WithContext(context.WithoutCancel(u.ctx))
+
+		// Lock payments for current payout
+		lock := &storage.PayoutsLock{
+			Owner:     u.id,
+			Login:     login,
+			Amount:    amount,
+			ExpiresAt: util.MakeTimestamp()/1000 + int64(payoutsLockTTL/time.Second),
+		}
+		err = pay.LockPayouts(lock)
+		if err != nil {
+			log.Printf("Failed to lock payment for %s: %v", login, err)
+			u.halt = true
+			u.lastFail = err
+			break
+		}
+		log.Printf("Locked payment for %s, %v Shannon", login, amount)
+
+		// Another payer may have paid this balance since we read it
+		balance, err := pay.GetBalance(login)
+		if err != nil {
+			log.Printf("Failed to get balance of %s after locking, leaving further payouts to the next run: %v", login, err)
+			u.unlock(pay, login)
+			break
+		}
+		if balance != amount {
+			log.Printf("Balance of %s changed while locking, skipping payment", login)
+			if !u.unlock(pay, login) {
+				break
+			}
+			continue
+		}
+
+		// Debit miner's balance and update stats
+		err = pay.UpdateBalance(login, amount)
+		if err != nil {
+			log.Printf("Failed to update balance for %s, %v Shannon: %v", login, amount, err)
+			u.halt = true
+			u.lastFail = err
+			break
+		}
+
+		value := hexutil.EncodeBig(amountInWei)
+		txHash, err := u.rpc.SendTransaction(u.config.Address, login, u.config.GasHex(), u.config.GasPriceHex(), value)
+		if err != nil {
+			log.Printf("Failed to send payment to %s, %v Shannon: %v. Check outgoing tx for %s in block explorer",
+				login, amount, err, login)
+			u.halt = true
+			u.lastFail = err
+			break
+		}
+
+		// Keep tx hash in the lock in case we crash before logging the payment.
+		// Without it the payment looks unsent, so payouts stop after logging
+		// it and the lock is kept if that fails too.
+		lockErr := pay.SetPayoutsLockTx(lock, txHash)
+		if lockErr != nil {
+			log.Printf("Failed to save payment tx for %s, %v Shannon, tx: %s: %v. The payment was sent, don't resolve it as unsent",
+				login, amount, txHash, lockErr)
+			u.halt = true
+			u.lastFail = lockErr
+		}
+
+		// Log transaction hash
+		err = pay.WritePayment(login, txHash, amount)
+		if err != nil {
+			log.Printf("Failed to log payment data for %s, %v Shannon, tx: %s: %v", login, amount, txHash, err)
+			u.halt = true
+			u.lastFail = err
+			break
+		}
+
+		minersPaid++
+		totalAmount.Add(totalAmount, big.NewInt(amount))
+		log.Printf("Paid %v Shannon to %v, TxHash: %v", amount, login, txHash)
+		if lockErr != nil {
+			break
+		}
+
+		// Wait for TX confirmation before further payouts
+		err = u.waitForReceipt(login, txHash)
+		if err != nil {
+			log.Println(err)
+			u.halt = true
+			u.lastFail = err
+			break
+		}
+	}
+
+	if mustPay > 0 {
+		log.Printf("Paid total %v Shannon to %v of %v payees", totalAmount, minersPaid, mustPay)
+	} else {
+		log.Println("No payees that have reached payout threshold")
+	}
+
+	// Save redis state to disk
+	if minersPaid > 0 && u.config.BgSave && !u.config.DryRun {
+		u.bgSave()
+	}
+}
+
+// unlock releases the payouts lock of a payment that was skipped before
+// debiting the balance. Payouts stop if that fails, the lock is kept until
+// it expires.
+func (u *PayoutsProcessor) unlock(pay storage.Backend, login string) bool {
+	if err := pay.UnlockPayouts(); err != nil {
+		log.Printf("Failed to unlock payouts after skipping %s: %v", login, err)
+		u.halt = true
+		u.lastFail = err
+		return false
+	}
+	return true
+}
+
+// waitForReceipt polls the node until the payout tx is mined, giving up
+// after txConfirmTimeout.
+func (u *PayoutsProcessor) waitForReceipt(login, txHash string) error {
+	deadline := time.Now().Add(txConfirmTimeout)
+	for time.Now().Before(deadline) {
+		log.Printf("Waiting for tx confirmation: %v", txHash)
+		select {
+		case <-u.ctx.Done():
+			return fmt.Errorf("shutting down before payout tx %s to %s was mined", txHash, login)
+		case <-time.After(txCheckInterval):
+		}
+		receipt, err := u.rpc.GetTxReceipt(txHash)
+		if err != nil {
+			log.Printf("Failed to get tx receipt for %v: %v", txHash, err)
+			continue
+		}
+		// Tx has been mined
+		if receipt != nil && receipt.Confirmed() {
+			if receipt.Successful() {
+				log.Printf("Payout tx successful for %s: %s", login, txHash)
+			} else {
+				log.Printf("Payout tx failed for %s: %s. Address contract throws on incoming tx.", login, txHash)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("payout tx %s to %s not mined within %v, check it in block explorer before resuming payouts",
+		txHash, login, txConfirmTimeout)
+}
+
+func (self PayoutsProcessor) checkPeers() bool {
+	n, err := self.rpc.GetPeerCount()
+	if err != nil {
+		log.Println("Unable to start payouts, failed to retrieve number of peers from node:", err)
+		return false
+	}
+	if n < self.config.RequirePeers {
+		log.Println("Unable to start payouts, number of peers on a node is less than required", self.config.RequirePeers)
+		return false
+	}
+	return true
+}
+
+func (self PayoutsProcessor) reachedThreshold(amount *big.Int) bool {
+	return big.NewInt(self.config.Threshold).Cmp(amount) < 0
+}
+
+func formatPendingPayments(list []*storage.PendingPayment) string {
+	var s string
+	for _, v := range list {
+		s += fmt.Sprintf("\tAddress: %s, Amount: %v Shannon, %v\n", v.Address, v.Amount, time.Unix(v.Timestamp, 0))
+	}
+	return s
+}
+
+func (self PayoutsProcessor) bgSave() {
+	result, err := self.backend.BgSave()
+	if err != nil {
+		log.Println("Failed to perform BGSAVE on backend:", err)
+		return
+	}
+	log.Println("Saving backend state to disk:", result)
+}
+
+// resolvePayouts logs the payment whose tx was sent according to the lock
+// and credits every other pending payment back to its miner.
+func (self PayoutsProcessor) resolvePayouts() {
+	lock, err := self.backend.GetPayoutsLock()
+	if err != nil {
+		log.Println("Unable to resolve payouts:", err)
+		return
+	}
+	if self.heldByOther(lock) {
+		log.Printf("Unable to resolve payouts, payer %s holds the lock for %s until %v",
+			lock.Owner, lock.Login, time.Unix(lock.ExpiresAt, 0))
+		return
+	}
+	payments := self.backend.GetPendingPayments()
+
+	if len(payments) > 0 {
+		var unsent []*storage.PendingPayment
+		for _, v := range payments {
+			if !sentPayment(lock, v) {
+				unsent = append(unsent, v)
+				continue
+			}
+			err := self.backend.WritePayment(v.Address, lock.TxHash, v.Amount)
+			if err != nil {
+				log.Printf("Failed to log sent payment for %s, %v Shannon, tx: %s: %v", v.Address, v.Amount, lock.TxHash, err)
+				return
+			}
+			log.Printf("Logged sent payment of %v Shannon to %v, TxHash: %v", v.Amount, v.Address, lock.TxHash)
+		}
+		if len(unsent) > 0 {
+			log.Printf("Will credit back following balances:\n%s", formatPendingPayments(unsent))
+		}
+
+		for _, v := range unsent {
+			err := self.backend.RollbackBalance(v.Address, v.Amount)
+			if err != nil {
+				log.Printf("Failed to credit %v Shannon back to %s, error is: %v", v.Amount, v.Address, err)
+				return
+			}
+			log.Printf("Credited %v Shannon back to %s", v.Amount, v.Address)
+		}
+	} else {
+		log.Println("No pending payments to resolve")
+	}
+	err = self.backend.UnlockPayouts()
+	if err != nil {
+		log.Println("Failed to unlock payouts:", err)
+		return
+	}
+
+	if self.config.BgSave {
+		self.bgSave()
+	}
+	log.Println("Payouts unlocked")
+}
+
+func (self PayoutsProcessor) mustResolvePayout() bool {
+	v, _ := strconv.ParseBool(os.Getenv("RESOLVE_PAYOUT"))
+	return v
+}
@@ -0,0 +1,297 @@
+package payouts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+// mockNode answers the JSON-RPC calls made by the payer and counts them.
+type mockNode struct {
+	sync.Mutex
+	calls map[string]int
+}
+
+func (m *mockNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string `json:"method"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	m.Lock()
+	m.calls[req.Method]++
+	m.Unlock()
+
+	result := map[string]string{
+		"net_peerCount":        "0x10",
+		"quai_getBalance":      "0x3635c9adc5dea00000",
+		"quai_sendTransaction": "0x1234",
+	}[req.Method]
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 0, "result": result})
+}
+
+func newTestPayer(t *testing.T) (*PayoutsProcessor, *mockNode) {
+	node := &mockNode{calls: make(map[string]int)}
+	srv := httptest.NewServer(node)
+	t.Cleanup(srv.Close)
+
+	backend := storage.NewMemoryBackend()
+
+	cfg := &PayoutsConfig{
+		Daemon:    srv.URL,
+		Timeout:   "1s",
+		Address:   "0x0",
+		Gas:       "21000",
+		GasPrice:  "1",
+		Threshold: 1000,
+		DryRun:    true,
+	}
+	return NewPayoutsProcessor(cfg, backend), node
+}
+
+func TestProcessDryRun(t *testing.T) {
+	u, node := newTestPayer(t)
+	credit(u.backend, map[string]int64{"0x1": 5000, "0x2": 10})
+
+	u.process()
+
+	if u.halt {
+		t.Fatalf("Must not halt: %v", u.lastFail)
+	}
+	if node.calls["quai_getBalance"] != 1 || node.calls["net_peerCount"] != 1 {
+		t.Error("Must query node for payees above threshold")
+	}
+	if node.calls["quai_sendTransaction"] != 0 {
+		t.Error("Must not send transactions")
+	}
+	if balance, _ := u.backend.GetBalance("0x1"); balance != 5000 {
+		t.Error("Must not touch balance")
+	}
+	if len(u.backend.GetPendingPayments()) != 0 {
+		t.Error("Must not add pending payment")
+	}
+	if locked, _ := u.backend.IsPayoutsLocked(); locked {
+		t.Error("Must not lock payouts")
+	}
+}
+
+func TestRecoverSentPayment(t *testing.T) {
+	u, _ := newTestPayer(t)
+	credit(u.backend, map[string]int64{"0x1": 5000})
+
+	// Crash after the tx was sent but before it was logged
+	lock := expiredLock("0x1", 5000)
+	u.backend.LockPayouts(lock)
+	u.backend.UpdateBalance("0x1", 5000)
+	u.backend.SetPayoutsLockTx(lock, "0xabc")
+
+	if !u.recoverPayouts() {
+		t.Fatal("Must recover sent payment")
+	}
+	if len(u.backend.GetPendingPayments()) != 0 {
+		t.Error("Must clear pending payment")
+	}
+	if locked, _ := u.backend.IsPayoutsLocked(); locked {
+		t.Error("Must release lock")
+	}
+	stats, _ := u.backend.GetMinerStats("0x1", 10)
+	if stats.Stats.Paid != 5000 {
+		t.Error("Must credit paid amount")
+	}
+}
+
+func TestRecoverUnsentPayment(t *testing.T) {
+	u, _ := newTestPayer(t)
+	credit(u.backend, map[string]int64{"0x1": 5000})
+
+	// Crash before the tx hash was known
+	u.backend.LockPayouts(expiredLock("0x1", 5000))
+	u.backend.UpdateBalance("0x1", 5000)
+
+	if u.recoverPayouts() {
+		t.Error("Must require manual resolution")
+	}
+	if len(u.backend.GetPendingPayments()) != 1 {
+		t.Error("Must keep pending payment")
+	}
+}
+
+func TestRecoverKeepsLiveLock(t *testing.T) {
+	u, _ := newTestPayer(t)
+	credit(u.backend, map[string]int64{"0x1": 5000})
+
+	// Another payer locked the payout and is about to debit the balance
+	lock := &storage.PayoutsLock{Owner: "other", Login: "0x1", Amount: 5000, ExpiresAt: util.MakeTimestamp()/1000 + 600}
+	u.backend.LockPayouts(lock)
+
+	if u.recoverPayouts() {
+		t.Error("Must not start while another payer holds the lock")
+	}
+	if held, _ := u.backend.GetPayoutsLock(); held == nil || held.Owner != "other" {
+		t.Errorf("Must keep the lock of the other payer: %+v", held)
+	}
+}
+
+func TestRecoverExpiredLock(t *testing.T) {
+	u, _ := newTestPayer(t)
+	credit(u.backend, map[string]int64{"0x1": 5000})
+
+	// Crash between locking and debiting the balance
+	u.backend.LockPayouts(expiredLock("0x1", 5000))
+
+	if !u.recoverPayouts() {
+		t.Fatal("Must start after the lock expired")
+	}
+	if locked, _ := u.backend.IsPayoutsLocked(); locked {
+		t.Error("Must release expired lock")
+	}
+}
+
+func TestResolveSentPayment(t *testing.T) {
+	u, _ := newTestPayer(t)
+	credit(u.backend, map[string]int64{"0x1": 5000, "0x2": 3000})
+
+	// The payment to 0x1 was sent, the one to 0x2 was left pending
+	u.backend.UpdateBalance("0x2", 3000)
+	lock := expiredLock("0x1", 5000)
+	u.backend.LockPayouts(lock)
+	u.backend.UpdateBalance("0x1", 5000)
+	u.backend.SetPayoutsLockTx(lock, "0xabc")
+
+	u.resolvePayouts()
+
+	if len(u.backend.GetPendingPayments()) != 0 {
+		t.Error("Must clear pending payments")
+	}
+	if locked, _ := u.backend.IsPayoutsLocked(); locked {
+		t.Error("Must release lock")
+	}
+	sent, _ := u.backend.GetMinerStats("0x1", 10)
+	if sent.Stats.Paid != 5000 || sent.Stats.Balance != 0 {
+		t.Errorf("Must log sent payment instead of crediting it back: %+v", sent.Stats)
+	}
+	unsent, _ := u.backend.GetMinerStats("0x2", 10)
+	if unsent.Stats.Paid != 0 || unsent.Stats.Balance != 3000 {
+		t.Errorf("Must credit unsent payment back: %+v", unsent.Stats)
+	}
+}
+
+func TestPayoutTxNotMined(t *testing.T) {
+	defer func(interval, timeout time.Duration) {
+		txCheckInterval, txConfirmTimeout = interval, timeout
+	}(txCheckInterval, txConfirmTimeout)
+	txCheckInterval, txConfirmTimeout = time.Millisecond, 20*time.Millisecond
+
+	u, node := newTestPayer(t)
+	u.config.DryRun = false
+	credit(u.backend, map[string]int64{"0x1": 5000, "0x2": 5000})
+
+	// The node never returns a receipt
+	u.process()
+
+	if !u.halt {
+		t.Error("Must halt when the payout tx isn't mined in time")
+	}
+	if node.calls["quai_sendTransaction"] != 1 {
+		t.Errorf("Must not pay further payees: %v", node.calls)
+	}
+}
+
+func TestStartStopsOnShutdown(t *testing.T) {
+	u, node := newTestPayer(t)
+	u.config.DryRun = false
+	u.config.Interval = "1h"
+	credit(u.backend, map[string]int64{"0x1": 5000})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		u.Start(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Must stop on shutdown")
+	}
+	if node.calls["quai_sendTransaction"] != 0 {
+		t.Error("Must not start payouts while shutting down")
+	}
+}
+
+// failingBackend fails the calls listed in errs.
+type failingBackend struct {
+	storage.Backend
+	errs map[string]error
+}
+
+func (b *failingBackend) WithContext(ctx context.Context) storage.Backend { return b }
+
+func (b *failingBackend) GetBalance(login string) (int64, error) {
+	if err := b.errs["GetBalance"]; err != nil {
+		return 0, err
+	}
+	return b.Backend.GetBalance(login)
+}
+
+func (b *failingBackend) SetPayoutsLockTx(lock *storage.PayoutsLock, txHash string) error {
+	if err := b.errs["SetPayoutsLockTx"]; err != nil {
+		return err
+	}
+	return b.Backend.SetPayoutsLockTx(lock, txHash)
+}
+
+func TestProcessStopsOnBalanceError(t *testing.T) {
+	u, node := newTestPayer(t)
+	u.config.DryRun = false
+	credit(u.backend, map[string]int64{"0x1": 5000})
+	u.backend = &failingBackend{Backend: u.backend, errs: map[string]error{"GetBalance": errors.New("redis down")}}
+
+	u.process()
+
+	if node.calls["quai_sendTransaction"] != 0 {
+		t.Error("Must not pay a balance that can't be read")
+	}
+	if locked, _ := u.backend.IsPayoutsLocked(); locked {
+		t.Error("Must not lock payouts")
+	}
+}
+
+func TestProcessHaltsOnLockTxError(t *testing.T) {
+	u, node := newTestPayer(t)
+	u.config.DryRun = false
+	credit(u.backend, map[string]int64{"0x1": 5000, "0x2": 5000})
+	u.backend = &failingBackend{Backend: u.backend, errs: map[string]error{"SetPayoutsLockTx": errors.New("redis down")}}
+
+	u.process()
+
+	if !u.halt {
+		t.Error("Must halt when the tx hash of a sent payment can't be saved")
+	}
+	if node.calls["quai_sendTransaction"] != 1 {
+		t.Errorf("Must not pay further payees: %v", node.calls)
+	}
+	if len(u.backend.GetPendingPayments()) != 0 {
+		t.Error("Must still log the sent payment")
+	}
+}
+
+// expiredLock is left behind by a payer which crashed.
+func expiredLock(login string, amount int64) *storage.PayoutsLock {
+	return &storage.PayoutsLock{Owner: "crashed", Login: login, Amount: amount, ExpiresAt: 1}
+}
+
+// credit matures a block paying rewards, the only way balances grow.
+func credit(backend storage.Backend, rewards map[string]int64) {
+	block := &storage.BlockData{Height: 1, RoundHeight: 1, Hash: "0x1", Reward: big.NewInt(0)}
+	backend.WriteMaturedBlock(block, rewards, nil)
+}
@@ -0,0 +1,111 @@
+package payouts
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+type ReconcilerConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Interval string `json:"interval"`
+	// Pending payments older than this are flagged even while locked
+	PaymentTimeout string `json:"paymentTimeout"`
+}
+
+// Reconciler periodically checks the finances totals against the records
+// behind them. It only reads, so it may run next to the unlocker and payer.
+type Reconciler struct {
+	config  *ReconcilerConfig
+	backend storage.Backend
+	timeout time.Duration
+	last    atomic.Value
+}
+
+func NewReconciler(cfg *ReconcilerConfig, backend storage.Backend) *Reconciler {
+	r := &Reconciler{config: cfg, backend: backend}
+	if len(cfg.PaymentTimeout) > 0 {
+		r.timeout = util.MustParseDuration(cfg.PaymentTimeout)
+	}
+	return r
+}
+
+func (r *Reconciler) Start() {
+	log.Println("Starting ledger reconciler")
+	intv := util.MustParseDuration(r.config.Interval)
+	timer := time.NewTimer(intv)
+	log.Printf("Set ledger reconcile interval to %v", intv)
+
+	r.reconcile()
+	timer.Reset(intv)
+
+	go func() {
+		for {
+			select {
+			case <-timer.C:
+				r.reconcile()
+				timer.Reset(intv)
+			}
+		}
+	}()
+}
+
+// Last returns the result of the last reconciliation, nil until the first
+// one is done.
+func (r *Reconciler) Last() *storage.Reconciliation {
+	result, _ := r.last.Load().(*storage.Reconciliation)
+	return result
+}
+
+func (r *Reconciler) reconcile() {
+	result, err := r.run()
+	// Records written while the ledger was read may show up as drift, so
+	// mismatches are confirmed by a second run
+	if err == nil && !result.OK() {
+		result, err = r.run()
+	}
+	if err != nil {
+		log.Printf("Failed to reconcile ledger: %v", err)
+		return
+	}
+	r.last.Store(result)
+	publishReconciliation(result)
+
+	if result.OK() {
+		log.Println("Ledger reconciled")
+		return
+	}
+	for _, check := range result.Checks {
+		if !check.OK() {
+			log.Printf("LEDGER DRIFT: %s recorded %v, computed %v", check.Name, check.Recorded, check.Computed)
+		}
+	}
+	for _, block := range result.Blocks {
+		log.Printf("LEDGER DRIFT: block %v %s with reward %v credited %v", block.Height, block.Hash, block.Reward, block.Credited)
+	}
+	for _, payment := range result.OrphanedPayments {
+		log.Printf("LEDGER DRIFT: orphaned pending payment of %v to %s since %v", payment.Amount, payment.Address, payment.Timestamp)
+	}
+}
+
+func (r *Reconciler) run() (*storage.Reconciliation, error) {
+	ledger, err := r.backend.GetLedger()
+	if err != nil {
+		return nil, err
+	}
+	return ledger.Reconcile(util.MakeTimestamp()/1000, r.timeout), nil
+}
+
+func publishReconciliation(result *storage.Reconciliation) {
+	for _, check := range result.Checks {
+		metrics.LedgerDrift.WithLabelValues(check.Name).Set(float64(check.Drift()))
+	}
+	metrics.LedgerProblems.WithLabelValues("checks").Set(float64(result.FailedChecks()))
+	metrics.LedgerProblems.WithLabelValues("blocks").Set(float64(len(result.Blocks)))
+	metrics.LedgerProblems.WithLabelValues("payments").Set(float64(len(result.OrphanedPayments)))
+	metrics.LedgerReconciled.Set(float64(result.CheckedAt))
+}
@@ -0,0 +1,39 @@
+package payouts
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+func TestReconcile(t *testing.T) {
+	backend := storage.NewMemoryBackend()
+	r := NewReconciler(&ReconcilerConfig{Interval: "1m", PaymentTimeout: "1h"}, backend)
+	if r.Last() != nil {
+		t.Error("Must have no result before the first run")
+	}
+
+	block := &storage.BlockData{Height: 1, RoundHeight: 1, Hash: "0x1", Reward: util.String2Big("1000000000000000000")}
+	backend.WriteMaturedBlock(block, map[string]int64{"0x1": 1000000000}, nil)
+	r.reconcile()
+	if result := r.Last(); result == nil || !result.OK() {
+		t.Fatalf("Must reconcile: %+v", result)
+	}
+
+	// Payer crashed without leaving a lock behind
+	backend.UpdateBalance("0x1", 1000000000)
+	r.reconcile()
+	if result := r.Last(); result.OK() || len(result.OrphanedPayments) != 1 {
+		t.Errorf("Must flag orphaned payment: %+v", result)
+	}
+	if n := testutil.ToFloat64(metrics.LedgerProblems.WithLabelValues("payments")); n != 1 {
+		t.Errorf("Must publish orphaned payments, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.LedgerDrift.WithLabelValues("pending")); n != 0 {
+		t.Errorf("Must publish drift, got %v", n)
+	}
+}
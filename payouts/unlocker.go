@@ -0,0 +1,418 @@
+package payouts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common"
+
+	"github.com/dominant-strategies/go-quai-stratum/rpc"
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+type UnlockerConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Depth         int64  `json:"depth"`
+	ImmatureDepth int64  `json:"immatureDepth"`
+	BlockReward   string `json:"blockReward"`
+	Interval      string `json:"interval"`
+	// HTTP RPC endpoints ordered prime, region, zone. Only zone is required.
+	Daemons []string `json:"daemons"`
+	Timeout string   `json:"timeout"`
+	// Percent of every miner reward kept by the pool
+	PoolFee float64 `json:"poolFee"`
+	// Pool fee is split evenly between these addresses
+	PoolFeeAddresses []string `json:"poolFeeAddresses"`
+	// Per-miner pool fee in percent, overrides PoolFee
+	FeeOverrides map[string]float64 `json:"feeOverrides"`
+	// Percent of the pool fee sent to the donation address
+	DonationFee     float64 `json:"donationFee"`
+	DonationAddress string  `json:"donationAddress"`
+}
+
+// MinerFee returns the pool fee in percent charged on rewards of login.
+func (c *UnlockerConfig) MinerFee(login string) float64 {
+	for miner, fee := range c.FeeOverrides {
+		if strings.EqualFold(miner, login) {
+			return fee
+		}
+	}
+	return c.PoolFee
+}
+
+type BlockUnlocker struct {
+	config   *UnlockerConfig
+	backend  storage.Backend
+	rpc      [common.HierarchyDepth]*rpc.RPCClient
+	reward   *big.Int
+	halt     bool
+	lastFail error
+}
+
+type UnlockResult struct {
+	maturedBlocks  []*storage.BlockData
+	orphanedBlocks []*storage.BlockData
+	orphans        int
+	blocks         int
+}
+
+func NewBlockUnlocker(cfg *UnlockerConfig, backend storage.Backend) *BlockUnlocker {
+	if cfg.Depth < 1 {
+		log.Fatalf("Block maturity depth can't be < 1, your depth is %v", cfg.Depth)
+	}
+	if cfg.ImmatureDepth < 1 {
+		log.Fatalf("Immature depth can't be < 1, your depth is %v", cfg.ImmatureDepth)
+	}
+	if len(cfg.Daemons) != common.HierarchyDepth || len(cfg.Daemons[common.ZONE_CTX]) == 0 {
+		log.Fatalf("Unlocker daemons must list prime, region and zone endpoints, zone is required")
+	}
+	reward, ok := new(big.Int).SetString(cfg.BlockReward, 10)
+	if !ok || reward.Sign() <= 0 {
+		log.Fatalf("Invalid unlocker block reward: %v", cfg.BlockReward)
+	}
+	if cfg.PoolFee < 0 || cfg.PoolFee > 100 {
+		log.Fatalf("Pool fee must be between 0 and 100, your fee is %v", cfg.PoolFee)
+	}
+	for login, fee := range cfg.FeeOverrides {
+		if fee < 0 || fee > 100 {
+			log.Fatalf("Pool fee must be between 0 and 100, fee of %v is %v", login, fee)
+		}
+		if fee > 0 && len(cfg.PoolFeeAddresses) == 0 {
+			log.Fatalf("Pool fee of %v is set but no pool fee addresses are configured", login)
+		}
+	}
+	if cfg.PoolFee > 0 && len(cfg.PoolFeeAddresses) == 0 {
+		log.Fatalf("Pool fee is set but no pool fee addresses are configured")
+	}
+	if cfg.DonationFee < 0 || cfg.DonationFee > 100 {
+		log.Fatalf("Donation fee must be between 0 and 100, your fee is %v", cfg.DonationFee)
+	}
+	if cfg.DonationFee > 0 && len(cfg.DonationAddress) == 0 {
+		log.Fatalf("Donation fee is set but no donation address is configured")
+	}
+
+	u := &BlockUnlocker{config: cfg, backend: backend, reward: reward}
+	for ctx, url := range cfg.Daemons {
+		if len(url) > 0 {
+			u.rpc[ctx] = rpc.NewRPCClient(strings.ToLower(common.OrderToString(ctx)), url, cfg.Timeout)
+		}
+	}
+	return u
+}
+
+// Start unlocks blocks until ctx is cancelled.
+func (u *BlockUnlocker) Start(ctx context.Context) {
+	u.backend = u.backend.WithContext(ctx)
+	log.Println("Starting block unlocker")
+	intv := util.MustParseDuration(u.config.Interval)
+	timer := time.NewTimer(intv)
+	log.Printf("Set block unlock interval to %v", intv)
+	log.Printf("Splitting round rewards with %s scheme", u.backend.RewardScheme().Name())
+	log.Printf("Set pool fee to %v%%, donating %v%% of it", u.config.PoolFee, u.config.DonationFee)
+
+	// Immediately unlock after start
+	u.unlockPendingBlocks()
+	u.unlockAndCreditMiners()
+	timer.Reset(intv)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopped block unlocker")
+			return
+		case <-timer.C:
+			u.unlockPendingBlocks()
+			u.unlockAndCreditMiners()
+			timer.Reset(intv)
+		}
+	}
+}
+
+// isCanonical checks that the zone chain has the block at its height and,
+// for region and prime blocks, that the dominant nodes know it as well.
+func (u *BlockUnlocker) isCanonical(block *storage.BlockData, checkDom bool) (bool, error) {
+	reply, err := u.rpc[common.ZONE_CTX].GetBlockByHeight(block.Height)
+	if err != nil {
+		return false, err
+	}
+	if reply == nil {
+		return false, fmt.Errorf("error while retrieving block %v from node, wrong node height", block.Height)
+	}
+	if !strings.EqualFold(reply.Hash, block.Hash) {
+		return false, nil
+	}
+	if !checkDom {
+		return true, nil
+	}
+	for ctx := block.Order; ctx < common.ZONE_CTX; ctx++ {
+		if u.rpc[ctx] == nil {
+			continue
+		}
+		reply, err := u.rpc[ctx].GetBlockByHash(block.Hash)
+		if err != nil {
+			return false, err
+		}
+		if reply == nil || !strings.EqualFold(reply.Hash, block.Hash) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (u *BlockUnlocker) unlockCandidates(candidates []*storage.BlockData) (*UnlockResult, error) {
+	result := &UnlockResult{}
+
+	for _, candidate := range candidates {
+		canonical, err := u.isCanonical(candidate, true)
+		if err != nil {
+			return nil, err
+		}
+		if !canonical {
+			candidate.Orphan = true
+			result.orphans++
+			result.orphanedBlocks = append(result.orphanedBlocks, candidate)
+			log.Printf("Orphaned block %v:%v", candidate.RoundHeight, candidate.Hash)
+			continue
+		}
+		candidate.Reward = new(big.Int).Set(u.reward)
+		result.blocks++
+		result.maturedBlocks = append(result.maturedBlocks, candidate)
+		log.Printf("Mature %s block %v with %v shares, reward %v",
+			strings.ToLower(common.OrderToString(candidate.Order)), candidate.Height, candidate.TotalShares, candidate.Reward)
+	}
+	return result, nil
+}
+
+// fail suspends unlocking after a ledger write failed, which may have left
+// the round half applied. Node and read errors are retried instead, nothing
+// was written yet.
+func (u *BlockUnlocker) fail(err error) {
+	u.halt = true
+	u.lastFail = err
+}
+
+func (u *BlockUnlocker) unlockPendingBlocks() {
+	if u.halt {
+		log.Println("Unlocking suspended due to last critical error:", u.lastFail)
+		return
+	}
+
+	current, err := u.rpc[common.ZONE_CTX].BlockNumber()
+	if err != nil {
+		log.Printf("Unable to get current blockchain height from node, retrying next interval: %v", err)
+		return
+	}
+
+	candidates, err := u.backend.GetCandidates(current - u.config.ImmatureDepth)
+	if err != nil {
+		log.Printf("Failed to get block candidates from backend, retrying next interval: %v", err)
+		return
+	}
+
+	if len(candidates) == 0 {
+		log.Println("No block candidates to unlock")
+		return
+	}
+
+	result, err := u.unlockCandidates(candidates)
+	if err != nil {
+		log.Printf("Failed to unlock blocks, retrying next interval: %v", err)
+		return
+	}
+	log.Printf("Immature %v blocks, %v orphans", result.blocks, result.orphans)
+
+	err = u.backend.WritePendingOrphans(result.orphanedBlocks)
+	if err != nil {
+		u.fail(err)
+		log.Printf("Failed to insert orphaned blocks into backend: %v", err)
+		return
+	} else {
+		log.Printf("Inserted %v orphaned blocks to backend", result.orphans)
+	}
+
+	totalRevenue := new(big.Rat)
+
+	for _, block := range result.maturedBlocks {
+		roundRewards, _, err := u.calculateRewards(block)
+		if errors.Is(err, storage.ErrEmptyRound) {
+			log.Printf("Leaving block %v a candidate, round %v has no shares", block.Height, block.RoundKey())
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to calculate rewards for round %v, retrying next interval: %v", block.RoundKey(), err)
+			return
+		}
+		err = u.backend.WriteImmatureBlock(block, roundRewards)
+		if err != nil {
+			u.fail(err)
+			log.Printf("Failed to credit rewards for round %v: %v", block.RoundKey(), err)
+			return
+		}
+		totalRevenue.Add(totalRevenue, new(big.Rat).SetInt(block.Reward))
+
+		logEntry := fmt.Sprintf(
+			"IMMATURE %v: revenue %v, %v miners",
+			block.RoundKey(),
+			util.FormatRatReward(new(big.Rat).SetInt(block.Reward)),
+			len(roundRewards),
+		)
+		for login, reward := range roundRewards {
+			logEntry += fmt.Sprintf("\n\tREWARD %s: %v Shannon", login, reward)
+		}
+		log.Println(logEntry)
+	}
+
+	log.Printf("IMMATURE SESSION: revenue %v", util.FormatRatReward(totalRevenue))
+}
+
+func (u *BlockUnlocker) unlockAndCreditMiners() {
+	if u.halt {
+		log.Println("Unlocking suspended due to last critical error:", u.lastFail)
+		return
+	}
+
+	current, err := u.rpc[common.ZONE_CTX].BlockNumber()
+	if err != nil {
+		log.Printf("Unable to get current blockchain height from node, retrying next interval: %v", err)
+		return
+	}
+
+	immature, err := u.backend.GetImmatureBlocks(current - u.config.Depth)
+	if err != nil {
+		log.Printf("Failed to get immature blocks from backend, retrying next interval: %v", err)
+		return
+	}
+
+	if len(immature) == 0 {
+		log.Println("No immature blocks to credit miners")
+		return
+	}
+
+	orphans, matured := 0, 0
+	totalRevenue := new(big.Rat)
+
+	for _, block := range immature {
+		canonical := false
+		if !block.Orphan {
+			canonical, err = u.isCanonical(block, false)
+			if err != nil {
+				log.Printf("Failed to unlock blocks, retrying next interval: %v", err)
+				return
+			}
+		}
+		if !canonical {
+			block.Orphan = true
+			err = u.backend.WriteOrphan(block)
+			if err != nil {
+				u.fail(err)
+				log.Printf("Failed to insert orphaned block into backend: %v", err)
+				return
+			}
+			orphans++
+			continue
+		}
+
+		block.Reward = util.String2Big(block.ImmatureReward)
+		roundRewards, fees, err := u.calculateRewards(block)
+		if errors.Is(err, storage.ErrEmptyRound) {
+			log.Printf("Leaving block %v immature, round %v has no shares", block.Height, block.RoundKey())
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to calculate rewards for round %v, retrying next interval: %v", block.RoundKey(), err)
+			return
+		}
+		err = u.backend.WriteMaturedBlock(block, roundRewards, fees)
+		if err != nil {
+			u.fail(err)
+			log.Printf("Failed to credit rewards for round %v: %v", block.RoundKey(), err)
+			return
+		}
+		matured++
+		totalRevenue.Add(totalRevenue, new(big.Rat).SetInt(block.Reward))
+
+		logEntry := fmt.Sprintf(
+			"MATURED %v: revenue %v, %v miners",
+			block.RoundKey(),
+			util.FormatRatReward(new(big.Rat).SetInt(block.Reward)),
+			len(roundRewards),
+		)
+		for login, reward := range roundRewards {
+			logEntry += fmt.Sprintf("\n\tREWARD %s: %v Shannon", login, reward)
+		}
+		for login, fee := range fees.Pool {
+			logEntry += fmt.Sprintf("\n\tFEE %s: %v Shannon", login, fee)
+		}
+		for login, donation := range fees.Donations {
+			logEntry += fmt.Sprintf("\n\tDONATION %s: %v Shannon", login, donation)
+		}
+		log.Println(logEntry)
+	}
+
+	log.Printf("Matured %v blocks, %v orphans", matured, orphans)
+	log.Printf("MATURE SESSION: revenue %v", util.FormatRatReward(totalRevenue))
+}
+
+func (u *BlockUnlocker) calculateRewards(block *storage.BlockData) (map[string]int64, *storage.RoundFees, error) {
+	shares, err := u.backend.GetRoundShares(block.RoundHeight, block.Nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	rewards, err := u.backend.RewardScheme().Rewards(shares, block.Reward)
+	if err != nil {
+		return nil, nil, err
+	}
+	fees := chargeFees(u.config, rewards)
+	return rewards, fees, nil
+}
+
+// chargeFees deducts the pool fee from every miner reward in place and splits
+// what was collected between the donation and pool fee addresses.
+func chargeFees(cfg *UnlockerConfig, rewards map[string]int64) *storage.RoundFees {
+	fees := &storage.RoundFees{Pool: make(map[string]int64), Donations: make(map[string]int64)}
+
+	collected := int64(0)
+	for login, reward := range rewards {
+		fee := percentOf(reward, cfg.MinerFee(login))
+		rewards[login] = reward - fee
+		collected += fee
+	}
+	if collected == 0 {
+		return fees
+	}
+
+	donation := percentOf(collected, cfg.DonationFee)
+	if donation > 0 {
+		fees.Donations[cfg.DonationAddress] = donation
+	}
+
+	// First address takes the remainder so nothing is lost to rounding
+	poolFee := collected - donation
+	n := int64(len(cfg.PoolFeeAddresses))
+	for i, login := range cfg.PoolFeeAddresses {
+		amount := poolFee / n
+		if i == 0 {
+			amount += poolFee % n
+		}
+		if amount > 0 {
+			fees.Pool[login] += amount
+		}
+	}
+	return fees
+}
+
+// percentOf rounds down. The percent is parsed from its decimal form so that
+// fees like 0.3% don't suffer from float precision.
+func percentOf(amount int64, percent float64) int64 {
+	rate, _ := new(big.Rat).SetString(strconv.FormatFloat(percent, 'f', -1, 64))
+	value := new(big.Rat).Mul(new(big.Rat).SetInt64(amount), rate)
+	value.Quo(value, big.NewRat(100, 1))
+	return new(big.Int).Quo(value.Num(), value.Denom()).Int64()
+}
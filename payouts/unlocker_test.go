@@ -0,0 +1,113 @@
+package payouts
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+)
+
+func TestChargeFees(t *testing.T) {
+	cfg := &UnlockerConfig{
+		PoolFee:          1,
+		PoolFeeAddresses: []string{"0xa", "0xb"},
+		FeeOverrides:     map[string]float64{"0X2": 0},
+		DonationFee:      10,
+		DonationAddress:  "0xd",
+	}
+	rewards := map[string]int64{"0x0": 100000, "0x1": 5050, "0x2": 100000}
+
+	fees := chargeFees(cfg, rewards)
+	expectedRewards := map[string]int64{"0x0": 99000, "0x1": 5000, "0x2": 100000}
+	for login, amount := range expectedRewards {
+		if rewards[login] != amount {
+			t.Errorf("Reward for %v must be equal to %v vs %v", login, amount, rewards[login])
+		}
+	}
+	if fees.Donations["0xd"] != 105 {
+		t.Errorf("Must donate 10%% of pool fee: %v", fees.Donations)
+	}
+	if fees.Pool["0xa"] != 473 || fees.Pool["0xb"] != 472 {
+		t.Errorf("Must split pool fee between addresses: %v", fees.Pool)
+	}
+	if fees.Total() != 1050 {
+		t.Errorf("Fees must add up to collected fee: %v", fees.Total())
+	}
+}
+
+func TestPercentOf(t *testing.T) {
+	if percentOf(1000, 0.3) != 3 {
+		t.Error("Must not lose precision on decimal percents")
+	}
+	if percentOf(999, 1) != 9 {
+		t.Error("Must round down")
+	}
+}
+
+func TestUnlockerRetriesNodeErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	cfg := &UnlockerConfig{
+		Depth:         20,
+		ImmatureDepth: 10,
+		BlockReward:   "5000000000000000000",
+		Interval:      "1m",
+		Daemons:       []string{"", "", srv.URL},
+		Timeout:       "1s",
+	}
+	u := NewBlockUnlocker(cfg, storage.NewMemoryBackend())
+
+	u.unlockPendingBlocks()
+	u.unlockAndCreditMiners()
+	if u.halt {
+		t.Errorf("Must retry on node errors instead of halting: %v", u.lastFail)
+	}
+}
+
+func TestUnlockerLeavesEmptyRoundImmature(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		result := map[string]interface{}{
+			"quai_blockNumber":      "0x64",
+			"quai_getBlockByNumber": map[string]string{"hash": "0x1"},
+		}[req.Method]
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 0, "result": result})
+	}))
+	defer srv.Close()
+
+	cfg := &UnlockerConfig{
+		Depth:         20,
+		ImmatureDepth: 10,
+		BlockReward:   "5000000000000000000",
+		Interval:      "1m",
+		Daemons:       []string{"", "", srv.URL},
+		Timeout:       "1s",
+	}
+	backend := storage.NewMemoryBackend()
+	block := &storage.BlockData{Height: 1, RoundHeight: 1, Hash: "0x1", Nonce: "0x2", Reward: big.NewInt(0)}
+	backend.WriteImmatureBlock(block, nil)
+	u := NewBlockUnlocker(cfg, backend)
+
+	if _, _, err := u.calculateRewards(block); err != storage.ErrEmptyRound {
+		t.Errorf("Must refuse to split an empty round: %v", err)
+	}
+	u.unlockAndCreditMiners()
+	if u.halt {
+		t.Errorf("Must not halt on an empty round: %v", u.lastFail)
+	}
+	if immature, _ := backend.GetImmatureBlocks(100); len(immature) != 1 {
+		t.Errorf("Must leave the block immature: %v", immature)
+	}
+	if ledger, _ := backend.GetLedger(); ledger.Finances.TotalMined != 0 || len(ledger.Blocks) != 0 {
+		t.Errorf("Must not count the block as mined: %+v", ledger)
+	}
+}
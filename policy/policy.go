@@ -9,6 +9,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
 	"github.com/dominant-strategies/go-quai-stratum/storage"
 	"github.com/dominant-strategies/go-quai-stratum/util"
 )
@@ -52,8 +53,9 @@ type Stats struct {
 
 type PolicyServer struct {
 	sync.RWMutex
-	statsMu    sync.Mutex
-	config     *Config
+	statsMu sync.Mutex
+	// Holds a *Config, replaced on reload
+	config     atomic.Value
 	stats      map[string]*Stats
 	banChannel chan string
 	startedAt  int64
@@ -61,46 +63,69 @@ type PolicyServer struct {
 	timeout    int64
 	blacklist  []string
 	whitelist  []string
-	storage    *storage.RedisClient
+	storage    storage.Backend
+	// Bans the backend failed to store, by IP. Guarded by statsMu.
+	unstored map[string]*storage.Ban
 }
 
-func Start(cfg *Config, storage *storage.RedisClient) *PolicyServer {
-	s := &PolicyServer{config: cfg, startedAt: util.MakeTimestamp()}
+func Start(cfg *Config, storage storage.Backend) *PolicyServer {
+	s := &PolicyServer{startedAt: util.MakeTimestamp()}
+	s.config.Store(cfg)
 	grace := util.MustParseDuration(cfg.Limits.Grace)
 	s.grace = int64(grace / time.Millisecond)
 	s.banChannel = make(chan string, 64)
 	s.stats = make(map[string]*Stats)
 	s.storage = storage
 
-	timeout := util.MustParseDuration(s.config.ResetInterval)
+	timeout := util.MustParseDuration(cfg.ResetInterval)
 	s.timeout = int64(timeout / time.Millisecond)
 
-	resetIntv := util.MustParseDuration(s.config.ResetInterval)
+	resetIntv := util.MustParseDuration(cfg.ResetInterval)
 	resetTimer := time.NewTimer(resetIntv)
 	log.Printf("Set policy stats reset every %v", resetIntv)
 
-	refreshIntv := util.MustParseDuration(s.config.RefreshInterval)
+	refreshIntv := util.MustParseDuration(cfg.RefreshInterval)
 	refreshTimer := time.NewTimer(refreshIntv)
 	log.Printf("Set policy state refresh every %v", refreshIntv)
 
+	s.refreshState()
+
 	go func() {
 		for {
 			select {
 			case <-resetTimer.C:
+				s.resetStats()
 				resetTimer.Reset(resetIntv)
 			case <-refreshTimer.C:
+				s.refreshState()
 				refreshTimer.Reset(refreshIntv)
 			}
 		}
 	}()
 
-	for i := 0; i < s.config.Workers; i++ {
+	for i := 0; i < cfg.Workers; i++ {
 		s.startPolicyWorker()
 	}
-	log.Printf("Running with %v policy workers", s.config.Workers)
+	log.Printf("Running with %v policy workers", cfg.Workers)
 	return s
 }
 
+func (s *PolicyServer) settings() *Config {
+	return s.config.Load().(*Config)
+}
+
+// Reload applies the limits and banning settings of cfg. Workers and
+// intervals are kept until restart.
+func (s *PolicyServer) Reload(cfg *Config) {
+	next := *s.settings()
+	next.Limits = cfg.Limits
+	next.Banning = cfg.Banning
+	grace := util.MustParseDuration(next.Limits.Grace)
+	atomic.StoreInt64(&s.grace, int64(grace/time.Millisecond))
+	s.config.Store(&next)
+	log.Printf("Reloaded policy limits and banning settings")
+}
+
 func (s *PolicyServer) startPolicyWorker() {
 	go func() {
 		for {
@@ -114,7 +139,7 @@ func (s *PolicyServer) startPolicyWorker() {
 
 func (s *PolicyServer) resetStats() {
 	now := util.MakeTimestamp()
-	banningTimeout := s.config.Banning.Timeout * 1000
+	banningTimeout := s.settings().Banning.Timeout * 1000
 	total := 0
 	s.statsMu.Lock()
 	defer s.statsMu.Unlock()
@@ -128,6 +153,8 @@ func (s *PolicyServer) resetStats() {
 			if atomic.CompareAndSwapInt32(&m.Banned, 1, 0) {
 				log.Printf("Ban dropped for %v", key)
 				delete(s.stats, key)
+				delete(s.unstored, key)
+				s.deleteBan(key)
 				total++
 			}
 		}
@@ -140,8 +167,10 @@ func (s *PolicyServer) resetStats() {
 }
 
 func (s *PolicyServer) refreshState() {
+	if s.storage == nil {
+		return
+	}
 	s.Lock()
-	defer s.Unlock()
 	var err error
 
 	s.blacklist, err = s.storage.GetBlacklist()
@@ -152,11 +181,91 @@ func (s *PolicyServer) refreshState() {
 	if err != nil {
 		log.Printf("Failed to get whitelist from backend: %v", err)
 	}
+	s.Unlock()
+
+	s.syncBans()
+}
+
+// syncBans places bans written to the backend by the admin tools and lifts
+// local bans which were removed from it through the admin API. Bans the
+// backend failed to store are written again instead of being lifted.
+func (s *PolicyServer) syncBans() {
+	s.statsMu.Lock()
+	retry := make([]*storage.Ban, 0, len(s.unstored))
+	for _, ban := range s.unstored {
+		retry = append(retry, ban)
+	}
+	s.statsMu.Unlock()
+	for _, ban := range retry {
+		s.writeBan(ban)
+	}
+
+	now := util.MakeTimestamp()
+	bans, err := s.storage.GetBans()
+	if err != nil {
+		log.Printf("Failed to get bans from backend: %v", err)
+		return
+	}
+	active := make(map[string]struct{}, len(bans))
+	for _, ban := range bans {
+		active[ban.IP] = struct{}{}
+	}
+
+	var placed, lifted []string
+	s.statsMu.Lock()
+	for _, ban := range bans {
+		if s.InWhiteList(ban.IP) {
+			continue
+		}
+		x, ok := s.stats[ban.IP]
+		if !ok {
+			x = s.NewStats()
+			s.stats[ban.IP] = x
+		}
+		if atomic.LoadInt32(&x.Banned) > 0 {
+			continue
+		}
+		atomic.StoreInt64(&x.BannedAt, ban.BannedAt)
+		if atomic.CompareAndSwapInt32(&x.Banned, 0, 1) {
+			placed = append(placed, ban.IP)
+		}
+	}
+	for ip, x := range s.stats {
+		if _, ok := active[ip]; ok {
+			continue
+		}
+		if _, ok := s.unstored[ip]; ok {
+			continue
+		}
+		// Bans placed after the fetch may not be written yet
+		if bannedAt := atomic.LoadInt64(&x.BannedAt); bannedAt == 0 || bannedAt >= now {
+			continue
+		}
+		if atomic.CompareAndSwapInt32(&x.Banned, 1, 0) {
+			delete(s.stats, ip)
+			lifted = append(lifted, ip)
+		}
+	}
+	s.statsMu.Unlock()
+
+	for _, ip := range placed {
+		if len(s.settings().Banning.IPSet) > 0 {
+			s.banChannel <- ip
+		} else {
+			log.Println("Banned peer from backend", ip)
+		}
+	}
+	for _, ip := range lifted {
+		log.Printf("Ban lifted for %v", ip)
+		if len(s.settings().Banning.IPSet) > 0 {
+			s.doUnban(ip)
+		}
+	}
 }
 
 func (s *PolicyServer) NewStats() *Stats {
 	x := &Stats{
-		ConnLimit: s.config.Limits.Limit,
+		ConnLimit: s.settings().Limits.Limit,
 	}
 	x.heartbeat()
 	return x
@@ -178,7 +287,7 @@ func (s *PolicyServer) Get(ip string) *Stats {
 
 func (s *PolicyServer) BanClient(ip string) {
 	x := s.Get(ip)
-	s.forceBan(x, ip)
+	s.forceBan(x, ip, "client")
 }
 
 func (s *PolicyServer) IsBanned(ip string) bool {
@@ -187,11 +296,11 @@ func (s *PolicyServer) IsBanned(ip string) bool {
 }
 
 func (s *PolicyServer) ApplyLimitPolicy(ip string) bool {
-	if !s.config.Limits.Enabled {
+	if !s.settings().Limits.Enabled {
 		return true
 	}
 	now := util.MakeTimestamp()
-	if now-s.startedAt > s.grace {
+	if now-s.startedAt > atomic.LoadInt64(&s.grace) {
 		return s.Get(ip).decrLimit() > 0
 	}
 	return true
@@ -200,7 +309,7 @@ func (s *PolicyServer) ApplyLimitPolicy(ip string) bool {
 func (s *PolicyServer) ApplyLoginPolicy(addy, ip string) bool {
 	if s.InBlackList(addy) {
 		x := s.Get(ip)
-		s.forceBan(x, ip)
+		s.forceBan(x, ip, "blacklist")
 		return false
 	}
 	return true
@@ -209,8 +318,8 @@ func (s *PolicyServer) ApplyLoginPolicy(addy, ip string) bool {
 func (s *PolicyServer) ApplyMalformedPolicy(ip string) bool {
 	x := s.Get(ip)
 	n := x.incrMalformed()
-	if n >= s.config.Banning.MalformedLimit {
-		s.forceBan(x, ip)
+	if n >= s.settings().Banning.MalformedLimit {
+		s.forceBan(x, ip, "malformed")
 		return false
 	}
 	return true
@@ -222,15 +331,15 @@ func (s *PolicyServer) ApplySharePolicy(ip string, validShare bool) bool {
 
 	if validShare {
 		x.ValidShares++
-		if s.config.Limits.Enabled {
-			x.incrLimit(s.config.Limits.LimitJump)
+		if s.settings().Limits.Enabled {
+			x.incrLimit(s.settings().Limits.LimitJump)
 		}
 	} else {
 		x.InvalidShares++
 	}
 
 	totalShares := x.ValidShares + x.InvalidShares
-	if totalShares < s.config.Banning.CheckThreshold {
+	if totalShares < s.settings().Banning.CheckThreshold {
 		x.Unlock()
 		return true
 	}
@@ -241,8 +350,8 @@ func (s *PolicyServer) ApplySharePolicy(ip string, validShare bool) bool {
 
 	ratio := invalidShares / validShares
 
-	if ratio >= s.config.Banning.InvalidPercent/100.0 {
-		s.forceBan(x, ip)
+	if ratio >= s.settings().Banning.InvalidPercent/100.0 {
+		s.forceBan(x, ip, "invalid_shares")
 		return false
 	}
 	return true
@@ -253,14 +362,22 @@ func (x *Stats) resetShares() {
 	x.InvalidShares = 0
 }
 
-func (s *PolicyServer) forceBan(x *Stats, ip string) {
-	if !s.config.Banning.Enabled || s.InWhiteList(ip) {
+func (s *PolicyServer) forceBan(x *Stats, ip, reason string) {
+	if !s.settings().Banning.Enabled || s.InWhiteList(ip) {
 		return
 	}
 	atomic.StoreInt64(&x.BannedAt, util.MakeTimestamp())
 
 	if atomic.CompareAndSwapInt32(&x.Banned, 0, 1) {
-		if len(s.config.Banning.IPSet) > 0 {
+		metrics.PolicyBans.WithLabelValues(reason).Inc()
+		bannedAt := atomic.LoadInt64(&x.BannedAt)
+		s.writeBan(&storage.Ban{
+			IP:        ip,
+			Reason:    reason,
+			BannedAt:  bannedAt,
+			ExpiresAt: bannedAt + s.settings().Banning.Timeout*1000,
+		})
+		if len(s.settings().Banning.IPSet) > 0 {
 			s.banChannel <- ip
 		} else {
 			log.Println("Banned peer", ip)
@@ -268,6 +385,35 @@ func (s *PolicyServer) forceBan(x *Stats, ip string) {
 	}
 }
 
+// writeBan stores ban in the backend. A ban failing to be stored is kept
+// for syncBans to write again.
+func (s *PolicyServer) writeBan(ban *storage.Ban) {
+	if s.storage == nil {
+		return
+	}
+	err := s.storage.WriteBan(ban)
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	if err != nil {
+		log.Printf("Failed to write ban of %v to backend, retrying on the next refresh: %v", ban.IP, err)
+		if s.unstored == nil {
+			s.unstored = make(map[string]*storage.Ban)
+		}
+		s.unstored[ban.IP] = ban
+		return
+	}
+	delete(s.unstored, ban.IP)
+}
+
+func (s *PolicyServer) deleteBan(ip string) {
+	if s.storage == nil {
+		return
+	}
+	if _, err := s.storage.DeleteBan(ip); err != nil {
+		log.Printf("Failed to delete ban of %v from backend: %v", ip, err)
+	}
+}
+
 func (x *Stats) incrLimit(n int32) {
 	atomic.AddInt32(&x.ConnLimit, n)
 }
@@ -293,7 +439,7 @@ func (s *PolicyServer) InWhiteList(ip string) bool {
 }
 
 func (s *PolicyServer) doBan(ip string) {
-	set, timeout := s.config.Banning.IPSet, s.config.Banning.Timeout
+	set, timeout := s.settings().Banning.IPSet, s.settings().Banning.Timeout
 	cmd := fmt.Sprintf("sudo ipset add %s %s timeout %v -!", set, ip, timeout)
 	args := strings.Fields(cmd)
 	head := args[0]
@@ -307,6 +453,21 @@ func (s *PolicyServer) doBan(ip string) {
 	}
 }
 
+func (s *PolicyServer) doUnban(ip string) {
+	set := s.settings().Banning.IPSet
+	cmd := fmt.Sprintf("sudo ipset del %s %s -!", set, ip)
+	args := strings.Fields(cmd)
+	head := args[0]
+	args = args[1:]
+
+	log.Printf("Unbanned %v on ipset %s", ip, set)
+
+	_, err := exec.Command(head, args...).Output()
+	if err != nil {
+		log.Printf("CMD Error: %s", err)
+	}
+}
+
 func (x *Stats) heartbeat() {
 	now := util.MakeTimestamp()
 	atomic.StoreInt64(&x.LastBeat, now)
@@ -0,0 +1,55 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+)
+
+// flakyBackend fails to write bans while down is set.
+type flakyBackend struct {
+	storage.Backend
+	down bool
+}
+
+func (b *flakyBackend) WriteBan(ban *storage.Ban) error {
+	if b.down {
+		return errors.New("redis down")
+	}
+	return b.Backend.WriteBan(ban)
+}
+
+func TestSyncBansKeepsUnstoredBans(t *testing.T) {
+	cfg := &Config{
+		Banning:         Banning{Enabled: true, Timeout: 60},
+		Limits:          Limits{Grace: "1m"},
+		ResetInterval:   "1h",
+		RefreshInterval: "1h",
+	}
+	backend := &flakyBackend{Backend: storage.NewMemoryBackend(), down: true}
+	s := Start(cfg, backend)
+
+	s.BanClient("10.0.0.1")
+	time.Sleep(2 * time.Millisecond)
+	s.syncBans()
+	if !s.IsBanned("10.0.0.1") {
+		t.Fatal("Must keep a ban the backend failed to store")
+	}
+
+	backend.down = false
+	s.syncBans()
+	if bans, _ := backend.GetBans(); len(bans) != 1 || bans[0].IP != "10.0.0.1" {
+		t.Fatalf("Must store the ban on the next refresh: %v", bans)
+	}
+	if !s.IsBanned("10.0.0.1") {
+		t.Error("Must keep a stored ban")
+	}
+
+	backend.DeleteBan("10.0.0.1")
+	s.syncBans()
+	if s.IsBanned("10.0.0.1") {
+		t.Error("Must lift a ban removed from the backend")
+	}
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dominant-strategies/go-quai-stratum/api"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+
+	"github.com/dominant-strategies/go-quai/log"
+	"github.com/gorilla/mux"
+)
+
+type Admin struct {
+	Enabled bool `json:"enabled"`
+	// Sent by clients as "Authorization: Bearer <token>"
+	Token string `json:"token"`
+}
+
+// SessionInfo is the admin view of a stratum connection.
+type SessionInfo struct {
+	ID          uint64 `json:"id"`
+	IP          string `json:"ip"`
+	Port        string `json:"port"`
+	Login       string `json:"login"`
+	Worker      string `json:"worker"`
+	Solo        bool   `json:"solo"`
+	Extranonce  string `json:"extranonce"`
+	UserAgent   string `json:"userAgent"`
+	ConnectedAt int64  `json:"connectedAt"`
+	LastShare   int64  `json:"lastShare"`
+	Difficulty  int64  `json:"difficulty"`
+}
+
+func (s *ProxyServer) registerAdminRoutes(r *mux.Router) {
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(util.BearerAuth(s.config.Proxy.Admin.Token))
+	admin.HandleFunc("/sessions", s.SessionsIndex).Methods("GET")
+	admin.HandleFunc("/sessions/{id:[0-9]+}", s.KickSession).Methods("DELETE")
+	admin.HandleFunc("/reload", s.ReloadConfig).Methods("POST")
+	log.Global.Printf("Admin API enabled on %v", s.config.Proxy.Listen)
+}
+
+// SessionsIndex lists live sessions, optionally filtered by login, worker,
+// ip or a user agent substring.
+func (s *ProxyServer) SessionsIndex(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	login := strings.ToLower(query.Get("login"))
+	worker := strings.ToLower(query.Get("worker"))
+	ip := query.Get("ip")
+	agent := strings.ToLower(query.Get("agent"))
+
+	difficulty := s.shareDifficulty()
+	result := []*SessionInfo{}
+
+	s.sessionsMu.RLock()
+	for cs := range s.sessions {
+		info := cs.info()
+		info.Difficulty = difficulty
+		if len(login) > 0 && info.Login != login ||
+			len(worker) > 0 && info.Worker != worker ||
+			len(ip) > 0 && info.IP != ip ||
+			len(agent) > 0 && !strings.Contains(strings.ToLower(info.UserAgent), agent) {
+			continue
+		}
+		result = append(result, info)
+	}
+	s.sessionsMu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sessions": result,
+		"total":    len(result),
+	})
+}
+
+// KickSession drops a stratum connection. The miner is free to reconnect.
+func (s *ProxyServer) KickSession(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+
+	cs := s.findSession(id)
+	if cs == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+		return
+	}
+	info := cs.info()
+	s.removeSession(cs)
+	cs.conn.Close()
+
+	log.Global.WithFields(log.Fields{
+		"login":  info.Login,
+		"worker": info.Worker,
+		"ip":     info.IP,
+		"port":   info.Port,
+	}).Warn("Kicked stratum session")
+	writeJSON(w, http.StatusOK, info)
+}
+
+// ReloadConfig reloads the config file of the process, see api.ConfigReloader.
+func (s *ProxyServer) ReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if s.reloader == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "reload not available"})
+		return
+	}
+	applied, restartRequired, err := s.reloader.ReloadConfig()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, &api.ReloadResponse{Applied: applied, RestartRequired: restartRequired})
+}
+
+func (s *ProxyServer) findSession(id uint64) *Session {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+
+	for cs := range s.sessions {
+		if cs.id == id {
+			return cs
+		}
+	}
+	return nil
+}
+
+func (cs *Session) info() *SessionInfo {
+	cs.infoMu.RLock()
+	defer cs.infoMu.RUnlock()
+
+	info := &SessionInfo{
+		ID:          cs.id,
+		IP:          cs.ip,
+		Port:        cs.port,
+		Login:       cs.login,
+		Worker:      cs.worker,
+		Solo:        cs.solo,
+		Extranonce:  cs.Extranonce,
+		UserAgent:   cs.userAgent,
+		ConnectedAt: cs.connectedAt.Unix(),
+	}
+	if lastShare := atomic.LoadInt64(&cs.lastShare); lastShare > 0 {
+		info.LastShare = time.Unix(0, lastShare).Unix()
+	}
+	return info
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Global.WithField("err", err).Error("Error serializing admin response")
+	}
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/dominant-strategies/go-quai-stratum/api"
+)
+
+func newAdminTestProxy(t *testing.T) (*ProxyServer, http.Handler) {
+	s := &ProxyServer{
+		config:   &Config{Proxy: Proxy{Admin: Admin{Enabled: true, Token: "secret"}}},
+		sessions: make(map[*Session]struct{}),
+	}
+	r := mux.NewRouter()
+	s.registerAdminRoutes(r)
+	return s, r
+}
+
+// tcpPair returns the server side of a loopback TCP connection.
+func tcpPair(t *testing.T) *net.TCPConn {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn.(*net.TCPConn)
+}
+
+func adminRequest(h http.Handler, method, url, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminAuth(t *testing.T) {
+	_, h := newAdminTestProxy(t)
+
+	if w := adminRequest(h, "GET", "/admin/sessions", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Must reject missing token, got %v", w.Code)
+	}
+	if w := adminRequest(h, "GET", "/admin/sessions", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Must reject wrong token, got %v", w.Code)
+	}
+	if w := adminRequest(h, "GET", "/admin/sessions", "secret"); w.Code != http.StatusOK {
+		t.Errorf("Must accept token, got %v", w.Code)
+	}
+}
+
+func TestAdminSessions(t *testing.T) {
+	s, h := newAdminTestProxy(t)
+
+	rig := &Session{id: 1, ip: "10.0.0.1", login: "0xa", worker: "rig", userAgent: "TeamRedMiner/0.10", connectedAt: time.Now(), conn: tcpPair(t)}
+	other := &Session{id: 2, ip: "10.0.0.2", login: "0xb", worker: "0", connectedAt: time.Now(), conn: tcpPair(t)}
+	s.registerSession(rig)
+	s.registerSession(other)
+
+	var reply struct {
+		Sessions []*SessionInfo `json:"sessions"`
+		Total    int            `json:"total"`
+	}
+	w := adminRequest(h, "GET", "/admin/sessions?agent=teamred", "secret")
+	json.NewDecoder(w.Body).Decode(&reply)
+	if reply.Total != 1 || reply.Sessions[0].Worker != "rig" || reply.Sessions[0].UserAgent != "TeamRedMiner/0.10" {
+		t.Errorf("Must filter sessions by agent: %+v", reply)
+	}
+
+	w = adminRequest(h, "GET", "/admin/sessions?login=0xB", "secret")
+	json.NewDecoder(w.Body).Decode(&reply)
+	if reply.Total != 1 || reply.Sessions[0].ID != 2 {
+		t.Errorf("Must filter sessions by login: %+v", reply)
+	}
+
+	if w := adminRequest(h, "DELETE", "/admin/sessions/1", "secret"); w.Code != http.StatusOK {
+		t.Fatalf("Must kick session, got %v", w.Code)
+	}
+	if s.findSession(1) != nil {
+		t.Error("Must remove kicked session")
+	}
+	if _, err := rig.conn.Write([]byte("x")); err == nil {
+		t.Error("Must close kicked connection")
+	}
+	if w := adminRequest(h, "DELETE", "/admin/sessions/1", "secret"); w.Code != http.StatusNotFound {
+		t.Errorf("Must return 404 for unknown session, got %v", w.Code)
+	}
+}
+
+type staticReloader struct {
+	restartRequired []string
+}
+
+func (r *staticReloader) ReloadConfig() ([]string, []string, error) {
+	return []string{}, r.restartRequired, nil
+}
+
+func TestAdminReload(t *testing.T) {
+	s, h := newAdminTestProxy(t)
+	if w := adminRequest(h, "POST", "/admin/reload", "secret"); w.Code != http.StatusNotFound {
+		t.Errorf("Must return 404 without reloader, got %v", w.Code)
+	}
+	s.SetConfigReloader(&staticReloader{restartRequired: []string{"proxy.listen"}})
+
+	var reply api.ReloadResponse
+	w := adminRequest(h, "POST", "/admin/reload", "secret")
+	json.NewDecoder(w.Body).Decode(&reply)
+	if w.Code != http.StatusOK || len(reply.RestartRequired) != 1 || reply.RestartRequired[0] != "proxy.listen" {
+		t.Errorf("Must report settings needing a restart: %v %+v", w.Code, reply)
+	}
+}
+
+func TestHelloAgent(t *testing.T) {
+	if helloAgent([]interface{}{"lolMiner 1.88", "pool", "3333", "EthereumStratum/2.0.0"}) != "lolMiner 1.88" {
+		t.Error("Must read agent from params list")
+	}
+	if helloAgent(map[string]interface{}{"agent": "quai-gpu-miner"}) != "quai-gpu-miner" {
+		t.Error("Must read agent from params object")
+	}
+}
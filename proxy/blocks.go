@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"math/big"
+	"time"
 
 	"sync"
 
@@ -18,6 +19,75 @@ type BlockTemplate struct {
 	Difficulty *big.Int
 	Height     []*big.Int
 	JobID      uint
+	// CleanJobs is set when the template builds on a new parent and
+	// miners must abandon any work on previous jobs.
+	CleanJobs bool
+	CreatedAt time.Time
+
+	key *templateKey
+}
+
+type templateChange int
+
+const (
+	templateUnchanged templateChange = iota
+	templateNewParent
+	templateRefresh
+)
+
+// templateKey holds the fields of a pending header telling how it differs
+// from the current template.
+type templateKey struct {
+	sealHash   common.Hash
+	parentHash common.Hash
+	number     uint64
+}
+
+func templateKeyOf(wo *types.WorkObject) *templateKey {
+	if wo == nil || wo.WorkObjectHeader() == nil {
+		return nil
+	}
+	return &templateKey{
+		sealHash:   wo.SealHash(),
+		parentHash: wo.ParentHash(common.ZONE_CTX),
+		number:     wo.NumberU64(common.ZONE_CTX),
+	}
+}
+
+// classifyTemplateChange tells whether the pending header moves the chain
+// forward or only refreshes the transactions/ETXs of the current height.
+func classifyTemplateChange(t *BlockTemplate, pending *templateKey) templateChange {
+	if pending == nil {
+		return templateUnchanged
+	}
+	if t == nil || t.key == nil {
+		return templateNewParent
+	}
+	if t.key.sealHash == pending.sealHash {
+		return templateUnchanged
+	}
+	if t.key.parentHash != pending.parentHash || t.key.number != pending.number {
+		return templateNewParent
+	}
+	return templateRefresh
+}
+
+// refreshDue holds back refreshes of t coming sooner than interval after it
+// was created.
+func refreshDue(t *BlockTemplate, interval time.Duration, now time.Time) bool {
+	return now.Sub(t.CreatedAt) >= interval
+}
+
+var big2e256 = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// meetsBlockTarget tells whether powHash meets the block difficulty rather
+// than only the workshare threshold.
+func meetsBlockTarget(powHash common.Hash, difficulty *big.Int) bool {
+	if difficulty == nil || difficulty.Sign() <= 0 {
+		return false
+	}
+	target := new(big.Int).Div(big2e256, difficulty)
+	return powHash.Big().Cmp(target) <= 0
 }
 
 type Block struct {
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common"
+)
+
+func TestClassifyTemplateChange(t *testing.T) {
+	current := &BlockTemplate{key: &templateKey{sealHash: common.Hash{1}, parentHash: common.Hash{2}, number: 10}}
+	tests := []struct {
+		name     string
+		template *BlockTemplate
+		pending  *templateKey
+		want     templateChange
+	}{
+		{"first template", nil, &templateKey{sealHash: common.Hash{1}}, templateNewParent},
+		{"no pending header", current, nil, templateUnchanged},
+		{"same header", current, &templateKey{sealHash: common.Hash{1}, parentHash: common.Hash{2}, number: 10}, templateUnchanged},
+		{"parent change", current, &templateKey{sealHash: common.Hash{3}, parentHash: common.Hash{4}, number: 11}, templateNewParent},
+		{"reorg at same height", current, &templateKey{sealHash: common.Hash{3}, parentHash: common.Hash{4}, number: 10}, templateNewParent},
+		{"same parent, txs or coinbase change", current, &templateKey{sealHash: common.Hash{3}, parentHash: common.Hash{2}, number: 10}, templateRefresh},
+	}
+	for _, test := range tests {
+		if got := classifyTemplateChange(test.template, test.pending); got != test.want {
+			t.Errorf("%s: must classify as %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestRefreshDue(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		age      time.Duration
+		interval time.Duration
+		want     bool
+	}{
+		{"inside interval", time.Second, 5 * time.Second, false},
+		{"interval elapsed", 5 * time.Second, 5 * time.Second, true},
+		{"after interval", time.Minute, 5 * time.Second, true},
+		{"no interval", 0, 0, true},
+	}
+	for _, test := range tests {
+		template := &BlockTemplate{CreatedAt: now.Add(-test.age)}
+		if got := refreshDue(template, test.interval, now); got != test.want {
+			t.Errorf("%s: refresh due must be %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestMeetsBlockTarget(t *testing.T) {
+	// Difficulty 2^8 makes 2^248 the block target
+	difficulty := big.NewInt(256)
+	target := new(big.Int).Lsh(big.NewInt(1), 248)
+	tests := []struct {
+		name       string
+		powHash    *big.Int
+		difficulty *big.Int
+		want       bool
+	}{
+		{"below target", new(big.Int).Sub(target, big.NewInt(1)), difficulty, true},
+		{"at target", target, difficulty, true},
+		{"workshare above target", new(big.Int).Add(target, big.NewInt(1)), difficulty, false},
+		{"no difficulty", big.NewInt(0), nil, false},
+	}
+	for _, test := range tests {
+		powHash := common.BytesToHash(test.powHash.Bytes())
+		if got := meetsBlockTarget(powHash, test.difficulty); got != test.want {
+			t.Errorf("%s: must be %v, got %v", test.name, test.want, got)
+		}
+	}
+}
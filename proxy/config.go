@@ -2,6 +2,10 @@ package proxy
 
 import (
 	"github.com/dominant-strategies/go-quai-stratum/api"
+	"github.com/dominant-strategies/go-quai-stratum/events"
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
+	"github.com/dominant-strategies/go-quai-stratum/notify"
+	"github.com/dominant-strategies/go-quai-stratum/payouts"
 	"github.com/dominant-strategies/go-quai-stratum/policy"
 	"github.com/dominant-strategies/go-quai-stratum/storage"
 	"github.com/dominant-strategies/go-quai/common/hexutil"
@@ -15,18 +19,25 @@ type Config struct {
 	UpstreamCheckInterval string        `json:"upstreamCheckInterval"`
 
 	Threads int `json:"threads"`
+	// One of panic, fatal, error, warn, info, debug or trace
+	LogLevel string `json:"logLevel"`
 
-	Network string         `json:"network"`
-	Coin    string         `json:"coin"`
-	Redis   storage.Config `json:"redis"`
+	Network string                `json:"network"`
+	Coin    string                `json:"coin"`
+	Storage storage.BackendConfig `json:"storage"`
+	Redis   storage.Config        `json:"redis"`
+	Rewards storage.RewardConfig  `json:"rewards"`
+
+	BlockUnlocker payouts.UnlockerConfig   `json:"unlocker"`
+	Payouts       payouts.PayoutsConfig    `json:"payouts"`
+	Reconciler    payouts.ReconcilerConfig `json:"reconciler"`
 
 	AvgBlockTime    float64 `json:"avgBlockTime"`
 	BlockTimeWindow int64   `json:"blockTimeWindow"`
 
-	NewrelicName    string `json:"newrelicName"`
-	NewrelicKey     string `json:"newrelicKey"`
-	NewrelicVerbose bool   `json:"newrelicVerbose"`
-	NewrelicEnabled bool   `json:"newrelicEnabled"`
+	Metrics metrics.Config `json:"metrics"`
+	Events  events.Config  `json:"events"`
+	Notify  notify.Config  `json:"notify"`
 }
 
 type Proxy struct {
@@ -36,11 +47,13 @@ type Proxy struct {
 	LimitBodySize        int64        `json:"limitBodySize"`
 	BehindReverseProxy   bool         `json:"behindReverseProxy"`
 	BlockRefreshInterval string       `json:"blockRefreshInterval"`
+	JobRefreshInterval   string       `json:"jobRefreshInterval"`
 	Difficulty           *hexutil.Big `json:"difficulty"`
 	StateUpdateInterval  string       `json:"stateUpdateInterval"`
 	HashrateExpiration   string       `json:"hashrateExpiration"`
 
 	Policy policy.Config `json:"policy"`
+	Admin  Admin         `json:"admin"`
 
 	MaxFails    int64 `json:"maxFails"`
 	HealthCheck bool  `json:"healthCheck"`
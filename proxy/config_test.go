@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func loadExampleConfig(t *testing.T) *Config {
+	data, err := os.ReadFile("../config/config.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	return &cfg
+}
+
+func problemsOf(t *testing.T, err error) []string {
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Must return a ValidationError, got %v", err)
+	}
+	return invalid.Problems
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := loadExampleConfig(t)
+	cfg.Redis.Enabled = true
+	cfg.Upstream[1].Url = "ws://127.0.0.1:8002"
+	cfg.Upstream[2].Url = "ws://127.0.0.1:8003"
+	all := Roles{Proxy: true, Api: true, Unlocker: true, Payer: true, Reconciler: true}
+	cfg.SetDefaults()
+	if err := cfg.Validate(all); err != nil {
+		t.Fatalf("Example config must be valid: %v", err)
+	}
+
+	cfg.Proxy.BlockRefreshInterval = "soon"
+	cfg.Upstream = cfg.Upstream[:2]
+	cfg.Payouts.Address = ""
+	cfg.BlockUnlocker.PoolFee = 120
+	cfg.BlockUnlocker.Depth = cfg.BlockUnlocker.ImmatureDepth
+	problems := problemsOf(t, cfg.Validate(all))
+	want := []string{"proxy.blockRefreshInterval", "upstream", "unlocker.depth", "unlocker.poolFee", "payouts.address"}
+	if len(problems) != len(want) {
+		t.Fatalf("Must report every problem at once: %v", problems)
+	}
+	for i, name := range want {
+		if !strings.HasPrefix(problems[i], name+": ") {
+			t.Errorf("Problem %v must be about %s: %s", i, name, problems[i])
+		}
+	}
+
+	if err := cfg.Validate(Roles{Api: true}); err != nil {
+		t.Errorf("Must only check the given roles: %v", err)
+	}
+	cfg.Redis.Enabled = false
+	if problems := problemsOf(t, cfg.Validate(Roles{Payer: true})); !strings.HasPrefix(problems[0], "redis.enabled: ") {
+		t.Errorf("Must require storage: %v", problems)
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	cfg := &Config{Upstream: make([]Upstream, 3)}
+	cfg.Proxy.HashrateExpiration = "1h"
+	cfg.SetDefaults()
+	if cfg.Proxy.HashrateExpiration != "1h" {
+		t.Error("Must keep settings")
+	}
+	if cfg.Proxy.BlockRefreshInterval != "1s" || cfg.Upstream[2].Timeout != "10s" || cfg.Proxy.Policy.Workers != 8 {
+		t.Errorf("Must fill in defaults: %+v", cfg.Proxy)
+	}
+	if len(cfg.Proxy.JobRefreshInterval) > 0 || len(cfg.Reconciler.PaymentTimeout) > 0 {
+		t.Error("Must leave settings where empty means disabled")
+	}
+}
+
+func TestConfigApplyEnv(t *testing.T) {
+	cfg := loadExampleConfig(t)
+	err := cfg.ApplyEnv([]string{
+		"HOME=/root",
+		"QUAI_STRATUM_PROXY_STRATUM_LISTEN=0.0.0.0:4444",
+		"QUAI_STRATUM_PROXY_BLOCK_REFRESH_INTERVAL=2s",
+		"QUAI_STRATUM_PROXY_DIFFICULTY=0x10",
+		"QUAI_STRATUM_REDIS_ENABLED=true",
+		"QUAI_STRATUM_UPSTREAM_2_URL=ws://node:8003",
+		"QUAI_STRATUM_UNLOCKER_POOL_FEE=1.5",
+		"QUAI_STRATUM_UNLOCKER_DAEMONS=[\"a\",\"\",\"c\"]",
+		"QUAI_STRATUM_UNLOCKER_FEE_OVERRIDES={\"0x1\":0.5}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Proxy.Stratum.Listen != "0.0.0.0:4444" || cfg.Proxy.BlockRefreshInterval != "2s" || cfg.Proxy.Difficulty.ToInt().Int64() != 16 {
+		t.Errorf("Must override proxy settings: %+v", cfg.Proxy)
+	}
+	if !cfg.Redis.Enabled || cfg.Upstream[2].Url != "ws://node:8003" {
+		t.Errorf("Must override redis and upstream: %+v %+v", cfg.Redis, cfg.Upstream[2])
+	}
+	u := cfg.BlockUnlocker
+	if u.PoolFee != 1.5 || len(u.Daemons) != 3 || u.Daemons[2] != "c" || u.FeeOverrides["0x1"] != 0.5 {
+		t.Errorf("Must override unlocker settings: %+v", u)
+	}
+
+	problems := problemsOf(t, cfg.ApplyEnv([]string{
+		"QUAI_STRATUM_REDIS_POOL_SIZE=many",
+		"QUAI_STRATUM_UPSTREAM_5_URL=ws://node:8005",
+		"QUAI_STRATUM_PROXY_LISTN=0.0.0.0:8888",
+	}))
+	if len(problems) != 3 || !strings.HasPrefix(problems[0], "QUAI_STRATUM_REDIS_POOL_SIZE: ") ||
+		!strings.HasPrefix(problems[1], "QUAI_STRATUM_PROXY_LISTN: unknown") {
+		t.Errorf("Must report bad values and unknown variables: %v", problems)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for key, name := range map[string]string{
+		"coin":                 "COIN",
+		"blockRefreshInterval": "BLOCK_REFRESH_INTERVAL",
+		"stratum_nice_hash":    "STRATUM_NICE_HASH",
+		"pplnsWindow":          "PPLNS_WINDOW",
+		"DSN":                  "DSN",
+	} {
+		if got := envName(key); got != name {
+			t.Errorf("envName(%q) = %q, want %q", key, got, name)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// EnvPrefix starts the names of environment variables overriding the config.
+const EnvPrefix = "QUAI_STRATUM_"
+
+// ApplyEnv overrides settings with the QUAI_STRATUM_* variables of environ,
+// given as "NAME=value". Variables are named after the JSON path of the
+// setting in upper snake case, with list entries by index, e.g.
+// QUAI_STRATUM_PROXY_STRATUM_LISTEN or QUAI_STRATUM_UPSTREAM_2_URL. Strings
+// are taken as is, other values are parsed as JSON, so a whole list or
+// section may be replaced at once. Unknown variables are reported along with
+// values which don't parse.
+func (c *Config) ApplyEnv(environ []string) error {
+	vars := make(map[string]string)
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && strings.HasPrefix(name, EnvPrefix) {
+			vars[name] = value
+		}
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+
+	var p problems
+	applyEnv(reflect.ValueOf(c).Elem(), strings.TrimSuffix(EnvPrefix, "_"), vars, &p)
+	unknown := make([]string, 0, len(vars))
+	for name := range vars {
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		p.add(name, "unknown setting")
+	}
+	if len(p) > 0 {
+		return &ValidationError{Problems: p}
+	}
+	return nil
+}
+
+// applyEnv sets v from the variable called name, then walks into structs and
+// list entries. Applied variables are removed from vars.
+func applyEnv(v reflect.Value, name string, vars map[string]string, p *problems) {
+	if value, ok := vars[name]; ok {
+		delete(vars, name)
+		if err := setEnvValue(v, value); err != nil {
+			p.add(name, "%v", err)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		if _, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if key, ok := jsonKey(t.Field(i)); ok {
+				applyEnv(v.Field(i), name+"_"+envName(key), vars, p)
+			}
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			applyEnv(v.Index(i), name+"_"+strconv.Itoa(i), vars, p)
+		}
+	}
+}
+
+// jsonKey returns the key of a field in the JSON config.
+func jsonKey(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if key == "-" {
+		return "", false
+	}
+	if len(key) == 0 {
+		key = field.Name
+	}
+	return key, true
+}
+
+func setEnvValue(v reflect.Value, value string) error {
+	if v.Kind() == reflect.String {
+		v.SetString(value)
+		return nil
+	}
+	target := v.Addr().Interface()
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		target = v.Interface()
+	}
+	if u, ok := target.(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+	return json.Unmarshal([]byte(value), target)
+}
+
+// envName turns a JSON key such as "blockRefreshInterval" into
+// "BLOCK_REFRESH_INTERVAL".
+func envName(key string) string {
+	var b strings.Builder
+	runes := []rune(key)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && !unicode.IsUpper(runes[i-1]) && runes[i-1] != '_' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
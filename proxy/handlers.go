@@ -31,15 +31,35 @@ func (s *ProxyServer) handleLoginRPC(cs *Session, req Request) error {
 	}
 	login := strings.ToLower(account)
 
+	// Miners may append a rig name to the address as "address.worker".
+	worker := "0"
+	if i := strings.Index(login, "."); i >= 0 {
+		if workerPattern.MatchString(login[i+1:]) {
+			worker = login[i+1:]
+		}
+		login = login[:i]
+	}
+
 	if !s.policy.ApplyLoginPolicy(login, cs.ip) {
 		return fmt.Errorf("you are blacklisted")
 	}
+	cs.infoMu.Lock()
 	cs.login = login
+	cs.worker = worker
+
+	// Miners opt into solo mining with the "solo" password.
+	if len(params) > 1 && s.config.Rewards.AllowSolo {
+		password, _ := params[1].(string)
+		cs.solo = password == "solo"
+	}
+	cs.infoMu.Unlock()
 	s.registerSession(cs)
 	log.Global.WithFields(log.Fields{
-		"login": cs.login,
-		"ip":    cs.ip,
-		"port":  cs.port,
+		"login":  cs.login,
+		"worker": cs.worker,
+		"solo":   cs.solo,
+		"ip":     cs.ip,
+		"port":   cs.port,
 	}).Printf("Stratum miner connected")
 
 	if s.config.Proxy.Stratum.Enabled {
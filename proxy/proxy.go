@@ -14,10 +14,15 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/dominant-strategies/go-quai-stratum/api"
+	"github.com/dominant-strategies/go-quai-stratum/events"
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
+	"github.com/dominant-strategies/go-quai-stratum/notify"
 	"github.com/dominant-strategies/go-quai-stratum/policy"
 	"github.com/dominant-strategies/go-quai-stratum/storage"
 	"github.com/dominant-strategies/go-quai-stratum/util"
 	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/common/hexutil"
 	"github.com/dominant-strategies/go-quai/consensus"
 	"github.com/dominant-strategies/go-quai/consensus/progpow"
 	"github.com/dominant-strategies/go-quai/core/types"
@@ -34,19 +39,26 @@ const (
 )
 
 type ProxyServer struct {
-	context            context.Context
-	config             *Config
-	blockTemplate      atomic.Value
-	upstreams          *[]Upstream
-	clients            SliceClients
-	backend            *storage.RedisClient
-	diff               string
+	context       context.Context
+	config        *Config
+	blockTemplate atomic.Value
+	upstreams     *[]Upstream
+	clients       SliceClients
+	backend       storage.Backend
+	// Holds the target of the configured difficulty, replaced on reload
+	diff               atomic.Value
 	threshold          uint64
 	policy             *policy.PolicyServer
 	hashrateExpiration time.Duration
+	jobRefreshIntv     time.Duration
 	failsCount         int64
 	engine             consensus.Engine
 	rng                *rand.Rand
+	events             *events.Stream
+	notifier           *notify.Notifier
+	sessionDrops       *notify.DropDetector
+	workerShares       *workerShares
+	reloader           api.ConfigReloader
 
 	// Channel to receive header updates
 	updateCh chan []byte
@@ -57,6 +69,7 @@ type ProxyServer struct {
 	// Stratum
 	sessionsMu sync.RWMutex
 	sessions   map[*Session]struct{}
+	sessionSeq uint64
 	timeout    time.Duration
 	Extranonce string
 }
@@ -68,14 +81,23 @@ type jobDetails struct {
 }
 
 type Session struct {
-	ip   string
-	port string
-	enc  *json.Encoder
+	id          uint64
+	ip          string
+	port        string
+	enc         *json.Encoder
+	connectedAt time.Time
+	// Unix nanoseconds of the last valid share, accessed atomically
+	lastShare int64
 
 	// Stratum
 	sync.Mutex
-	conn           *net.TCPConn
+	conn *net.TCPConn
+	// Guards fields read by the admin API
+	infoMu         sync.RWMutex
 	login          string
+	worker         string
+	solo           bool
+	userAgent      string
 	subscriptionID string
 	Extranonce     string
 	JobDetails     jobDetails
@@ -83,7 +105,7 @@ type Session struct {
 
 type SliceClients [common.HierarchyDepth]*quaiclient.Client
 
-func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
+func NewProxy(cfg *Config, backend storage.Backend) *ProxyServer {
 	if len(cfg.Name) == 0 {
 		log.Global.Fatal("You must set instance name")
 	}
@@ -103,10 +125,11 @@ func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
 			false,
 			log.Global,
 		),
-		updateCh: make(chan []byte, 5*1024),
-		woCache:  lru.NewLRU[uint, *types.WorkObject](100, nil, 0),
+		updateCh:     make(chan []byte, 5*1024),
+		woCache:      lru.NewLRU[uint, *types.WorkObject](100, nil, 0),
+		workerShares: newWorkerShares(),
 	}
-	proxy.diff = util.GetTargetHex(cfg.Proxy.Difficulty)
+	proxy.diff.Store(util.GetTargetHex(cfg.Proxy.Difficulty))
 
 	proxy.clients = proxy.connectToSlice()
 
@@ -114,6 +137,35 @@ func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
 
 	proxy.hashrateExpiration = util.MustParseDuration(cfg.Proxy.HashrateExpiration)
 
+	if backend != nil {
+		go proxy.writeWorkerShares()
+	}
+
+	if cfg.Events.Enabled {
+		stream, err := events.New(&cfg.Events, backend)
+		if err != nil {
+			log.Global.Fatal("Unable to start event stream: ", err)
+		}
+		proxy.events = stream
+	}
+
+	if cfg.Notify.Enabled {
+		notifier, err := notify.New(&cfg.Notify)
+		if err != nil {
+			log.Global.Fatal("Unable to start notifier: ", err)
+		}
+		proxy.notifier = notifier
+		if cfg.Notify.SessionDrop.Enabled {
+			window := util.MustParseDuration(cfg.Notify.SessionDrop.Window)
+			proxy.sessionDrops = notify.NewDropDetector(&cfg.Notify.SessionDrop, window)
+		}
+	}
+
+	if len(cfg.Proxy.JobRefreshInterval) > 0 {
+		proxy.jobRefreshIntv = util.MustParseDuration(cfg.Proxy.JobRefreshInterval)
+		log.Global.Printf("Limit same-height job refreshes to one every %v", proxy.jobRefreshIntv)
+	}
+
 	refreshIntv := util.MustParseDuration(cfg.Proxy.BlockRefreshInterval)
 	refreshTimer := time.NewTimer(refreshIntv)
 	log.Global.Printf("Set block refresh every %v", refreshIntv)
@@ -231,6 +283,9 @@ func (s *ProxyServer) connectToSlice() SliceClients {
 func (s *ProxyServer) Start() {
 	log.Global.Printf("Starting proxy on %v", s.config.Proxy.Listen)
 	r := mux.NewRouter()
+	if s.config.Proxy.Admin.Enabled {
+		s.registerAdminRoutes(r)
+	}
 	srv := &http.Server{
 		Addr:           s.config.Proxy.Listen,
 		Handler:        r,
@@ -257,7 +312,14 @@ func (s *ProxyServer) currentBlockTemplate() *BlockTemplate {
 }
 
 func (s *ProxyServer) markSick() {
-	atomic.AddInt64(&s.failsCount, 1)
+	x := atomic.AddInt64(&s.failsCount, 1)
+	if s.config.Proxy.HealthCheck && x == s.config.Proxy.MaxFails {
+		s.notifier.Notify(&notify.Alert{
+			Kind:    notify.AlertUpstreamUnhealthy,
+			Message: fmt.Sprintf("Upstream %s is unhealthy after %d failures", (*s.upstreams)[common.ZONE_CTX].Name, x),
+			Fields:  map[string]interface{}{"upstream": (*s.upstreams)[common.ZONE_CTX].Name, "fails": x},
+		})
+	}
 }
 
 func (s *ProxyServer) isSick() bool {
@@ -269,24 +331,44 @@ func (s *ProxyServer) isSick() bool {
 }
 
 func (s *ProxyServer) markOk() {
-	atomic.StoreInt64(&s.failsCount, 0)
+	x := atomic.SwapInt64(&s.failsCount, 0)
+	if s.config.Proxy.HealthCheck && x >= s.config.Proxy.MaxFails {
+		s.notifier.Notify(&notify.Alert{
+			Kind:    notify.AlertUpstreamRecovered,
+			Message: fmt.Sprintf("Upstream %s recovered", (*s.upstreams)[common.ZONE_CTX].Name),
+			Fields:  map[string]interface{}{"upstream": (*s.upstreams)[common.ZONE_CTX].Name},
+		})
+	}
 }
 
 func (s *ProxyServer) fetchBlockTemplate() {
+	start := time.Now()
 	pendingHeader, err := s.clients[common.ZONE_CTX].GetPendingHeader(s.context)
+	metrics.ObserveUpstream(levelName(common.ZONE_CTX), "getPendingHeader", start, err)
 	if err != nil {
 		log.Global.Printf("Error while getting pending header (work) on %s: %s", (*s.upstreams)[common.ZONE_CTX].Name, err)
+		s.markSick()
 		return
 	}
+	s.markOk()
 	s.updateBlockTemplate(pendingHeader)
 }
 
 func (s *ProxyServer) updateBlockTemplate(pendingWo *types.WorkObject) {
 	t := s.currentBlockTemplate()
 
-	// Short circuit if the pending header is the same as the current one
-	if t != nil && t.WorkObject != nil && t.WorkObject.WorkObjectHeader() != nil && t.WorkObject.WorkObjectHeader().SealHash() == pendingWo.SealHash() {
+	key := templateKeyOf(pendingWo)
+	change := classifyTemplateChange(t, key)
+	switch change {
+	case templateUnchanged:
+		// Short circuit if the pending header is the same as the current one
 		return
+	case templateRefresh:
+		// Only transactions/ETXs changed. Work on the previous job is still
+		// valid, so don't flood miners with a new job on every refresh.
+		if !refreshDue(t, s.jobRefreshIntv, time.Now()) {
+			return
+		}
 	}
 
 	if pendingWo.PrimeTerminusNumber() == nil {
@@ -305,6 +387,9 @@ func (s *ProxyServer) updateBlockTemplate(pendingWo *types.WorkObject) {
 		WorkObject: pendingWo,
 		Target:     threshold,
 		Height:     pendingWo.NumberArray(),
+		CleanJobs:  change == templateNewParent,
+		CreatedAt:  time.Now(),
+		key:        key,
 	}
 
 	if t == nil {
@@ -315,6 +400,18 @@ func (s *ProxyServer) updateBlockTemplate(pendingWo *types.WorkObject) {
 
 	s.blockTemplate.Store(&newTemplate)
 	s.woCache.Add(newTemplate.JobID, newTemplate.WorkObject)
+	metrics.SetTemplateCreated(newTemplate.CreatedAt)
+
+	if !newTemplate.CleanJobs {
+		log.Global.WithFields(log.Fields{
+			"location": s.config.Upstream[common.ZONE_CTX].Name,
+			"number":   pendingWo.NumberArray(),
+			"sealHash": pendingWo.SealHash(),
+		}).Debug("Refreshed block template")
+		go s.broadcastNewJobs()
+		return
+	}
+
 	difficultyMh := strconv.FormatUint(new(big.Int).Div(consensus.TargetToDifficulty(newTemplate.Target), big.NewInt(1000)).Uint64(), 10)
 	log.Global.WithFields(log.Fields{
 		"location": s.config.Upstream[common.ZONE_CTX].Name,
@@ -331,39 +428,45 @@ func (s *ProxyServer) updateBlockTemplate(pendingWo *types.WorkObject) {
 	go s.broadcastNewJobs()
 }
 
-func (s *ProxyServer) verifyMinedHeader(jobID uint, nonce []byte) (*types.WorkObject, error) {
+// verifyMinedHeader hands the header to the node as a workshare and tells
+// whether it meets the block target as well.
+func (s *ProxyServer) verifyMinedHeader(jobID uint, nonce []byte) (*types.WorkObject, bool, error) {
 	wObject, ok := s.woCache.Get(jobID)
 	if !ok {
-		return nil, fmt.Errorf("unable to find header for that jobID: %d", jobID)
+		return nil, false, fmt.Errorf("unable to find header for that jobID: %d", jobID)
 	}
 	wObject = types.CopyWorkObject(wObject)
 
 	wObject.WorkObjectHeader().SetNonce(types.BlockNonce(nonce))
-	mixHash, _ := s.engine.ComputePowLight(wObject.WorkObjectHeader())
+	mixHash, powHash := s.engine.ComputePowLight(wObject.WorkObjectHeader())
 	wObject.SetMixHash(mixHash)
 
-	if wObject.NumberU64(common.ZONE_CTX) != s.currentBlockTemplate().WorkObject.NumberU64(common.ZONE_CTX) {
+	if s.isStale(wObject) {
 		log.Global.Printf("Stale header received, block number: %d", wObject.NumberU64(common.ZONE_CTX))
 	}
 
+	start := time.Now()
 	err := s.clients[common.ZONE_CTX].ReceiveWorkShare(s.context, wObject.WorkObjectHeader())
+	metrics.ObserveUpstream(levelName(common.ZONE_CTX), "receiveWorkShare", start, err)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	return wObject, nil
+	return wObject, meetsBlockTarget(powHash, wObject.Difficulty()), nil
 }
 
-func (s *ProxyServer) submitMinedHeader(cs *Session, wObject *types.WorkObject) error {
+func (s *ProxyServer) submitMinedHeader(cs *Session, wObject *types.WorkObject) (int, error) {
 
 	powHash, err := s.engine.VerifySeal(wObject.WorkObjectHeader())
 	if err != nil {
-		return fmt.Errorf("unable to verify seal of block: %#x. %v", powHash, err)
+		return 0, fmt.Errorf("unable to verify seal of block: %#x. %v", powHash, err)
 	}
 
+	start := time.Now()
 	order, err := (*s.clients[common.ZONE_CTX]).CalcOrder(s.context, wObject)
+	metrics.ObserveUpstream(levelName(common.ZONE_CTX), "calcOrder", start, err)
 	if err != nil {
-		return fmt.Errorf("rejecting header: %v", err)
+		return 0, fmt.Errorf("rejecting header: %v", err)
 	}
 
 	log.Global.Printf("Received a %s block", strings.ToLower(common.OrderToString(order)))
@@ -371,13 +474,172 @@ func (s *ProxyServer) submitMinedHeader(cs *Session, wObject *types.WorkObject)
 	// Send mined header to the relevant go-quai nodes.
 	// Should be synchronous starting with the lowest levels.
 	for i := common.HierarchyDepth - 1; i >= order; i-- {
+		start := time.Now()
 		err := s.clients[i].ReceiveMinedHeader(s.context, wObject)
+		metrics.ObserveUpstream(levelName(i), "receiveMinedHeader", start, err)
 		if err != nil {
 			// Header was rejected. Refresh workers to try again.
 			cs.pushNewJob(s.currentBlockTemplate())
-			return fmt.Errorf("rejected header: %v", err)
+			return 0, fmt.Errorf("rejected header: %v", err)
 		}
 	}
 
-	return nil
+	s.recordBlock(cs, wObject, order)
+	if order < common.ZONE_CTX {
+		s.notifier.Notify(&notify.Alert{
+			Kind:    notify.AlertBlock,
+			Key:     wObject.Hash().Hex(),
+			Message: fmt.Sprintf("Found a %s block %s", levelName(order), wObject.Hash().Hex()),
+			Fields: map[string]interface{}{
+				"order":  levelName(order),
+				"hash":   wObject.Hash().Hex(),
+				"number": wObject.NumberU64(common.ZONE_CTX),
+				"login":  cs.login,
+				"worker": cs.worker,
+			},
+		})
+	}
+	return order, nil
+}
+
+func levelName(ctx int) string {
+	return strings.ToLower(common.OrderToString(ctx))
+}
+
+// isStale reports whether the header was mined on a previous zone height.
+func (s *ProxyServer) isStale(wObject *types.WorkObject) bool {
+	t := s.currentBlockTemplate()
+	return t != nil && wObject.NumberU64(common.ZONE_CTX) != t.WorkObject.NumberU64(common.ZONE_CTX)
+}
+
+// shareParams identifies a submitted header for the backend PoW dedup check.
+func shareParams(wObject *types.WorkObject) []string {
+	nonce := wObject.WorkObjectHeader().Nonce()
+	return []string{
+		hexutil.Encode(nonce[:]),
+		wObject.Hash().Hex(),
+	}
+}
+
+func (s *ProxyServer) shareDifficulty() int64 {
+	t := s.currentBlockTemplate()
+	if t == nil || t.Target == nil {
+		return 0
+	}
+	return consensus.TargetToDifficulty(t.Target).Int64()
+}
+
+// recordShare credits a valid workshare to the miner's current round and
+// reports how the share was counted.
+func (s *ProxyServer) recordShare(cs *Session, wObject *types.WorkObject) (status, reason string) {
+	status, reason = metrics.ShareAccepted, "workshare"
+	if s.isStale(wObject) {
+		status, reason = metrics.ShareStale, "old_height"
+	}
+
+	if s.backend == nil {
+		return
+	}
+	writeShare := s.backend.WriteShare
+	if cs.solo {
+		writeShare = s.backend.WriteSoloShare
+	}
+	exist, err := writeShare(cs.login, cs.worker, shareParams(wObject), s.shareDifficulty(), wObject.NumberU64(common.ZONE_CTX), s.hashrateExpiration)
+	if exist {
+		status, reason = metrics.ShareRejected, "duplicate"
+		log.Global.WithFields(log.Fields{
+			"login": cs.login,
+			"ip":    cs.ip,
+		}).Warn("Duplicate share")
+	}
+	if err != nil {
+		log.Global.WithField("err", err).Error("Failed to insert share data into backend")
+	}
+	return
+}
+
+// trackShare counts a submitted share and queues it on the event stream.
+// The header is nil when the submission couldn't be decoded.
+func (s *ProxyServer) trackShare(cs *Session, jobID uint64, wObject *types.WorkObject, status, reason string) {
+	metrics.Shares.WithLabelValues(status, reason).Inc()
+	if status != metrics.ShareRejected {
+		atomic.StoreInt64(&cs.lastShare, time.Now().UnixNano())
+	}
+	if s.backend != nil && len(cs.login) > 0 {
+		s.workerShares.add(cs.login, cs.worker, status)
+	}
+	if s.events == nil {
+		return
+	}
+	e := s.newEvent(cs, events.TypeShare, jobID, wObject)
+	e.Difficulty = s.shareDifficulty()
+	e.Result = status
+	e.Reason = reason
+	s.events.Emit(e)
+}
+
+// trackBlock counts a block accepted by the nodes and queues it on the event stream.
+func (s *ProxyServer) trackBlock(cs *Session, jobID uint64, wObject *types.WorkObject, order int) {
+	metrics.BlocksFound.WithLabelValues(levelName(order)).Inc()
+	if s.events == nil {
+		return
+	}
+	e := s.newEvent(cs, events.TypeBlock, jobID, wObject)
+	e.Difficulty = wObject.Difficulty().Int64()
+	e.Result = metrics.ShareAccepted
+	e.Order = &order
+	s.events.Emit(e)
+}
+
+func (s *ProxyServer) newEvent(cs *Session, kind string, jobID uint64, wObject *types.WorkObject) *events.Event {
+	e := &events.Event{
+		Type:   kind,
+		Login:  cs.login,
+		Worker: cs.worker,
+		IP:     cs.ip,
+		JobID:  jobID,
+	}
+	if wObject != nil {
+		for _, n := range wObject.NumberArray() {
+			e.Height = append(e.Height, n.Uint64())
+		}
+		e.SealHash = wObject.SealHash().Hex()
+	}
+	return e
+}
+
+// trackRejectedBlock counts a block the nodes rejected and queues it on the
+// event stream.
+func (s *ProxyServer) trackRejectedBlock(cs *Session, jobID uint64, wObject *types.WorkObject) {
+	metrics.BlocksRejected.Inc()
+	if s.events == nil {
+		return
+	}
+	e := s.newEvent(cs, events.TypeBlock, jobID, wObject)
+	e.Difficulty = wObject.Difficulty().Int64()
+	e.Result = metrics.ShareRejected
+	e.Reason = "node_rejected"
+	s.events.Emit(e)
+}
+
+// recordBlock closes the current round and stores the block as an unlocker candidate.
+func (s *ProxyServer) recordBlock(cs *Session, wObject *types.WorkObject, order int) {
+	if s.backend == nil {
+		return
+	}
+	params := append(shareParams(wObject), strconv.Itoa(order))
+	writeBlock := s.backend.WriteBlock
+	if cs.solo {
+		writeBlock = s.backend.WriteSoloBlock
+	}
+	exist, err := writeBlock(cs.login, cs.worker, params, s.shareDifficulty(), wObject.Difficulty().Int64(), wObject.NumberU64(common.ZONE_CTX), s.hashrateExpiration)
+	if exist {
+		log.Global.WithFields(log.Fields{
+			"login": cs.login,
+			"ip":    cs.ip,
+		}).Warn("Duplicate block")
+	}
+	if err != nil {
+		log.Global.WithField("err", err).Error("Failed to insert block candidate into backend")
+	}
 }
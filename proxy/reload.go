@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/dominant-strategies/go-quai-stratum/api"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+
+	"github.com/dominant-strategies/go-quai/log"
+)
+
+// liveSettings are applied on reload without a restart, given as JSON paths
+// covering every setting below them.
+var liveSettings = []string{
+	"logLevel",
+	"proxy.difficulty",
+	"proxy.policy.limits",
+	"proxy.policy.banning",
+	"api.hashrateWindow",
+	"api.hashrateLargeWindow",
+	"api.luckWindow",
+}
+
+func isLiveSetting(path string) bool {
+	for _, live := range liveSettings {
+		if path == live || strings.HasPrefix(path, live+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// ReloadChanges compares a reloaded config with the running one. Applied
+// lists the live settings changed since the last load, restartRequired the
+// other settings which differ from those the process was started with.
+func ReloadChanges(started, last, next *Config) (applied, restartRequired []string) {
+	applied = []string{}
+	for _, path := range changedSettings(last, next) {
+		if isLiveSetting(path) {
+			applied = append(applied, path)
+		}
+	}
+	restartRequired = []string{}
+	for _, path := range changedSettings(started, next) {
+		if !isLiveSetting(path) {
+			restartRequired = append(restartRequired, path)
+		}
+	}
+	return applied, restartRequired
+}
+
+// changedSettings returns the JSON paths of the settings which differ, with
+// list entries by index, e.g. "upstream[2].url".
+func changedSettings(a, b *Config) []string {
+	var changed []string
+	compareSettings("", reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem(), &changed)
+	return changed
+}
+
+func compareSettings(path string, a, b reflect.Value, changed *[]string) {
+	switch {
+	case a.Kind() == reflect.Struct && !isTextValue(a):
+		t := a.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if key, ok := jsonKey(t.Field(i)); ok {
+				name := key
+				if len(path) > 0 {
+					name = path + "." + key
+				}
+				compareSettings(name, a.Field(i), b.Field(i), changed)
+			}
+		}
+	case a.Kind() == reflect.Slice && a.Type().Elem().Kind() == reflect.Struct && a.Len() == b.Len():
+		for i := 0; i < a.Len(); i++ {
+			compareSettings(fmt.Sprintf("%s[%d]", path, i), a.Index(i), b.Index(i), changed)
+		}
+	default:
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*changed = append(*changed, path)
+		}
+	}
+}
+
+func isTextValue(v reflect.Value) bool {
+	_, ok := v.Addr().Interface().(interface{ UnmarshalText([]byte) error })
+	return ok
+}
+
+// Reload applies the live settings of cfg to the proxy and its policy.
+func (s *ProxyServer) Reload(cfg *Config) {
+	s.diff.Store(util.GetTargetHex(cfg.Proxy.Difficulty))
+	s.policy.Reload(&cfg.Proxy.Policy)
+	log.Global.WithField("difficulty", cfg.Proxy.Difficulty).Info("Reloaded proxy settings")
+}
+
+// SetConfigReloader enables reloads through the admin API. It must be set
+// before Start.
+func (s *ProxyServer) SetConfigReloader(reloader api.ConfigReloader) {
+	s.reloader = reloader
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dominant-strategies/go-quai/common/hexutil"
+)
+
+func TestReloadChanges(t *testing.T) {
+	started := loadExampleConfig(t)
+	started.SetDefaults()
+
+	next := loadExampleConfig(t)
+	next.SetDefaults()
+	next.Proxy.Policy.Limits.Limit = 50
+	next.Proxy.Difficulty = (*hexutil.Big)(started.Proxy.Difficulty.ToInt())
+	next.Api.HashrateWindow = "10m"
+	next.Proxy.Stratum.Listen = "0.0.0.0:4444"
+	next.Upstream[2].Url = "ws://node:8003"
+
+	applied, restartRequired := ReloadChanges(started, started, next)
+	if want := []string{"proxy.policy.limits.limit", "api.hashrateWindow"}; !reflect.DeepEqual(applied, want) {
+		t.Errorf("Must apply live settings %v, got %v", want, applied)
+	}
+	if want := []string{"proxy.stratum.listen", "upstream[2].url"}; !reflect.DeepEqual(restartRequired, want) {
+		t.Errorf("Must report settings needing a restart %v, got %v", want, restartRequired)
+	}
+
+	// Applied settings are reported once, pending restarts until restarted
+	applied, restartRequired = ReloadChanges(started, next, next)
+	if len(applied) != 0 || len(restartRequired) != 2 {
+		t.Errorf("Must report changes since the last load: %v %v", applied, restartRequired)
+	}
+}
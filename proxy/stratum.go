@@ -8,7 +8,11 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
+	"github.com/dominant-strategies/go-quai-stratum/notify"
 	"github.com/dominant-strategies/go-quai-stratum/util"
 	"github.com/dominant-strategies/go-quai/common"
 	"github.com/dominant-strategies/go-quai/consensus/progpow"
@@ -59,10 +63,12 @@ func (s *ProxyServer) ListenTCP() {
 		}
 		n += 1
 		cs := &Session{
-			conn:       conn,
-			ip:         ip,
-			port:       port,
-			Extranonce: fmt.Sprintf("%04x", s.rng.Intn(0xffff)),
+			id:          atomic.AddUint64(&s.sessionSeq, 1),
+			conn:        conn,
+			ip:          ip,
+			port:        port,
+			connectedAt: time.Now(),
+			Extranonce:  fmt.Sprintf("%04x", s.rng.Intn(0xffff)),
 		}
 
 		accept <- n
@@ -144,6 +150,10 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *Request) error {
 	// Handle RPC methods
 	switch req.Method {
 	case "mining.hello":
+		cs.infoMu.Lock()
+		cs.userAgent = helloAgent(req.Params)
+		cs.infoMu.Unlock()
+
 		response := Response{
 			ID: req.Id,
 			Result: map[string]interface{}{
@@ -194,6 +204,7 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *Request) error {
 				"client": cs.ip,
 				"port":   cs.port,
 			}).Warn("Error decoding jobID")
+			s.trackShare(cs, 0, nil, metrics.ShareRejected, "bad_job")
 			errorResponse = Response{
 				ID: req.Id,
 				Error: map[string]interface{}{
@@ -212,6 +223,7 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *Request) error {
 				"client": cs.ip,
 				"port":   cs.port,
 			}).Warn("Error decoding nonce")
+			s.trackShare(cs, jobId, nil, metrics.ShareRejected, "malformed_nonce")
 			errorResponse = Response{
 				ID: req.Id,
 				Error: map[string]interface{}{
@@ -222,13 +234,14 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *Request) error {
 			return cs.sendMessage(&errorResponse)
 		}
 
-		header, err := s.verifyMinedHeader(uint(jobId), nonce)
+		header, block, err := s.verifyMinedHeader(uint(jobId), nonce)
 		if err != nil {
 			log.Global.WithFields(log.Fields{
 				"err":    err,
 				"client": cs.ip,
 				"port":   cs.port,
 			}).Warn("Unable to verify header")
+			s.trackShare(cs, jobId, nil, metrics.ShareRejected, "bad_nonce")
 			errorResponse = Response{
 				ID: req.Id,
 				Error: map[string]interface{}{
@@ -240,15 +253,35 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *Request) error {
 			return cs.sendMessage(&errorResponse)
 		}
 
-		err = s.submitMinedHeader(cs, header)
-		if err != nil {
+		if !block {
 			log.Global.WithField("workShareHash", header.Hash()).Info("Miner submitted a workShare")
+			status, reason := s.recordShare(cs, header)
+			s.trackShare(cs, jobId, header, status, reason)
 		} else {
+			order, err := s.submitMinedHeader(cs, header)
+			if err != nil {
+				log.Global.WithFields(log.Fields{
+					"err":       err,
+					"location":  s.config.Upstream[common.ZONE_CTX].Name,
+					"number":    header.NumberArray(),
+					"blockhash": header.Hash(),
+				}).Warn("Block rejected by the node")
+				s.trackRejectedBlock(cs, jobId, header)
+				errorResponse = Response{
+					ID: req.Id,
+					Error: map[string]interface{}{
+						"code":    500,
+						"message": "Block rejected",
+					},
+				}
+				return cs.sendMessage(&errorResponse)
+			}
 			log.Global.WithFields(log.Fields{
 				"location":  s.config.Upstream[common.ZONE_CTX].Name,
 				"number":    header.NumberArray(),
 				"blockhash": header.Hash(),
 			}).Info("Miner submitted a block")
+			s.trackBlock(cs, jobId, header, order)
 		}
 
 		successResponse := Response{
@@ -261,6 +294,22 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *Request) error {
 	}
 }
 
+// helloAgent extracts the miner software from mining.hello params, sent
+// either as ["agent", "host", port, "proto"] or {"agent": ...}.
+func helloAgent(params interface{}) string {
+	switch p := params.(type) {
+	case []interface{}:
+		if len(p) > 0 {
+			agent, _ := p[0].(string)
+			return agent
+		}
+	case map[string]interface{}:
+		agent, _ := p["agent"].(string)
+		return agent
+	}
+	return ""
+}
+
 func (cs *Session) sendTCPResult(id uint, result interface{}) error {
 	message := Response{
 		ID:     id,
@@ -286,13 +335,17 @@ func (cs *Session) pushNewJob(template *BlockTemplate) error {
 	// Update target to worker.
 	cs.setMining(template)
 
+	cleanJobs := "0"
+	if template.CleanJobs {
+		cleanJobs = "1"
+	}
 	notification := Notification{
 		Method: "mining.notify",
 		Params: []string{
 			fmt.Sprintf("%x", template.JobID),
 			fmt.Sprintf("%x", template.WorkObject.PrimeTerminusNumber().Uint64()),
 			fmt.Sprintf("%x", template.WorkObject.SealHash()),
-			"0",
+			cleanJobs,
 		},
 	}
 	return cs.sendMessage(&notification)
@@ -302,12 +355,28 @@ func (s *ProxyServer) registerSession(cs *Session) {
 	s.sessionsMu.Lock()
 	defer s.sessionsMu.Unlock()
 	s.sessions[cs] = struct{}{}
+	s.observeSessions(len(s.sessions))
 }
 
 func (s *ProxyServer) removeSession(cs *Session) {
 	s.sessionsMu.Lock()
 	defer s.sessionsMu.Unlock()
 	delete(s.sessions, cs)
+	s.observeSessions(len(s.sessions))
+}
+
+func (s *ProxyServer) observeSessions(n int) {
+	metrics.Sessions.Set(float64(n))
+	if s.sessionDrops == nil {
+		return
+	}
+	if peak, dropped := s.sessionDrops.Observe(n, time.Now()); dropped {
+		s.notifier.Notify(&notify.Alert{
+			Kind:    notify.AlertSessionsDrop,
+			Message: fmt.Sprintf("Stratum sessions dropped from %d to %d", peak, n),
+			Fields:  map[string]interface{}{"from": peak, "to": n},
+		})
+	}
 }
 
 // func (cs *Session) setMining(target common.Hash) error {
@@ -346,7 +415,9 @@ func (s *ProxyServer) broadcastNewJobs() {
 		go func(cs *Session) {
 			err := cs.pushNewJob(t)
 			<-bcast
-			if err != nil {
+			if err == nil {
+				metrics.JobBroadcast.Observe(time.Since(t.CreatedAt).Seconds())
+			} else {
 				log.Global.WithFields(log.Fields{
 					"login": cs.login,
 					"ip":    cs.ip,
@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/sirupsen/logrus"
+)
+
+// ValidationError lists every problem found in a config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// Roles selects the parts of the config checked by Validate.
+type Roles struct {
+	Proxy      bool
+	Api        bool
+	Unlocker   bool
+	Payer      bool
+	Reconciler bool
+}
+
+// EnabledRoles returns the roles enabled by the config.
+func (c *Config) EnabledRoles() Roles {
+	return Roles{
+		Proxy:      c.Proxy.Enabled,
+		Api:        c.Api.Enabled,
+		Unlocker:   c.BlockUnlocker.Enabled,
+		Payer:      c.Payouts.Enabled,
+		Reconciler: c.Reconciler.Enabled,
+	}
+}
+
+// HasStorage tells whether the config sets up a storage backend.
+func (c *Config) HasStorage() bool {
+	return c.Redis.Enabled || c.Storage.Driver == storage.DriverMemory
+}
+
+// SetDefaults fills in the settings left empty which have a documented
+// default. Settings where empty means disabled are left alone.
+func (c *Config) SetDefaults() {
+	defaultString(&c.LogLevel, "info")
+	for i := range c.Upstream {
+		defaultString(&c.Upstream[i].Timeout, "10s")
+	}
+
+	p := &c.Proxy
+	defaultString(&p.BlockRefreshInterval, "1s")
+	defaultString(&p.HashrateExpiration, "3h")
+	defaultString(&p.Stratum.Timeout, "120s")
+	defaultInt(&p.Policy.Workers, 8)
+	defaultString(&p.Policy.ResetInterval, "60m")
+	defaultString(&p.Policy.RefreshInterval, "1m")
+	defaultString(&p.Policy.Limits.Grace, "5m")
+	defaultInt64(&p.Policy.Banning.Timeout, 1800)
+
+	a := &c.Api
+	defaultString(&a.StatsCollectInterval, "5s")
+	defaultString(&a.HashrateWindow, "30m")
+	defaultString(&a.HashrateLargeWindow, "3h")
+	defaultString(&a.PurgeInterval, "10m")
+	defaultInt64(&a.Payments, 30)
+	defaultInt64(&a.Blocks, 50)
+	defaultInt64(&a.Admin.AuditLogSize, 10000)
+	if len(a.LuckWindow) == 0 {
+		a.LuckWindow = []int{64, 128, 256}
+	}
+
+	defaultString(&c.BlockUnlocker.Interval, "10m")
+	defaultString(&c.BlockUnlocker.Timeout, "10s")
+	defaultString(&c.Payouts.Interval, "120m")
+	defaultString(&c.Payouts.Timeout, "10s")
+	defaultString(&c.Payouts.Gas, "21000")
+	defaultString(&c.Reconciler.Interval, "30m")
+
+	defaultString(&c.Metrics.Listen, "127.0.0.1:9100")
+	defaultString(&c.Events.Webhook.Timeout, "5s")
+	defaultString(&c.Notify.Timeout, "5s")
+	defaultString(&c.Notify.RetryDelay, "2s")
+	defaultString(&c.Notify.DedupWindow, "10m")
+	defaultString(&c.Notify.SessionDrop.Window, "5m")
+}
+
+func defaultString(s *string, value string) {
+	if len(*s) == 0 {
+		*s = value
+	}
+}
+
+func defaultInt(n *int, value int) {
+	if *n == 0 {
+		*n = value
+	}
+}
+
+func defaultInt64(n *int64, value int64) {
+	if *n == 0 {
+		*n = value
+	}
+}
+
+// problems collects the messages of a ValidationError.
+type problems []string
+
+func (p *problems) add(name, format string, args ...interface{}) {
+	*p = append(*p, name+": "+fmt.Sprintf(format, args...))
+}
+
+func (p *problems) required(name, value string) {
+	if len(value) == 0 {
+		p.add(name, "must be set")
+	}
+}
+
+// duration checks a duration, an empty one is only allowed when optional.
+func (p *problems) duration(name, value string, optional bool) {
+	if len(value) == 0 && optional {
+		return
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		p.add(name, "invalid duration %q", value)
+	} else if d <= 0 {
+		p.add(name, "must be positive")
+	}
+}
+
+func (p *problems) percent(name string, value float64) {
+	if value < 0 || value > 100 {
+		p.add(name, "must be between 0 and 100")
+	}
+}
+
+// Validate checks the parts of the config used by roles and returns a
+// ValidationError listing every problem, or nil.
+func (c *Config) Validate(roles Roles) error {
+	var p problems
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		p.add("logLevel", "unknown level %q", c.LogLevel)
+	}
+	if (roles.Api || roles.Unlocker || roles.Payer || roles.Reconciler) && !c.HasStorage() {
+		p.add("redis.enabled", "api, unlocker, payouts and reconciler require a storage backend")
+	}
+	if c.HasStorage() {
+		switch c.Storage.Driver {
+		case "", storage.DriverRedis, storage.DriverMemory, storage.DriverHybrid:
+		default:
+			p.add("storage.driver", "unknown driver %q", c.Storage.Driver)
+		}
+		if c.Storage.Driver == storage.DriverHybrid {
+			p.required("storage.sql.dsn", c.Storage.SQL.DSN)
+		}
+		p.duration("redis.timeout", c.Redis.Timeout, true)
+	}
+	if roles.Proxy {
+		c.validateProxy(&p)
+	}
+	if roles.Api {
+		c.validateApi(&p)
+	}
+	if roles.Unlocker {
+		c.validateUnlocker(&p)
+	}
+	if roles.Payer {
+		c.validatePayer(&p)
+	}
+	if roles.Reconciler {
+		p.duration("reconciler.interval", c.Reconciler.Interval, false)
+		p.duration("reconciler.paymentTimeout", c.Reconciler.PaymentTimeout, true)
+	}
+	if c.Metrics.Enabled {
+		p.required("metrics.listen", c.Metrics.Listen)
+	}
+	if len(p) > 0 {
+		return &ValidationError{Problems: p}
+	}
+	return nil
+}
+
+func (c *Config) validateProxy(p *problems) {
+	p.required("name", c.Name)
+	p.required("proxy.listen", c.Proxy.Listen)
+	if c.Proxy.Stratum.Enabled {
+		p.required("proxy.stratum.listen", c.Proxy.Stratum.Listen)
+		p.duration("proxy.stratum.timeout", c.Proxy.Stratum.Timeout, false)
+	}
+	if c.Proxy.Difficulty == nil || c.Proxy.Difficulty.ToInt().Sign() <= 0 {
+		p.add("proxy.difficulty", "must be positive")
+	}
+	p.duration("proxy.blockRefreshInterval", c.Proxy.BlockRefreshInterval, false)
+	p.duration("proxy.jobRefreshInterval", c.Proxy.JobRefreshInterval, true)
+	p.duration("proxy.hashrateExpiration", c.Proxy.HashrateExpiration, false)
+
+	policy := &c.Proxy.Policy
+	p.duration("proxy.policy.resetInterval", policy.ResetInterval, false)
+	p.duration("proxy.policy.refreshInterval", policy.RefreshInterval, false)
+	p.duration("proxy.policy.limits.grace", policy.Limits.Grace, false)
+	if policy.Banning.Timeout <= 0 {
+		p.add("proxy.policy.banning.timeout", "must be positive")
+	}
+	if policy.Banning.Enabled {
+		p.percent("proxy.policy.banning.invalidPercent", float64(policy.Banning.InvalidPercent))
+	}
+
+	if len(c.Upstream) != common.HierarchyDepth {
+		p.add("upstream", "must list %v upstreams ordered prime, region, zone", common.HierarchyDepth)
+	} else {
+		// The prime upstream is optional
+		p.required("upstream[1].url", c.Upstream[common.REGION_CTX].Url)
+		p.required("upstream[2].url", c.Upstream[common.ZONE_CTX].Url)
+		for i, upstream := range c.Upstream {
+			p.duration(fmt.Sprintf("upstream[%v].timeout", i), upstream.Timeout, false)
+		}
+	}
+
+	if c.Events.Webhook.Enabled {
+		p.required("events.webhook.url", c.Events.Webhook.Url)
+		p.duration("events.webhook.timeout", c.Events.Webhook.Timeout, false)
+	}
+	if c.Notify.Enabled {
+		p.duration("notify.timeout", c.Notify.Timeout, false)
+		p.duration("notify.retryDelay", c.Notify.RetryDelay, false)
+		p.duration("notify.dedupWindow", c.Notify.DedupWindow, false)
+		if c.Notify.SessionDrop.Enabled {
+			p.duration("notify.sessionDrop.window", c.Notify.SessionDrop.Window, false)
+		}
+	}
+}
+
+func (c *Config) validateApi(p *problems) {
+	p.required("api.listen", c.Api.Listen)
+	p.duration("api.statsCollectInterval", c.Api.StatsCollectInterval, false)
+	p.duration("api.hashrateWindow", c.Api.HashrateWindow, false)
+	p.duration("api.hashrateLargeWindow", c.Api.HashrateLargeWindow, false)
+	p.duration("api.purgeInterval", c.Api.PurgeInterval, false)
+	if c.Api.Admin.Enabled {
+		p.required("api.admin.token", c.Api.Admin.Token)
+	}
+}
+
+func (c *Config) validateUnlocker(p *problems) {
+	u := &c.BlockUnlocker
+	if len(u.Daemons) != common.HierarchyDepth || len(u.Daemons[common.ZONE_CTX]) == 0 {
+		p.add("unlocker.daemons", "must list prime, region and zone endpoints, zone is required")
+	}
+	if reward, ok := new(big.Int).SetString(u.BlockReward, 10); !ok || reward.Sign() <= 0 {
+		p.add("unlocker.blockReward", "must be a positive amount in Wei")
+	}
+	if u.Depth < 1 {
+		p.add("unlocker.depth", "must be positive")
+	}
+	if u.ImmatureDepth < 1 {
+		p.add("unlocker.immatureDepth", "must be positive")
+	}
+	if u.Depth >= 1 && u.ImmatureDepth >= 1 && u.Depth <= u.ImmatureDepth {
+		p.add("unlocker.depth", "must be greater than unlocker.immatureDepth")
+	}
+	p.percent("unlocker.poolFee", u.PoolFee)
+	p.percent("unlocker.donationFee", u.DonationFee)
+	for login, fee := range u.FeeOverrides {
+		p.percent("unlocker.feeOverrides."+login, fee)
+	}
+	p.duration("unlocker.interval", u.Interval, false)
+	p.duration("unlocker.timeout", u.Timeout, false)
+}
+
+func (c *Config) validatePayer(p *problems) {
+	u := &c.Payouts
+	p.required("payouts.daemon", u.Daemon)
+	p.required("payouts.address", u.Address)
+	if u.Threshold <= 0 {
+		p.add("payouts.threshold", "must be positive")
+	}
+	p.duration("payouts.interval", u.Interval, false)
+	p.duration("payouts.timeout", u.Timeout, false)
+}
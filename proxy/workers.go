@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+	"github.com/dominant-strategies/go-quai/log"
+)
+
+// Worker share counters are gathered in memory and written in one batch
+// every interval, keeping the write off the submit path.
+const workerSharesInterval = 5 * time.Second
+
+type workerKey struct {
+	login  string
+	worker string
+}
+
+// workerShares counts shares per worker until they are taken for writing.
+type workerShares struct {
+	sync.Mutex
+	counts map[workerKey]*storage.WorkerShares
+}
+
+func newWorkerShares() *workerShares {
+	return &workerShares{counts: make(map[workerKey]*storage.WorkerShares)}
+}
+
+func (w *workerShares) add(login, worker, status string) {
+	w.Lock()
+	defer w.Unlock()
+
+	key := workerKey{login, worker}
+	c, ok := w.counts[key]
+	if !ok {
+		c = &storage.WorkerShares{Login: login, Worker: worker}
+		w.counts[key] = c
+	}
+	switch status {
+	case metrics.ShareAccepted:
+		c.Valid++
+	case metrics.ShareStale:
+		c.Stale++
+	default:
+		c.Invalid++
+	}
+	c.LastShare = util.MakeTimestamp() / 1000
+}
+
+// take returns the counters gathered so far and starts over.
+func (w *workerShares) take() []*storage.WorkerShares {
+	w.Lock()
+	defer w.Unlock()
+
+	shares := make([]*storage.WorkerShares, 0, len(w.counts))
+	for _, c := range w.counts {
+		shares = append(shares, c)
+	}
+	w.counts = make(map[workerKey]*storage.WorkerShares)
+	return shares
+}
+
+func (s *ProxyServer) writeWorkerShares() {
+	for range time.Tick(workerSharesInterval) {
+		s.flushWorkerShares()
+	}
+}
+
+func (s *ProxyServer) flushWorkerShares() {
+	shares := s.workerShares.take()
+	if len(shares) == 0 {
+		return
+	}
+	if err := s.backend.WriteWorkerShares(shares); err != nil {
+		log.Global.WithField("err", err).Error("Failed to update worker share counters")
+	}
+}
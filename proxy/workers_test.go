@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
+	"github.com/dominant-strategies/go-quai-stratum/storage"
+)
+
+func TestFlushWorkerShares(t *testing.T) {
+	backend := storage.NewMemoryBackend()
+	s := &ProxyServer{backend: backend, workerShares: newWorkerShares()}
+	cs := &Session{login: "x", worker: "rig"}
+
+	s.trackShare(cs, 0, nil, metrics.ShareAccepted, "")
+	s.trackShare(cs, 0, nil, metrics.ShareAccepted, "")
+	s.trackShare(cs, 0, nil, metrics.ShareStale, "")
+	s.trackShare(cs, 0, nil, metrics.ShareRejected, "bad_nonce")
+	if stats, _ := backend.GetWorkerStats(time.Minute, time.Hour, "x", "rig"); stats != nil {
+		t.Fatal("Must not write counters on the submit path")
+	}
+
+	s.flushWorkerShares()
+	stats, err := backend.GetWorkerStats(time.Minute, time.Hour, "x", "rig")
+	if err != nil || stats == nil {
+		t.Fatalf("Must write worker counters: %v", err)
+	}
+	if stats.Valid != 2 || stats.Stale != 1 || stats.Invalid != 1 || stats.LastShare == 0 {
+		t.Errorf("Must count shares by status: %+v", stats)
+	}
+	if shares := s.workerShares.take(); len(shares) != 0 {
+		t.Errorf("Must start over after a flush: %+v", shares)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/dominant-strategies/go-quai-stratum/api"
+	"github.com/dominant-strategies/go-quai-stratum/proxy"
+
+	"github.com/dominant-strategies/go-quai/log"
+)
+
+// reloader reads the config file again on SIGHUP or through the admin APIs
+// and hands the live settings to the running proxy and API. The config the
+// process was started with is left untouched.
+type reloader struct {
+	sync.Mutex
+	path      string
+	roles     proxy.Roles
+	overrides *upstreamOverrides
+	proxy     *proxy.ProxyServer
+	api       *api.ApiServer
+	// Config of the last successful load
+	last *proxy.Config
+}
+
+var running = &reloader{}
+
+// configure records how the config was loaded, so that reloads do the same.
+func (r *reloader) configure(path string, roles proxy.Roles, overrides *upstreamOverrides) {
+	r.Lock()
+	defer r.Unlock()
+	r.path, r.roles, r.overrides = path, roles, overrides
+}
+
+func (r *reloader) setProxy(s *proxy.ProxyServer) {
+	r.Lock()
+	defer r.Unlock()
+	r.proxy = s
+}
+
+func (r *reloader) setApi(s *api.ApiServer) {
+	r.Lock()
+	defer r.Unlock()
+	r.api = s
+}
+
+// ReloadConfig implements api.ConfigReloader. Nothing is applied unless the
+// whole config is valid.
+func (r *reloader) ReloadConfig() ([]string, []string, error) {
+	r.Lock()
+	defer r.Unlock()
+	if len(r.path) == 0 {
+		return nil, nil, errors.New("config was not loaded from a file")
+	}
+
+	next := new(proxy.Config)
+	if err := readConfigFile(r.path, next); err != nil {
+		return nil, nil, err
+	}
+	if r.overrides != nil {
+		r.overrides.apply(next)
+	}
+	if err := next.Validate(r.roles); err != nil {
+		return nil, nil, err
+	}
+	last := r.last
+	if last == nil {
+		last = &cfg
+	}
+	applied, restartRequired := proxy.ReloadChanges(&cfg, last, next)
+
+	setLogLevel(next.LogLevel)
+	if r.proxy != nil {
+		r.proxy.Reload(next)
+	}
+	if r.api != nil {
+		// Only the difficulty is published from the new config, the rest
+		// is in effect until restart
+		settings := apiSettings(&cfg)
+		if next.Proxy.Difficulty != nil {
+			settings.Difficulty = next.Proxy.Difficulty.String()
+		}
+		r.api.Reload(&next.Api, settings)
+	}
+	r.last = next
+
+	log.Global.WithField("applied", applied).Info("Config reloaded")
+	if len(restartRequired) > 0 {
+		log.Global.WithField("settings", restartRequired).Warn("Changed settings need a restart to take effect")
+	}
+	return applied, restartRequired, nil
+}
+
+// wait reloads the config on SIGHUP until the process is stopped.
+// wait reloads the config on SIGHUP until SIGINT or SIGTERM, then stops the
+// unlocker and payer and waits for them. Another SIGINT or SIGTERM exits
+// right away.
+func wait() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
+	for {
+		select {
+		case <-hup:
+			log.Global.Info("Received SIGHUP, reloading config")
+			if _, _, err := running.ReloadConfig(); err != nil {
+				logConfigError(err)
+				log.Global.Error("Config reload failed, keeping the running config")
+			}
+		case sig := <-term:
+			signal.Stop(term)
+			log.Global.WithField("signal", sig).Info("Shutting down")
+			stopWorkers()
+			workers.Wait()
+			return
+		}
+	}
+}
+
+func setLogLevel(name string) {
+	level, err := logrus.ParseLevel(name)
+	if err != nil {
+		log.Global.WithField("level", name).Warn("Unknown log level")
+		return
+	}
+	log.Global.SetLevel(level)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
+	"github.com/dominant-strategies/go-quai-stratum/proxy"
+
+	"github.com/dominant-strategies/go-quai/log"
+)
+
+// role runs a single part of the pool regardless of the enabled flags of the
+// config, so that every part can be deployed on its own with only its own
+// settings validated. The reconciler runs along with the API, which serves
+// its results.
+type role struct {
+	name  string
+	roles func(cfg *proxy.Config) proxy.Roles
+	// Must not block
+	start func()
+}
+
+var (
+	proxyRole = &role{
+		name:  "proxy",
+		roles: func(cfg *proxy.Config) proxy.Roles { return proxy.Roles{Proxy: true} },
+		start: func() { go startProxy() },
+	}
+	apiRole = &role{
+		name: "api",
+		roles: func(cfg *proxy.Config) proxy.Roles {
+			return proxy.Roles{Api: true, Reconciler: cfg.Reconciler.Enabled}
+		},
+		start: func() {
+			if cfg.Reconciler.Enabled {
+				startReconciler()
+			}
+			go startApi()
+		},
+	}
+	unlockerRole = &role{
+		name:  "unlocker",
+		roles: func(cfg *proxy.Config) proxy.Roles { return proxy.Roles{Unlocker: true} },
+		start: startBlockUnlocker,
+	}
+	payerRole = &role{
+		name:  "payer",
+		roles: func(cfg *proxy.Config) proxy.Roles { return proxy.Roles{Payer: true} },
+		start: startPayoutsProcessor,
+	}
+)
+
+func (r *role) run(args []string) {
+	flags := flag.NewFlagSet(r.name, flag.ExitOnError)
+	configPath := flags.String("config", "config/config.json", "Path to config file")
+	var upstream *upstreamOverrides
+	if r == proxyRole {
+		upstream = upstreamFlags(flags)
+	}
+	flags.Parse(args)
+
+	loadConfig(*configPath, &cfg)
+	if upstream != nil {
+		upstream.apply(&cfg)
+	}
+	validateConfig(&cfg, r.roles(&cfg))
+	running.configure(*configPath, r.roles(&cfg), upstream)
+	setLogLevel(cfg.LogLevel)
+	setThreads()
+
+	if cfg.HasStorage() {
+		openBackend()
+		checkSchema()
+	}
+	if cfg.Metrics.Enabled {
+		go metrics.Start(&cfg.Metrics)
+	}
+	log.Global.WithField("role", r.name).Info("Starting")
+	r.start()
+	wait()
+}
@@ -4,11 +4,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strconv"
 
 	"math/big"
 	"net/http"
 
 	"log"
+	"strings"
 	"sync"
 
 	"github.com/INFURA/go-ethlibs/jsonrpc"
@@ -52,6 +55,11 @@ type GetBlockReply struct {
 	Hash          common.Hash      `json:"hash"`
 }
 
+// GetBlockReplyPart holds the fields the unlocker needs to check canonical inclusion.
+type GetBlockReplyPart struct {
+	Hash string `json:"hash"`
+}
+
 const receiptStatusSuccessful = "0x1"
 
 type TxReceipt struct {
@@ -101,6 +109,117 @@ func NewRPCClient(name, url, timeout string) *RPCClient {
 	return rpcClient
 }
 
+func (r *RPCClient) BlockNumber() (int64, error) {
+	rpcResp, err := r.doPost(r.Url, "quai_blockNumber", []interface{}{})
+	if err != nil {
+		return 0, err
+	}
+	if rpcResp.Result == nil {
+		return 0, errors.New("empty block number reply")
+	}
+	var reply string
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.Replace(reply, "0x", "", -1), 16, 64)
+}
+
+func (r *RPCClient) GetBlockByHeight(height int64) (*GetBlockReplyPart, error) {
+	params := []interface{}{fmt.Sprintf("0x%x", height), false}
+	return r.getBlockBy("quai_getBlockByNumber", params)
+}
+
+func (r *RPCClient) GetBlockByHash(hash string) (*GetBlockReplyPart, error) {
+	params := []interface{}{hash, false}
+	return r.getBlockBy("quai_getBlockByHash", params)
+}
+
+func (r *RPCClient) getBlockBy(method string, params []interface{}) (*GetBlockReplyPart, error) {
+	rpcResp, err := r.doPost(r.Url, method, params)
+	if err != nil {
+		return nil, err
+	}
+	if rpcResp.Result != nil {
+		var reply *GetBlockReplyPart
+		err = json.Unmarshal(*rpcResp.Result, &reply)
+		return reply, err
+	}
+	return nil, nil
+}
+
+func (r *RPCClient) GetTxReceipt(hash string) (*TxReceipt, error) {
+	rpcResp, err := r.doPost(r.Url, "quai_getTransactionReceipt", []string{hash})
+	if err != nil {
+		return nil, err
+	}
+	if rpcResp.Result != nil {
+		var reply *TxReceipt
+		err = json.Unmarshal(*rpcResp.Result, &reply)
+		return reply, err
+	}
+	return nil, nil
+}
+
+func (r *RPCClient) GetBalance(address string) (*big.Int, error) {
+	rpcResp, err := r.doPost(r.Url, "quai_getBalance", []string{address, "latest"})
+	if err != nil {
+		return nil, err
+	}
+	if rpcResp.Result == nil {
+		return nil, errors.New("empty balance reply")
+	}
+	var reply string
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	if err != nil {
+		return nil, err
+	}
+	return util.String2Big(reply), err
+}
+
+func (r *RPCClient) GetPeerCount() (int64, error) {
+	rpcResp, err := r.doPost(r.Url, "net_peerCount", []interface{}{})
+	if err != nil {
+		return 0, err
+	}
+	if rpcResp.Result == nil {
+		return 0, errors.New("empty peer count reply")
+	}
+	var reply string
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.Replace(reply, "0x", "", -1), 16, 64)
+}
+
+func (r *RPCClient) SendTransaction(from, to, gas, gasPrice, value string) (string, error) {
+	params := map[string]string{
+		"from":     from,
+		"to":       to,
+		"gas":      gas,
+		"gasPrice": gasPrice,
+		"value":    value,
+	}
+	rpcResp, err := r.doPost(r.Url, "quai_sendTransaction", []interface{}{params})
+	if err != nil {
+		return "", err
+	}
+	if rpcResp.Result == nil {
+		return "", errors.New("empty transaction hash reply")
+	}
+	var reply string
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	if err != nil {
+		return reply, err
+	}
+	// Some signers return a zero hash instead of an error when the tx was not sent.
+	if util.IsZeroHash(reply) {
+		err = errors.New("transaction is not yet available")
+	}
+	return reply, err
+}
+
 func (r *RPCClient) doPost(url string, method string, params interface{}) (*JsonRPCResponse, error) {
 	var data []byte
 	var err error
@@ -150,6 +269,7 @@ func (r *RPCClient) doPost(url string, method string, params interface{}) (*Json
 		r.markSick()
 		return nil, errors.New(rpcResp.Error.Message)
 	}
+	r.markAlive()
 	return rpcResp, err
 }
 
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Storage drivers
+const (
+	DriverRedis  = "redis"
+	DriverMemory = "memory"
+	DriverHybrid = "hybrid"
+)
+
+type BackendConfig struct {
+	// One of redis, memory or hybrid. Memory keeps state in the process
+	// only, hybrid keeps financial records in SQL and the rest in Redis.
+	Driver string    `json:"driver"`
+	SQL    SQLConfig `json:"sql"`
+}
+
+// ShareStore records shares and found blocks.
+type ShareStore interface {
+	SetRewardScheme(scheme RewardScheme)
+	RewardScheme() RewardScheme
+	WriteShare(login, id string, params []string, diff int64, height uint64, window time.Duration) (bool, error)
+	WriteSoloShare(login, id string, params []string, diff int64, height uint64, window time.Duration) (bool, error)
+	WriteBlock(login, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration) (bool, error)
+	WriteSoloBlock(login, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration) (bool, error)
+	WriteWorkerShares(shares []*WorkerShares) error
+	WriteNodeState(id string, height uint64, diff *big.Int, blocktime float64) error
+}
+
+// BlockStore moves blocks from candidates to immature and matured.
+type BlockStore interface {
+	GetCandidates(maxHeight int64) ([]*BlockData, error)
+	GetImmatureBlocks(maxHeight int64) ([]*BlockData, error)
+	GetRoundShares(height int64, nonce string) (map[string]int64, error)
+	WriteImmatureBlock(block *BlockData, roundRewards map[string]int64) error
+	WriteMaturedBlock(block *BlockData, roundRewards map[string]int64, fees *RoundFees) error
+	WriteOrphan(block *BlockData) error
+	WritePendingOrphans(blocks []*BlockData) error
+	GetBlocksPage(status string, q *PageQuery) ([]*BlockData, string, error)
+}
+
+// BalanceStore keeps miner balances and pays them out.
+type BalanceStore interface {
+	GetPayees() ([]string, error)
+	GetBalance(login string) (int64, error)
+	GetPendingPayments() []*PendingPayment
+	LockPayouts(lock *PayoutsLock) error
+	SetPayoutsLockTx(lock *PayoutsLock, txHash string) error
+	GetPayoutsLock() (*PayoutsLock, error)
+	UnlockPayouts() error
+	IsPayoutsLocked() (bool, error)
+	UpdateBalance(login string, amount int64) error
+	RollbackBalance(login string, amount int64) error
+	WritePayment(login, txHash string, amount int64) error
+	GetPaymentsPage(login string, q *PageQuery) ([]*Payment, string, error)
+	GetCreditsPage(login string, q *PageQuery) ([]*Credit, string, error)
+	GetLedger() (*Ledger, error)
+}
+
+// StatsStore aggregates pool, miner and worker stats and charts.
+type StatsStore interface {
+	GetNodeStates() ([]*NodeState, error)
+	IsMinerExists(login string) (bool, error)
+	GetAllMinerAccount() ([]string, error)
+	GetMinerStats(login string, maxPayments int64) (*MinerStats, error)
+	FlushStaleStats(window, largeWindow time.Duration) (int64, error)
+	CollectStats(smallWindow time.Duration, maxBlocks, maxPayments int64) (*PoolStats, error)
+	CollectWorkersStats(sWindow, lWindow time.Duration, login string, maxBlocks int64) (*WorkersStats, error)
+	GetWorkerStats(sWindow, lWindow time.Duration, login, worker string) (*WorkerStats, error)
+	CollectLuckStats(windows []int) (map[string]*LuckStats, error)
+	CollectLuckCharts(max int) ([]*LuckCharts, error)
+	WritePoolCharts(time1 int64, time2 string, poolHash string) error
+	WriteMinerCharts(time1 int64, time2, k string, hash, largeHash, workerOnline int64) error
+	WriteWorkerCharts(time1 int64, time2, login, worker string, hash, largeHash int64) error
+	GetPoolCharts(poolHashLen int64) ([]*PoolCharts, error)
+	GetMinerCharts(hashNum int64, login string) ([]*MinerCharts, error)
+	GetWorkerCharts(hashNum int64, login, worker string) ([]*WorkerCharts, error)
+	GetPaymentCharts(login string) ([]*PaymentCharts, error)
+}
+
+// ListStore holds access lists, bans and the admin audit log.
+type ListStore interface {
+	GetBlacklist() ([]string, error)
+	GetWhitelist() ([]string, error)
+	AddListEntry(list, entry string) (bool, error)
+	RemoveListEntry(list, entry string) (bool, error)
+	WriteBan(ban *Ban) error
+	GetBans() ([]*Ban, error)
+	DeleteBan(ip string) (bool, error)
+	WriteAudit(entry *AuditEntry, maxLen int64) error
+	GetAuditLog(limit int64) ([]*AuditEntry, error)
+}
+
+// Backend is everything the pool keeps in storage.
+type Backend interface {
+	ShareStore
+	BlockStore
+	BalanceStore
+	StatsStore
+	ListStore
+	Check() (string, error)
+	BgSave() (string, error)
+	WriteEvents(stream string, maxLen int64, entries []string) error
+	// WithContext returns the backend with calls cancelled along with ctx,
+	// e.g. when the request they serve goes away or on shutdown
+	WithContext(ctx context.Context) Backend
+}
+
+var (
+	_ Backend = (*RedisClient)(nil)
+	_ Backend = (*MemoryBackend)(nil)
+	_ Backend = (*HybridBackend)(nil)
+)
+
+// NewBackend opens the backend selected by cfg, Redis unless told otherwise.
+func NewBackend(cfg *BackendConfig, redisCfg *Config, prefix string) (Backend, error) {
+	switch cfg.Driver {
+	case "", DriverRedis:
+		return NewRedisClient(redisCfg, prefix), nil
+	case DriverMemory:
+		return NewMemoryBackend(), nil
+	case DriverHybrid:
+		ledger, err := NewSQLBackend(&cfg.SQL)
+		if err != nil {
+			return nil, err
+		}
+		return NewHybridBackend(NewRedisClient(redisCfg, prefix), ledger), nil
+	}
+	return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+}
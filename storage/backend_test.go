@@ -0,0 +1,479 @@
+package storage
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+// conformance runs test against every backend, each starting out empty.
+// Hybrid runs on SQLite, and on PostgreSQL too if TEST_POSTGRES_DSN is set.
+func conformance(t *testing.T, test func(t *testing.T, b Backend)) {
+	backends := []struct {
+		name string
+		open func(t *testing.T) Backend
+	}{
+		{DriverRedis, func(t *testing.T) Backend {
+			reset()
+			r.SetRewardScheme(propScheme{})
+			return r
+		}},
+		{DriverMemory, func(t *testing.T) Backend { return NewMemoryBackend() }},
+		{DriverHybrid, func(t *testing.T) Backend {
+			reset()
+			r.SetRewardScheme(propScheme{})
+			return NewHybridBackend(r, newTestLedger(t))
+		}},
+	}
+	if dsn := os.Getenv("TEST_POSTGRES_DSN"); len(dsn) > 0 {
+		backends = append(backends, struct {
+			name string
+			open func(t *testing.T) Backend
+		}{"hybrid-postgres", func(t *testing.T) Backend {
+			reset()
+			r.SetRewardScheme(propScheme{})
+			return NewHybridBackend(r, newPostgresLedger(t, dsn))
+		}})
+	}
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			test(t, backend.open(t))
+		})
+	}
+}
+
+// newTestLedger opens a fresh SQLite ledger.
+func newTestLedger(t *testing.T) *SQLBackend {
+	cfg := &SQLConfig{Driver: "sqlite", DSN: filepath.Join(t.TempDir(), "ledger.db"), MaxOpenConns: 1}
+	ledger, err := NewSQLBackend(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ledger.Close() })
+	return ledger
+}
+
+// newPostgresLedger opens dsn with every table emptied.
+func newPostgresLedger(t *testing.T, dsn string) *SQLBackend {
+	ledger, err := NewSQLBackend(&SQLConfig{DSN: dsn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ledger.Close() })
+	tables := []string{"blocks", "round_shares", "immature_credits", "credits", "accounts", "payments", "pending_payments", "payout_lock"}
+	for _, table := range tables {
+		if _, err := ledger.db.Exec("DELETE FROM " + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ledger.db.Exec(`UPDATE finances SET balance = 0, immature = 0, pending = 0, paid = 0, pool_fee = 0,
+		donations = 0, total_mined = 0, last_credit_height = 0, last_credit_hash = ''`)
+	return ledger
+}
+
+func TestNewBackend(t *testing.T) {
+	b, err := NewBackend(&BackendConfig{Driver: DriverMemory}, &Config{}, prefix)
+	if _, ok := b.(*MemoryBackend); !ok || err != nil {
+		t.Errorf("Must open memory backend: %v", err)
+	}
+	b, err = NewBackend(&BackendConfig{}, &Config{Endpoint: "127.0.0.1:6379"}, prefix)
+	if _, ok := b.(*RedisClient); !ok || err != nil {
+		t.Errorf("Must default to redis: %v", err)
+	}
+	if _, err = NewBackend(&BackendConfig{Driver: "disk"}, &Config{}, prefix); err == nil {
+		t.Error("Must reject unknown driver")
+	}
+}
+
+func TestBackendShares(t *testing.T) {
+	conformance(t, func(t *testing.T, b Backend) {
+		exist, err := b.WriteShare("x", "rig", []string{"0x0", "0x0"}, 10, 1008, time.Minute)
+		if exist || err != nil {
+			t.Fatalf("PoW must not exist: %v", err)
+		}
+		exist, _ = b.WriteShare("x", "rig", []string{"0x0", "0x0"}, 10, 1008, time.Minute)
+		if !exist {
+			t.Error("PoW must exist")
+		}
+		b.WriteShare("y", "rig", []string{"0x0", "0x1"}, 20, 1008, time.Minute)
+
+		stats, err := b.CollectStats(time.Minute, 10, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Stats.RoundShares != 30 || stats.MinersTotal != 2 || stats.Miners["y"].Offline {
+			t.Errorf("Invalid pool stats: %+v", stats)
+		}
+		miner, _ := b.GetMinerStats("x", 10)
+		if miner.RoundShares != 10 || miner.Stats.LastShare == 0 {
+			t.Errorf("Invalid miner stats: %+v", miner)
+		}
+		if ok, _ := b.IsMinerExists("x"); !ok {
+			t.Error("Miner must exist")
+		}
+		if ok, _ := b.IsMinerExists("z"); ok {
+			t.Error("Miner must not exist")
+		}
+	})
+}
+
+func TestBackendBlockLifecycle(t *testing.T) {
+	conformance(t, func(t *testing.T, b Backend) {
+		b.WriteShare("x", "rig", []string{"0x1", "0xa"}, 10, 1008, time.Minute)
+		b.WriteBlock("x", "rig", []string{"0x2", "0xb", "1"}, 20, 500, 1009, time.Minute)
+
+		candidates, err := b.GetCandidates(1009)
+		if err != nil || len(candidates) != 1 {
+			t.Fatalf("Must have one candidate: %v %v", candidates, err)
+		}
+		block := candidates[0]
+		if block.Hash != "0xb" || block.Difficulty != 500 || block.TotalShares != 30 {
+			t.Errorf("Invalid candidate: %+v", block)
+		}
+		shares, _ := b.GetRoundShares(1009, "0x2")
+		if !reflect.DeepEqual(shares, map[string]int64{"x": 30}) {
+			t.Errorf("Invalid round shares: %v", shares)
+		}
+
+		block.Reward = util.String2Big("2000000000000000000")
+		b.WriteImmatureBlock(block, map[string]int64{"x": 1500})
+		if candidates, _ = b.GetCandidates(1009); len(candidates) != 0 {
+			t.Error("Must move candidate")
+		}
+		immature, _ := b.GetImmatureBlocks(1009)
+		if len(immature) != 1 {
+			t.Fatalf("Must have one immature block: %v", immature)
+		}
+		miner, _ := b.GetMinerStats("x", 10)
+		if miner.Stats.Immature != 1500 {
+			t.Errorf("Must credit immature: %+v", miner.Stats)
+		}
+
+		block = immature[0]
+		block.Reward = util.String2Big("2000000000000000000")
+		fees := &RoundFees{Pool: map[string]int64{"pool": 100}}
+		b.WriteMaturedBlock(block, map[string]int64{"x": 1400}, fees)
+		if immature, _ = b.GetImmatureBlocks(1009); len(immature) != 0 {
+			t.Error("Must move immature block")
+		}
+		if balance, _ := b.GetBalance("x"); balance != 1400 {
+			t.Errorf("Invalid balance %v", balance)
+		}
+		if balance, _ := b.GetBalance("pool"); balance != 100 {
+			t.Errorf("Invalid pool fee balance %v", balance)
+		}
+		miner, _ = b.GetMinerStats("x", 10)
+		if miner.Stats.Immature != 0 {
+			t.Errorf("Must release immature: %+v", miner.Stats)
+		}
+		shares, _ = b.GetRoundShares(1009, "0x2")
+		if len(shares) != 0 {
+			t.Errorf("Must drop round shares: %v", shares)
+		}
+
+		stats, _ := b.CollectStats(time.Minute, 10, 10)
+		if stats.MaturedTotal != 1 || stats.Matured[0].RewardString != "2000000000000000000" {
+			t.Errorf("Invalid matured blocks: %+v", stats.Matured)
+		}
+		page, _, _ := b.GetCreditsPage("x", &PageQuery{Limit: 10})
+		if len(page) != 1 || page[0].Amount != 1400 || page[0].Height != 1009 || page[0].Kind != creditReward {
+			t.Errorf("Invalid credits: %+v", page)
+		}
+		if page, _, _ = b.GetCreditsPage("pool", &PageQuery{Limit: 10}); len(page) != 1 || page[0].Kind != creditPoolFee {
+			t.Errorf("Invalid fee credits: %+v", page)
+		}
+		blocks, _, _ := b.GetBlocksPage(BlocksMatured, &PageQuery{Limit: 10})
+		if len(blocks) != 1 || blocks[0].Hash != "0xb" {
+			t.Errorf("Invalid blocks page: %+v", blocks)
+		}
+		workers, _ := b.CollectWorkersStats(time.Minute, time.Hour, "x", 10)
+		if len(workers.Finders) != 1 || workers.Finders[0].Hash != "0xb" {
+			t.Errorf("Invalid finders: %+v", workers.Finders)
+		}
+		luck, _ := b.CollectLuckStats([]int{1, 64})
+		if luck["1"] == nil || luck["1"].Luck != 30.0/500 {
+			t.Errorf("Invalid luck: %v", luck)
+		}
+		charts, _ := b.CollectLuckCharts(10)
+		if len(charts) != 1 || charts[0].Shares != 30 {
+			t.Errorf("Invalid luck charts: %v", charts)
+		}
+	})
+}
+
+func TestBackendOrphan(t *testing.T) {
+	conformance(t, func(t *testing.T, b Backend) {
+		b.WriteBlock("x", "rig", []string{"0x2", "0xb", "1"}, 20, 500, 1009, time.Minute)
+		candidates, _ := b.GetCandidates(1009)
+		block := candidates[0]
+		block.Orphan = true
+		block.Reward = big.NewInt(0)
+		b.WritePendingOrphans([]*BlockData{block})
+
+		immature, _ := b.GetImmatureBlocks(1009)
+		if len(immature) != 1 || !immature[0].Orphan {
+			t.Fatalf("Must keep orphan as immature: %+v", immature)
+		}
+		block = immature[0]
+		block.Reward = big.NewInt(0)
+		b.WriteOrphan(block)
+		stats, _ := b.CollectStats(time.Minute, 10, 10)
+		if stats.ImmatureTotal != 0 || stats.MaturedTotal != 1 || !stats.Matured[0].Orphan {
+			t.Errorf("Must mature orphan: %+v", stats)
+		}
+	})
+}
+
+func TestBackendSchemes(t *testing.T) {
+	conformance(t, func(t *testing.T, b Backend) {
+		b.SetRewardScheme(pplnsScheme{window: 2})
+		b.WriteShare("x", "rig", []string{"0x1"}, 10, 1008, time.Minute)
+		b.WriteShare("y", "rig", []string{"0x2"}, 20, 1008, time.Minute)
+		b.WriteBlock("z", "rig", []string{"0x3", "0xc", "1"}, 30, 500, 1008, time.Minute)
+
+		shares, _ := b.GetRoundShares(1008, "0x3")
+		if !reflect.DeepEqual(shares, map[string]int64{"y": 20, "z": 30}) {
+			t.Errorf("Must pay the pplns window: %v", shares)
+		}
+		if b.RewardScheme().Name() != SchemePPLNS {
+			t.Error("Must keep scheme")
+		}
+
+		b.WriteSoloShare("s", "rig", []string{"0x4"}, 10, 1009, time.Minute)
+		b.WriteSoloBlock("s", "rig", []string{"0x5", "0xd", "1"}, 5, 500, 1009, time.Minute)
+		shares, _ = b.GetRoundShares(1009, "0x5")
+		if !reflect.DeepEqual(shares, map[string]int64{"s": 15}) {
+			t.Errorf("Must pay the solo miner only: %v", shares)
+		}
+	})
+}
+
+func TestBackendPayouts(t *testing.T) {
+	conformance(t, func(t *testing.T, b Backend) {
+		block := &BlockData{Height: 1, RoundHeight: 1, Hash: "0x1", Reward: big.NewInt(0)}
+		b.WriteMaturedBlock(block, map[string]int64{"x": 5000}, nil)
+
+		payees, _ := b.GetPayees()
+		if !util.StringInSlice("x", payees) {
+			t.Errorf("Invalid payees: %v", payees)
+		}
+		lock := &PayoutsLock{Owner: "payer", Login: "x", Amount: 5000, ExpiresAt: 100}
+		if err := b.LockPayouts(lock); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.LockPayouts(&PayoutsLock{Owner: "other", Login: "x", Amount: 5000}); err == nil {
+			t.Error("Must not lock twice")
+		}
+		b.UpdateBalance("x", 5000)
+		pending := b.GetPendingPayments()
+		if len(pending) != 1 || pending[0].Address != "x" || pending[0].Amount != 5000 {
+			t.Errorf("Invalid pending payments: %+v", pending)
+		}
+		b.SetPayoutsLockTx(lock, "0xabc")
+		held, _ := b.GetPayoutsLock()
+		expected := PayoutsLock{Owner: "payer", Login: "x", Amount: 5000, TxHash: "0xabc", ExpiresAt: 100}
+		if held == nil || *held != expected {
+			t.Errorf("Invalid lock: %+v", held)
+		}
+		if !held.Expired(100) || held.Expired(99) {
+			t.Error("Must expire at ExpiresAt")
+		}
+
+		b.WritePayment("x", "0xabc", 5000)
+		if locked, _ := b.IsPayoutsLocked(); locked {
+			t.Error("Must release lock")
+		}
+		if len(b.GetPendingPayments()) != 0 {
+			t.Error("Must clear pending payment")
+		}
+		miner, _ := b.GetMinerStats("x", 10)
+		if miner.Stats.Balance != 0 || miner.Stats.Paid != 5000 || miner.PaymentsTotal != 1 {
+			t.Errorf("Invalid miner stats: %+v", miner)
+		}
+		payments, _, _ := b.GetPaymentsPage("", &PageQuery{Limit: 10})
+		if len(payments) != 1 || payments[0].Address != "x" || payments[0].Tx != "0xabc" {
+			t.Errorf("Invalid payments: %+v", payments)
+		}
+		charts, _ := b.GetPaymentCharts("x")
+		if len(charts) != 1 || charts[0].Amount != 5000 {
+			t.Errorf("Invalid payment charts: %+v", charts)
+		}
+
+		b.WriteMaturedBlock(block, map[string]int64{"y": 100}, nil)
+		b.UpdateBalance("y", 100)
+		b.RollbackBalance("y", 100)
+		if balance, _ := b.GetBalance("y"); balance != 100 {
+			t.Errorf("Must roll back balance: %v", balance)
+		}
+		if len(b.GetPendingPayments()) != 0 {
+			t.Error("Must drop rolled back payment")
+		}
+		b.UnlockPayouts()
+	})
+}
+
+func TestBackendLedger(t *testing.T) {
+	conformance(t, func(t *testing.T, b Backend) {
+		reward := util.String2Big("2000000000000000000")
+		matured := &BlockData{Height: 10, RoundHeight: 10, Hash: "0xa", Nonce: "0x1", Reward: reward}
+		b.WriteImmatureBlock(matured, map[string]int64{"x": 1500000000, "y": 500000000})
+		fees := &RoundFees{Pool: map[string]int64{"pool": 20000000}}
+		b.WriteMaturedBlock(matured, map[string]int64{"x": 1485000000, "y": 495000000}, fees)
+		b.WriteImmatureBlock(&BlockData{Height: 12, RoundHeight: 12, Hash: "0xb", Nonce: "0x2", Reward: reward}, map[string]int64{"y": 2000000000})
+		b.LockPayouts(&PayoutsLock{Login: "x", Amount: 1000000000})
+		b.UpdateBalance("x", 1000000000)
+
+		ledger, err := b.GetLedger()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := LedgerTotals{Balance: 1000000000, Immature: 2000000000, Pending: 1000000000, PoolFee: 20000000, TotalMined: 2000000000}
+		if ledger.Finances != expected {
+			t.Errorf("Invalid finances: %+v", ledger.Finances)
+		}
+		if len(ledger.Accounts) != 3 || ledger.Accounts["x"].Balance != 485000000 || ledger.Accounts["y"].Immature != 2000000000 {
+			t.Errorf("Invalid accounts: %+v", ledger.Accounts)
+		}
+		if ledger.ImmatureCredits != 2000000000 {
+			t.Errorf("Invalid immature credits: %v", ledger.ImmatureCredits)
+		}
+		block := &LedgerBlock{Height: 10, Hash: "0xa", Reward: 2000000000, Credited: 1980000000, Credits: 2}
+		if len(ledger.Blocks) != 1 || *ledger.Blocks[0] != *block {
+			t.Errorf("Invalid blocks: %+v", ledger.Blocks)
+		}
+		if len(ledger.Pending) != 1 || ledger.LockLogin != "x" || ledger.LockAmount != 1000000000 {
+			t.Errorf("Invalid payout: %+v %v %v", ledger.Pending, ledger.LockLogin, ledger.LockAmount)
+		}
+
+		now := util.MakeTimestamp() / 1000
+		if result := ledger.Reconcile(now, time.Hour); !result.OK() {
+			t.Errorf("Must reconcile: %+v", result)
+		}
+		if result := ledger.Reconcile(now+7200, time.Hour); len(result.OrphanedPayments) != 1 {
+			t.Errorf("Must flag stuck payment: %+v", result.OrphanedPayments)
+		}
+		b.UnlockPayouts()
+		ledger, _ = b.GetLedger()
+		if result := ledger.Reconcile(now, time.Hour); len(result.OrphanedPayments) != 1 || result.FailedChecks() != 0 {
+			t.Errorf("Must flag unlocked payment only: %+v", result)
+		}
+	})
+}
+
+func TestBackendStats(t *testing.T) {
+	conformance(t, func(t *testing.T, b Backend) {
+		b.WriteNodeState("main", 1010, big.NewInt(1000), 12.5)
+		nodes, _ := b.GetNodeStates()
+		if len(nodes) != 1 || nodes[0].Height != "1010" || nodes[0].Blocktime != "12.5000" {
+			t.Errorf("Invalid node states: %+v", nodes)
+		}
+
+		now := util.MakeTimestamp() / 1000
+		b.WritePoolCharts(now-60, "a", "10")
+		b.WritePoolCharts(now, "b", "20")
+		b.WritePoolCharts(now-3*86400, "c", "30")
+		pool, _ := b.GetPoolCharts(10)
+		if len(pool) != 2 || pool[0].PoolHash != 10 || pool[1].TimeFormat != "b" {
+			t.Errorf("Invalid pool charts: %+v", pool)
+		}
+		b.WriteMinerCharts(now, "a", "x", 1, 2, 3)
+		miner, _ := b.GetMinerCharts(10, "x")
+		if len(miner) != 1 || miner[0].WorkerOnline != "3" {
+			t.Errorf("Invalid miner charts: %+v", miner)
+		}
+		b.WriteWorkerCharts(now, "a", "x", "rig", 10, 20)
+		worker, _ := b.GetWorkerCharts(10, "x", "rig")
+		if len(worker) != 1 || worker[0].WorkerLargeHash != 20 {
+			t.Errorf("Invalid worker charts: %+v", worker)
+		}
+
+		b.WriteShare("x", "rig", []string{"0x1"}, 10, 1008, time.Minute)
+		b.WriteWorkerShares([]*WorkerShares{{Login: "x", Worker: "rig", Valid: 1, Stale: 1, LastShare: util.MakeTimestamp() / 1000}})
+		stats, err := b.GetWorkerStats(time.Minute, time.Hour, "x", "rig")
+		if err != nil || stats == nil || stats.Valid != 1 || stats.Stale != 1 || stats.Offline {
+			t.Errorf("Invalid worker stats: %+v %v", stats, err)
+		}
+		if stats, _ = b.GetWorkerStats(time.Minute, time.Hour, "x", "other"); stats != nil {
+			t.Error("Must return nil for unknown worker")
+		}
+		workers, _ := b.CollectWorkersStats(time.Minute, time.Hour, "x", 0)
+		if workers.WorkersTotal != 1 || workers.WorkersOnline != 1 {
+			t.Errorf("Invalid workers: %+v", workers)
+		}
+		accounts, _ := b.GetAllMinerAccount()
+		if !reflect.DeepEqual(accounts, []string{"x"}) {
+			t.Errorf("Invalid accounts: %v", accounts)
+		}
+		if _, err := b.FlushStaleStats(time.Minute, time.Hour); err != nil {
+			t.Error(err)
+		}
+		if err := b.WriteEvents("events", 10, []string{"{}"}); err != nil {
+			t.Error(err)
+		}
+		if _, err := b.Check(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestBackendLists(t *testing.T) {
+	conformance(t, func(t *testing.T, b Backend) {
+		if added, _ := b.AddListEntry(Whitelist, "10.0.0.1"); !added {
+			t.Error("Must add new entry")
+		}
+		if added, _ := b.AddListEntry(Whitelist, "10.0.0.1"); added {
+			t.Error("Must not add existing entry twice")
+		}
+		b.AddListEntry(Blacklist, "0xabc")
+		whitelist, _ := b.GetWhitelist()
+		blacklist, _ := b.GetBlacklist()
+		if !reflect.DeepEqual(whitelist, []string{"10.0.0.1"}) || !reflect.DeepEqual(blacklist, []string{"0xabc"}) {
+			t.Errorf("Invalid lists: %v %v", whitelist, blacklist)
+		}
+		if removed, _ := b.RemoveListEntry(Whitelist, "10.0.0.1"); !removed {
+			t.Error("Must remove existing entry")
+		}
+		if removed, _ := b.RemoveListEntry(Whitelist, "10.0.0.1"); removed {
+			t.Error("Must not remove missing entry")
+		}
+
+		now := util.MakeTimestamp()
+		b.WriteBan(&Ban{IP: "10.0.0.1", BannedAt: now - 2000, ExpiresAt: now + 60000})
+		b.WriteBan(&Ban{IP: "10.0.0.2", BannedAt: now - 1000, ExpiresAt: now + 60000})
+		b.WriteBan(&Ban{IP: "10.0.0.3", BannedAt: now - 90000, ExpiresAt: now - 30000})
+		bans, _ := b.GetBans()
+		if len(bans) != 2 || bans[0].IP != "10.0.0.2" {
+			t.Errorf("Must list unexpired bans, most recent first: %+v", bans)
+		}
+		if deleted, _ := b.DeleteBan("10.0.0.2"); !deleted {
+			t.Error("Must delete ban")
+		}
+		if deleted, _ := b.DeleteBan("10.0.0.2"); deleted {
+			t.Error("Must not delete missing ban")
+		}
+
+		for i := 0; i < 5; i++ {
+			b.WriteAudit(&AuditEntry{Timestamp: int64(i), Action: "add"}, 3)
+		}
+		entries, _ := b.GetAuditLog(10)
+		if len(entries) != 3 || entries[0].Timestamp != 4 {
+			t.Errorf("Must keep the most recent audit entries: %+v", entries)
+		}
+	})
+}
+
+func TestMemoryExpiry(t *testing.T) {
+	m := NewMemoryBackend()
+	m.WriteShare("x", "rig", []string{"0x1"}, 10, 1008, 0)
+	workers, _ := m.CollectWorkersStats(time.Minute, time.Hour, "x", 0)
+	if workers.WorkersTotal != 0 {
+		t.Errorf("Must expire miner hashrate: %+v", workers)
+	}
+}
@@ -0,0 +1,443 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+// BackupFormat is the layout of backup archives written by this build.
+const BackupFormat = 1
+
+// Financial state kept in a backup, relative to the prefix. Round shares
+// are needed to credit blocks still waiting to mature.
+var backupPatterns = []string{"finances", "miners:*", "credits:*", "blocks:*", "shares:*", "payments:*"}
+
+// A backup archive is gzipped JSON lines: the header, one entry per key and
+// a trailer with the SHA-256 of the entry lines.
+type BackupHeader struct {
+	Format    int    `json:"format"`
+	Schema    int    `json:"schema"`
+	Prefix    string `json:"prefix"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// BackupEntry holds a key, without prefix, and the value of its type.
+type BackupEntry struct {
+	Key    string            `json:"key"`
+	Type   string            `json:"type"`
+	String string            `json:"string,omitempty"`
+	Hash   map[string]string `json:"hash,omitempty"`
+	ZSet   []BackupMember    `json:"zset,omitempty"`
+	List   []string          `json:"list,omitempty"`
+	Set    []string          `json:"set,omitempty"`
+}
+
+type BackupMember struct {
+	Score  float64 `json:"score"`
+	Member string  `json:"member"`
+}
+
+type backupTrailer struct {
+	Keys   int64  `json:"keys"`
+	SHA256 string `json:"sha256"`
+}
+
+// ErrBackupKeysChanged is returned when keys were added, removed or modified
+// while taking a backup.
+var ErrBackupKeysChanged = errors.New("keys changed while taking the backup, stop the unlocker and payer and try again")
+
+// Backup writes the financial state to w and returns the number of keys.
+// The keys are watched while their types and values are read, so the values
+// are a point in time snapshot. Keys are listed beforehand though, the backup
+// fails if any were added, removed or modified meanwhile. Stop the unlocker
+// and payer first.
+func (r *RedisClient) Backup(w io.Writer) (int64, error) {
+	ctx := r.ctx
+	keys, err := r.backupKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	values, schema, err := r.readBackupEntries(ctx, keys)
+	if err != nil {
+		return 0, err
+	}
+	after, err := r.backupKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !reflect.DeepEqual(after, keys) {
+		return 0, ErrBackupKeysChanged
+	}
+
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	header := &BackupHeader{Format: BackupFormat, Schema: schema, Prefix: r.prefix, CreatedAt: util.MakeTimestamp() / 1000}
+	if err := enc.Encode(header); err != nil {
+		return 0, err
+	}
+
+	sum := sha256.New()
+	entries := json.NewEncoder(io.MultiWriter(zw, sum))
+	count := int64(0)
+	for _, entry := range values {
+		if err := entries.Encode(entry); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := enc.Encode(&backupTrailer{Keys: count, SHA256: hex.EncodeToString(sum.Sum(nil))}); err != nil {
+		return count, err
+	}
+	return count, zw.Close()
+}
+
+// backupKeys lists the keys kept in a backup, sorted and without the
+// duplicates scans may return.
+func (r *RedisClient) backupKeys(ctx context.Context) ([]string, error) {
+	var keys []string
+	for _, pattern := range backupPatterns {
+		matched, err := r.scanKeys(ctx, r.formatKey(pattern))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, matched...)
+	}
+	sort.Strings(keys)
+
+	unique := keys[:0]
+	for i, key := range keys {
+		if i == 0 || keys[i-1] != key {
+			unique = append(unique, key)
+		}
+	}
+	return unique, nil
+}
+
+// readBackupEntries reads keys along with the key schema version. The keys
+// are watched while their types are read, so the transaction reading the
+// values fails with ErrBackupKeysChanged if any of them changed in between.
+func (r *RedisClient) readBackupEntries(ctx context.Context, keys []string) ([]*BackupEntry, int, error) {
+	var types []*redis.StatusCmd
+	var schema *redis.StringCmd
+	values := make([]redis.Cmder, len(keys))
+	watched := append([]string{r.formatKey("schema")}, keys...)
+	err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+		types = make([]*redis.StatusCmd, len(keys))
+		_, err := tx.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				types[i] = pipe.Type(ctx, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			schema = pipe.Get(ctx, r.formatKey("schema"))
+			for i, key := range keys {
+				switch kind := types[i].Val(); kind {
+				case "string":
+					values[i] = pipe.Get(ctx, key)
+				case "hash":
+					values[i] = pipe.HGetAll(ctx, key)
+				case "zset":
+					values[i] = pipe.ZRangeWithScores(ctx, key, 0, -1)
+				case "list":
+					values[i] = pipe.LRange(ctx, key, 0, -1)
+				case "set":
+					values[i] = pipe.SMembers(ctx, key)
+				case "none":
+					return ErrBackupKeysChanged
+				default:
+					return fmt.Errorf("unsupported type %s of key %s", kind, key)
+				}
+			}
+			return nil
+		})
+		return err
+	}, watched...)
+	if err == redis.TxFailedErr {
+		return nil, 0, ErrBackupKeysChanged
+	}
+	// The schema is missing on unversioned keys
+	if err != nil && err != redis.Nil {
+		return nil, 0, err
+	}
+
+	entries := make([]*BackupEntry, len(keys))
+	for i, key := range keys {
+		entry := &BackupEntry{Key: strings.TrimPrefix(key, r.prefix+":"), Type: types[i].Val()}
+		switch v := values[i].(type) {
+		case *redis.StringCmd:
+			entry.String, err = v.Result()
+		case *redis.MapStringStringCmd:
+			entry.Hash, err = v.Result()
+		case *redis.ZSliceCmd:
+			var members []redis.Z
+			members, err = v.Result()
+			for _, z := range members {
+				entry.ZSet = append(entry.ZSet, BackupMember{Score: z.Score, Member: z.Member.(string)})
+			}
+		case *redis.StringSliceCmd:
+			if entry.Type == "set" {
+				entry.Set, err = v.Result()
+			} else {
+				entry.List, err = v.Result()
+			}
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		entries[i] = entry
+	}
+	version, err := schema.Int()
+	if err == redis.Nil {
+		return entries, 0, nil
+	}
+	return entries, version, err
+}
+
+// ReadBackup verifies the archive in rd while passing every entry to fn,
+// which may be nil. Entries are handed out before the checksum is known, so
+// read an archive once without fn before acting on it.
+func ReadBackup(rd io.Reader, fn func(*BackupEntry) error) (*BackupHeader, error) {
+	zr, err := gzip.NewReader(rd)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	br := bufio.NewReader(zr)
+
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	header := &BackupHeader{}
+	if err := json.Unmarshal(line, header); err != nil {
+		return nil, err
+	}
+	if header.Format != BackupFormat {
+		return header, fmt.Errorf("unsupported backup format %v", header.Format)
+	}
+
+	sum := sha256.New()
+	count := int64(0)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return header, errors.New("truncated backup")
+		} else if err != nil {
+			return header, err
+		}
+		if bytes.HasPrefix(line, []byte(`{"keys":`)) {
+			trailer := &backupTrailer{}
+			if err := json.Unmarshal(line, trailer); err != nil {
+				return header, err
+			}
+			if trailer.Keys != count || trailer.SHA256 != hex.EncodeToString(sum.Sum(nil)) {
+				return header, errors.New("backup checksum mismatch")
+			}
+			return header, nil
+		}
+		sum.Write(line)
+		count++
+		if fn == nil {
+			continue
+		}
+		entry := &BackupEntry{}
+		if err := json.Unmarshal(line, entry); err != nil {
+			return header, err
+		}
+		if err := fn(entry); err != nil {
+			return header, err
+		}
+	}
+}
+
+// Restore writes the archive in rd into an empty database along with its
+// schema version, and returns the number of keys. Entries are written as
+// they are read, so whatever was written is deleted again if the archive
+// turns out to be invalid or a write fails, leaving the database empty for
+// another attempt.
+func (r *RedisClient) Restore(rd io.Reader) (int64, error) {
+	ctx := r.ctx
+	existing, err := r.scanKeys(ctx, r.formatKey("*"))
+	if err != nil {
+		return 0, err
+	}
+	if len(existing) > 0 {
+		return 0, fmt.Errorf("refusing to restore into %v existing keys under %s", len(existing), r.prefix)
+	}
+
+	count := int64(0)
+	written := []string{r.formatKey("schema")}
+	pipe := r.client.Pipeline()
+	flush := func() error {
+		_, err := pipe.Exec(ctx)
+		return err
+	}
+	header, err := ReadBackup(rd, func(entry *BackupEntry) error {
+		if err := r.restoreEntry(ctx, pipe, entry); err != nil {
+			return err
+		}
+		written = append(written, r.formatKey(entry.Key))
+		count++
+		if pipe.Len() >= 100 {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		if header.Schema > 0 {
+			pipe.Set(ctx, r.formatKey("schema"), header.Schema, 0)
+		}
+		err = flush()
+	}
+	if err != nil {
+		pipe.Discard()
+		if cleanErr := r.deleteKeys(ctx, written); cleanErr != nil {
+			return count, fmt.Errorf("%v, and failed to delete restored keys: %v", err, cleanErr)
+		}
+		return count, err
+	}
+	return count, nil
+}
+
+// deleteKeys deletes keys in batches to keep commands short.
+func (r *RedisClient) deleteKeys(ctx context.Context, keys []string) error {
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > 100 {
+			n = 100
+		}
+		if err := r.client.Del(ctx, keys[:n]...).Err(); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+func (r *RedisClient) restoreEntry(ctx context.Context, pipe redis.Pipeliner, entry *BackupEntry) error {
+	key := r.formatKey(entry.Key)
+	switch entry.Type {
+	case "string":
+		pipe.Set(ctx, key, entry.String, 0)
+	case "hash":
+		pipe.HSet(ctx, key, entry.Hash)
+	case "zset":
+		members := make([]redis.Z, len(entry.ZSet))
+		for i, m := range entry.ZSet {
+			members[i] = redis.Z{Score: m.Score, Member: m.Member}
+		}
+		pipe.ZAdd(ctx, key, members...)
+	case "list":
+		values := make([]interface{}, len(entry.List))
+		for i, v := range entry.List {
+			values[i] = v
+		}
+		pipe.RPush(ctx, key, values...)
+	case "set":
+		members := make([]interface{}, len(entry.Set))
+		for i, v := range entry.Set {
+			members[i] = v
+		}
+		pipe.SAdd(ctx, key, members...)
+	default:
+		return fmt.Errorf("unsupported type %s of key %s", entry.Type, entry.Key)
+	}
+	return nil
+}
+
+// FinanceCheck compares a pool wide total in finances with the sum of the
+// same counter over all miners.
+type FinanceCheck struct {
+	Field    string `json:"field"`
+	Finances int64  `json:"finances"`
+	Miners   int64  `json:"miners"`
+}
+
+func (c *FinanceCheck) OK() bool {
+	return c.Finances == c.Miners
+}
+
+// Finances totals kept per miner too
+var financeFields = []string{"balance", "immature", "pending", "paid"}
+
+// FinanceTotals sums up the counters of miners and checks them against
+// finances, both as stored in a hash.
+type FinanceTotals struct {
+	finances map[string]string
+	miners   map[string]int64
+}
+
+func NewFinanceTotals() *FinanceTotals {
+	return &FinanceTotals{miners: make(map[string]int64)}
+}
+
+// Add counts the backup entry if it holds finances or a miner.
+func (t *FinanceTotals) Add(entry *BackupEntry) {
+	if entry.Type != "hash" {
+		return
+	}
+	if entry.Key == "finances" {
+		t.finances = entry.Hash
+	} else if strings.HasPrefix(entry.Key, "miners:") {
+		t.addMiner(entry.Hash)
+	}
+}
+
+func (t *FinanceTotals) addMiner(counters map[string]string) {
+	for _, field := range financeFields {
+		t.miners[field] += parseCounter(counters, field)
+	}
+}
+
+func (t *FinanceTotals) Checks() []*FinanceCheck {
+	checks := make([]*FinanceCheck, len(financeFields))
+	for i, field := range financeFields {
+		checks[i] = &FinanceCheck{Field: field, Finances: parseCounter(t.finances, field), Miners: t.miners[field]}
+	}
+	return checks
+}
+
+// VerifyFinances recomputes the finances totals from the miners.
+func (r *RedisClient) VerifyFinances() ([]*FinanceCheck, error) {
+	ctx := r.ctx
+	totals := NewFinanceTotals()
+	keys, err := r.scanKeys(ctx, r.formatKey("miners", "*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		if i > 0 && keys[i-1] == key {
+			continue
+		}
+		counters, err := r.client.HGetAll(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		totals.addMiner(counters)
+	}
+	if totals.finances, err = r.client.HGetAll(ctx, r.formatKey("finances")).Result(); err != nil {
+		return nil, err
+	}
+	return totals.Checks(), nil
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFinances leaves a matured block credited to two miners, one of them
+// partially paid, and a block still immature.
+func writeFinances(t *testing.T) {
+	r.WriteImmatureBlock(&BlockData{Height: 10, Hash: "0xa", Nonce: "0x1", Reward: big.NewInt(100)}, map[string]int64{"x": 60, "y": 40})
+	r.WriteMaturedBlock(&BlockData{Height: 10, RoundHeight: 10, Hash: "0xa", Nonce: "0x1", Reward: big.NewInt(100)}, map[string]int64{"x": 60, "y": 40}, nil)
+	r.WriteImmatureBlock(&BlockData{Height: 12, Hash: "0xb", Nonce: "0x2", Reward: big.NewInt(100)}, map[string]int64{"y": 100})
+	if err := r.LockPayouts(&PayoutsLock{Login: "x", Amount: 50}); err != nil {
+		t.Fatal(err)
+	}
+	r.UpdateBalance("x", 50)
+	r.WritePayment("x", "0xtx", 50)
+	r.UnlockPayouts()
+	r.WriteBan(&Ban{IP: "10.0.0.1"})
+}
+
+func snapshot(t *testing.T, patterns ...string) map[string]*BackupEntry {
+	result := make(map[string]*BackupEntry)
+	for _, pattern := range patterns {
+		keys, err := r.scanKeys(ctx, r.formatKey(pattern))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, _, err := r.readBackupEntries(ctx, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			result[entry.Key] = entry
+		}
+	}
+	return result
+}
+
+func TestBackupRestore(t *testing.T) {
+	reset()
+	r.CheckKeySchema()
+	writeFinances(t)
+	before := snapshot(t, backupPatterns...)
+	if len(before) == 0 {
+		t.Fatal("Expected keys to back up")
+	}
+
+	var archive bytes.Buffer
+	keys, err := r.Backup(&archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys != int64(len(before)) {
+		t.Errorf("Expected %v keys, got %v", len(before), keys)
+	}
+	header, err := ReadBackup(bytes.NewReader(archive.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Format != BackupFormat || header.Schema != KeySchemaVersion || header.Prefix != prefix {
+		t.Errorf("Unexpected header %+v", header)
+	}
+
+	if _, err := r.Restore(bytes.NewReader(archive.Bytes())); err == nil {
+		t.Error("Must refuse to restore into a non-empty database")
+	}
+
+	reset()
+	restored, err := r.Restore(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != keys {
+		t.Errorf("Expected %v restored keys, got %v", keys, restored)
+	}
+	if after := snapshot(t, backupPatterns...); !reflect.DeepEqual(before, after) {
+		t.Errorf("Restored state differs:\n%+v\n%+v", before, after)
+	}
+	if len(snapshot(t, "bans")) != 0 {
+		t.Error("Bans are not financial state")
+	}
+	if version, _ := r.KeySchema(); version != KeySchemaVersion {
+		t.Errorf("Expected schema %v, got %v", KeySchemaVersion, version)
+	}
+	if err := r.CheckKeySchema(); err != nil {
+		t.Errorf("Restored database must pass the schema check: %v", err)
+	}
+}
+
+func TestReadBackupTampered(t *testing.T) {
+	reset()
+	writeFinances(t)
+	var archive bytes.Buffer
+	if _, err := r.Backup(&archive); err != nil {
+		t.Fatal(err)
+	}
+	zr, _ := gzip.NewReader(&archive)
+	plain, _ := io.ReadAll(zr)
+
+	repack := func(data string) io.Reader {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(data))
+		zw.Close()
+		return &buf
+	}
+	tampered := strings.Replace(string(plain), `"paid":"50"`, `"paid":"5000"`, 1)
+	if tampered == string(plain) {
+		t.Fatal("Expected a paid counter in the archive")
+	}
+	if _, err := ReadBackup(repack(tampered), nil); err == nil {
+		t.Error("Must detect modified entries")
+	}
+	lines := strings.SplitAfter(string(plain), "\n")
+	if _, err := ReadBackup(repack(strings.Join(lines[:len(lines)-2], "")), nil); err == nil {
+		t.Error("Must detect truncated archive")
+	}
+	dropped := strings.Join(append(lines[:1:1], lines[2:]...), "")
+	if _, err := ReadBackup(repack(dropped), nil); err == nil {
+		t.Error("Must detect dropped entries")
+	}
+
+	reset()
+	if _, err := r.Restore(repack(tampered)); err == nil {
+		t.Error("Must not restore modified archive")
+	}
+}
+
+func TestRestoreCleansUpFailure(t *testing.T) {
+	reset()
+	var archive bytes.Buffer
+	zw := gzip.NewWriter(&archive)
+	enc := json.NewEncoder(zw)
+	enc.Encode(&BackupHeader{Format: BackupFormat, Schema: KeySchemaVersion, Prefix: prefix})
+	for i := 0; i < 250; i++ {
+		enc.Encode(&BackupEntry{Key: fmt.Sprintf("miners:%v", i), Type: "hash", Hash: map[string]string{"balance": "1"}})
+	}
+	enc.Encode(&backupTrailer{Keys: 250, SHA256: "bad"})
+	zw.Close()
+
+	if _, err := r.Restore(bytes.NewReader(archive.Bytes())); err == nil {
+		t.Fatal("Must not restore an archive with a bad checksum")
+	}
+	if left, _ := r.scanKeys(ctx, r.formatKey("*")); len(left) != 0 {
+		t.Errorf("Expected a failed restore to leave no keys, got %v", len(left))
+	}
+}
+
+func TestVerifyFinances(t *testing.T) {
+	reset()
+	writeFinances(t)
+
+	checks, err := r.VerifyFinances()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int64{"balance": 50, "immature": 100, "pending": 0, "paid": 50}
+	for _, check := range checks {
+		if !check.OK() || check.Miners != expected[check.Field] {
+			t.Errorf("Unexpected %s totals %+v", check.Field, check)
+		}
+	}
+
+	var archive bytes.Buffer
+	r.Backup(&archive)
+	totals := NewFinanceTotals()
+	if _, err := ReadBackup(&archive, func(entry *BackupEntry) error {
+		totals.Add(entry)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(totals.Checks(), checks) {
+		t.Errorf("Archive totals differ from database: %+v", totals.Checks())
+	}
+
+	r.client.HIncrBy(ctx, r.formatKey("miners", "y"), "balance", 1)
+	checks, _ = r.VerifyFinances()
+	for _, check := range checks {
+		if check.OK() == (check.Field == "balance") {
+			t.Errorf("Unexpected %s check %+v", check.Field, check)
+		}
+	}
+}
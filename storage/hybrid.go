@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// HybridBackend keeps shares, hashrate windows, charts and lists in Redis
+// and the financial records in SQL. Blocks are written to Redis by the
+// proxy as usual, so SQL never holds up share submission, and handed off to
+// SQL along with their round when the unlocker picks up candidates.
+type HybridBackend struct {
+	*RedisClient
+	ledger *SQLBackend
+}
+
+func NewHybridBackend(hot *RedisClient, ledger *SQLBackend) *HybridBackend {
+	return &HybridBackend{RedisClient: hot, ledger: ledger}
+}
+
+func (h *HybridBackend) WithContext(ctx context.Context) Backend {
+	return &HybridBackend{RedisClient: h.RedisClient.withContext(ctx), ledger: h.ledger.WithContext(ctx)}
+}
+
+func (h *HybridBackend) Check() (string, error) {
+	if err := h.ledger.Check(); err != nil {
+		return "", err
+	}
+	return h.RedisClient.Check()
+}
+
+// handOff moves candidates and their rounds from Redis to SQL. Candidates
+// are written to SQL before they are dropped from Redis and writing them
+// twice is harmless, so concurrent hand-offs are safe.
+func (h *HybridBackend) handOff() error {
+	candidates, err := h.RedisClient.GetCandidates(math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := h.call()
+	defer cancel()
+	for _, block := range candidates {
+		shares, err := h.RedisClient.GetRoundShares(block.RoundHeight, block.Nonce)
+		if err != nil {
+			return err
+		}
+		if err := h.ledger.WriteCandidate(block, shares); err != nil {
+			return err
+		}
+		_, err = h.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+			tx.ZRem(ctx, h.formatKey("blocks", "candidates"), block.candidateKey)
+			tx.Del(ctx, h.formatRound(block.RoundHeight, block.Nonce))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *HybridBackend) GetCandidates(maxHeight int64) ([]*BlockData, error) {
+	if err := h.handOff(); err != nil {
+		return nil, err
+	}
+	return h.ledger.GetCandidates(maxHeight)
+}
+
+func (h *HybridBackend) GetImmatureBlocks(maxHeight int64) ([]*BlockData, error) {
+	return h.ledger.GetImmatureBlocks(maxHeight)
+}
+
+// GetRoundShares falls back to Redis for rounds waiting for hand-off.
+func (h *HybridBackend) GetRoundShares(height int64, nonce string) (map[string]int64, error) {
+	shares, err := h.ledger.GetRoundShares(height, nonce)
+	if err != nil || len(shares) > 0 {
+		return shares, err
+	}
+	return h.RedisClient.GetRoundShares(height, nonce)
+}
+
+func (h *HybridBackend) WriteImmatureBlock(block *BlockData, roundRewards map[string]int64) error {
+	return h.ledger.WriteImmatureBlock(block, roundRewards)
+}
+
+func (h *HybridBackend) WriteMaturedBlock(block *BlockData, roundRewards map[string]int64, fees *RoundFees) error {
+	return h.ledger.WriteMaturedBlock(block, roundRewards, fees)
+}
+
+func (h *HybridBackend) WriteOrphan(block *BlockData) error {
+	return h.ledger.WriteOrphan(block)
+}
+
+func (h *HybridBackend) WritePendingOrphans(blocks []*BlockData) error {
+	return h.ledger.WritePendingOrphans(blocks)
+}
+
+func (h *HybridBackend) GetBlocksPage(status string, q *PageQuery) ([]*BlockData, string, error) {
+	return h.ledger.GetBlocksPage(status, q)
+}
+
+func (h *HybridBackend) GetPayees() ([]string, error) {
+	return h.ledger.GetPayees()
+}
+
+func (h *HybridBackend) GetBalance(login string) (int64, error) {
+	return h.ledger.GetBalance(login)
+}
+
+func (h *HybridBackend) GetPendingPayments() []*PendingPayment {
+	return h.ledger.GetPendingPayments()
+}
+
+func (h *HybridBackend) GetLedger() (*Ledger, error) {
+	return h.ledger.GetLedger()
+}
+
+func (h *HybridBackend) LockPayouts(lock *PayoutsLock) error {
+	return h.ledger.LockPayouts(lock)
+}
+
+func (h *HybridBackend) SetPayoutsLockTx(lock *PayoutsLock, txHash string) error {
+	return h.ledger.SetPayoutsLockTx(lock, txHash)
+}
+
+func (h *HybridBackend) GetPayoutsLock() (*PayoutsLock, error) {
+	return h.ledger.GetPayoutsLock()
+}
+
+func (h *HybridBackend) UnlockPayouts() error {
+	return h.ledger.UnlockPayouts()
+}
+
+func (h *HybridBackend) IsPayoutsLocked() (bool, error) {
+	return h.ledger.IsPayoutsLocked()
+}
+
+func (h *HybridBackend) UpdateBalance(login string, amount int64) error {
+	return h.ledger.UpdateBalance(login, amount)
+}
+
+func (h *HybridBackend) RollbackBalance(login string, amount int64) error {
+	return h.ledger.RollbackBalance(login, amount)
+}
+
+func (h *HybridBackend) WritePayment(login, txHash string, amount int64) error {
+	return h.ledger.WritePayment(login, txHash, amount)
+}
+
+func (h *HybridBackend) GetPaymentsPage(login string, q *PageQuery) ([]*Payment, string, error) {
+	return h.ledger.GetPaymentsPage(login, q)
+}
+
+func (h *HybridBackend) GetCreditsPage(login string, q *PageQuery) ([]*Credit, string, error) {
+	return h.ledger.GetCreditsPage(login, q)
+}
+
+// CollectStats takes hashrate from Redis and blocks and payments from SQL.
+// Candidates still waiting for hand-off are listed first.
+func (h *HybridBackend) CollectStats(smallWindow time.Duration, maxBlocks, maxPayments int64) (*PoolStats, error) {
+	stats, err := h.RedisClient.CollectStats(smallWindow, maxBlocks, maxPayments)
+	if err != nil {
+		return nil, err
+	}
+	l := h.ledger
+	candidates, err := l.getBlocks(BlocksCandidates, 0)
+	if err != nil {
+		return nil, err
+	}
+	stats.Candidates = append(stats.Candidates, candidates...)
+	stats.CandidatesTotal += int64(len(candidates))
+
+	if stats.Immature, err = l.getBlocks(BlocksImmature, 0); err != nil {
+		return nil, err
+	}
+	stats.ImmatureTotal = int64(len(stats.Immature))
+
+	if stats.Matured, err = l.getBlocks(BlocksMatured, maxBlocks); err != nil {
+		return nil, err
+	}
+	if stats.MaturedTotal, err = l.countBlocks(BlocksMatured); err != nil {
+		return nil, err
+	}
+
+	if stats.Payments, err = l.getPayments("", maxPayments); err != nil {
+		return nil, err
+	}
+	stats.PaymentsTotal, err = l.countPayments("")
+	return stats, err
+}
+
+// GetMinerStats takes share counters from Redis and balances from SQL.
+func (h *HybridBackend) GetMinerStats(login string, maxPayments int64) (*MinerStats, error) {
+	stats, err := h.RedisClient.GetMinerStats(login, maxPayments)
+	if err != nil {
+		return nil, err
+	}
+	l := h.ledger
+	account, err := l.getAccount(login)
+	if err != nil {
+		return nil, err
+	}
+	stats.Stats.Balance = account.Balance
+	stats.Stats.Immature = account.Immature
+	stats.Stats.Pending = account.Pending
+	stats.Stats.Paid = account.Paid
+
+	if stats.Payments, err = l.getPayments(login, maxPayments); err != nil {
+		return nil, err
+	}
+	stats.PaymentsTotal, err = l.countPayments(login)
+	return stats, err
+}
+
+// CollectWorkersStats resolves blocks found by the miner against SQL.
+func (h *HybridBackend) CollectWorkersStats(sWindow, lWindow time.Duration, login string, maxBlocks int64) (*WorkersStats, error) {
+	stats, err := h.RedisClient.CollectWorkersStats(sWindow, lWindow, login, 0)
+	if err != nil || maxBlocks <= 0 {
+		return stats, err
+	}
+	ctx, cancel := h.call()
+	defer cancel()
+	finders, err := h.client.ZRevRangeWithScores(ctx, h.formatKey("finders", login), 0, maxBlocks-1).Result()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	stats.Finders = convertFindersStats(finders, h.ledger.getMaturedBlocks)
+	return stats, nil
+}
+
+func (h *HybridBackend) CollectLuckStats(windows []int) (map[string]*LuckStats, error) {
+	l := h.ledger
+	max := int64(windows[len(windows)-1])
+	immature, err := l.getBlocks(BlocksImmature, 0)
+	if err != nil {
+		return make(map[string]*LuckStats), err
+	}
+	matured, err := l.getBlocks(BlocksMatured, max)
+	if err != nil {
+		return make(map[string]*LuckStats), err
+	}
+	return luckStats(append(immature, matured...), windows), nil
+}
+
+func (h *HybridBackend) CollectLuckCharts(max int) ([]*LuckCharts, error) {
+	matured, err := h.ledger.getBlocks(BlocksMatured, int64(max))
+	if err != nil {
+		return nil, err
+	}
+	return luckCharts(matured, max), nil
+}
+
+func (h *HybridBackend) GetPaymentCharts(login string) ([]*PaymentCharts, error) {
+	payments, err := h.ledger.getPayments(login, 361)
+	if err != nil {
+		return nil, err
+	}
+	return paymentCharts(payments), nil
+}
@@ -0,0 +1,294 @@
+package storage
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+// Ledger is a snapshot of the financial records checked by Reconcile.
+type Ledger struct {
+	Finances LedgerTotals
+	Accounts map[string]AccountCounters
+	// Sum of the credits of blocks yet to mature
+	ImmatureCredits int64
+	// Matured blocks, orphans excluded
+	Blocks  []*LedgerBlock
+	Pending []*PendingPayment
+	// Payout held by the payouts lock, if any
+	LockLogin  string
+	LockAmount int64
+}
+
+// LedgerTotals are the pool wide counters kept in finances.
+type LedgerTotals struct {
+	Balance    int64
+	Immature   int64
+	Pending    int64
+	Paid       int64
+	PoolFee    int64
+	Donations  int64
+	TotalMined int64
+}
+
+// LedgerBlock is a matured block along with the rewards credited to miners
+// for it, fees excluded.
+type LedgerBlock struct {
+	Height   int64
+	Hash     string
+	Reward   int64
+	Credited int64
+	Credits  int64
+}
+
+func convertLedgerTotals(m map[string]string) LedgerTotals {
+	return LedgerTotals{
+		Balance:    parseCounter(m, "balance"),
+		Immature:   parseCounter(m, "immature"),
+		Pending:    parseCounter(m, "pending"),
+		Paid:       parseCounter(m, "paid"),
+		PoolFee:    parseCounter(m, "poolFee"),
+		Donations:  parseCounter(m, "donations"),
+		TotalMined: parseCounter(m, "totalMined"),
+	}
+}
+
+// rewardInShannon converts a reward in Wei as stored with a block.
+func rewardInShannon(wei string) int64 {
+	reward, ok := new(big.Int).SetString(wei, 10)
+	if !ok {
+		return 0
+	}
+	return reward.Div(reward, util.Shannon).Int64()
+}
+
+// ledgerKeys builds a Ledger from the Redis key layout shared by RedisClient
+// and MemoryBackend. Credit hashes are keyed by what follows "credits:".
+type ledgerKeys struct {
+	finances map[string]string
+	miners   map[string]map[string]string
+	credits  map[string]map[string]string
+	matured  []redis.Z
+	pending  []redis.Z
+	lock     string
+}
+
+func (k *ledgerKeys) ledger() *Ledger {
+	l := &Ledger{
+		Finances: convertLedgerTotals(k.finances),
+		Accounts: make(map[string]AccountCounters, len(k.miners)),
+		Pending:  convertPendingPayments(k.pending),
+	}
+	for login, counters := range k.miners {
+		l.Accounts[login] = convertAccountCounters(counters)
+	}
+	for key, credits := range k.credits {
+		if strings.HasPrefix(key, "immature:") {
+			l.ImmatureCredits += sumCreditHash(credits)
+		}
+	}
+	for _, block := range convertBlockResults(k.matured) {
+		if block.Orphan {
+			continue
+		}
+		credits := k.credits[join(block.Height, block.Hash)]
+		l.Blocks = append(l.Blocks, &LedgerBlock{
+			Height:   block.Height,
+			Hash:     block.Hash,
+			Reward:   rewardInShannon(block.RewardString),
+			Credited: sumCreditHash(credits),
+			Credits:  int64(len(credits)),
+		})
+	}
+	if len(k.lock) > 0 {
+		lock := parsePayoutsLock(k.lock)
+		l.LockLogin, l.LockAmount = lock.Login, lock.Amount
+	}
+	return l
+}
+
+func sumCreditHash(credits map[string]string) int64 {
+	total := int64(0)
+	for _, amount := range credits {
+		n, _ := strconv.ParseInt(amount, 10, 64)
+		total += n
+	}
+	return total
+}
+
+// isCreditHash tells the credit hashes of blocks, keyed by height and hash
+// or by immature, height and hash, from the credit logs of logins.
+func isCreditHash(key string) bool {
+	parts := strings.Split(key, ":")
+	if parts[0] == "immature" {
+		parts = parts[1:]
+	}
+	if len(parts) != 2 {
+		return false
+	}
+	_, err := strconv.ParseInt(parts[0], 10, 64)
+	return err == nil
+}
+
+// GetLedger reads the financial records in a single transaction. Keys are
+// listed beforehand, so records created meanwhile may be missed.
+func (r *RedisClient) GetLedger() (*Ledger, error) {
+	defer metrics.ObserveRedis("get_ledger", time.Now())
+	ctx := r.ctx
+
+	minerKeys, err := r.scanKeys(ctx, r.formatKey("miners", "*"))
+	if err != nil {
+		return nil, err
+	}
+	creditKeys, err := r.scanKeys(ctx, r.formatKey("credits", "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var finances *redis.MapStringStringCmd
+	var matured, pending *redis.ZSliceCmd
+	var lock *redis.StringCmd
+	miners := make(map[string]*redis.MapStringStringCmd)
+	credits := make(map[string]*redis.MapStringStringCmd)
+	_, err = r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		finances = tx.HGetAll(ctx, r.formatKey("finances"))
+		for _, key := range minerKeys {
+			miners[strings.TrimPrefix(key, r.formatKey("miners")+":")] = tx.HGetAll(ctx, key)
+		}
+		for _, key := range creditKeys {
+			key = strings.TrimPrefix(key, r.formatKey("credits")+":")
+			if isCreditHash(key) {
+				credits[key] = tx.HGetAll(ctx, r.formatKey("credits", key))
+			}
+		}
+		matured = tx.ZRangeWithScores(ctx, r.formatKey("blocks", "matured"), 0, -1)
+		pending = tx.ZRevRangeWithScores(ctx, r.formatKey("payments", "pending"), 0, -1)
+		lock = tx.Get(ctx, r.formatKey("payments", "lock"))
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	k := &ledgerKeys{
+		finances: finances.Val(),
+		miners:   make(map[string]map[string]string, len(miners)),
+		credits:  make(map[string]map[string]string, len(credits)),
+		matured:  matured.Val(),
+		pending:  pending.Val(),
+		lock:     lock.Val(),
+	}
+	for login, cmd := range miners {
+		k.miners[login] = cmd.Val()
+	}
+	for key, cmd := range credits {
+		k.credits[key] = cmd.Val()
+	}
+	return k.ledger(), nil
+}
+
+// LedgerCheck compares a total recorded in finances with the same total
+// computed from the records behind it. Rounding of rewards is tolerated.
+type LedgerCheck struct {
+	Name      string `json:"name"`
+	Recorded  int64  `json:"recorded"`
+	Computed  int64  `json:"computed"`
+	Tolerance int64  `json:"tolerance,omitempty"`
+}
+
+func (c *LedgerCheck) Drift() int64 {
+	return c.Recorded - c.Computed
+}
+
+func (c *LedgerCheck) OK() bool {
+	drift := c.Drift()
+	return drift <= c.Tolerance && -drift <= c.Tolerance
+}
+
+// BlockCredits is a matured block credited nothing or more than its reward.
+type BlockCredits struct {
+	Height   int64  `json:"height"`
+	Hash     string `json:"hash"`
+	Reward   int64  `json:"reward"`
+	Credited int64  `json:"credited"`
+}
+
+type Reconciliation struct {
+	CheckedAt int64           `json:"checkedAt"`
+	Checks    []*LedgerCheck  `json:"checks"`
+	Blocks    []*BlockCredits `json:"blocks"`
+	// Pending payments not held by the payouts lock, or held for too long
+	OrphanedPayments []*PendingPayment `json:"orphanedPayments"`
+}
+
+func (r *Reconciliation) FailedChecks() int {
+	failed := 0
+	for _, check := range r.Checks {
+		if !check.OK() {
+			failed++
+		}
+	}
+	return failed
+}
+
+func (r *Reconciliation) OK() bool {
+	return r.FailedChecks() == 0 && len(r.Blocks) == 0 && len(r.OrphanedPayments) == 0
+}
+
+// Reconcile checks the totals in finances against accounts, credits and
+// pending payments, and every matured block against its credits. Now is in
+// unix seconds. Payments pending for longer than timeout are flagged even
+// when locked, zero disables that.
+func (l *Ledger) Reconcile(now int64, timeout time.Duration) *Reconciliation {
+	result := &Reconciliation{CheckedAt: now, Blocks: []*BlockCredits{}, OrphanedPayments: []*PendingPayment{}}
+
+	var accounts AccountCounters
+	for _, c := range l.Accounts {
+		accounts.Balance += c.Balance
+		accounts.Immature += c.Immature
+		accounts.Pending += c.Pending
+		accounts.Paid += c.Paid
+	}
+	pending := int64(0)
+	for _, payment := range l.Pending {
+		pending += payment.Amount
+	}
+
+	// Every miner reward is rounded to the nearest Shannon while the block
+	// reward is rounded down
+	credited, tolerance := l.Finances.PoolFee+l.Finances.Donations, int64(0)
+	for _, block := range l.Blocks {
+		credited += block.Credited
+		tolerance += block.Credits + 1
+		if block.Credits == 0 || block.Credited > block.Reward+block.Credits {
+			result.Blocks = append(result.Blocks, &BlockCredits{
+				Height: block.Height, Hash: block.Hash, Reward: block.Reward, Credited: block.Credited,
+			})
+		}
+	}
+
+	result.Checks = []*LedgerCheck{
+		{Name: "balance", Recorded: l.Finances.Balance, Computed: accounts.Balance},
+		{Name: "immature", Recorded: l.Finances.Immature, Computed: accounts.Immature},
+		{Name: "pending", Recorded: l.Finances.Pending, Computed: accounts.Pending},
+		{Name: "paid", Recorded: l.Finances.Paid, Computed: accounts.Paid},
+		{Name: "immatureCredits", Recorded: l.Finances.Immature, Computed: l.ImmatureCredits},
+		{Name: "pendingPayments", Recorded: l.Finances.Pending, Computed: pending},
+		{Name: "credited", Recorded: l.Finances.TotalMined, Computed: credited, Tolerance: tolerance},
+	}
+
+	for _, payment := range l.Pending {
+		locked := payment.Address == l.LockLogin && payment.Amount == l.LockAmount
+		expired := timeout > 0 && now-payment.Timestamp > int64(timeout/time.Second)
+		if !locked || expired {
+			result.OrphanedPayments = append(result.OrphanedPayments, payment)
+		}
+	}
+	return result
+}
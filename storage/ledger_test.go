@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLedgerReconcile(t *testing.T) {
+	ledger := &Ledger{
+		Finances: LedgerTotals{Balance: 1000, Immature: 50, Pending: 30, Paid: 20, PoolFee: 10, TotalMined: 1100},
+		Accounts: map[string]AccountCounters{
+			"x": {Balance: 600, Immature: 50, Pending: 30, Paid: 20},
+			"y": {Balance: 400},
+		},
+		ImmatureCredits: 50,
+		Blocks: []*LedgerBlock{
+			// Three rewards rounded up
+			{Height: 1, Hash: "0x1", Reward: 600, Credited: 593, Credits: 3},
+			{Height: 2, Hash: "0x2", Reward: 500, Credited: 500, Credits: 1},
+		},
+		Pending:   []*PendingPayment{{Timestamp: 100, Address: "x", Amount: 30}},
+		LockLogin: "x", LockAmount: 30,
+	}
+	if result := ledger.Reconcile(200, time.Hour); !result.OK() {
+		t.Fatalf("Must reconcile: %+v", result)
+	}
+
+	drifted := *ledger
+	drifted.Finances.Balance += 5
+	drifted.Finances.TotalMined += 150
+	drifted.Blocks = append(drifted.Blocks, &LedgerBlock{Height: 3, Hash: "0x3", Reward: 100}, &LedgerBlock{Height: 4, Hash: "0x4", Reward: 100, Credited: 150, Credits: 2})
+	drifted.LockLogin = ""
+	result := drifted.Reconcile(200, time.Hour)
+
+	failed := make(map[string]int64)
+	for _, check := range result.Checks {
+		if !check.OK() {
+			failed[check.Name] = check.Drift()
+		}
+	}
+	if len(failed) != 1 || failed["balance"] != 5 {
+		t.Errorf("Must flag balance drift only, credited drift is within tolerance: %v", failed)
+	}
+	if len(result.Blocks) != 2 || result.Blocks[0].Height != 3 || result.Blocks[1].Credited != 150 {
+		t.Errorf("Must flag uncredited and overcredited blocks: %+v", result.Blocks)
+	}
+	if len(result.OrphanedPayments) != 1 {
+		t.Errorf("Must flag unlocked payment: %+v", result.OrphanedPayments)
+	}
+
+	drifted.Finances.TotalMined += 100
+	if result := drifted.Reconcile(200, time.Hour); result.FailedChecks() != 2 {
+		t.Errorf("Must flag credited drift: %+v", result.Checks)
+	}
+}
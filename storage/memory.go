@@ -0,0 +1,704 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+// MemoryBackend keeps pool state in process memory using the Redis key
+// layout, so it behaves like RedisClient for tests and single node pools.
+// Nothing is persisted and the state can't be shared between processes.
+type MemoryBackend struct {
+	memStore
+	scheme RewardScheme
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{memStore: newMemStore(), scheme: propScheme{}}
+}
+
+// WithContext returns the backend itself, its calls never block.
+func (m *MemoryBackend) WithContext(ctx context.Context) Backend {
+	return m
+}
+
+func (m *MemoryBackend) SetRewardScheme(scheme RewardScheme) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scheme = scheme
+}
+
+func (m *MemoryBackend) RewardScheme() RewardScheme {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.scheme
+}
+
+func (m *MemoryBackend) Check() (string, error) {
+	return "PONG", nil
+}
+
+// BgSave is a no-op, the memory backend has nothing to persist to.
+func (m *MemoryBackend) BgSave() (string, error) {
+	return "OK", nil
+}
+
+func (m *MemoryBackend) GetBlacklist() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sMembers(m.formatKey(Blacklist)), nil
+}
+
+func (m *MemoryBackend) GetWhitelist() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sMembers(m.formatKey(Whitelist)), nil
+}
+
+func (m *MemoryBackend) AddListEntry(list, entry string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sAdd(m.formatKey(list), entry), nil
+}
+
+func (m *MemoryBackend) RemoveListEntry(list, entry string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sRem(m.formatKey(list), entry), nil
+}
+
+func (m *MemoryBackend) WriteBan(ban *Ban) error {
+	data, err := json.Marshal(ban)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hSet(m.formatKey("bans"), ban.IP, string(data))
+	return nil
+}
+
+func (m *MemoryBackend) GetBans() ([]*Ban, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return convertBans(m.hGetAll(m.formatKey("bans")), util.MakeTimestamp())
+}
+
+func (m *MemoryBackend) DeleteBan(ip string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.hGet(m.formatKey("bans"), ip)
+	m.hDel(m.formatKey("bans"), ip)
+	return ok, nil
+}
+
+func (m *MemoryBackend) WriteAudit(entry *AuditEntry, maxLen int64) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lPush(m.formatKey("audit"), string(data))
+	if maxLen > 0 {
+		m.lTrim(m.formatKey("audit"), 0, maxLen-1)
+	}
+	return nil
+}
+
+func (m *MemoryBackend) GetAuditLog(limit int64) ([]*AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return convertAuditLog(m.lRange(m.formatKey("audit"), 0, limit-1))
+}
+
+func (m *MemoryBackend) GetBlocksPage(status string, q *PageQuery) ([]*BlockData, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return getBlocksPage(m, status, q)
+}
+
+func (m *MemoryBackend) GetPaymentsPage(login string, q *PageQuery) ([]*Payment, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return getPaymentsPage(m, login, q)
+}
+
+func (m *MemoryBackend) GetCreditsPage(login string, q *PageQuery) ([]*Credit, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return getCreditsPage(m, login, q)
+}
+
+func (m *MemoryBackend) WritePoolCharts(time1 int64, time2 string, poolHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.zAdd(m.formatKey("charts", "pool"), float64(time1), join(time1, time2, poolHash))
+	return nil
+}
+
+func (m *MemoryBackend) WriteMinerCharts(time1 int64, time2, k string, hash, largeHash, workerOnline int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.zAdd(m.formatKey("charts", "miner", k), float64(time1), join(time1, time2, hash, largeHash, workerOnline))
+	return nil
+}
+
+func (m *MemoryBackend) WriteWorkerCharts(time1 int64, time2, login, worker string, hash, largeHash int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.zAdd(m.formatKey("charts", "worker", login, worker), float64(time1), join(time1, time2, hash, largeHash))
+	return nil
+}
+
+// chartRange drops entries older than two days and returns the newest ones.
+func (m *MemoryBackend) chartRange(key string, num int64) []redis.Z {
+	now := util.MakeTimestamp() / 1000
+	m.zRemRangeByScore(key, "-inf", fmt.Sprint("(", now-172800))
+	return m.zRange(key, 0, num, true)
+}
+
+func (m *MemoryBackend) GetPoolCharts(poolHashLen int64) ([]*PoolCharts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return convertPoolChartsResults(m.chartRange(m.formatKey("charts", "pool"), poolHashLen)), nil
+}
+
+func (m *MemoryBackend) GetMinerCharts(hashNum int64, login string) ([]*MinerCharts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return convertMinerChartsResults(m.chartRange(m.formatKey("charts", "miner", login), hashNum)), nil
+}
+
+func (m *MemoryBackend) GetWorkerCharts(hashNum int64, login, worker string) ([]*WorkerCharts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return convertWorkerChartsResults(m.chartRange(m.formatKey("charts", "worker", login, worker), hashNum)), nil
+}
+
+func (m *MemoryBackend) GetPaymentCharts(login string) ([]*PaymentCharts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return convertPaymentChartsResults(m.zRange(m.formatKey("payments", login), 0, 360, true)), nil
+}
+
+// logins returns the last key segment of every key under prefix.
+func (m *MemoryBackend) logins(prefix string) []string {
+	var result []string
+	for _, key := range m.keys(m.formatKey(prefix) + ":") {
+		parts := strings.Split(key, ":")
+		result = append(result, parts[1])
+	}
+	return result
+}
+
+func (m *MemoryBackend) GetAllMinerAccount() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.logins("miners"), nil
+}
+
+func (m *MemoryBackend) GetPayees() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.logins("miners"), nil
+}
+
+func (m *MemoryBackend) WriteNodeState(id string, height uint64, diff *big.Int, blocktime float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := util.MakeTimestamp() / 1000
+	m.hSet(m.formatKey("nodes"), join(id, "name"), id)
+	m.hSet(m.formatKey("nodes"), join(id, "height"), strconv.FormatUint(height, 10))
+	m.hSet(m.formatKey("nodes"), join(id, "difficulty"), diff.String())
+	m.hSet(m.formatKey("nodes"), join(id, "lastBeat"), strconv.FormatInt(now, 10))
+	m.hSet(m.formatKey("nodes"), join(id, "blocktime"), strconv.FormatFloat(blocktime, 'f', 4, 64))
+	return nil
+}
+
+func (m *MemoryBackend) GetNodeStates() ([]*NodeState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return convertNodeStates(m.hGetAll(m.formatKey("nodes"))), nil
+}
+
+// checkPoWExist records params and reports whether they were seen before,
+// forgetting entries more than 8 blocks behind height.
+func (m *MemoryBackend) checkPoWExist(height uint64, params []string) bool {
+	m.zRemRangeByScore(m.formatKey("pow"), "-inf", fmt.Sprint("(", height-8))
+	return !m.zAdd(m.formatKey("pow"), float64(height), strings.Join(params, ":"))
+}
+
+func (m *MemoryBackend) WriteShare(login, id string, params []string, diff int64, height uint64, window time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.writeShareWith(m.scheme, login, id, params, diff, height, window), nil
+}
+
+func (m *MemoryBackend) WriteSoloShare(login, id string, params []string, diff int64, height uint64, window time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.writeShareWith(soloScheme{}, login, id, params, diff, height, window), nil
+}
+
+func (m *MemoryBackend) writeShareWith(scheme RewardScheme, login, id string, params []string, diff int64, height uint64, window time.Duration) bool {
+	if m.checkPoWExist(height, params) {
+		return true
+	}
+	ms := util.MakeTimestamp()
+	m.writeShare(scheme, ms, ms/1000, login, id, diff, window)
+	return false
+}
+
+func (m *MemoryBackend) WriteBlock(login, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.writeBlockWith(m.scheme, login, id, params, diff, roundDiff, height, window), nil
+}
+
+func (m *MemoryBackend) WriteSoloBlock(login, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.writeBlockWith(soloScheme{}, login, id, params, diff, roundDiff, height, window), nil
+}
+
+func (m *MemoryBackend) writeBlockWith(scheme RewardScheme, login, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration) bool {
+	if m.checkPoWExist(height, params) {
+		return true
+	}
+	ms := util.MakeTimestamp()
+	ts := ms / 1000
+
+	m.writeShare(scheme, ms, ts, login, id, diff, window)
+	m.hSet(m.formatKey("stats"), "lastBlockFound", strconv.FormatInt(ts, 10))
+	m.zIncrBy(m.formatKey("finders"), 1, login)
+	m.hIncrBy(m.formatKey("miners", login), "blocksFound", 1)
+	m.zAdd(m.formatKey("finders", login), float64(ts), join(height, id, ms))
+	roundKey := m.formatRound(int64(height), params[0])
+	scheme.closeRound(m, login, roundKey)
+
+	totalShares := sumCredits(convertRoundShares(m.hGetAll(roundKey)))
+	m.zAdd(m.formatKey("blocks", "candidates"), float64(height), candidateMember(params, ts, roundDiff, totalShares))
+	return false
+}
+
+func (m *MemoryBackend) writeShare(scheme RewardScheme, ms, ts int64, login, id string, diff int64, expire time.Duration) {
+	scheme.writeShare(m, login, diff)
+	m.zAdd(m.formatKey("hashrate"), float64(ts), join(diff, login, id, ms))
+	m.zAdd(m.formatKey("hashrate", login), float64(ts), join(diff, id, ms))
+	m.expire(m.formatKey("hashrate", login), expire)
+	m.hSet(m.formatKey("miners", login), "lastShare", strconv.FormatInt(ts, 10))
+}
+
+// WriteEvents keeps entries in a capped list standing in for the stream.
+func (m *MemoryBackend) WriteEvents(stream string, maxLen int64, entries []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range entries {
+		m.lPush(m.formatKey(stream), entry)
+	}
+	if maxLen > 0 {
+		m.lTrim(m.formatKey(stream), 0, maxLen-1)
+	}
+	return nil
+}
+
+func (m *MemoryBackend) GetCandidates(maxHeight int64) ([]*BlockData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	option := &redis.ZRangeBy{Min: "0", Max: strconv.FormatInt(maxHeight, 10)}
+	raw, err := m.zRangeByScore(m.formatKey("blocks", "candidates"), option, true)
+	if err != nil {
+		return nil, err
+	}
+	return convertCandidateResults(raw), nil
+}
+
+func (m *MemoryBackend) GetImmatureBlocks(maxHeight int64) ([]*BlockData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	option := &redis.ZRangeBy{Min: "0", Max: strconv.FormatInt(maxHeight, 10)}
+	raw, err := m.zRangeByScore(m.formatKey("blocks", "immature"), option, true)
+	if err != nil {
+		return nil, err
+	}
+	return convertBlockResults(raw), nil
+}
+
+func (m *MemoryBackend) GetRoundShares(height int64, nonce string) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return convertRoundShares(m.hGetAll(m.formatRound(height, nonce))), nil
+}
+
+func (m *MemoryBackend) GetBalance(login string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.hGet(m.formatKey("miners", login), "balance")
+	if !ok {
+		return 0, nil
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+func (m *MemoryBackend) GetPendingPayments() []*PendingPayment {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return convertPendingPayments(m.zRange(m.formatKey("payments", "pending"), 0, -1, true))
+}
+
+func (m *MemoryBackend) GetLedger() (*Ledger, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := &ledgerKeys{
+		finances: m.hGetAll(m.formatKey("finances")),
+		miners:   make(map[string]map[string]string),
+		credits:  make(map[string]map[string]string),
+		matured:  m.zRange(m.formatKey("blocks", "matured"), 0, -1, false),
+		pending:  m.zRange(m.formatKey("payments", "pending"), 0, -1, true),
+	}
+	k.lock, _ = m.get(m.formatKey("payments", "lock"))
+	for _, login := range m.logins("miners") {
+		k.miners[login] = m.hGetAll(m.formatKey("miners", login))
+	}
+	prefix := m.formatKey("credits") + ":"
+	for _, key := range m.keys(prefix) {
+		if key = strings.TrimPrefix(key, prefix); isCreditHash(key) {
+			k.credits[key] = m.hGetAll(m.formatKey("credits", key))
+		}
+	}
+	return k.ledger(), nil
+}
+
+func (m *MemoryBackend) LockPayouts(lock *PayoutsLock) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := m.formatKey("payments", "lock")
+	if _, ok := m.get(key); ok {
+		return fmt.Errorf("unable to acquire lock '%s'", key)
+	}
+	m.set(key, lock.value())
+	return nil
+}
+
+func (m *MemoryBackend) SetPayoutsLockTx(lock *PayoutsLock, txHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sent := *lock
+	sent.TxHash = txHash
+	m.set(m.formatKey("payments", "lock"), sent.value())
+	return nil
+}
+
+func (m *MemoryBackend) GetPayoutsLock() (*PayoutsLock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	val, ok := m.get(m.formatKey("payments", "lock"))
+	if !ok {
+		return nil, nil
+	}
+	return parsePayoutsLock(val), nil
+}
+
+func (m *MemoryBackend) UnlockPayouts() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.del(m.formatKey("payments", "lock"))
+	return nil
+}
+
+func (m *MemoryBackend) IsPayoutsLocked() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.get(m.formatKey("payments", "lock"))
+	return ok, nil
+}
+
+func (m *MemoryBackend) UpdateBalance(login string, amount int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ts := util.MakeTimestamp() / 1000
+	m.hIncrBy(m.formatKey("miners", login), "balance", (amount * -1))
+	m.hIncrBy(m.formatKey("miners", login), "pending", amount)
+	m.hIncrBy(m.formatKey("finances"), "balance", (amount * -1))
+	m.hIncrBy(m.formatKey("finances"), "pending", amount)
+	m.zAdd(m.formatKey("payments", "pending"), float64(ts), join(login, amount))
+	return nil
+}
+
+func (m *MemoryBackend) RollbackBalance(login string, amount int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hIncrBy(m.formatKey("miners", login), "balance", amount)
+	m.hIncrBy(m.formatKey("miners", login), "pending", (amount * -1))
+	m.hIncrBy(m.formatKey("finances"), "balance", amount)
+	m.hIncrBy(m.formatKey("finances"), "pending", (amount * -1))
+	m.zRem(m.formatKey("payments", "pending"), join(login, amount))
+	return nil
+}
+
+func (m *MemoryBackend) WritePayment(login, txHash string, amount int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ts := util.MakeTimestamp() / 1000
+	m.hIncrBy(m.formatKey("miners", login), "pending", (amount * -1))
+	m.hIncrBy(m.formatKey("miners", login), "paid", amount)
+	m.hIncrBy(m.formatKey("finances"), "pending", (amount * -1))
+	m.hIncrBy(m.formatKey("finances"), "paid", amount)
+	m.zAdd(m.formatKey("payments", "all"), float64(ts), join(txHash, login, amount))
+	m.zAdd(m.formatKey("payments", login), float64(ts), join(txHash, amount))
+	m.zRem(m.formatKey("payments", "pending"), join(login, amount))
+	m.del(m.formatKey("payments", "lock"))
+	return nil
+}
+
+func (m *MemoryBackend) WriteImmatureBlock(block *BlockData, roundRewards map[string]int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.writeImmatureBlock(block)
+	total := int64(0)
+	for login, amount := range roundRewards {
+		total += amount
+		m.hIncrBy(m.formatKey("miners", login), "immature", amount)
+		m.hSetNX(m.formatKey("credits", "immature", block.Height, block.Hash), login, strconv.FormatInt(amount, 10))
+	}
+	m.hIncrBy(m.formatKey("finances"), "immature", total)
+	return nil
+}
+
+// releaseImmature takes back the immature credits logged for block and
+// returns their total.
+func (m *MemoryBackend) releaseImmature(block *BlockData) int64 {
+	creditKey := m.formatKey("credits", "immature", block.RoundHeight, block.Hash)
+	totalImmature := int64(0)
+	for login, amountString := range m.hGetAll(creditKey) {
+		amount, _ := strconv.ParseInt(amountString, 10, 64)
+		totalImmature += amount
+		m.hIncrBy(m.formatKey("miners", login), "immature", (amount * -1))
+	}
+	m.del(creditKey)
+	return totalImmature
+}
+
+func (m *MemoryBackend) WriteMaturedBlock(block *BlockData, roundRewards map[string]int64, fees *RoundFees) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ts := util.MakeTimestamp() / 1000
+	m.writeMaturedBlock(block)
+	m.zAdd(m.formatKey("credits", "all"), float64(block.Height), join(block.Hash, ts, block.Reward))
+	totalImmature := m.releaseImmature(block)
+
+	total := int64(0)
+	for login, amount := range roundRewards {
+		total += amount
+		m.hIncrBy(m.formatKey("miners", login), "balance", amount)
+		m.hSetNX(m.formatKey("credits", block.Height, block.Hash), login, strconv.FormatInt(amount, 10))
+		m.writeCredit(login, block, creditReward, ts, amount)
+	}
+	if fees != nil {
+		for login, amount := range fees.Pool {
+			m.hIncrBy(m.formatKey("miners", login), "balance", amount)
+			m.writeCredit(login, block, creditPoolFee, ts, amount)
+		}
+		for login, amount := range fees.Donations {
+			m.hIncrBy(m.formatKey("miners", login), "balance", amount)
+			m.writeCredit(login, block, creditDonation, ts, amount)
+		}
+		total += fees.Total()
+		m.hIncrBy(m.formatKey("finances"), "poolFee", sumCredits(fees.Pool))
+		m.hIncrBy(m.formatKey("finances"), "donations", sumCredits(fees.Donations))
+	}
+	m.hIncrBy(m.formatKey("finances"), "balance", total)
+	m.hIncrBy(m.formatKey("finances"), "immature", (totalImmature * -1))
+	m.hSet(m.formatKey("finances"), "lastCreditHeight", strconv.FormatInt(block.Height, 10))
+	m.hSet(m.formatKey("finances"), "lastCreditHash", block.Hash)
+	m.hIncrBy(m.formatKey("finances"), "totalMined", block.RewardInShannon())
+	return nil
+}
+
+func (m *MemoryBackend) writeCredit(login string, block *BlockData, kind string, ts, amount int64) {
+	m.zAdd(m.formatKey("credits", login), float64(ts), creditMember(block, kind, amount))
+}
+
+func (m *MemoryBackend) WriteOrphan(block *BlockData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.writeMaturedBlock(block)
+	totalImmature := m.releaseImmature(block)
+	m.hIncrBy(m.formatKey("finances"), "immature", (totalImmature * -1))
+	return nil
+}
+
+func (m *MemoryBackend) WritePendingOrphans(blocks []*BlockData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, block := range blocks {
+		m.writeImmatureBlock(block)
+	}
+	return nil
+}
+
+func (m *MemoryBackend) writeImmatureBlock(block *BlockData) {
+	m.rename(m.formatRound(block.RoundHeight, block.Nonce), m.formatRound(block.Height, block.Nonce))
+	m.zRem(m.formatKey("blocks", "candidates"), block.candidateKey)
+	m.zAdd(m.formatKey("blocks", "immature"), float64(block.Height), block.key())
+}
+
+func (m *MemoryBackend) writeMaturedBlock(block *BlockData) {
+	m.del(m.formatRound(block.RoundHeight, block.Nonce))
+	m.zRem(m.formatKey("blocks", "immature"), block.immatureKey)
+	m.zAdd(m.formatKey("blocks", "matured"), float64(block.Height), block.key())
+}
+
+func (m *MemoryBackend) IsMinerExists(login string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.exists(m.formatKey("miners", login)), nil
+}
+
+func (m *MemoryBackend) GetMinerStats(login string, maxPayments int64) (*MinerStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := &MinerStats{}
+	stats.Stats = convertAccountCounters(m.hGetAll(m.formatKey("miners", login)))
+	stats.Payments = convertPaymentsResults(m.zRange(m.formatKey("payments", login), 0, maxPayments-1, true))
+	stats.PaymentsTotal = m.zCard(m.formatKey("payments", login))
+	shares, _ := m.hGet(m.formatKey("shares", "roundCurrent"), login)
+	stats.RoundShares, _ = strconv.ParseInt(shares, 10, 64)
+	return stats, nil
+}
+
+func (m *MemoryBackend) FlushStaleStats(window, largeWindow time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := util.MakeTimestamp() / 1000
+	total := m.zRemRangeByScore(m.formatKey("hashrate"), "-inf", fmt.Sprint("(", now-int64(window/time.Second)))
+	max := fmt.Sprint("(", now-int64(largeWindow/time.Second))
+	for _, login := range m.logins("hashrate") {
+		total += m.zRemRangeByScore(m.formatKey("hashrate", login), "-inf", max)
+	}
+	return total, nil
+}
+
+func (m *MemoryBackend) CollectStats(smallWindow time.Duration, maxBlocks, maxPayments int64) (*PoolStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	window := int64(smallWindow / time.Second)
+	stats := &PoolStats{}
+	now := util.MakeTimestamp() / 1000
+
+	m.zRemRangeByScore(m.formatKey("hashrate"), "-inf", fmt.Sprint("(", now-window))
+	stats.Stats = convertPoolCounters(m.hGetAll(m.formatKey("stats")))
+	stats.Candidates = convertCandidateResults(m.zRange(m.formatKey("blocks", "candidates"), 0, -1, true))
+	stats.CandidatesTotal = m.zCard(m.formatKey("blocks", "candidates"))
+	stats.Immature = convertBlockResults(m.zRange(m.formatKey("blocks", "immature"), 0, -1, true))
+	stats.ImmatureTotal = m.zCard(m.formatKey("blocks", "immature"))
+	stats.Matured = convertBlockResults(m.zRange(m.formatKey("blocks", "matured"), 0, maxBlocks-1, true))
+	stats.MaturedTotal = m.zCard(m.formatKey("blocks", "matured"))
+	stats.Payments = convertPaymentsResults(m.zRange(m.formatKey("payments", "all"), 0, maxPayments-1, true))
+	stats.PaymentsTotal = m.zCard(m.formatKey("payments", "all"))
+	stats.Hashrate, stats.Miners = convertMinersStats(window, m.zRange(m.formatKey("hashrate"), 0, -1, false))
+	stats.MinersTotal = len(stats.Miners)
+	return stats, nil
+}
+
+func (m *MemoryBackend) CollectWorkersStats(sWindow, lWindow time.Duration, login string, maxBlocks int64) (*WorkersStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.collectWorkersStats(sWindow, lWindow, login, maxBlocks), nil
+}
+
+func (m *MemoryBackend) collectWorkersStats(sWindow, lWindow time.Duration, login string, maxBlocks int64) *WorkersStats {
+	smallWindow := int64(sWindow / time.Second)
+	largeWindow := int64(lWindow / time.Second)
+	now := util.MakeTimestamp() / 1000
+
+	m.zRemRangeByScore(m.formatKey("hashrate", login), "-inf", fmt.Sprint("(", now-largeWindow))
+	raw := m.zRange(m.formatKey("hashrate", login), 0, -1, false)
+	stats := summarizeWorkers(now, smallWindow, largeWindow, convertWorkersStats(smallWindow, raw))
+	if maxBlocks > 0 {
+		stats.Finders = convertFindersStats(m.zRange(m.formatKey("finders", login), 0, maxBlocks-1, true), m.maturedBlocksByHeight)
+	}
+	return stats
+}
+
+func (m *MemoryBackend) maturedBlocksByHeight(minHeight, maxHeight int64) ([]*BlockData, error) {
+	opt := &redis.ZRangeBy{Min: strconv.FormatInt(minHeight, 10), Max: strconv.FormatInt(maxHeight, 10)}
+	raw, err := m.zRangeByScore(m.formatKey("blocks", "matured"), opt, true)
+	return convertBlockResults(raw), err
+}
+
+func (m *MemoryBackend) WriteWorkerShares(shares []*WorkerShares) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, w := range shares {
+		key := m.formatKey("workers", w.Login, w.Worker)
+		for field, n := range w.counters() {
+			if n > 0 {
+				m.hIncrBy(key, field, n)
+			}
+		}
+		m.hSet(key, "lastShare", strconv.FormatInt(w.LastShare, 10))
+	}
+	return nil
+}
+
+func (m *MemoryBackend) GetWorkerStats(sWindow, lWindow time.Duration, login, worker string) (*WorkerStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	collected := m.collectWorkersStats(sWindow, lWindow, login, 0)
+	counters := m.hGetAll(m.formatKey("workers", login, worker))
+	w, online := collected.Workers[worker]
+	if !online && len(counters) == 0 {
+		return nil, nil
+	}
+	return &WorkerStats{
+		CurrentHashrate: w.HR,
+		Hashrate:        w.TotalHR,
+		LastBeat:        w.LastBeat,
+		Offline:         !online || w.Offline,
+		Valid:           parseCounter(counters, WorkerSharesValid),
+		Stale:           parseCounter(counters, WorkerSharesStale),
+		Invalid:         parseCounter(counters, WorkerSharesInvalid),
+		LastShare:       parseCounter(counters, "lastShare"),
+	}, nil
+}
+
+func (m *MemoryBackend) CollectLuckStats(windows []int) (map[string]*LuckStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	max := int64(windows[len(windows)-1])
+	blocks := convertBlockResults(
+		m.zRange(m.formatKey("blocks", "immature"), 0, -1, true),
+		m.zRange(m.formatKey("blocks", "matured"), 0, max-1, true),
+	)
+	return luckStats(blocks, windows), nil
+}
+
+func (m *MemoryBackend) CollectLuckCharts(max int) ([]*LuckCharts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return luckCharts(convertBlockResults(m.zRange(m.formatKey("blocks", "matured"), 0, int64(max-1), true)), max), nil
+}
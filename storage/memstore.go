@@ -0,0 +1,420 @@
+package storage
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// memStore holds the Redis data types the pool uses, keyed the same way as
+// in Redis minus the prefix. Methods expect the caller to hold mu, which
+// gives every backend call the atomicity of a Redis transaction.
+type memStore struct {
+	mu      sync.Mutex
+	values  map[string]string
+	hashes  map[string]map[string]string
+	zsets   map[string]map[string]float64
+	sets    map[string]map[string]struct{}
+	lists   map[string][]string
+	expires map[string]time.Time
+}
+
+func newMemStore() memStore {
+	return memStore{
+		values:  make(map[string]string),
+		hashes:  make(map[string]map[string]string),
+		zsets:   make(map[string]map[string]float64),
+		sets:    make(map[string]map[string]struct{}),
+		lists:   make(map[string][]string),
+		expires: make(map[string]time.Time),
+	}
+}
+
+func (s *memStore) formatKey(args ...interface{}) string {
+	return join(args...)
+}
+
+func (s *memStore) formatRound(height int64, nonce string) string {
+	return s.formatKey("shares", "round"+strconv.FormatInt(height, 10), nonce)
+}
+
+// evict drops key once its expiry has passed.
+func (s *memStore) evict(key string) {
+	if at, ok := s.expires[key]; ok && !time.Now().Before(at) {
+		s.del(key)
+	}
+}
+
+func (s *memStore) exists(key string) bool {
+	s.evict(key)
+	_, v := s.values[key]
+	_, h := s.hashes[key]
+	_, z := s.zsets[key]
+	_, st := s.sets[key]
+	_, l := s.lists[key]
+	return v || h || z || st || l
+}
+
+// keys returns live keys starting with prefix.
+func (s *memStore) keys(prefix string) []string {
+	seen := make(map[string]struct{})
+	collect := func(key string) {
+		if strings.HasPrefix(key, prefix) {
+			seen[key] = struct{}{}
+		}
+	}
+	for key := range s.values {
+		collect(key)
+	}
+	for key := range s.hashes {
+		collect(key)
+	}
+	for key := range s.zsets {
+		collect(key)
+	}
+	for key := range s.sets {
+		collect(key)
+	}
+	for key := range s.lists {
+		collect(key)
+	}
+	var result []string
+	for key := range seen {
+		if s.exists(key) {
+			result = append(result, key)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (s *memStore) del(key string) {
+	delete(s.values, key)
+	delete(s.hashes, key)
+	delete(s.zsets, key)
+	delete(s.sets, key)
+	delete(s.lists, key)
+	delete(s.expires, key)
+}
+
+// rename moves from over to, a missing source is left alone.
+func (s *memStore) rename(from, to string) {
+	if from == to || !s.exists(from) {
+		return
+	}
+	s.del(to)
+	if v, ok := s.values[from]; ok {
+		s.values[to] = v
+	}
+	if h, ok := s.hashes[from]; ok {
+		s.hashes[to] = h
+	}
+	if z, ok := s.zsets[from]; ok {
+		s.zsets[to] = z
+	}
+	if st, ok := s.sets[from]; ok {
+		s.sets[to] = st
+	}
+	if l, ok := s.lists[from]; ok {
+		s.lists[to] = l
+	}
+	if at, ok := s.expires[from]; ok {
+		s.expires[to] = at
+	}
+	s.del(from)
+}
+
+// expire sets a time to live on key, zero or less deletes it right away.
+func (s *memStore) expire(key string, d time.Duration) {
+	if !s.exists(key) {
+		return
+	}
+	if d <= 0 {
+		s.del(key)
+		return
+	}
+	s.expires[key] = time.Now().Add(d)
+}
+
+func (s *memStore) get(key string) (string, bool) {
+	s.evict(key)
+	v, ok := s.values[key]
+	return v, ok
+}
+
+func (s *memStore) set(key, value string) {
+	s.del(key)
+	s.values[key] = value
+}
+
+func (s *memStore) hash(key string, create bool) map[string]string {
+	s.evict(key)
+	h, ok := s.hashes[key]
+	if !ok && create {
+		h = make(map[string]string)
+		s.hashes[key] = h
+	}
+	return h
+}
+
+func (s *memStore) hGet(key, field string) (string, bool) {
+	v, ok := s.hash(key, false)[field]
+	return v, ok
+}
+
+func (s *memStore) hGetAll(key string) map[string]string {
+	result := make(map[string]string)
+	for field, v := range s.hash(key, false) {
+		result[field] = v
+	}
+	return result
+}
+
+func (s *memStore) hSet(key, field, value string) {
+	s.hash(key, true)[field] = value
+}
+
+func (s *memStore) hSetNX(key, field, value string) {
+	h := s.hash(key, true)
+	if _, ok := h[field]; !ok {
+		h[field] = value
+	}
+}
+
+func (s *memStore) hIncrBy(key, field string, n int64) {
+	h := s.hash(key, true)
+	v, _ := strconv.ParseInt(h[field], 10, 64)
+	h[field] = strconv.FormatInt(v+n, 10)
+}
+
+// hDel removes field, empty hashes are dropped like in Redis.
+func (s *memStore) hDel(key, field string) {
+	h := s.hash(key, false)
+	delete(h, field)
+	if h != nil && len(h) == 0 {
+		s.del(key)
+	}
+}
+
+func (s *memStore) lPush(key, value string) {
+	s.evict(key)
+	s.lists[key] = append([]string{value}, s.lists[key]...)
+}
+
+// listRange resolves Redis style start and stop indexes, negative ones
+// counting from the tail.
+func listRange(n, start, stop int64) (int64, int64) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	return start, stop
+}
+
+func (s *memStore) lRange(key string, start, stop int64) []string {
+	s.evict(key)
+	l := s.lists[key]
+	start, stop = listRange(int64(len(l)), start, stop)
+	if start > stop {
+		return []string{}
+	}
+	return append([]string{}, l[start:stop+1]...)
+}
+
+func (s *memStore) lTrim(key string, start, stop int64) {
+	l := s.lRange(key, start, stop)
+	if len(l) == 0 {
+		s.del(key)
+		return
+	}
+	s.lists[key] = l
+}
+
+func (s *memStore) sumShares(list, roundKey string) {
+	for _, share := range s.lRange(list, 0, -1) {
+		i := strings.Index(share, ":")
+		diff, _ := strconv.ParseInt(share[:i], 10, 64)
+		s.hIncrBy(roundKey, share[i+1:], diff)
+	}
+}
+
+func (s *memStore) sAdd(key, member string) bool {
+	s.evict(key)
+	st, ok := s.sets[key]
+	if !ok {
+		st = make(map[string]struct{})
+		s.sets[key] = st
+	}
+	if _, ok := st[member]; ok {
+		return false
+	}
+	st[member] = struct{}{}
+	return true
+}
+
+func (s *memStore) sRem(key, member string) bool {
+	s.evict(key)
+	st := s.sets[key]
+	if _, ok := st[member]; !ok {
+		return false
+	}
+	delete(st, member)
+	if len(st) == 0 {
+		s.del(key)
+	}
+	return true
+}
+
+func (s *memStore) sMembers(key string) []string {
+	s.evict(key)
+	result := []string{}
+	for member := range s.sets[key] {
+		result = append(result, member)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (s *memStore) zset(key string, create bool) map[string]float64 {
+	s.evict(key)
+	z, ok := s.zsets[key]
+	if !ok && create {
+		z = make(map[string]float64)
+		s.zsets[key] = z
+	}
+	return z
+}
+
+// zAdd reports whether member is new.
+func (s *memStore) zAdd(key string, score float64, member string) bool {
+	z := s.zset(key, true)
+	_, ok := z[member]
+	z[member] = score
+	return !ok
+}
+
+func (s *memStore) zIncrBy(key string, n float64, member string) {
+	s.zset(key, true)[member] += n
+}
+
+func (s *memStore) zRem(key, member string) {
+	z := s.zset(key, false)
+	delete(z, member)
+	if len(z) == 0 {
+		s.del(key)
+	}
+}
+
+func (s *memStore) zCard(key string) int64 {
+	return int64(len(s.zset(key, false)))
+}
+
+// zSorted returns members ordered by score then member, like Redis.
+func (s *memStore) zSorted(key string, reverse bool) []redis.Z {
+	z := s.zset(key, false)
+	result := make([]redis.Z, 0, len(z))
+	for member, score := range z {
+		result = append(result, redis.Z{Score: score, Member: member})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if reverse {
+			a, b = b, a
+		}
+		if a.Score != b.Score {
+			return a.Score < b.Score
+		}
+		return a.Member.(string) < b.Member.(string)
+	})
+	return result
+}
+
+// zRange returns the members ranked start to stop, highest first if reverse.
+func (s *memStore) zRange(key string, start, stop int64, reverse bool) []redis.Z {
+	sorted := s.zSorted(key, reverse)
+	start, stop = listRange(int64(len(sorted)), start, stop)
+	if start > stop {
+		return []redis.Z{}
+	}
+	return sorted[start : stop+1]
+}
+
+// scoreBounds is a Redis score range, "(" marking exclusive bounds.
+type scoreBounds struct {
+	min, max         float64
+	minOpen, maxOpen bool
+}
+
+func parseScoreBounds(min, max string) (*scoreBounds, error) {
+	b := &scoreBounds{}
+	var err error
+	if b.min, b.minOpen, err = parseScore(min); err != nil {
+		return nil, err
+	}
+	if b.max, b.maxOpen, err = parseScore(max); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// parseScore accepts "-inf", "+inf" and numbers optionally prefixed with "(".
+func parseScore(s string) (float64, bool, error) {
+	open := strings.HasPrefix(s, "(")
+	v, err := strconv.ParseFloat(strings.TrimPrefix(s, "("), 64)
+	return v, open, err
+}
+
+func (b *scoreBounds) contains(score float64) bool {
+	if score < b.min || (b.minOpen && score == b.min) {
+		return false
+	}
+	return score < b.max || (!b.maxOpen && score == b.max)
+}
+
+func (s *memStore) zRangeByScore(key string, opt *redis.ZRangeBy, ascending bool) ([]redis.Z, error) {
+	b, err := parseScoreBounds(opt.Min, opt.Max)
+	if err != nil {
+		return nil, err
+	}
+	result := []redis.Z{}
+	skip := opt.Offset
+	for _, z := range s.zSorted(key, !ascending) {
+		if !b.contains(z.Score) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		result = append(result, z)
+		if opt.Count > 0 && int64(len(result)) == opt.Count {
+			break
+		}
+	}
+	return result, nil
+}
+
+// zRemRangeByScore drops members scored within min and max and returns how
+// many were removed.
+func (s *memStore) zRemRangeByScore(key, min, max string) int64 {
+	removed, err := s.zRangeByScore(key, &redis.ZRangeBy{Min: min, Max: max}, true)
+	if err != nil {
+		return 0
+	}
+	for _, z := range removed {
+		s.zRem(key, z.Member.(string))
+	}
+	return int64(len(removed))
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+// Access lists read by the policy server
+const (
+	Blacklist = "blacklist"
+	Whitelist = "whitelist"
+)
+
+// Ban mirrors an active policy server ban so that it can be listed and
+// lifted from outside of the proxy.
+type Ban struct {
+	IP        string `json:"ip"`
+	Reason    string `json:"reason"`
+	BannedAt  int64  `json:"bannedAt"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// AuditEntry records a single change made through the admin API.
+type AuditEntry struct {
+	Timestamp  int64  `json:"timestamp"`
+	Actor      string `json:"actor"`
+	RemoteAddr string `json:"remoteAddr"`
+	Action     string `json:"action"`
+	Target     string `json:"target"`
+	Entry      string `json:"entry"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// AddListEntry adds entry to the blacklist or whitelist and reports whether
+// it was not there yet.
+func (r *RedisClient) AddListEntry(list, entry string) (bool, error) {
+	defer metrics.ObserveRedis("add_list_entry", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
+
+	cmd := r.client.SAdd(ctx, r.formatKey(list), entry)
+	return cmd.Val() > 0, cmd.Err()
+}
+
+// RemoveListEntry removes entry from the blacklist or whitelist and reports
+// whether it was there.
+func (r *RedisClient) RemoveListEntry(list, entry string) (bool, error) {
+	defer metrics.ObserveRedis("remove_list_entry", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
+
+	cmd := r.client.SRem(ctx, r.formatKey(list), entry)
+	return cmd.Val() > 0, cmd.Err()
+}
+
+func (r *RedisClient) WriteBan(ban *Ban) error {
+	defer metrics.ObserveRedis("write_ban", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
+
+	data, err := json.Marshal(ban)
+	if err != nil {
+		return err
+	}
+	return r.client.HSet(ctx, r.formatKey("bans"), ban.IP, string(data)).Err()
+}
+
+// GetBans returns unexpired bans, most recent first.
+func (r *RedisClient) GetBans() ([]*Ban, error) {
+	defer metrics.ObserveRedis("get_bans", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
+
+	cmd := r.client.HGetAll(ctx, r.formatKey("bans"))
+	if cmd.Err() != nil && cmd.Err() != redis.Nil {
+		return nil, cmd.Err()
+	}
+	return convertBans(cmd.Val(), util.MakeTimestamp())
+}
+
+func convertBans(raw map[string]string, now int64) ([]*Ban, error) {
+	result := []*Ban{}
+	for ip, data := range raw {
+		ban := &Ban{}
+		if err := json.Unmarshal([]byte(data), ban); err != nil {
+			return nil, err
+		}
+		ban.IP = ip
+		if ban.ExpiresAt > 0 && ban.ExpiresAt <= now {
+			continue
+		}
+		result = append(result, ban)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].BannedAt > result[j].BannedAt })
+	return result, nil
+}
+
+// DeleteBan removes the ban of ip and reports whether there was one. The
+// policy server lifts bans missing here on its next refresh.
+func (r *RedisClient) DeleteBan(ip string) (bool, error) {
+	defer metrics.ObserveRedis("delete_ban", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
+
+	cmd := r.client.HDel(ctx, r.formatKey("bans"), ip)
+	return cmd.Val() > 0, cmd.Err()
+}
+
+// WriteAudit prepends entry to the audit log keeping at most maxLen entries.
+func (r *RedisClient) WriteAudit(entry *AuditEntry, maxLen int64) error {
+	defer metrics.ObserveRedis("write_audit", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		tx.LPush(ctx, r.formatKey("audit"), string(data))
+		if maxLen > 0 {
+			tx.LTrim(ctx, r.formatKey("audit"), 0, maxLen-1)
+		}
+		return nil
+	})
+	return err
+}
+
+// GetAuditLog returns up to limit audit entries, most recent first.
+func (r *RedisClient) GetAuditLog(limit int64) ([]*AuditEntry, error) {
+	defer metrics.ObserveRedis("get_audit_log", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
+
+	cmd := r.client.LRange(ctx, r.formatKey("audit"), 0, limit-1)
+	if cmd.Err() != nil && cmd.Err() != redis.Nil {
+		return nil, cmd.Err()
+	}
+	return convertAuditLog(cmd.Val())
+}
+
+func convertAuditLog(raw []string) ([]*AuditEntry, error) {
+	result := make([]*AuditEntry, 0, len(raw))
+	for _, data := range raw {
+		entry := &AuditEntry{}
+		if err := json.Unmarshal([]byte(data), entry); err != nil {
+			return nil, err
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
+)
+
+const (
+	// Entries fetched per round trip while scanning a page
+	pageBatch = 100
+	// Filtered scans stop after this many entries and hand out a cursor
+	maxPageScan = 10000
+)
+
+// Block lists which can be paged
+const (
+	BlocksCandidates = "candidates"
+	BlocksImmature   = "immature"
+	BlocksMatured    = "matured"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageQuery selects a page of newest first entries. From and To are
+// inclusive unix timestamps in seconds, zero means unbounded. Cursor is
+// empty for the first page and taken from the previous page afterwards.
+type PageQuery struct {
+	Cursor string
+	Limit  int64
+	From   int64
+	To     int64
+	// Oldest entries first
+	Ascending bool
+}
+
+func (q *PageQuery) inRange(ts int64) bool {
+	return (q.From == 0 || ts >= q.From) && (q.To == 0 || ts <= q.To)
+}
+
+type Credit struct {
+	Height    int64  `json:"height"`
+	Hash      string `json:"hash"`
+	Kind      string `json:"kind"`
+	Timestamp int64  `json:"timestamp"`
+	Amount    int64  `json:"amount"`
+}
+
+// Credit kinds
+const (
+	creditReward   = "reward"
+	creditPoolFee  = "pool_fee"
+	creditDonation = "donation"
+)
+
+// creditMember encodes a credit as "height:hash:amount", followed by the
+// kind unless it is a block reward.
+func creditMember(block *BlockData, kind string, amount int64) string {
+	if kind == creditReward {
+		return join(block.Height, block.Hash, amount)
+	}
+	return join(block.Height, block.Hash, amount, kind)
+}
+
+// pageCursor points past the last entry handed out: the score it had and
+// how many entries with that score were consumed already.
+type pageCursor struct {
+	score int64
+	skip  int64
+	set   bool
+}
+
+func parseCursor(s string) (*pageCursor, error) {
+	c := &pageCursor{}
+	if len(s) == 0 {
+		return c, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	var err error
+	if c.score, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.skip, err = strconv.ParseInt(parts[1], 10, 64); err != nil || c.skip < 0 {
+		return nil, ErrInvalidCursor
+	}
+	c.set = true
+	return c, nil
+}
+
+func (c *pageCursor) String() string {
+	return fmt.Sprintf("%d:%d", c.score, c.skip)
+}
+
+func (c *pageCursor) advance(score int64) {
+	if c.set && c.score == score {
+		c.skip++
+		return
+	}
+	c.score, c.skip, c.set = score, 1, true
+}
+
+// pageSource reads sorted sets for paging, implemented by every backend.
+type pageSource interface {
+	formatKey(args ...interface{}) string
+	zRangeByScore(key string, opt *redis.ZRangeBy, ascending bool) ([]redis.Z, error)
+}
+
+func (r *RedisClient) zRangeByScore(key string, opt *redis.ZRangeBy, ascending bool) ([]redis.Z, error) {
+	ctx, cancel := r.call()
+	defer cancel()
+
+	if ascending {
+		return r.client.ZRangeByScoreWithScores(ctx, key, opt).Result()
+	}
+	return r.client.ZRevRangeByScoreWithScores(ctx, key, opt).Result()
+}
+
+// scanPage walks key from the highest score down, or up when ascending,
+// starting at the bound or the cursor, and collects up to q.Limit entries
+// accepted by filter. The returned cursor is empty once the set is exhausted.
+func scanPage(src pageSource, key, min, max string, q *PageQuery, filter func(redis.Z) bool) ([]redis.Z, string, error) {
+	cursor, err := parseCursor(q.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	var result []redis.Z
+	for scanned := 0; scanned < maxPageScan; {
+		opt := &redis.ZRangeBy{Min: min, Max: max, Count: pageBatch}
+		if cursor.set {
+			if q.Ascending {
+				opt.Min = strconv.FormatInt(cursor.score, 10)
+			} else {
+				opt.Max = strconv.FormatInt(cursor.score, 10)
+			}
+			opt.Offset = cursor.skip
+		}
+		batch, err := src.zRangeByScore(key, opt, q.Ascending)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, z := range batch {
+			cursor.advance(int64(z.Score))
+			scanned++
+			if filter == nil || filter(z) {
+				result = append(result, z)
+				if int64(len(result)) == q.Limit {
+					return result, cursor.String(), nil
+				}
+			}
+		}
+		if len(batch) < pageBatch {
+			return result, "", nil
+		}
+	}
+	return result, cursor.String(), nil
+}
+
+func scoreBound(ts int64, open string) string {
+	if ts == 0 {
+		return open
+	}
+	return strconv.FormatInt(ts, 10)
+}
+
+// GetBlocksPage pages through candidates, immature or matured blocks.
+// Blocks are scored by height so time filters are applied per entry.
+func (r *RedisClient) GetBlocksPage(status string, q *PageQuery) ([]*BlockData, string, error) {
+	defer metrics.ObserveRedis("get_blocks_page", time.Now())
+	return getBlocksPage(r, status, q)
+}
+
+func getBlocksPage(src pageSource, status string, q *PageQuery) ([]*BlockData, string, error) {
+	if status != BlocksCandidates && status != BlocksImmature && status != BlocksMatured {
+		return nil, "", fmt.Errorf("unknown block status %v", status)
+	}
+	var blocks []*BlockData
+	filter := func(z redis.Z) bool {
+		var block *BlockData
+		if status == BlocksCandidates {
+			block = convertCandidate(z)
+		} else {
+			block = convertBlock(z)
+		}
+		if !q.inRange(block.Timestamp) {
+			return false
+		}
+		blocks = append(blocks, block)
+		return true
+	}
+	_, cursor, err := scanPage(src, src.formatKey("blocks", status), "-inf", "+inf", q, filter)
+	if err != nil {
+		return nil, "", err
+	}
+	return blocks, cursor, nil
+}
+
+// GetPaymentsPage pages through payments of login or of the whole pool
+// when login is empty.
+func (r *RedisClient) GetPaymentsPage(login string, q *PageQuery) ([]*Payment, string, error) {
+	defer metrics.ObserveRedis("get_payments_page", time.Now())
+	return getPaymentsPage(r, login, q)
+}
+
+func getPaymentsPage(src pageSource, login string, q *PageQuery) ([]*Payment, string, error) {
+	key := src.formatKey("payments", "all")
+	if len(login) > 0 {
+		key = src.formatKey("payments", login)
+	}
+	entries, cursor, err := scanPage(src, key, scoreBound(q.From, "-inf"), scoreBound(q.To, "+inf"), q, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	payments := make([]*Payment, 0, len(entries))
+	for _, z := range entries {
+		payments = append(payments, convertPayment(z))
+	}
+	return payments, cursor, nil
+}
+
+// GetCreditsPage pages through matured block rewards and fees credited to
+// login.
+func (r *RedisClient) GetCreditsPage(login string, q *PageQuery) ([]*Credit, string, error) {
+	defer metrics.ObserveRedis("get_credits_page", time.Now())
+	return getCreditsPage(r, login, q)
+}
+
+func getCreditsPage(src pageSource, login string, q *PageQuery) ([]*Credit, string, error) {
+	key := src.formatKey("credits", login)
+	entries, cursor, err := scanPage(src, key, scoreBound(q.From, "-inf"), scoreBound(q.To, "+inf"), q, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	credits := make([]*Credit, 0, len(entries))
+	for _, z := range entries {
+		// "height:hash:amount[:kind]"
+		fields := strings.Split(z.Member.(string), ":")
+		credit := &Credit{Kind: creditReward, Timestamp: int64(z.Score)}
+		credit.Height, _ = strconv.ParseInt(fields[0], 10, 64)
+		credit.Hash = fields[1]
+		credit.Amount, _ = strconv.ParseInt(fields[2], 10, 64)
+		if len(fields) > 3 {
+			credit.Kind = fields[3]
+		}
+		credits = append(credits, credit)
+	}
+	return credits, cursor, nil
+}
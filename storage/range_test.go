@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+func TestPaymentsPage(t *testing.T) {
+	reset()
+
+	// Two payments share every timestamp to exercise the cursor skip
+	for i := 0; i < 10; i++ {
+		ts := float64(1000 + i/2)
+		r.client.ZAdd(ctx, r.formatKey("payments", "all"), redis.Z{Score: ts, Member: join(fmt.Sprintf("0x%d", i), "0xa", int64(100))})
+	}
+
+	var seen []string
+	query := &PageQuery{Limit: 3}
+	for pages := 0; ; pages++ {
+		payments, cursor, err := r.GetPaymentsPage("", query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range payments {
+			seen = append(seen, p.Tx)
+		}
+		if len(cursor) == 0 {
+			break
+		}
+		if pages > 5 {
+			t.Fatal("Must exhaust payments")
+		}
+		query.Cursor = cursor
+	}
+	if len(seen) != 10 {
+		t.Errorf("Must page through every payment once: %v", seen)
+	}
+	unique := make(map[string]struct{})
+	for _, tx := range seen {
+		unique[tx] = struct{}{}
+	}
+	if len(unique) != 10 {
+		t.Errorf("Must not repeat payments: %v", seen)
+	}
+
+	payments, _, _ := r.GetPaymentsPage("", &PageQuery{Limit: 10, From: 1001, To: 1002})
+	if len(payments) != 4 || payments[0].Timestamp != 1002 || payments[3].Timestamp != 1001 {
+		t.Errorf("Must filter payments by time: %v", payments)
+	}
+
+	if _, _, err := r.GetPaymentsPage("", &PageQuery{Limit: 10, Cursor: "x"}); err != ErrInvalidCursor {
+		t.Error("Must reject malformed cursor")
+	}
+}
+
+func TestBlocksPage(t *testing.T) {
+	reset()
+
+	for i := int64(1); i <= 5; i++ {
+		member := join(int64(0), false, "0x1", fmt.Sprintf("0x%d", i), 1000*i, int64(10), int64(10), int64(100))
+		r.client.ZAdd(ctx, r.formatKey("blocks", BlocksMatured), redis.Z{Score: float64(i), Member: member})
+	}
+
+	blocks, cursor, err := r.GetBlocksPage(BlocksMatured, &PageQuery{Limit: 2, From: 2000, To: 4000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Height != 4 || blocks[1].Height != 3 {
+		t.Errorf("Must return newest blocks in range: %+v", blocks)
+	}
+	blocks, cursor, _ = r.GetBlocksPage(BlocksMatured, &PageQuery{Limit: 2, From: 2000, To: 4000, Cursor: cursor})
+	if len(blocks) != 1 || blocks[0].Height != 2 || len(cursor) > 0 {
+		t.Errorf("Must continue from cursor: %+v %v", blocks, cursor)
+	}
+}
+
+func TestCreditsPage(t *testing.T) {
+	reset()
+
+	for _, height := range []int64{1008, 1009} {
+		block := &BlockData{Height: height, RoundHeight: height, Hash: "0x0", Nonce: "0x1", Reward: util.String2Big("2000000000000000000")}
+		r.WriteImmatureBlock(block, map[string]int64{"x": 1980000000})
+		r.WriteMaturedBlock(block, map[string]int64{"x": 1980000000}, &RoundFees{Pool: map[string]int64{"fee": 20000000}})
+	}
+
+	credits, _, err := r.GetCreditsPage("x", &PageQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(credits) != 2 || credits[0].Amount != 1980000000 || credits[0].Hash != "0x0" {
+		t.Errorf("Must log miner credits: %+v", credits)
+	}
+	credits, _, _ = r.GetCreditsPage("fee", &PageQuery{Limit: 10})
+	if len(credits) != 2 || credits[0].Amount != 20000000 || credits[0].Kind != creditPoolFee {
+		t.Errorf("Must log fee credits: %+v", credits)
+	}
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"math/big"
@@ -9,22 +10,34 @@ import (
 	"strings"
 	"time"
 
-	"gopkg.in/redis.v3"
+	"github.com/redis/go-redis/v9"
 
+	"github.com/dominant-strategies/go-quai-stratum/metrics"
 	"github.com/dominant-strategies/go-quai-stratum/util"
 )
 
 type Config struct {
 	Enabled  bool   `json:"enabled"`
 	Endpoint string `json:"endpoint"`
-	Password string `json:"password"`
-	Database int64  `json:"database"`
-	PoolSize int    `json:"poolSize"`
+	// Sentinels, or cluster nodes when cluster is set. Used instead of
+	// endpoint if not empty.
+	Endpoints []string `json:"endpoints"`
+	// Name of the master monitored by the sentinels
+	MasterName string `json:"masterName"`
+	Cluster    bool   `json:"cluster"`
+	Password   string `json:"password"`
+	Database   int64  `json:"database"`
+	PoolSize   int    `json:"poolSize"`
+	// Deadline of a single storage call, none if empty
+	Timeout string `json:"timeout"`
 }
 
 type RedisClient struct {
-	client *redis.Client
-	prefix string
+	client  redis.UniversalClient
+	prefix  string
+	scheme  RewardScheme
+	ctx     context.Context
+	timeout time.Duration
 }
 
 type PoolCharts struct {
@@ -41,6 +54,13 @@ type MinerCharts struct {
 	WorkerOnline   string `json:"workerOnline"`
 }
 
+type WorkerCharts struct {
+	Timestamp       int64  `json:"x"`
+	TimeFormat      string `json:"timeFormat"`
+	WorkerHash      int64  `json:"workerHash"`
+	WorkerLargeHash int64  `json:"workerLargeHash"`
+}
+
 type PaymentCharts struct {
 	Timestamp  int64  `json:"x"`
 	TimeFormat string `json:"timeFormat"`
@@ -65,6 +85,7 @@ type BlockData struct {
 	UncleHeight    int64    `json:"uncleHeight"`
 	Orphan         bool     `json:"orphan"`
 	Hash           string   `json:"hash"`
+	Order          int      `json:"order"`
 	Nonce          string   `json:"-"`
 	PowHash        string   `json:"-"`
 	Reward         *big.Int `json:"-"`
@@ -119,30 +140,125 @@ type Finder struct {
 }
 
 func NewRedisClient(cfg *Config, prefix string) *RedisClient {
-	client := redis.NewClient(&redis.Options{
-		Addr:     cfg.Endpoint,
-		Password: cfg.Password,
-		DB:       cfg.Database,
-		PoolSize: cfg.PoolSize,
-	})
-	return &RedisClient{client: client, prefix: prefix}
+	addrs := cfg.Endpoints
+	if len(addrs) == 0 {
+		addrs = []string{cfg.Endpoint}
+	}
+	var client redis.UniversalClient
+	switch {
+	case len(cfg.MasterName) > 0:
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    cfg.MasterName,
+			SentinelAddrs: addrs,
+			Password:      cfg.Password,
+			DB:            int(cfg.Database),
+			PoolSize:      cfg.PoolSize,
+		})
+	case cfg.Cluster:
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    addrs,
+			Password: cfg.Password,
+			PoolSize: cfg.PoolSize,
+		})
+		// Transactions and scripts span many keys, which a cluster only
+		// allows within a single hash slot.
+		prefix = "{" + prefix + "}"
+	default:
+		client = redis.NewClient(&redis.Options{
+			Addr:     addrs[0],
+			Password: cfg.Password,
+			DB:       int(cfg.Database),
+			PoolSize: cfg.PoolSize,
+		})
+	}
+	r := &RedisClient{client: client, prefix: prefix, scheme: propScheme{}, ctx: context.Background()}
+	if len(cfg.Timeout) > 0 {
+		r.timeout = util.MustParseDuration(cfg.Timeout)
+	}
+	return r
+}
+
+func (r *RedisClient) SetRewardScheme(scheme RewardScheme) {
+	r.scheme = scheme
+}
+
+func (r *RedisClient) RewardScheme() RewardScheme {
+	return r.scheme
 }
 
-func (r *RedisClient) Client() *redis.Client {
+func (r *RedisClient) Client() redis.UniversalClient {
 	return r.client
 }
 
+// WithContext returns a copy of the client whose calls are cancelled along
+// with ctx.
+func (r *RedisClient) WithContext(ctx context.Context) Backend {
+	return r.withContext(ctx)
+}
+
+func (r *RedisClient) withContext(ctx context.Context) *RedisClient {
+	c := *r
+	c.ctx = ctx
+	return &c
+}
+
+// call returns the context of a single storage call, bounded by the
+// configured timeout.
+func (r *RedisClient) call() (context.Context, context.CancelFunc) {
+	if r.timeout > 0 {
+		return context.WithTimeout(r.ctx, r.timeout)
+	}
+	return context.WithCancel(r.ctx)
+}
+
+func (r *RedisClient) Close() error {
+	return r.client.Close()
+}
+
+// scanKeys returns the keys matching pattern. Cluster keys share the hash
+// slot of the prefix, so only the master owning it is scanned.
+func (r *RedisClient) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	var node redis.Cmdable = r.client
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		master, err := cluster.MasterForKey(ctx, r.prefix)
+		if err != nil {
+			return nil, err
+		}
+		node = master
+	}
+	var result []string
+	var c uint64
+	for {
+		keys, next, err := node.Scan(ctx, c, pattern, 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, keys...)
+		if c = next; c == 0 {
+			return result, nil
+		}
+	}
+}
+
 func (r *RedisClient) Check() (string, error) {
-	return r.client.Ping().Result()
+	ctx, cancel := r.call()
+	defer cancel()
+	return r.client.Ping(ctx).Result()
 }
 
 func (r *RedisClient) BgSave() (string, error) {
-	return r.client.BgSave().Result()
+	ctx, cancel := r.call()
+	defer cancel()
+	return r.client.BgSave(ctx).Result()
 }
 
 // Always returns list of addresses. If Redis fails it will return empty list.
 func (r *RedisClient) GetBlacklist() ([]string, error) {
-	cmd := r.client.SMembers(r.formatKey("blacklist"))
+	defer metrics.ObserveRedis("get_blacklist", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
+
+	cmd := r.client.SMembers(ctx, r.formatKey(Blacklist))
 	if cmd.Err() != nil {
 		return []string{}, cmd.Err()
 	}
@@ -151,7 +267,11 @@ func (r *RedisClient) GetBlacklist() ([]string, error) {
 
 // Always returns list of IPs. If Redis fails it will return empty list.
 func (r *RedisClient) GetWhitelist() ([]string, error) {
-	cmd := r.client.SMembers(r.formatKey("whitelist"))
+	defer metrics.ObserveRedis("get_whitelist", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
+
+	cmd := r.client.SMembers(ctx, r.formatKey(Whitelist))
 	if cmd.Err() != nil {
 		return []string{}, cmd.Err()
 	}
@@ -159,41 +279,53 @@ func (r *RedisClient) GetWhitelist() ([]string, error) {
 }
 
 func (r *RedisClient) WritePoolCharts(time1 int64, time2 string, poolHash string) error {
+	ctx, cancel := r.call()
+	defer cancel()
+
 	s := join(time1, time2, poolHash)
-	cmd := r.client.ZAdd(r.formatKey("charts", "pool"), redis.Z{Score: float64(time1), Member: s})
+	cmd := r.client.ZAdd(ctx, r.formatKey("charts", "pool"), redis.Z{Score: float64(time1), Member: s})
 	return cmd.Err()
 }
 
 func (r *RedisClient) WriteMinerCharts(time1 int64, time2, k string, hash, largeHash, workerOnline int64) error {
+	ctx, cancel := r.call()
+	defer cancel()
+
 	s := join(time1, time2, hash, largeHash, workerOnline)
-	cmd := r.client.ZAdd(r.formatKey("charts", "miner", k), redis.Z{Score: float64(time1), Member: s})
+	cmd := r.client.ZAdd(ctx, r.formatKey("charts", "miner", k), redis.Z{Score: float64(time1), Member: s})
 	return cmd.Err()
 }
 
-func (r *RedisClient) GetPoolCharts(poolHashLen int64) (stats []*PoolCharts, err error) {
+func (r *RedisClient) WriteWorkerCharts(time1 int64, time2, login, worker string, hash, largeHash int64) error {
+	ctx, cancel := r.call()
+	defer cancel()
+
+	s := join(time1, time2, hash, largeHash)
+	cmd := r.client.ZAdd(ctx, r.formatKey("charts", "worker", login, worker), redis.Z{Score: float64(time1), Member: s})
+	return cmd.Err()
+}
 
-	tx := r.client.Multi()
-	defer tx.Close()
+func (r *RedisClient) GetPoolCharts(poolHashLen int64) (stats []*PoolCharts, err error) {
+	ctx, cancel := r.call()
+	defer cancel()
 
+	key := r.formatKey("charts", "pool")
 	now := util.MakeTimestamp() / 1000
-
-	cmds, err := tx.Exec(func() error {
-		tx.ZRemRangeByScore(r.formatKey("charts", "pool"), "-inf", fmt.Sprint("(", now-172800))
-		tx.ZRevRangeWithScores(r.formatKey("charts", "pool"), 0, poolHashLen)
+	var charts *redis.ZSliceCmd
+	_, err = r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		tx.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprint("(", now-172800))
+		charts = tx.ZRevRangeWithScores(ctx, key, 0, poolHashLen)
 		return nil
 	})
-
 	if err != nil {
 		return nil, err
 	}
-
-	stats = convertPoolChartsResults(cmds[1].(*redis.ZSliceCmd))
-	return stats, nil
+	return convertPoolChartsResults(charts.Val()), nil
 }
 
-func convertPoolChartsResults(raw *redis.ZSliceCmd) []*PoolCharts {
+func convertPoolChartsResults(raw []redis.Z) []*PoolCharts {
 	var result []*PoolCharts
-	for _, v := range raw.Val() {
+	for _, v := range raw {
 		// "Timestamp:TimeFormat:Hash"
 		pc := PoolCharts{}
 		pc.Timestamp = int64(v.Score)
@@ -209,9 +341,9 @@ func convertPoolChartsResults(raw *redis.ZSliceCmd) []*PoolCharts {
 	return reverse
 }
 
-func convertMinerChartsResults(raw *redis.ZSliceCmd) []*MinerCharts {
+func convertMinerChartsResults(raw []redis.Z) []*MinerCharts {
 	var result []*MinerCharts
-	for _, v := range raw.Val() {
+	for _, v := range raw {
 		// "Timestamp:TimeFormat:Hash:largeHash:workerOnline"
 		mc := MinerCharts{}
 		mc.Timestamp = int64(v.Score)
@@ -229,182 +361,221 @@ func convertMinerChartsResults(raw *redis.ZSliceCmd) []*MinerCharts {
 	return reverse
 }
 
+func convertWorkerChartsResults(raw []redis.Z) []*WorkerCharts {
+	var result []*WorkerCharts
+	for _, v := range raw {
+		// "Timestamp:TimeFormat:Hash:largeHash"
+		wc := WorkerCharts{}
+		wc.Timestamp = int64(v.Score)
+		parts := strings.Split(v.Member.(string), ":")
+		wc.TimeFormat = parts[1]
+		wc.WorkerHash, _ = strconv.ParseInt(parts[2], 10, 64)
+		wc.WorkerLargeHash, _ = strconv.ParseInt(parts[3], 10, 64)
+		result = append(result, &wc)
+	}
+	var reverse []*WorkerCharts
+	for i := len(result) - 1; i >= 0; i-- {
+		reverse = append(reverse, result[i])
+	}
+	return reverse
+}
+
 func (r *RedisClient) GetAllMinerAccount() (account []string, err error) {
-	var c int64
-	for {
-		now := util.MakeTimestamp() / 1000
-		c, keys, err := r.client.Scan(c, r.formatKey("miners", "*"), now).Result()
+	ctx, cancel := r.call()
+	defer cancel()
 
-		if err != nil {
-			return account, err
-		}
-		for _, key := range keys {
-			m := strings.Split(key, ":")
-			//if ( len(m) >= 2 && strings.Index(strings.ToLower(m[2]), "0x") == 0) {
-			if len(m) >= 2 {
-				account = append(account, m[2])
-			}
-		}
-		if c == 0 {
-			break
+	keys, err := r.scanKeys(ctx, r.formatKey("miners", "*"))
+	if err != nil {
+		return account, err
+	}
+	for _, key := range keys {
+		m := strings.Split(key, ":")
+		//if ( len(m) >= 2 && strings.Index(strings.ToLower(m[2]), "0x") == 0) {
+		if len(m) >= 2 {
+			account = append(account, m[2])
 		}
 	}
 	return account, nil
 }
 
 func (r *RedisClient) GetMinerCharts(hashNum int64, login string) (stats []*MinerCharts, err error) {
+	ctx, cancel := r.call()
+	defer cancel()
 
-	tx := r.client.Multi()
-	defer tx.Close()
+	key := r.formatKey("charts", "miner", login)
 	now := util.MakeTimestamp() / 1000
-	cmds, err := tx.Exec(func() error {
-		tx.ZRemRangeByScore(r.formatKey("charts", "miner", login), "-inf", fmt.Sprint("(", now-172800))
-		tx.ZRevRangeWithScores(r.formatKey("charts", "miner", login), 0, hashNum)
+	var charts *redis.ZSliceCmd
+	_, err = r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		tx.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprint("(", now-172800))
+		charts = tx.ZRevRangeWithScores(ctx, key, 0, hashNum)
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	stats = convertMinerChartsResults(cmds[1].(*redis.ZSliceCmd))
-	return stats, nil
+	return convertMinerChartsResults(charts.Val()), nil
 }
 
-func (r *RedisClient) GetPaymentCharts(login string) (stats []*PaymentCharts, err error) {
+func (r *RedisClient) GetWorkerCharts(hashNum int64, login, worker string) (stats []*WorkerCharts, err error) {
+	ctx, cancel := r.call()
+	defer cancel()
 
-	tx := r.client.Multi()
-	defer tx.Close()
-	cmds, err := tx.Exec(func() error {
-		tx.ZRevRangeWithScores(r.formatKey("payments", login), 0, 360)
+	key := r.formatKey("charts", "worker", login, worker)
+	now := util.MakeTimestamp() / 1000
+	var charts *redis.ZSliceCmd
+	_, err = r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		tx.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprint("(", now-172800))
+		charts = tx.ZRevRangeWithScores(ctx, key, 0, hashNum)
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	stats = convertPaymentChartsResults(cmds[0].(*redis.ZSliceCmd))
-	//fmt.Println(stats)
-	return stats, nil
+	return convertWorkerChartsResults(charts.Val()), nil
+}
+
+func (r *RedisClient) GetPaymentCharts(login string) (stats []*PaymentCharts, err error) {
+	ctx, cancel := r.call()
+	defer cancel()
+
+	payments, err := r.client.ZRevRangeWithScores(ctx, r.formatKey("payments", login), 0, 360).Result()
+	if err != nil {
+		return nil, err
+	}
+	return convertPaymentChartsResults(payments), nil
 }
 
 func (r *RedisClient) WriteNodeState(id string, height uint64, diff *big.Int, blocktime float64) error {
-	tx := r.client.Multi()
-	defer tx.Close()
+	defer metrics.ObserveRedis("write_node_state", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
 
 	now := util.MakeTimestamp() / 1000
 
-	_, err := tx.Exec(func() error {
-		tx.HSet(r.formatKey("nodes"), join(id, "name"), id)
-		tx.HSet(r.formatKey("nodes"), join(id, "height"), strconv.FormatUint(height, 10))
-		tx.HSet(r.formatKey("nodes"), join(id, "difficulty"), diff.String())
-		tx.HSet(r.formatKey("nodes"), join(id, "lastBeat"), strconv.FormatInt(now, 10))
-		tx.HSet(r.formatKey("nodes"), join(id, "blocktime"), strconv.FormatFloat(blocktime, 'f', 4, 64))
-		return nil
-	})
-	return err
+	return r.client.HSet(ctx, r.formatKey("nodes"),
+		join(id, "name"), id,
+		join(id, "height"), strconv.FormatUint(height, 10),
+		join(id, "difficulty"), diff.String(),
+		join(id, "lastBeat"), strconv.FormatInt(now, 10),
+		join(id, "blocktime"), strconv.FormatFloat(blocktime, 'f', 4, 64),
+	).Err()
 }
 
-func (r *RedisClient) GetNodeStates() ([]map[string]interface{}, error) {
-	cmd := r.client.HGetAllMap(r.formatKey("nodes"))
+func (r *RedisClient) GetNodeStates() ([]*NodeState, error) {
+	ctx, cancel := r.call()
+	defer cancel()
+
+	cmd := r.client.HGetAll(ctx, r.formatKey("nodes"))
 	if cmd.Err() != nil {
 		return nil, cmd.Err()
 	}
-	m := make(map[string]map[string]interface{})
-	for key, value := range cmd.Val() {
+	return convertNodeStates(cmd.Val()), nil
+}
+
+func convertNodeStates(fields map[string]string) []*NodeState {
+	m := make(map[string]*NodeState)
+	for key, value := range fields {
 		parts := strings.Split(key, ":")
-		if val, ok := m[parts[0]]; ok {
-			val[parts[1]] = value
-		} else {
-			node := make(map[string]interface{})
-			node[parts[1]] = value
+		node, ok := m[parts[0]]
+		if !ok {
+			node = &NodeState{}
 			m[parts[0]] = node
 		}
+		switch parts[1] {
+		case "name":
+			node.Name = value
+		case "height":
+			node.Height = value
+		case "difficulty":
+			node.Difficulty = value
+		case "lastBeat":
+			node.LastBeat = value
+		case "blocktime":
+			node.Blocktime = value
+		}
 	}
-	v := make([]map[string]interface{}, len(m), len(m))
-	i := 0
-	for _, value := range m {
-		v[i] = value
-		i++
+	v := make([]*NodeState, 0, len(m))
+	for _, node := range m {
+		v = append(v, node)
 	}
-	return v, nil
+	return v
 }
 
-func (r *RedisClient) checkPoWExist(height uint64, params []string) (bool, error) {
-	r.client.ZRemRangeByScore(r.formatKey("pow"), "-inf", fmt.Sprint("(", height-8))
-
-	fmt.Println(strings.Join(params, ":"))
+func (r *RedisClient) WriteShare(login, id string, params []string, diff int64, height uint64, window time.Duration) (bool, error) {
+	return r.writeShareWith(r.scheme, login, id, params, diff, height, window)
+}
 
-	val, err := r.client.ZAdd(r.formatKey("pow"), redis.Z{Score: float64(height), Member: strings.Join(params, ":")}).Result()
-	return val == 0, err
+// WriteSoloShare records a share of a miner mining solo, outside the pool round.
+func (r *RedisClient) WriteSoloShare(login, id string, params []string, diff int64, height uint64, window time.Duration) (bool, error) {
+	return r.writeShareWith(soloScheme{}, login, id, params, diff, height, window)
 }
 
-func (r *RedisClient) WriteShare(login, id string, params []string, diff int64, height uint64, window time.Duration) (bool, error) {
-	exist, err := r.checkPoWExist(height, params)
-	if err != nil {
-		return false, err
-	}
-	if exist {
-		return true, nil
-	}
-	tx := r.client.Multi()
-	defer tx.Close()
+func (r *RedisClient) writeShareWith(scheme RewardScheme, login, id string, params []string, diff int64, height uint64, window time.Duration) (bool, error) {
+	defer metrics.ObserveRedis("write_share", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
 
 	ms := util.MakeTimestamp()
 	ts := ms / 1000
 
-	_, err = tx.Exec(func() error {
-		r.writeShare(tx, ms, ts, login, id, diff, window)
-		tx.HIncrBy(r.formatKey("stats"), "roundShares", diff)
-		return nil
-	})
-	return false, err
+	b := &shareBatch{RedisClient: r}
+	b.writeShare(scheme, ms, ts, login, id, diff, window)
+	return b.run(ctx, height, params)
 }
 
 func (r *RedisClient) WriteBlock(login, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration) (bool, error) {
-	exist, err := r.checkPoWExist(height, params)
-	if err != nil {
-		return false, err
-	}
-	if exist {
-		return true, nil
-	}
-	tx := r.client.Multi()
-	defer tx.Close()
+	return r.writeBlockWith(r.scheme, login, id, params, diff, roundDiff, height, window)
+}
+
+// WriteSoloBlock records a block found by a solo miner, the round holds only the finder.
+func (r *RedisClient) WriteSoloBlock(login, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration) (bool, error) {
+	return r.writeBlockWith(soloScheme{}, login, id, params, diff, roundDiff, height, window)
+}
+
+func (r *RedisClient) writeBlockWith(scheme RewardScheme, login, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration) (bool, error) {
+	defer metrics.ObserveRedis("write_block", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
 
 	ms := util.MakeTimestamp()
 	ts := ms / 1000
-
-	cmds, err := tx.Exec(func() error {
-		r.writeShare(tx, ms, ts, login, id, diff, window)
-		tx.HSet(r.formatKey("stats"), "lastBlockFound", strconv.FormatInt(ts, 10))
-		tx.HDel(r.formatKey("stats"), "roundShares")
-		tx.ZIncrBy(r.formatKey("finders"), 1, login)
-		tx.HIncrBy(r.formatKey("miners", login), "blocksFound", 1)
-		tx.ZAdd(r.formatKey("finders", login), redis.Z{Score: float64(ts), Member: join(height, id, ms)})
-		tx.Rename(r.formatKey("shares", "roundCurrent"), r.formatRound(int64(height), params[0]))
-		tx.HGetAllMap(r.formatRound(int64(height), params[0]))
+	roundKey := r.formatRound(int64(height), params[0])
+
+	b := &shareBatch{RedisClient: r}
+	b.writeShare(scheme, ms, ts, login, id, diff, window)
+	b.hSet(r.formatKey("stats"), "lastBlockFound", strconv.FormatInt(ts, 10))
+	b.zIncrBy(r.formatKey("finders"), 1, login)
+	b.hIncrBy(r.formatKey("miners", login), "blocksFound", 1)
+	b.zAdd(r.formatKey("finders", login), ts, join(height, id, ms))
+	scheme.closeRound(b, login, roundKey)
+	// Total shares are appended once the round is closed
+	b.candidate(height, roundKey, join(strings.Join(params, ":"), ts, roundDiff))
+	return b.run(ctx, height, params)
+}
+
+// candidateMember encodes a candidate as "nonce:hash:order:timestamp:diff:totalShares".
+func candidateMember(params []string, ts, roundDiff, totalShares int64) string {
+	return join(strings.Join(params, ":"), ts, roundDiff, totalShares)
+}
+
+// WriteEvents appends entries to a capped stream, MAXLEN 0 keeps all of them.
+func (r *RedisClient) WriteEvents(stream string, maxLen int64, entries []string) error {
+	defer metrics.ObserveRedis("write_events", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
+
+	_, err := r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		for _, entry := range entries {
+			tx.XAdd(ctx, &redis.XAddArgs{
+				Stream: r.formatKey(stream),
+				MaxLen: maxLen,
+				Approx: true,
+				Values: []string{"event", entry},
+			})
+		}
 		return nil
 	})
-	if err != nil {
-		return false, err
-	} else {
-		sharesMap, _ := cmds[11].(*redis.StringStringMapCmd).Result()
-		totalShares := int64(0)
-		for _, v := range sharesMap {
-			n, _ := strconv.ParseInt(v, 10, 64)
-			totalShares += n
-		}
-		hashHex := strings.Join(params, ":")
-		s := join(hashHex, ts, roundDiff, totalShares)
-		cmd := r.client.ZAdd(r.formatKey("blocks", "candidates"), redis.Z{Score: float64(height), Member: s})
-		return false, cmd.Err()
-	}
-}
-
-func (r *RedisClient) writeShare(tx *redis.Multi, ms, ts int64, login, id string, diff int64, expire time.Duration) {
-	tx.HIncrBy(r.formatKey("shares", "roundCurrent"), login, diff)
-	tx.ZAdd(r.formatKey("hashrate"), redis.Z{Score: float64(ts), Member: join(diff, login, id, ms)})
-	tx.ZAdd(r.formatKey("hashrate", login), redis.Z{Score: float64(ts), Member: join(diff, id, ms)})
-	tx.Expire(r.formatKey("hashrate", login), expire) // Will delete hashrates for miners that gone
-	tx.HSet(r.formatKey("miners", login), "lastShare", strconv.FormatInt(ts, 10))
+	return err
 }
 
 func (r *RedisClient) formatKey(args ...interface{}) string {
@@ -448,56 +619,62 @@ func join(args ...interface{}) string {
 }
 
 func (r *RedisClient) GetCandidates(maxHeight int64) ([]*BlockData, error) {
-	option := redis.ZRangeByScore{Min: "0", Max: strconv.FormatInt(maxHeight, 10)}
-	cmd := r.client.ZRangeByScoreWithScores(r.formatKey("blocks", "candidates"), option)
+	ctx, cancel := r.call()
+	defer cancel()
+
+	option := &redis.ZRangeBy{Min: "0", Max: strconv.FormatInt(maxHeight, 10)}
+	cmd := r.client.ZRangeByScoreWithScores(ctx, r.formatKey("blocks", "candidates"), option)
 	if cmd.Err() != nil {
 		return nil, cmd.Err()
 	}
-	return convertCandidateResults(cmd), nil
+	return convertCandidateResults(cmd.Val()), nil
 }
 
 func (r *RedisClient) GetImmatureBlocks(maxHeight int64) ([]*BlockData, error) {
-	option := redis.ZRangeByScore{Min: "0", Max: strconv.FormatInt(maxHeight, 10)}
-	cmd := r.client.ZRangeByScoreWithScores(r.formatKey("blocks", "immature"), option)
+	ctx, cancel := r.call()
+	defer cancel()
+
+	option := &redis.ZRangeBy{Min: "0", Max: strconv.FormatInt(maxHeight, 10)}
+	cmd := r.client.ZRangeByScoreWithScores(ctx, r.formatKey("blocks", "immature"), option)
 	if cmd.Err() != nil {
 		return nil, cmd.Err()
 	}
-	return convertBlockResults(cmd), nil
+	return convertBlockResults(cmd.Val()), nil
 }
 
 func (r *RedisClient) GetRoundShares(height int64, nonce string) (map[string]int64, error) {
-	result := make(map[string]int64)
-	cmd := r.client.HGetAllMap(r.formatRound(height, nonce))
+	ctx, cancel := r.call()
+	defer cancel()
+
+	cmd := r.client.HGetAll(ctx, r.formatRound(height, nonce))
 	if cmd.Err() != nil {
 		return nil, cmd.Err()
 	}
-	sharesMap, _ := cmd.Result()
+	return convertRoundShares(cmd.Val()), nil
+}
+
+func convertRoundShares(sharesMap map[string]string) map[string]int64 {
+	result := make(map[string]int64)
 	for login, v := range sharesMap {
 		n, _ := strconv.ParseInt(v, 10, 64)
 		result[login] = n
 	}
-	return result, nil
+	return result
 }
 
 func (r *RedisClient) GetPayees() ([]string, error) {
+	ctx, cancel := r.call()
+	defer cancel()
+
+	keys, err := r.scanKeys(ctx, r.formatKey("miners", "*"))
+	if err != nil {
+		return nil, err
+	}
 	payees := make(map[string]struct{})
 	var result []string
-	var c int64
-
-	for {
-		var keys []string
-		var err error
-		c, keys, err = r.client.Scan(c, r.formatKey("miners", "*"), 100).Result()
-		if err != nil {
-			return nil, err
-		}
-		for _, row := range keys {
-			login := strings.Split(row, ":")[2]
-			payees[login] = struct{}{}
-		}
-		if c == 0 {
-			break
-		}
+	for _, row := range keys {
+		login := strings.Split(row, ":")[2]
+		payees[login] = struct{}{}
 	}
 	for login := range payees {
 		result = append(result, login)
@@ -506,7 +683,10 @@ func (r *RedisClient) GetPayees() ([]string, error) {
 }
 
 func (r *RedisClient) GetBalance(login string) (int64, error) {
-	cmd := r.client.HGet(r.formatKey("miners", login), "balance")
+	ctx, cancel := r.call()
+	defer cancel()
+
+	cmd := r.client.HGet(ctx, r.formatKey("miners", login), "balance")
 	if cmd.Err() == redis.Nil {
 		return 0, nil
 	} else if cmd.Err() != nil {
@@ -522,9 +702,16 @@ type PendingPayment struct {
 }
 
 func (r *RedisClient) GetPendingPayments() []*PendingPayment {
-	raw := r.client.ZRevRangeWithScores(r.formatKey("payments", "pending"), 0, -1)
+	ctx, cancel := r.call()
+	defer cancel()
+
+	raw := r.client.ZRevRangeWithScores(ctx, r.formatKey("payments", "pending"), 0, -1)
+	return convertPendingPayments(raw.Val())
+}
+
+func convertPendingPayments(raw []redis.Z) []*PendingPayment {
 	var result []*PendingPayment
-	for _, v := range raw.Val() {
+	for _, v := range raw {
 		// timestamp -> "address:amount"
 		payment := PendingPayment{}
 		payment.Timestamp = int64(v.Score)
@@ -536,341 +723,488 @@ func (r *RedisClient) GetPendingPayments() []*PendingPayment {
 	return result
 }
 
+// PayoutsLock is held by a payer while it pays a single miner, so that only
+// one payer runs. Owner identifies the payer and ExpiresAt, in unix seconds,
+// tells when it is presumed dead if it still holds the lock.
+type PayoutsLock struct {
+	Owner     string
+	Login     string
+	Amount    int64
+	TxHash    string
+	ExpiresAt int64
+}
+
+func (l *PayoutsLock) Expired(now int64) bool {
+	return now >= l.ExpiresAt
+}
+
+// ttl lets Redis drop the lock of a crashed payer once it expires. Locks
+// without an expiry are kept until released.
+func (l *PayoutsLock) ttl(now int64) time.Duration {
+	if l.ExpiresAt <= 0 {
+		return 0
+	}
+	if l.ExpiresAt <= now {
+		return time.Second
+	}
+	return time.Duration(l.ExpiresAt-now) * time.Second
+}
+
+func (l *PayoutsLock) value() string {
+	return join(l.Login, l.Amount, l.TxHash, l.Owner, l.ExpiresAt)
+}
+
+// parsePayoutsLock splits a "login:amount:txHash:owner:expiresAt" lock
+// value. Locks written before owners were recorded hold "login:amount[:txHash]"
+// and are taken as expired.
+func parsePayoutsLock(val string) *PayoutsLock {
+	fields := strings.Split(val, ":")
+	lock := &PayoutsLock{Login: fields[0]}
+	if len(fields) > 1 {
+		lock.Amount, _ = strconv.ParseInt(fields[1], 10, 64)
+	}
+	if len(fields) > 2 {
+		lock.TxHash = fields[2]
+	}
+	if len(fields) > 4 {
+		lock.Owner = fields[3]
+		lock.ExpiresAt, _ = strconv.ParseInt(fields[4], 10, 64)
+	}
+	return lock
+}
+
+func (r *RedisClient) LockPayouts(lock *PayoutsLock) error {
+	ctx, cancel := r.call()
+	defer cancel()
+
+	key := r.formatKey("payments", "lock")
+	result, err := r.client.SetNX(ctx, key, lock.value(), lock.ttl(util.MakeTimestamp()/1000)).Result()
+	if err != nil {
+		return err
+	}
+	if !result {
+		return fmt.Errorf("unable to acquire lock '%s'", key)
+	}
+	return nil
+}
+
+// Record the hash of a sent payout tx in the lock, so a crashed payer can
+// finish the payment instead of rolling it back. The lock no longer expires,
+// the payment must not be taken for an unsent one.
+func (r *RedisClient) SetPayoutsLockTx(lock *PayoutsLock, txHash string) error {
+	ctx, cancel := r.call()
+	defer cancel()
+
+	sent := *lock
+	sent.TxHash = txHash
+	return r.client.Set(ctx, r.formatKey("payments", "lock"), sent.value(), 0).Err()
+}
+
+// Returns the lock of the payout in progress, nil if there is none.
+func (r *RedisClient) GetPayoutsLock() (*PayoutsLock, error) {
+	ctx, cancel := r.call()
+	defer cancel()
+
+	val, err := r.client.Get(ctx, r.formatKey("payments", "lock")).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return parsePayoutsLock(val), nil
+}
+
+func (r *RedisClient) UnlockPayouts() error {
+	ctx, cancel := r.call()
+	defer cancel()
+
+	key := r.formatKey("payments", "lock")
+	_, err := r.client.Del(ctx, key).Result()
+	return err
+}
+
+func (r *RedisClient) IsPayoutsLocked() (bool, error) {
+	ctx, cancel := r.call()
+	defer cancel()
+
+	_, err := r.client.Get(ctx, r.formatKey("payments", "lock")).Result()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	} else {
+		return true, nil
+	}
+}
+
 // Deduct miner's balance for payment
 func (r *RedisClient) UpdateBalance(login string, amount int64) error {
-	tx := r.client.Multi()
-	defer tx.Close()
+	ctx, cancel := r.call()
+	defer cancel()
 
 	ts := util.MakeTimestamp() / 1000
 
-	_, err := tx.Exec(func() error {
-		tx.HIncrBy(r.formatKey("miners", login), "balance", (amount * -1))
-		tx.HIncrBy(r.formatKey("miners", login), "pending", amount)
-		tx.HIncrBy(r.formatKey("finances"), "balance", (amount * -1))
-		tx.HIncrBy(r.formatKey("finances"), "pending", amount)
-		tx.ZAdd(r.formatKey("payments", "pending"), redis.Z{Score: float64(ts), Member: join(login, amount)})
+	_, err := r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		tx.HIncrBy(ctx, r.formatKey("miners", login), "balance", (amount * -1))
+		tx.HIncrBy(ctx, r.formatKey("miners", login), "pending", amount)
+		tx.HIncrBy(ctx, r.formatKey("finances"), "balance", (amount * -1))
+		tx.HIncrBy(ctx, r.formatKey("finances"), "pending", amount)
+		tx.ZAdd(ctx, r.formatKey("payments", "pending"), redis.Z{Score: float64(ts), Member: join(login, amount)})
 		return nil
 	})
 	return err
 }
 
 func (r *RedisClient) RollbackBalance(login string, amount int64) error {
-	tx := r.client.Multi()
-	defer tx.Close()
-
-	_, err := tx.Exec(func() error {
-		tx.HIncrBy(r.formatKey("miners", login), "balance", amount)
-		tx.HIncrBy(r.formatKey("miners", login), "pending", (amount * -1))
-		tx.HIncrBy(r.formatKey("finances"), "balance", amount)
-		tx.HIncrBy(r.formatKey("finances"), "pending", (amount * -1))
-		tx.ZRem(r.formatKey("payments", "pending"), join(login, amount))
+	ctx, cancel := r.call()
+	defer cancel()
+
+	_, err := r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		tx.HIncrBy(ctx, r.formatKey("miners", login), "balance", amount)
+		tx.HIncrBy(ctx, r.formatKey("miners", login), "pending", (amount * -1))
+		tx.HIncrBy(ctx, r.formatKey("finances"), "balance", amount)
+		tx.HIncrBy(ctx, r.formatKey("finances"), "pending", (amount * -1))
+		tx.ZRem(ctx, r.formatKey("payments", "pending"), join(login, amount))
 		return nil
 	})
 	return err
 }
 
 func (r *RedisClient) WritePayment(login, txHash string, amount int64) error {
-	tx := r.client.Multi()
-	defer tx.Close()
+	ctx, cancel := r.call()
+	defer cancel()
 
 	ts := util.MakeTimestamp() / 1000
 
-	_, err := tx.Exec(func() error {
-		tx.HIncrBy(r.formatKey("miners", login), "pending", (amount * -1))
-		tx.HIncrBy(r.formatKey("miners", login), "paid", amount)
-		tx.HIncrBy(r.formatKey("finances"), "pending", (amount * -1))
-		tx.HIncrBy(r.formatKey("finances"), "paid", amount)
-		tx.ZAdd(r.formatKey("payments", "all"), redis.Z{Score: float64(ts), Member: join(txHash, login, amount)})
-		tx.ZAdd(r.formatKey("payments", login), redis.Z{Score: float64(ts), Member: join(txHash, amount)})
-		tx.ZRem(r.formatKey("payments", "pending"), join(login, amount))
-		tx.Del(r.formatKey("payments", "lock"))
+	_, err := r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		tx.HIncrBy(ctx, r.formatKey("miners", login), "pending", (amount * -1))
+		tx.HIncrBy(ctx, r.formatKey("miners", login), "paid", amount)
+		tx.HIncrBy(ctx, r.formatKey("finances"), "pending", (amount * -1))
+		tx.HIncrBy(ctx, r.formatKey("finances"), "paid", amount)
+		tx.ZAdd(ctx, r.formatKey("payments", "all"), redis.Z{Score: float64(ts), Member: join(txHash, login, amount)})
+		tx.ZAdd(ctx, r.formatKey("payments", login), redis.Z{Score: float64(ts), Member: join(txHash, amount)})
+		tx.ZRem(ctx, r.formatKey("payments", "pending"), join(login, amount))
+		tx.Del(ctx, r.formatKey("payments", "lock"))
 		return nil
 	})
 	return err
 }
 
 func (r *RedisClient) WriteImmatureBlock(block *BlockData, roundRewards map[string]int64) error {
-	tx := r.client.Multi()
-	defer tx.Close()
+	ctx, cancel := r.call()
+	defer cancel()
 
-	_, err := tx.Exec(func() error {
-		r.writeImmatureBlock(tx, block)
+	_, err := r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		r.writeImmatureBlock(ctx, tx, block)
 		total := int64(0)
 		for login, amount := range roundRewards {
 			total += amount
-			tx.HIncrBy(r.formatKey("miners", login), "immature", amount)
-			tx.HSetNX(r.formatKey("credits", "immature", block.Height, block.Hash), login, strconv.FormatInt(amount, 10))
+			tx.HIncrBy(ctx, r.formatKey("miners", login), "immature", amount)
+			tx.HSetNX(ctx, r.formatKey("credits", "immature", block.Height, block.Hash), login, strconv.FormatInt(amount, 10))
 		}
-		tx.HIncrBy(r.formatKey("finances"), "immature", total)
+		tx.HIncrBy(ctx, r.formatKey("finances"), "immature", total)
 		return nil
 	})
 	return err
 }
 
-func (r *RedisClient) WriteMaturedBlock(block *BlockData, roundRewards map[string]int64) error {
-	creditKey := r.formatKey("credits", "immature", block.RoundHeight, block.Hash)
-	tx, err := r.client.Watch(creditKey)
-	// Must decrement immatures using existing log entry
-	immatureCredits := tx.HGetAllMap(creditKey)
-	if err != nil {
-		return err
-	}
-	defer tx.Close()
+// RoundFees holds the fees charged on a matured block, keyed by the address
+// they are credited to.
+type RoundFees struct {
+	Pool      map[string]int64
+	Donations map[string]int64
+}
 
-	ts := util.MakeTimestamp() / 1000
-	value := join(block.Hash, ts, block.Reward)
-
-	_, err = tx.Exec(func() error {
-		r.writeMaturedBlock(tx, block)
-		tx.ZAdd(r.formatKey("credits", "all"), redis.Z{Score: float64(block.Height), Member: value})
-
-		// Decrement immature balances
-		totalImmature := int64(0)
-		for login, amountString := range immatureCredits.Val() {
-			amount, _ := strconv.ParseInt(amountString, 10, 64)
-			totalImmature += amount
-			tx.HIncrBy(r.formatKey("miners", login), "immature", (amount * -1))
-		}
+func (f *RoundFees) Total() int64 {
+	return sumCredits(f.Pool) + sumCredits(f.Donations)
+}
 
-		// Increment balances
-		total := int64(0)
-		for login, amount := range roundRewards {
-			total += amount
-			// NOTICE: Maybe expire round reward entry in 604800 (a week)?
-			tx.HIncrBy(r.formatKey("miners", login), "balance", amount)
-			tx.HSetNX(r.formatKey("credits", block.Height, block.Hash), login, strconv.FormatInt(amount, 10))
-		}
-		tx.Del(creditKey)
-		tx.HIncrBy(r.formatKey("finances"), "balance", total)
-		tx.HIncrBy(r.formatKey("finances"), "immature", (totalImmature * -1))
-		tx.HSet(r.formatKey("finances"), "lastCreditHeight", strconv.FormatInt(block.Height, 10))
-		tx.HSet(r.formatKey("finances"), "lastCreditHash", block.Hash)
-		tx.HIncrBy(r.formatKey("finances"), "totalMined", block.RewardInShannon())
-		return nil
-	})
-	return err
+// writeCredit logs a matured reward or fee of login for paging through its
+// history.
+func (r *RedisClient) writeCredit(ctx context.Context, tx redis.Pipeliner, login string, block *BlockData, kind string, ts, amount int64) {
+	tx.ZAdd(ctx, r.formatKey("credits", login), redis.Z{Score: float64(ts), Member: creditMember(block, kind, amount)})
 }
 
-func (r *RedisClient) WriteOrphan(block *BlockData) error {
+func sumCredits(credits map[string]int64) int64 {
+	total := int64(0)
+	for _, amount := range credits {
+		total += amount
+	}
+	return total
+}
+
+func (r *RedisClient) WriteMaturedBlock(block *BlockData, roundRewards map[string]int64, fees *RoundFees) error {
+	ctx, cancel := r.call()
+	defer cancel()
+
 	creditKey := r.formatKey("credits", "immature", block.RoundHeight, block.Hash)
-	tx, err := r.client.Watch(creditKey)
-	// Must decrement immatures using existing log entry
-	immatureCredits := tx.HGetAllMap(creditKey)
-	if err != nil {
+	return r.client.Watch(ctx, func(tx *redis.Tx) error {
+		// Must decrement immatures using existing log entry
+		immatureCredits, err := tx.HGetAll(ctx, creditKey).Result()
+		if err != nil {
+			return err
+		}
+		ts := util.MakeTimestamp() / 1000
+		value := join(block.Hash, ts, block.Reward)
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.writeMaturedBlock(ctx, pipe, block)
+			pipe.ZAdd(ctx, r.formatKey("credits", "all"), redis.Z{Score: float64(block.Height), Member: value})
+
+			// Decrement immature balances
+			totalImmature := int64(0)
+			for login, amountString := range immatureCredits {
+				amount, _ := strconv.ParseInt(amountString, 10, 64)
+				totalImmature += amount
+				pipe.HIncrBy(ctx, r.formatKey("miners", login), "immature", (amount * -1))
+			}
+
+			// Increment balances
+			total := int64(0)
+			for login, amount := range roundRewards {
+				total += amount
+				// NOTICE: Maybe expire round reward entry in 604800 (a week)?
+				pipe.HIncrBy(ctx, r.formatKey("miners", login), "balance", amount)
+				pipe.HSetNX(ctx, r.formatKey("credits", block.Height, block.Hash), login, strconv.FormatInt(amount, 10))
+				r.writeCredit(ctx, pipe, login, block, creditReward, ts, amount)
+			}
+
+			// Credit fees to pool and donation addresses
+			if fees != nil {
+				for login, amount := range fees.Pool {
+					pipe.HIncrBy(ctx, r.formatKey("miners", login), "balance", amount)
+					r.writeCredit(ctx, pipe, login, block, creditPoolFee, ts, amount)
+				}
+				for login, amount := range fees.Donations {
+					pipe.HIncrBy(ctx, r.formatKey("miners", login), "balance", amount)
+					r.writeCredit(ctx, pipe, login, block, creditDonation, ts, amount)
+				}
+				total += fees.Total()
+				pipe.HIncrBy(ctx, r.formatKey("finances"), "poolFee", sumCredits(fees.Pool))
+				pipe.HIncrBy(ctx, r.formatKey("finances"), "donations", sumCredits(fees.Donations))
+			}
+			pipe.Del(ctx, creditKey)
+			pipe.HIncrBy(ctx, r.formatKey("finances"), "balance", total)
+			pipe.HIncrBy(ctx, r.formatKey("finances"), "immature", (totalImmature * -1))
+			pipe.HSet(ctx, r.formatKey("finances"), "lastCreditHeight", strconv.FormatInt(block.Height, 10))
+			pipe.HSet(ctx, r.formatKey("finances"), "lastCreditHash", block.Hash)
+			pipe.HIncrBy(ctx, r.formatKey("finances"), "totalMined", block.RewardInShannon())
+			return nil
+		})
 		return err
-	}
-	defer tx.Close()
+	}, creditKey)
+}
 
-	_, err = tx.Exec(func() error {
-		r.writeMaturedBlock(tx, block)
+func (r *RedisClient) WriteOrphan(block *BlockData) error {
+	ctx, cancel := r.call()
+	defer cancel()
 
-		// Decrement immature balances
-		totalImmature := int64(0)
-		for login, amountString := range immatureCredits.Val() {
-			amount, _ := strconv.ParseInt(amountString, 10, 64)
-			totalImmature += amount
-			tx.HIncrBy(r.formatKey("miners", login), "immature", (amount * -1))
+	creditKey := r.formatKey("credits", "immature", block.RoundHeight, block.Hash)
+	return r.client.Watch(ctx, func(tx *redis.Tx) error {
+		// Must decrement immatures using existing log entry
+		immatureCredits, err := tx.HGetAll(ctx, creditKey).Result()
+		if err != nil {
+			return err
 		}
-		tx.Del(creditKey)
-		tx.HIncrBy(r.formatKey("finances"), "immature", (totalImmature * -1))
-		return nil
-	})
-	return err
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			r.writeMaturedBlock(ctx, pipe, block)
+
+			// Decrement immature balances
+			totalImmature := int64(0)
+			for login, amountString := range immatureCredits {
+				amount, _ := strconv.ParseInt(amountString, 10, 64)
+				totalImmature += amount
+				pipe.HIncrBy(ctx, r.formatKey("miners", login), "immature", (amount * -1))
+			}
+			pipe.Del(ctx, creditKey)
+			pipe.HIncrBy(ctx, r.formatKey("finances"), "immature", (totalImmature * -1))
+			return nil
+		})
+		return err
+	}, creditKey)
 }
 
 func (r *RedisClient) WritePendingOrphans(blocks []*BlockData) error {
-	tx := r.client.Multi()
-	defer tx.Close()
+	ctx, cancel := r.call()
+	defer cancel()
 
-	_, err := tx.Exec(func() error {
+	_, err := r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
 		for _, block := range blocks {
-			r.writeImmatureBlock(tx, block)
+			r.writeImmatureBlock(ctx, tx, block)
 		}
 		return nil
 	})
 	return err
 }
 
-func (r *RedisClient) writeImmatureBlock(tx *redis.Multi, block *BlockData) {
+func (r *RedisClient) writeImmatureBlock(ctx context.Context, tx redis.Pipeliner, block *BlockData) {
 	// Redis 2.8.x returns "ERR source and destination objects are the same"
 	if block.Height != block.RoundHeight {
-		tx.Rename(r.formatRound(block.RoundHeight, block.Nonce), r.formatRound(block.Height, block.Nonce))
+		tx.Rename(ctx, r.formatRound(block.RoundHeight, block.Nonce), r.formatRound(block.Height, block.Nonce))
 	}
-	tx.ZRem(r.formatKey("blocks", "candidates"), block.candidateKey)
-	tx.ZAdd(r.formatKey("blocks", "immature"), redis.Z{Score: float64(block.Height), Member: block.key()})
+	tx.ZRem(ctx, r.formatKey("blocks", "candidates"), block.candidateKey)
+	tx.ZAdd(ctx, r.formatKey("blocks", "immature"), redis.Z{Score: float64(block.Height), Member: block.key()})
 }
 
-func (r *RedisClient) writeMaturedBlock(tx *redis.Multi, block *BlockData) {
-	tx.Del(r.formatRound(block.RoundHeight, block.Nonce))
-	tx.ZRem(r.formatKey("blocks", "immature"), block.immatureKey)
-	tx.ZAdd(r.formatKey("blocks", "matured"), redis.Z{Score: float64(block.Height), Member: block.key()})
+func (r *RedisClient) writeMaturedBlock(ctx context.Context, tx redis.Pipeliner, block *BlockData) {
+	tx.Del(ctx, r.formatRound(block.RoundHeight, block.Nonce))
+	tx.ZRem(ctx, r.formatKey("blocks", "immature"), block.immatureKey)
+	tx.ZAdd(ctx, r.formatKey("blocks", "matured"), redis.Z{Score: float64(block.Height), Member: block.key()})
 }
 
 func (r *RedisClient) IsMinerExists(login string) (bool, error) {
-	return r.client.Exists(r.formatKey("miners", login)).Result()
-}
-
-func (r *RedisClient) GetMinerStats(login string, maxPayments int64) (map[string]interface{}, error) {
-	stats := make(map[string]interface{})
-
-	tx := r.client.Multi()
-	defer tx.Close()
-
-	cmds, err := tx.Exec(func() error {
-		tx.HGetAllMap(r.formatKey("miners", login))
-		tx.ZRevRangeWithScores(r.formatKey("payments", login), 0, maxPayments-1)
-		tx.ZCard(r.formatKey("payments", login))
-		tx.HGet(r.formatKey("shares", "roundCurrent"), login)
+	ctx, cancel := r.call()
+	defer cancel()
+
+	n, err := r.client.Exists(ctx, r.formatKey("miners", login)).Result()
+	return n > 0, err
+}
+
+func (r *RedisClient) GetMinerStats(login string, maxPayments int64) (*MinerStats, error) {
+	defer metrics.ObserveRedis("get_miner_stats", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
+
+	var counters *redis.MapStringStringCmd
+	var payments *redis.ZSliceCmd
+	var paymentsTotal *redis.IntCmd
+	var roundShares *redis.StringCmd
+	_, err := r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		counters = tx.HGetAll(ctx, r.formatKey("miners", login))
+		payments = tx.ZRevRangeWithScores(ctx, r.formatKey("payments", login), 0, maxPayments-1)
+		paymentsTotal = tx.ZCard(ctx, r.formatKey("payments", login))
+		roundShares = tx.HGet(ctx, r.formatKey("shares", "roundCurrent"), login)
 		return nil
 	})
-
 	if err != nil && err != redis.Nil {
 		return nil, err
-	} else {
-		result, _ := cmds[0].(*redis.StringStringMapCmd).Result()
-		stats["stats"] = convertStringMap(result)
-		payments := convertPaymentsResults(cmds[1].(*redis.ZSliceCmd))
-		stats["payments"] = payments
-		stats["paymentsTotal"] = cmds[2].(*redis.IntCmd).Val()
-		roundShares, _ := cmds[3].(*redis.StringCmd).Int64()
-		stats["roundShares"] = roundShares
 	}
 
+	stats := &MinerStats{}
+	stats.Stats = convertAccountCounters(counters.Val())
+	stats.Payments = convertPaymentsResults(payments.Val())
+	stats.PaymentsTotal = paymentsTotal.Val()
+	stats.RoundShares, _ = roundShares.Int64()
 	return stats, nil
 }
 
-// Try to convert all numeric strings to int64
-func convertStringMap(m map[string]string) map[string]interface{} {
-	result := make(map[string]interface{})
-	var err error
-	for k, v := range m {
-		result[k], err = strconv.ParseInt(v, 10, 64)
-		if err != nil {
-			result[k] = v
-		}
-	}
-	return result
-}
-
 // WARNING: Must run it periodically to flush out of window hashrate entries
 func (r *RedisClient) FlushStaleStats(window, largeWindow time.Duration) (int64, error) {
+	ctx, cancel := r.call()
+	defer cancel()
+
 	now := util.MakeTimestamp() / 1000
 	max := fmt.Sprint("(", now-int64(window/time.Second))
-	total, err := r.client.ZRemRangeByScore(r.formatKey("hashrate"), "-inf", max).Result()
+	total, err := r.client.ZRemRangeByScore(ctx, r.formatKey("hashrate"), "-inf", max).Result()
 	if err != nil {
 		return total, err
 	}
 
-	var c int64
+	keys, err := r.scanKeys(ctx, r.formatKey("hashrate", "*"))
+	if err != nil {
+		return total, err
+	}
 	miners := make(map[string]struct{})
 	max = fmt.Sprint("(", now-int64(largeWindow/time.Second))
 
-	for {
-		var keys []string
-		var err error
-		c, keys, err = r.client.Scan(c, r.formatKey("hashrate", "*"), 100).Result()
-		if err != nil {
-			return total, err
-		}
-		for _, row := range keys {
-			login := strings.Split(row, ":")[2]
-			if _, ok := miners[login]; !ok {
-				n, err := r.client.ZRemRangeByScore(r.formatKey("hashrate", login), "-inf", max).Result()
-				if err != nil {
-					return total, err
-				}
-				miners[login] = struct{}{}
-				total += n
+	for _, row := range keys {
+		login := strings.Split(row, ":")[2]
+		if _, ok := miners[login]; !ok {
+			n, err := r.client.ZRemRangeByScore(ctx, r.formatKey("hashrate", login), "-inf", max).Result()
+			if err != nil {
+				return total, err
 			}
-		}
-		if c == 0 {
-			break
+			miners[login] = struct{}{}
+			total += n
 		}
 	}
 	return total, nil
 }
 
-func (r *RedisClient) CollectStats(smallWindow time.Duration, maxBlocks, maxPayments int64) (map[string]interface{}, error) {
-	window := int64(smallWindow / time.Second)
-	stats := make(map[string]interface{})
-
-	tx := r.client.Multi()
-	defer tx.Close()
+func (r *RedisClient) CollectStats(smallWindow time.Duration, maxBlocks, maxPayments int64) (*PoolStats, error) {
+	defer metrics.ObserveRedis("collect_stats", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
 
+	window := int64(smallWindow / time.Second)
 	now := util.MakeTimestamp() / 1000
 
-	cmds, err := tx.Exec(func() error {
-		tx.ZRemRangeByScore(r.formatKey("hashrate"), "-inf", fmt.Sprint("(", now-window))
-		tx.ZRangeWithScores(r.formatKey("hashrate"), 0, -1)
-		tx.HGetAllMap(r.formatKey("stats"))
-		tx.ZRevRangeWithScores(r.formatKey("blocks", "candidates"), 0, -1)
-		tx.ZRevRangeWithScores(r.formatKey("blocks", "immature"), 0, -1)
-		tx.ZRevRangeWithScores(r.formatKey("blocks", "matured"), 0, maxBlocks-1)
-		tx.ZCard(r.formatKey("blocks", "candidates"))
-		tx.ZCard(r.formatKey("blocks", "immature"))
-		tx.ZCard(r.formatKey("blocks", "matured"))
-		tx.ZCard(r.formatKey("payments", "all"))
-		tx.ZRevRangeWithScores(r.formatKey("payments", "all"), 0, maxPayments-1)
+	var hashrate, candidates, immature, matured, payments *redis.ZSliceCmd
+	var counters *redis.MapStringStringCmd
+	var candidatesTotal, immatureTotal, maturedTotal, paymentsTotal *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		tx.ZRemRangeByScore(ctx, r.formatKey("hashrate"), "-inf", fmt.Sprint("(", now-window))
+		hashrate = tx.ZRangeWithScores(ctx, r.formatKey("hashrate"), 0, -1)
+		counters = tx.HGetAll(ctx, r.formatKey("stats"))
+		candidates = tx.ZRevRangeWithScores(ctx, r.formatKey("blocks", "candidates"), 0, -1)
+		immature = tx.ZRevRangeWithScores(ctx, r.formatKey("blocks", "immature"), 0, -1)
+		matured = tx.ZRevRangeWithScores(ctx, r.formatKey("blocks", "matured"), 0, maxBlocks-1)
+		candidatesTotal = tx.ZCard(ctx, r.formatKey("blocks", "candidates"))
+		immatureTotal = tx.ZCard(ctx, r.formatKey("blocks", "immature"))
+		maturedTotal = tx.ZCard(ctx, r.formatKey("blocks", "matured"))
+		paymentsTotal = tx.ZCard(ctx, r.formatKey("payments", "all"))
+		payments = tx.ZRevRangeWithScores(ctx, r.formatKey("payments", "all"), 0, maxPayments-1)
 		return nil
 	})
-
 	if err != nil {
 		return nil, err
 	}
 
-	result, _ := cmds[2].(*redis.StringStringMapCmd).Result()
-	stats["stats"] = convertStringMap(result)
-	candidates := convertCandidateResults(cmds[3].(*redis.ZSliceCmd))
-	stats["candidates"] = candidates
-	stats["candidatesTotal"] = cmds[6].(*redis.IntCmd).Val()
+	stats := &PoolStats{}
+	stats.Stats = convertPoolCounters(counters.Val())
+	stats.Candidates = convertCandidateResults(candidates.Val())
+	stats.CandidatesTotal = candidatesTotal.Val()
 
-	immature := convertBlockResults(cmds[4].(*redis.ZSliceCmd))
-	stats["immature"] = immature
-	stats["immatureTotal"] = cmds[7].(*redis.IntCmd).Val()
+	stats.Immature = convertBlockResults(immature.Val())
+	stats.ImmatureTotal = immatureTotal.Val()
 
-	matured := convertBlockResults(cmds[5].(*redis.ZSliceCmd))
-	stats["matured"] = matured
-	stats["maturedTotal"] = cmds[8].(*redis.IntCmd).Val()
+	stats.Matured = convertBlockResults(matured.Val())
+	stats.MaturedTotal = maturedTotal.Val()
 
-	payments := convertPaymentsResults(cmds[10].(*redis.ZSliceCmd))
-	stats["payments"] = payments
-	stats["paymentsTotal"] = cmds[9].(*redis.IntCmd).Val()
+	stats.Payments = convertPaymentsResults(payments.Val())
+	stats.PaymentsTotal = paymentsTotal.Val()
 
-	totalHashrate, miners := convertMinersStats(window, cmds[1].(*redis.ZSliceCmd))
-	stats["miners"] = miners
-	stats["minersTotal"] = len(miners)
-	stats["hashrate"] = totalHashrate
+	stats.Hashrate, stats.Miners = convertMinersStats(window, hashrate.Val())
+	stats.MinersTotal = len(stats.Miners)
 	return stats, nil
 }
 
-func (r *RedisClient) CollectWorkersStats(sWindow, lWindow time.Duration, login string, maxBlocks int64) (map[string]interface{}, error) {
+func (r *RedisClient) CollectWorkersStats(sWindow, lWindow time.Duration, login string, maxBlocks int64) (*WorkersStats, error) {
+	defer metrics.ObserveRedis("collect_workers_stats", time.Now())
+	ctx, cancel := r.call()
+	defer cancel()
+
 	smallWindow := int64(sWindow / time.Second)
 	largeWindow := int64(lWindow / time.Second)
-	stats := make(map[string]interface{})
-
-	tx := r.client.Multi()
-	defer tx.Close()
-
 	now := util.MakeTimestamp() / 1000
 
-	cmds, err := tx.Exec(func() error {
-		tx.ZRemRangeByScore(r.formatKey("hashrate", login), "-inf", fmt.Sprint("(", now-largeWindow))
-		tx.ZRangeWithScores(r.formatKey("hashrate", login), 0, -1)
+	var hashrate, finders *redis.ZSliceCmd
+	_, err := r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		tx.ZRemRangeByScore(ctx, r.formatKey("hashrate", login), "-inf", fmt.Sprint("(", now-largeWindow))
+		hashrate = tx.ZRangeWithScores(ctx, r.formatKey("hashrate", login), 0, -1)
 		if maxBlocks > 0 {
-			tx.ZRevRangeWithScores(r.formatKey("finders", login), 0, maxBlocks-1)
+			finders = tx.ZRevRangeWithScores(ctx, r.formatKey("finders", login), 0, maxBlocks-1)
 		}
 		return nil
 	})
-
 	if err != nil {
 		return nil, err
 	}
 
+	stats := summarizeWorkers(now, smallWindow, largeWindow, convertWorkersStats(smallWindow, hashrate.Val()))
+	if finders != nil && finders.Err() == nil {
+		stats.Finders = convertFindersStats(finders.Val(), r.maturedBlocksByHeight)
+	}
+	return stats, nil
+}
+
+// summarizeWorkers turns summed shares into hashrates and online counts.
+func summarizeWorkers(now, smallWindow, largeWindow int64, workers map[string]Worker) *WorkersStats {
+	stats := &WorkersStats{}
 	totalHashrate := int64(0)
 	currentHashrate := int64(0)
 	online := int64(0)
 	offline := int64(0)
-	workers := convertWorkersStats(smallWindow, cmds[1].(*redis.ZSliceCmd))
 
 	for id, worker := range workers {
 		timeOnline := now - worker.startedAt
@@ -901,36 +1235,114 @@ func (r *RedisClient) CollectWorkersStats(sWindow, lWindow time.Duration, login
 		totalHashrate += worker.TotalHR
 		workers[id] = worker
 	}
-	stats["workers"] = workers
-	stats["workersTotal"] = len(workers)
-	stats["workersOnline"] = online
-	stats["workersOffline"] = offline
-	stats["hashrate"] = totalHashrate
-	stats["currentHashrate"] = currentHashrate
-	if maxBlocks > 0 && cmds[2].Err() == nil {
-		finders := r.convertFindersStats(cmds[2].(*redis.ZSliceCmd))
-		stats["finders"] = finders
+	stats.Workers = workers
+	stats.WorkersTotal = len(workers)
+	stats.WorkersOnline = online
+	stats.WorkersOffline = offline
+	stats.Hashrate = totalHashrate
+	stats.CurrentHashrate = currentHashrate
+	return stats
+}
+
+// Share counters kept per worker
+const (
+	WorkerSharesValid   = "valid"
+	WorkerSharesStale   = "stale"
+	WorkerSharesInvalid = "invalid"
+)
+
+// WorkerShares holds the share counters a worker gathered since they were
+// last written.
+type WorkerShares struct {
+	Login     string
+	Worker    string
+	Valid     int64
+	Stale     int64
+	Invalid   int64
+	LastShare int64
+}
+
+func (w *WorkerShares) counters() map[string]int64 {
+	return map[string]int64{
+		WorkerSharesValid:   w.Valid,
+		WorkerSharesStale:   w.Stale,
+		WorkerSharesInvalid: w.Invalid,
 	}
-	return stats, nil
 }
 
-func (r *RedisClient) CollectLuckStats(windows []int) (map[string]interface{}, error) {
-	stats := make(map[string]interface{})
+// WriteWorkerShares adds the counters of several workers in one round trip.
+func (r *RedisClient) WriteWorkerShares(shares []*WorkerShares) error {
+	ctx, cancel := r.call()
+	defer cancel()
+
+	_, err := r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		for _, w := range shares {
+			key := r.formatKey("workers", w.Login, w.Worker)
+			for field, n := range w.counters() {
+				if n > 0 {
+					tx.HIncrBy(ctx, key, field, n)
+				}
+			}
+			tx.HSet(ctx, key, "lastShare", strconv.FormatInt(w.LastShare, 10))
+		}
+		return nil
+	})
+	return err
+}
+
+// GetWorkerStats returns hashrate and share counters of a single worker,
+// nil if the worker has no shares on record.
+func (r *RedisClient) GetWorkerStats(sWindow, lWindow time.Duration, login, worker string) (*WorkerStats, error) {
+	collected, err := r.CollectWorkersStats(sWindow, lWindow, login, 0)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := r.call()
+	defer cancel()
+	counters, err := r.client.HGetAll(ctx, r.formatKey("workers", login, worker)).Result()
+	if err != nil {
+		return nil, err
+	}
+	w, online := collected.Workers[worker]
+	if !online && len(counters) == 0 {
+		return nil, nil
+	}
+
+	return &WorkerStats{
+		CurrentHashrate: w.HR,
+		Hashrate:        w.TotalHR,
+		LastBeat:        w.LastBeat,
+		Offline:         !online || w.Offline,
+		Valid:           parseCounter(counters, WorkerSharesValid),
+		Stale:           parseCounter(counters, WorkerSharesStale),
+		Invalid:         parseCounter(counters, WorkerSharesInvalid),
+		LastShare:       parseCounter(counters, "lastShare"),
+	}, nil
+}
 
-	tx := r.client.Multi()
-	defer tx.Close()
+func (r *RedisClient) CollectLuckStats(windows []int) (map[string]*LuckStats, error) {
+	ctx, cancel := r.call()
+	defer cancel()
 
 	max := int64(windows[len(windows)-1])
 
-	cmds, err := tx.Exec(func() error {
-		tx.ZRevRangeWithScores(r.formatKey("blocks", "immature"), 0, -1)
-		tx.ZRevRangeWithScores(r.formatKey("blocks", "matured"), 0, max-1)
+	var immature, matured *redis.ZSliceCmd
+	_, err := r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+		immature = tx.ZRevRangeWithScores(ctx, r.formatKey("blocks", "immature"), 0, -1)
+		matured = tx.ZRevRangeWithScores(ctx, r.formatKey("blocks", "matured"), 0, max-1)
 		return nil
 	})
 	if err != nil {
-		return stats, err
+		return make(map[string]*LuckStats), err
 	}
-	blocks := convertBlockResults(cmds[0].(*redis.ZSliceCmd), cmds[1].(*redis.ZSliceCmd))
+	blocks := convertBlockResults(immature.Val(), matured.Val())
+	return luckStats(blocks, windows), nil
+}
+
+// luckStats averages luck, uncle and orphan rates of the newest blocks per
+// window, up to the first window with too few blocks.
+func luckStats(blocks []*BlockData, windows []int) map[string]*LuckStats {
+	stats := make(map[string]*LuckStats)
 
 	calcLuck := func(max int) (int, float64, float64, float64) {
 		var total int
@@ -945,7 +1357,9 @@ func (r *RedisClient) CollectLuckStats(windows []int) (map[string]interface{}, e
 			if block.Orphan {
 				orphans++
 			}
-			sharesDiff += float64(block.TotalShares) / float64(block.Difficulty)
+			if block.Difficulty > 0 {
+				sharesDiff += float64(block.TotalShares) / float64(block.Difficulty)
+			}
 			total++
 		}
 		if total > 0 {
@@ -957,37 +1371,36 @@ func (r *RedisClient) CollectLuckStats(windows []int) (map[string]interface{}, e
 	}
 	for _, max := range windows {
 		total, sharesDiff, uncleRate, orphanRate := calcLuck(max)
-		row := map[string]float64{
-			"luck": sharesDiff, "uncleRate": uncleRate, "orphanRate": orphanRate,
-		}
-		stats[strconv.Itoa(total)] = row
+		stats[strconv.Itoa(total)] = &LuckStats{Luck: sharesDiff, UncleRate: uncleRate, OrphanRate: orphanRate}
 		if total < max {
 			break
 		}
 	}
-	return stats, nil
+	return stats
 }
 
 func (r *RedisClient) CollectLuckCharts(max int) (stats []*LuckCharts, err error) {
-	var result []*LuckCharts
-	tx := r.client.Multi()
-	defer tx.Close()
+	ctx, cancel := r.call()
+	defer cancel()
 
-	cmds, err := tx.Exec(func() error {
-		tx.ZRevRangeWithScores(r.formatKey("blocks", "matured"), 0, int64(max-1))
-		return nil
-	})
+	matured, err := r.client.ZRevRangeWithScores(ctx, r.formatKey("blocks", "matured"), 0, int64(max-1)).Result()
 	if err != nil {
-		return result, err
+		return nil, err
 	}
-	blocks := convertBlockResults(cmds[0].(*redis.ZSliceCmd))
+	return luckCharts(convertBlockResults(matured), max), nil
+}
 
+func luckCharts(blocks []*BlockData, max int) []*LuckCharts {
+	var result []*LuckCharts
 	for i, block := range blocks {
 		if i > (max - 1) {
 			break
 		}
 		lc := LuckCharts{}
-		var sharesDiff = float64(block.TotalShares) / float64(block.Difficulty)
+		var sharesDiff float64
+		if block.Difficulty > 0 {
+			sharesDiff = float64(block.TotalShares) / float64(block.Difficulty)
+		}
 		lc.Timestamp = block.Timestamp
 		lc.Height = block.RoundHeight
 		lc.Difficulty = block.Difficulty
@@ -997,7 +1410,7 @@ func (r *RedisClient) CollectLuckCharts(max int) (stats []*LuckCharts, err error
 		result = append(result, &lc)
 	}
 	sort.Sort(TimestampSorter(result))
-	return result, nil
+	return result
 }
 
 type TimestampSorter []*LuckCharts
@@ -1006,58 +1419,67 @@ func (a TimestampSorter) Len() int           { return len(a) }
 func (a TimestampSorter) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a TimestampSorter) Less(i, j int) bool { return a[i].Timestamp < a[j].Timestamp }
 
-func convertCandidateResults(raw *redis.ZSliceCmd) []*BlockData {
+func convertCandidateResults(raw []redis.Z) []*BlockData {
 	var result []*BlockData
-	for _, v := range raw.Val() {
-		// "nonce:powHash:mixDigest:timestamp:diff:totalShares"
-		block := BlockData{}
-		block.Height = int64(v.Score)
-		block.RoundHeight = block.Height
-		fields := strings.Split(v.Member.(string), ":")
-		block.Nonce = fields[0]
-		block.PowHash = fields[1]
-		block.Timestamp, _ = strconv.ParseInt(fields[3], 10, 64)
-		block.Difficulty, _ = strconv.ParseInt(fields[4], 10, 64)
-		block.TotalShares, _ = strconv.ParseInt(fields[5], 10, 64)
-		block.candidateKey = v.Member.(string)
-		result = append(result, &block)
+	for _, v := range raw {
+		result = append(result, convertCandidate(v))
 	}
 	return result
 }
 
-func convertBlockResults(rows ...*redis.ZSliceCmd) []*BlockData {
+func convertCandidate(v redis.Z) *BlockData {
+	// "nonce:hash:order:timestamp:diff:totalShares"
+	block := BlockData{}
+	block.Height = int64(v.Score)
+	block.RoundHeight = block.Height
+	fields := strings.Split(v.Member.(string), ":")
+	block.Nonce = fields[0]
+	block.Hash = fields[1]
+	block.Order, _ = strconv.Atoi(fields[2])
+	block.Timestamp, _ = strconv.ParseInt(fields[3], 10, 64)
+	block.Difficulty, _ = strconv.ParseInt(fields[4], 10, 64)
+	block.TotalShares, _ = strconv.ParseInt(fields[5], 10, 64)
+	block.candidateKey = v.Member.(string)
+	return &block
+}
+
+func convertBlockResults(rows ...[]redis.Z) []*BlockData {
 	var result []*BlockData
 	for _, row := range rows {
-		for _, v := range row.Val() {
-			// "uncleHeight:orphan:nonce:blockHash:timestamp:diff:totalShares:rewardInWei"
-			block := BlockData{}
-			block.Height = int64(v.Score)
-			block.RoundHeight = block.Height
-			fields := strings.Split(v.Member.(string), ":")
-			block.UncleHeight, _ = strconv.ParseInt(fields[0], 10, 64)
-			block.Uncle = block.UncleHeight > 0
-			block.Orphan, _ = strconv.ParseBool(fields[1])
-			block.Nonce = fields[2]
-			block.Hash = fields[3]
-			block.Timestamp, _ = strconv.ParseInt(fields[4], 10, 64)
-			block.Difficulty, _ = strconv.ParseInt(fields[5], 10, 64)
-			block.TotalShares, _ = strconv.ParseInt(fields[6], 10, 64)
-			block.RewardString = fields[7]
-			block.ImmatureReward = fields[7]
-			block.immatureKey = v.Member.(string)
-			result = append(result, &block)
+		for _, v := range row {
+			result = append(result, convertBlock(v))
 		}
 	}
 	return result
 }
 
+func convertBlock(v redis.Z) *BlockData {
+	// "uncleHeight:orphan:nonce:blockHash:timestamp:diff:totalShares:rewardInWei"
+	block := BlockData{}
+	block.Height = int64(v.Score)
+	block.RoundHeight = block.Height
+	fields := strings.Split(v.Member.(string), ":")
+	block.UncleHeight, _ = strconv.ParseInt(fields[0], 10, 64)
+	block.Uncle = block.UncleHeight > 0
+	block.Orphan, _ = strconv.ParseBool(fields[1])
+	block.Nonce = fields[2]
+	block.Hash = fields[3]
+	block.Timestamp, _ = strconv.ParseInt(fields[4], 10, 64)
+	block.Difficulty, _ = strconv.ParseInt(fields[5], 10, 64)
+	block.TotalShares, _ = strconv.ParseInt(fields[6], 10, 64)
+	block.RewardString = fields[7]
+	block.ImmatureReward = fields[7]
+	block.immatureKey = v.Member.(string)
+	return &block
+}
+
 // Build per login workers's total shares map {'rig-1': 12345, 'rig-2': 6789, ...}
 // TS => diff, id, ms
-func convertWorkersStats(window int64, raw *redis.ZSliceCmd) map[string]Worker {
+func convertWorkersStats(window int64, raw []redis.Z) map[string]Worker {
 	now := util.MakeTimestamp() / 1000
 	workers := make(map[string]Worker)
 
-	for _, v := range raw.Val() {
+	for _, v := range raw {
 		parts := strings.Split(v.Member.(string), ":")
 		share, _ := strconv.ParseInt(parts[0], 10, 64)
 		id := parts[1]
@@ -1083,12 +1505,12 @@ func convertWorkersStats(window int64, raw *redis.ZSliceCmd) map[string]Worker {
 	return workers
 }
 
-func convertMinersStats(window int64, raw *redis.ZSliceCmd) (int64, map[string]Miner) {
+func convertMinersStats(window int64, raw []redis.Z) (int64, map[string]Miner) {
 	now := util.MakeTimestamp() / 1000
 	miners := make(map[string]Miner)
 	totalHashrate := int64(0)
 
-	for _, v := range raw.Val() {
+	for _, v := range raw {
 		parts := strings.Split(v.Member.(string), ":")
 		share, _ := strconv.ParseInt(parts[0], 10, 64)
 		id := parts[1]
@@ -1126,37 +1548,44 @@ func convertMinersStats(window int64, raw *redis.ZSliceCmd) (int64, map[string]M
 	return totalHashrate, miners
 }
 
-func (r *RedisClient) convertFindersStats(raw *redis.ZSliceCmd) []*Finder {
+func (r *RedisClient) maturedBlocksByHeight(minHeight, maxHeight int64) ([]*BlockData, error) {
+	ctx, cancel := r.call()
+	defer cancel()
+	opt := &redis.ZRangeBy{Min: strconv.FormatInt(minHeight, 10), Max: strconv.FormatInt(maxHeight, 10)}
+	raw, err := r.client.ZRangeByScoreWithScores(ctx, r.formatKey("blocks", "matured"), opt).Result()
+	return convertBlockResults(raw), err
+}
+
+// convertFindersStats resolves blocks found by a miner, matured looks up
+// matured blocks by height.
+func convertFindersStats(raw []redis.Z, matured func(minHeight, maxHeight int64) ([]*BlockData, error)) []*Finder {
 	var finders []*Finder
 
-	for _, v := range raw.Val() {
+	for _, v := range raw {
 		finder := Finder{}
 		parts := strings.Split(v.Member.(string), ":")
 		height, _ := strconv.ParseInt(parts[0], 10, 64)
 		timestamp := int64(v.Score)
 		id := parts[1]
 
-		option := redis.ZRangeByScore{Min: parts[0], Max: strconv.FormatInt(height+7, 10)}
-		cmd := r.client.ZRangeByScoreWithScores(r.formatKey("blocks", "matured"), option)
-		if cmd.Err() != nil || len(cmd.Val()) == 0 {
+		blocks, err := matured(height, height+7)
+		if err != nil || len(blocks) == 0 {
 			continue
 		}
-		for _, w := range cmd.Val() {
-			parts = strings.Split(w.Member.(string), ":")
-			if parts[0] != "0" {
+		for _, block := range blocks {
+			if block.UncleHeight != 0 {
 				// Uncle case
-				uncleHeight, _ := strconv.ParseInt(parts[0], 10, 64)
 				// is it correct uncleHeight?
-				if uncleHeight != height {
+				if block.UncleHeight != height {
 					continue
 				}
-				finder.UncleHeight = uncleHeight
-				finder.Height = int64(w.Score)
+				finder.UncleHeight = block.UncleHeight
+				finder.Height = block.Height
 			} else {
 				finder.Height = height
 			}
-			finder.Hash = parts[3]
-			finder.Reward = parts[7]
+			finder.Hash = block.Hash
+			finder.Reward = block.RewardString
 			break
 		}
 
@@ -1172,34 +1601,41 @@ func (r *RedisClient) convertFindersStats(raw *redis.ZSliceCmd) []*Finder {
 	return finders
 }
 
-func convertPaymentsResults(raw *redis.ZSliceCmd) []map[string]interface{} {
-	var result []map[string]interface{}
-	for _, v := range raw.Val() {
-		tx := make(map[string]interface{})
-		tx["timestamp"] = int64(v.Score)
-		fields := strings.Split(v.Member.(string), ":")
-		tx["tx"] = fields[0]
-		// Individual or whole payments row
-		if len(fields) < 3 {
-			tx["amount"], _ = strconv.ParseInt(fields[1], 10, 64)
-		} else {
-			tx["address"] = fields[1]
-			tx["amount"], _ = strconv.ParseInt(fields[2], 10, 64)
-		}
-		result = append(result, tx)
+func convertPaymentsResults(raw []redis.Z) []*Payment {
+	var result []*Payment
+	for _, v := range raw {
+		result = append(result, convertPayment(v))
 	}
 	return result
 }
 
-func convertPaymentChartsResults(raw *redis.ZSliceCmd) []*PaymentCharts {
+func convertPayment(v redis.Z) *Payment {
+	tx := &Payment{Timestamp: int64(v.Score)}
+	fields := strings.Split(v.Member.(string), ":")
+	tx.Tx = fields[0]
+	// Individual or whole payments row
+	if len(fields) < 3 {
+		tx.Amount, _ = strconv.ParseInt(fields[1], 10, 64)
+	} else {
+		tx.Address = fields[1]
+		tx.Amount, _ = strconv.ParseInt(fields[2], 10, 64)
+	}
+	return tx
+}
+
+func convertPaymentChartsResults(raw []redis.Z) []*PaymentCharts {
+	return paymentCharts(convertPaymentsResults(raw))
+}
+
+// paymentCharts sums up payments, newest first, per day.
+func paymentCharts(payments []*Payment) []*PaymentCharts {
 	var result []*PaymentCharts
-	for _, v := range raw.Val() {
+	for _, v := range payments {
 		pc := PaymentCharts{}
-		pc.Timestamp = int64(v.Score)
+		pc.Timestamp = v.Timestamp
 		tm := time.Unix(pc.Timestamp, 0)
 		pc.TimeFormat = tm.Format("2006-01-02") + " 00_00"
-		fields := strings.Split(v.Member.(string), ":")
-		pc.Amount, _ = strconv.ParseInt(fields[1], 10, 64)
+		pc.Amount = v.Amount
 		//fmt.Printf("%d : %s : %d \n", pc.Timestamp, pc.TimeFormat, pc.Amount)
 
 		var chkAppend bool
@@ -1210,7 +1646,7 @@ func convertPaymentChartsResults(raw *redis.ZSliceCmd) []*PaymentCharts {
 			}
 		}
 		if !chkAppend {
-			pc.Timestamp -= int64(math.Mod(float64(v.Score), float64(86400)))
+			pc.Timestamp -= int64(math.Mod(float64(v.Timestamp), float64(86400)))
 			result = append(result, &pc)
 		}
 	}
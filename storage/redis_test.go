@@ -1,16 +1,22 @@
 package storage
 
 import (
+	"context"
 	"os"
 	"reflect"
 	"strconv"
 	"testing"
+	"time"
 
-	"gopkg.in/redis.v3"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/dominant-strategies/go-quai-stratum/util"
 )
 
 var r *RedisClient
 
+var ctx = context.Background()
+
 const prefix = "test"
 
 func TestMain(m *testing.M) {
@@ -46,12 +52,113 @@ func TestWriteShareCheckExist(t *testing.T) {
 	}
 }
 
+func TestWriteBlockCandidate(t *testing.T) {
+	reset()
+
+	r.WriteShare("x", "rig", []string{"0x1", "0xa"}, 10, 1008, time.Minute)
+	r.WriteBlock("y", "rig", []string{"0x2", "0xb", "1"}, 20, 500, 1009, time.Minute)
+
+	candidates, _ := r.GetCandidates(1009)
+	if len(candidates) != 1 {
+		t.Fatal("Must return block candidate")
+	}
+	block := candidates[0]
+	if block.Nonce != "0x2" || block.Hash != "0xb" || block.Order != 1 {
+		t.Error("Must parse nonce, hash and order")
+	}
+	if block.Difficulty != 500 || block.TotalShares != 30 {
+		t.Error("Must store round difficulty and total shares")
+	}
+
+	shares, _ := r.GetRoundShares(1009, "0x2")
+	if shares["x"] != 10 || shares["y"] != 20 {
+		t.Error("Must close round with all shares")
+	}
+}
+
+func TestWriteBlockLargeRound(t *testing.T) {
+	reset()
+
+	r.WriteBlock("x", "rig", []string{"0x2", "0xb", "1"}, 123456789012345678, 500, 1009, time.Minute)
+	candidates, _ := r.GetCandidates(1009)
+	if len(candidates) != 1 || candidates[0].TotalShares != 123456789012345678 {
+		t.Errorf("Must not round total shares: %+v", candidates)
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := r.WithContext(cctx).Check(); err != context.Canceled {
+		t.Errorf("Must cancel call along with context: %v", err)
+	}
+	if _, err := r.Check(); err != nil {
+		t.Errorf("Must not cancel calls of the parent client: %v", err)
+	}
+
+	timed := NewRedisClient(&Config{Endpoint: "127.0.0.1:6379", Timeout: "1ns"}, prefix)
+	defer timed.Close()
+	if _, err := timed.Check(); err != context.DeadlineExceeded {
+		t.Errorf("Must time out call: %v", err)
+	}
+}
+
+func TestWriteBlockPPLNS(t *testing.T) {
+	reset()
+	r.SetRewardScheme(pplnsScheme{window: 3})
+	defer r.SetRewardScheme(propScheme{})
+
+	r.WriteShare("x", "rig", []string{"0x1", "0xa"}, 10, 1008, time.Minute)
+	r.WriteShare("y", "rig", []string{"0x2", "0xb"}, 10, 1008, time.Minute)
+	r.WriteBlock("x", "rig", []string{"0x3", "0xc", "2"}, 10, 500, 1008, time.Minute)
+	r.WriteShare("z", "rig", []string{"0x4", "0xd"}, 10, 1009, time.Minute)
+	r.WriteBlock("z", "rig", []string{"0x5", "0xe", "2"}, 10, 500, 1009, time.Minute)
+
+	shares, _ := r.GetRoundShares(1008, "0x3")
+	if !reflect.DeepEqual(shares, map[string]int64{"x": 20, "y": 10}) {
+		t.Errorf("Must pay all shares in window: %v", shares)
+	}
+	shares, _ = r.GetRoundShares(1009, "0x5")
+	if !reflect.DeepEqual(shares, map[string]int64{"x": 10, "z": 20}) {
+		t.Errorf("Must pay only last shares in window: %v", shares)
+	}
+}
+
+func TestWriteSoloBlock(t *testing.T) {
+	reset()
+
+	r.WriteShare("x", "rig", []string{"0x1", "0xa"}, 10, 1008, time.Minute)
+	r.WriteSoloShare("y", "rig", []string{"0x2", "0xb"}, 10, 1008, time.Minute)
+	r.WriteSoloBlock("y", "rig", []string{"0x3", "0xc", "2"}, 10, 500, 1008, time.Minute)
+
+	shares, _ := r.GetRoundShares(1008, "0x3")
+	if !reflect.DeepEqual(shares, map[string]int64{"y": 20}) {
+		t.Errorf("Must pay solo finder only: %v", shares)
+	}
+	round := r.client.HGetAll(ctx, r.formatKey("shares", "roundCurrent")).Val()
+	if !reflect.DeepEqual(round, map[string]string{"x": "10"}) {
+		t.Errorf("Must keep pool round untouched: %v", round)
+	}
+}
+
+func TestWriteEvents(t *testing.T) {
+	reset()
+
+	err := r.WriteEvents("events", 0, []string{`{"type":"share"}`, `{"type":"block"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := r.client.XLen(ctx, r.formatKey("events")).Val(); n != 2 {
+		t.Errorf("Must append all events to stream: %v", n)
+	}
+}
+
 func TestGetPayees(t *testing.T) {
 	reset()
 
 	n := 256
 	for i := 0; i < n; i++ {
-		r.client.HSet(r.formatKey("miners", strconv.Itoa(i)), "balance", strconv.Itoa(i))
+		r.client.HSet(ctx, r.formatKey("miners", strconv.Itoa(i)), "balance", strconv.Itoa(i))
 	}
 
 	var payees []string
@@ -71,7 +178,7 @@ func TestGetPayees(t *testing.T) {
 func TestGetBalance(t *testing.T) {
 	reset()
 
-	r.client.HSet(r.formatKey("miners:x"), "balance", "750")
+	r.client.HSet(ctx, r.formatKey("miners:x"), "balance", "750")
 
 	v, _ := r.GetBalance("x")
 	if v != 750 {
@@ -90,18 +197,18 @@ func TestGetBalance(t *testing.T) {
 func TestUpdateBalance(t *testing.T) {
 	reset()
 
-	r.client.HMSetMap(
+	r.client.HSet(ctx,
 		r.formatKey("miners:x"),
 		map[string]string{"paid": "50", "balance": "1000"},
 	)
-	r.client.HMSetMap(
+	r.client.HSet(ctx,
 		r.formatKey("finances"),
 		map[string]string{"paid": "500", "balance": "10000"},
 	)
 
 	amount := int64(250)
 	r.UpdateBalance("x", amount)
-	result := r.client.HGetAllMap(r.formatKey("miners:x")).Val()
+	result := r.client.HGetAll(ctx, r.formatKey("miners:x")).Val()
 	if result["pending"] != "250" {
 		t.Error("Must set pending amount")
 	}
@@ -112,7 +219,7 @@ func TestUpdateBalance(t *testing.T) {
 		t.Error("Must not touch paid")
 	}
 
-	result = r.client.HGetAllMap(r.formatKey("finances")).Val()
+	result = r.client.HGetAll(ctx, r.formatKey("finances")).Val()
 	if result["pending"] != "250" {
 		t.Error("Must set pool pending amount")
 	}
@@ -123,7 +230,7 @@ func TestUpdateBalance(t *testing.T) {
 		t.Error("Must not touch pool paid")
 	}
 
-	rank := r.client.ZRank(r.formatKey("payments:pending"), join("x", amount)).Val()
+	rank := r.client.ZRank(ctx, r.formatKey("payments:pending"), join("x", amount)).Val()
 	if rank != 0 {
 		t.Error("Must add pending payment")
 	}
@@ -132,19 +239,19 @@ func TestUpdateBalance(t *testing.T) {
 func TestRollbackBalance(t *testing.T) {
 	reset()
 
-	r.client.HMSetMap(
+	r.client.HSet(ctx,
 		r.formatKey("miners:x"),
 		map[string]string{"paid": "100", "balance": "750", "pending": "250"},
 	)
-	r.client.HMSetMap(
+	r.client.HSet(ctx,
 		r.formatKey("finances"),
 		map[string]string{"paid": "500", "balance": "10000", "pending": "250"},
 	)
-	r.client.ZAdd(r.formatKey("payments:pending"), redis.Z{Score: 1, Member: "xx"})
+	r.client.ZAdd(ctx, r.formatKey("payments:pending"), redis.Z{Score: 1, Member: "xx"})
 
 	amount := int64(250)
 	r.RollbackBalance("x", amount)
-	result := r.client.HGetAllMap(r.formatKey("miners:x")).Val()
+	result := r.client.HGetAll(ctx, r.formatKey("miners:x")).Val()
 	if result["paid"] != "100" {
 		t.Error("Must not touch paid")
 	}
@@ -155,7 +262,7 @@ func TestRollbackBalance(t *testing.T) {
 		t.Error("Must deduct pending")
 	}
 
-	result = r.client.HGetAllMap(r.formatKey("finances")).Val()
+	result = r.client.HGetAll(ctx, r.formatKey("finances")).Val()
 	if result["paid"] != "500" {
 		t.Error("Must not touch pool paid")
 	}
@@ -166,7 +273,7 @@ func TestRollbackBalance(t *testing.T) {
 		t.Error("Must deduct pool pending")
 	}
 
-	err := r.client.ZRank(r.formatKey("payments:pending"), join("x", amount)).Err()
+	err := r.client.ZRank(ctx, r.formatKey("payments:pending"), join("x", amount)).Err()
 	if err != redis.Nil {
 		t.Errorf("Must remove pending payment")
 	}
@@ -175,18 +282,18 @@ func TestRollbackBalance(t *testing.T) {
 func TestWritePayment(t *testing.T) {
 	reset()
 
-	r.client.HMSetMap(
+	r.client.HSet(ctx,
 		r.formatKey("miners:x"),
 		map[string]string{"paid": "50", "balance": "1000", "pending": "250"},
 	)
-	r.client.HMSetMap(
+	r.client.HSet(ctx,
 		r.formatKey("finances"),
 		map[string]string{"paid": "500", "balance": "10000", "pending": "250"},
 	)
 
 	amount := int64(250)
 	r.WritePayment("x", "0x0", amount)
-	result := r.client.HGetAllMap(r.formatKey("miners:x")).Val()
+	result := r.client.HGetAll(ctx, r.formatKey("miners:x")).Val()
 	if result["pending"] != "0" {
 		t.Error("Must unset pending amount")
 	}
@@ -197,7 +304,7 @@ func TestWritePayment(t *testing.T) {
 		t.Error("Must increase paid")
 	}
 
-	result = r.client.HGetAllMap(r.formatKey("finances")).Val()
+	result = r.client.HGetAll(ctx, r.formatKey("finances")).Val()
 	if result["pending"] != "0" {
 		t.Error("Must deduct pool pending amount")
 	}
@@ -208,20 +315,20 @@ func TestWritePayment(t *testing.T) {
 		t.Error("Must increase pool paid")
 	}
 
-	err := r.client.Get(r.formatKey("payments:lock")).Err()
+	err := r.client.Get(ctx, r.formatKey("payments:lock")).Err()
 	if err != redis.Nil {
 		t.Errorf("Must release lock")
 	}
 
-	err = r.client.ZRank(r.formatKey("payments:pending"), join("x", amount)).Err()
+	err = r.client.ZRank(ctx, r.formatKey("payments:pending"), join("x", amount)).Err()
 	if err != redis.Nil {
 		t.Error("Must remove pending payment")
 	}
-	err = r.client.ZRank(r.formatKey("payments:all"), join("0x0", "x", amount)).Err()
+	err = r.client.ZRank(ctx, r.formatKey("payments:all"), join("0x0", "x", amount)).Err()
 	if err == redis.Nil {
 		t.Error("Must add payment to set")
 	}
-	err = r.client.ZRank(r.formatKey("payments:x"), join("0x0", amount)).Err()
+	err = r.client.ZRank(ctx, r.formatKey("payments:x"), join("0x0", amount)).Err()
 	if err == redis.Nil {
 		t.Error("Must add payment to set")
 	}
@@ -230,7 +337,7 @@ func TestWritePayment(t *testing.T) {
 func TestGetPendingPayments(t *testing.T) {
 	reset()
 
-	r.client.HMSetMap(
+	r.client.HSet(ctx,
 		r.formatKey("miners:x"),
 		map[string]string{"paid": "100", "balance": "750", "pending": "250"},
 	)
@@ -253,31 +360,79 @@ func TestGetPendingPayments(t *testing.T) {
 	}
 }
 
+func TestParsePayoutsLock(t *testing.T) {
+	lock := &PayoutsLock{Owner: "host-1-ab", Login: "x", Amount: 5000, ExpiresAt: 100}
+	if parsed := parsePayoutsLock(lock.value()); *parsed != *lock {
+		t.Errorf("Invalid lock: %+v", parsed)
+	}
+	legacy := parsePayoutsLock("x:5000:0xabc")
+	if legacy.Login != "x" || legacy.Amount != 5000 || legacy.TxHash != "0xabc" {
+		t.Errorf("Invalid legacy lock: %+v", legacy)
+	}
+	if !legacy.Expired(1) {
+		t.Error("Legacy lock without owner must be expired")
+	}
+}
+
+func TestPayoutsLockExpiry(t *testing.T) {
+	reset()
+
+	lock := &PayoutsLock{Owner: "payer", Login: "x", Amount: 5000, ExpiresAt: util.MakeTimestamp()/1000 + 600}
+	if err := r.LockPayouts(lock); err != nil {
+		t.Fatal(err)
+	}
+	key := r.formatKey("payments", "lock")
+	if ttl := r.client.TTL(ctx, key).Val(); ttl <= 0 || ttl > 600*time.Second {
+		t.Errorf("Must expire the lock along with ExpiresAt: %v", ttl)
+	}
+	r.SetPayoutsLockTx(lock, "0xabc")
+	if ttl := r.client.TTL(ctx, key).Val(); ttl > 0 {
+		t.Errorf("Must keep the lock of a sent payment: %v", ttl)
+	}
+}
+
+func TestWriteMaturedBlockFees(t *testing.T) {
+	reset()
+
+	block := &BlockData{Height: 1008, RoundHeight: 1008, Hash: "0x0", Nonce: "0x1", Reward: util.String2Big("2000000000000000000")}
+	r.WriteImmatureBlock(block, map[string]int64{"x": 1980000000})
+	fees := &RoundFees{Pool: map[string]int64{"fee": 18000000}, Donations: map[string]int64{"donate": 2000000}}
+	r.WriteMaturedBlock(block, map[string]int64{"x": 1980000000}, fees)
+
+	if v, _ := r.GetBalance("fee"); v != 18000000 {
+		t.Error("Must credit pool fee address")
+	}
+	if v, _ := r.GetBalance("donate"); v != 2000000 {
+		t.Error("Must credit donation address")
+	}
+	result := r.client.HGetAll(ctx, r.formatKey("finances")).Val()
+	if result["poolFee"] != "18000000" || result["donations"] != "2000000" {
+		t.Error("Must record fees in finances")
+	}
+	if result["balance"] != "2000000000" || result["immature"] != "0" {
+		t.Error("Must credit whole block reward to pool balance")
+	}
+}
+
 func TestCollectLuckStats(t *testing.T) {
 	reset()
 
 	members := []redis.Z{
 		{Score: 0, Member: "1:0:0x0:0x0:0:100:100:0"},
 	}
-	r.client.ZAdd(r.formatKey("blocks:immature"), members...)
+	r.client.ZAdd(ctx, r.formatKey("blocks:immature"), members...)
 	members = []redis.Z{
 		{Score: 1, Member: "1:0:0x2:0x0:0:50:100:0"},
 		{Score: 2, Member: "0:1:0x1:0x0:0:100:100:0"},
 		{Score: 3, Member: "0:0:0x3:0x0:0:200:100:0"},
 	}
-	r.client.ZAdd(r.formatKey("blocks:matured"), members...)
+	r.client.ZAdd(ctx, r.formatKey("blocks:matured"), members...)
 
 	stats, _ := r.CollectLuckStats([]int{1, 2, 5, 10})
-	expectedStats := map[string]interface{}{
-		"1": map[string]float64{
-			"luck": 1, "uncleRate": 1, "orphanRate": 0,
-		},
-		"2": map[string]float64{
-			"luck": 0.75, "uncleRate": 0.5, "orphanRate": 0,
-		},
-		"4": map[string]float64{
-			"luck": 1.125, "uncleRate": 0.5, "orphanRate": 0.25,
-		},
+	expectedStats := map[string]*LuckStats{
+		"1": {Luck: 1, UncleRate: 1, OrphanRate: 0},
+		"2": {Luck: 0.75, UncleRate: 0.5, OrphanRate: 0},
+		"4": {Luck: 1.125, UncleRate: 0.5, OrphanRate: 0.25},
 	}
 
 	if !reflect.DeepEqual(stats, expectedStats) {
@@ -285,9 +440,115 @@ func TestCollectLuckStats(t *testing.T) {
 	}
 }
 
+func TestGetWorkerStats(t *testing.T) {
+	reset()
+
+	r.WriteShare("x", "rig", []string{"0x1", "0xa"}, 10, 1008, time.Minute)
+	r.WriteWorkerShares([]*WorkerShares{{Login: "x", Worker: "rig", Valid: 1, LastShare: util.MakeTimestamp() / 1000}})
+	r.WriteWorkerShares([]*WorkerShares{{Login: "x", Worker: "rig", Valid: 1, Stale: 1, Invalid: 1, LastShare: util.MakeTimestamp() / 1000}})
+
+	stats, err := r.GetWorkerStats(time.Minute, time.Hour, "x", "rig")
+	if err != nil || stats == nil {
+		t.Fatalf("Must return worker stats: %v", err)
+	}
+	if stats.Valid != 2 || stats.Stale != 1 || stats.Invalid != 1 {
+		t.Errorf("Must count shares by status: %+v", stats)
+	}
+	if stats.Offline || stats.LastShare == 0 {
+		t.Errorf("Must report worker online: %+v", stats)
+	}
+
+	stats, _ = r.GetWorkerStats(time.Minute, time.Hour, "x", "other")
+	if stats != nil {
+		t.Error("Must return nil for unknown worker")
+	}
+}
+
+func TestWorkerCharts(t *testing.T) {
+	reset()
+
+	now := util.MakeTimestamp() / 1000
+	r.WriteWorkerCharts(now-60, "a", "x", "rig", 10, 20)
+	r.WriteWorkerCharts(now, "b", "x", "rig", 30, 40)
+
+	charts, _ := r.GetWorkerCharts(10, "x", "rig")
+	if len(charts) != 2 || charts[0].WorkerHash != 10 || charts[1].WorkerLargeHash != 40 {
+		t.Errorf("Must return worker charts in chronological order: %v", charts)
+	}
+}
+
+func TestListEntries(t *testing.T) {
+	reset()
+
+	added, _ := r.AddListEntry(Blacklist, "0xabc")
+	if !added {
+		t.Error("Must add new entry")
+	}
+	added, _ = r.AddListEntry(Blacklist, "0xabc")
+	if added {
+		t.Error("Must not add existing entry twice")
+	}
+	blacklist, _ := r.GetBlacklist()
+	if !reflect.DeepEqual(blacklist, []string{"0xabc"}) {
+		t.Errorf("Invalid blacklist: %v", blacklist)
+	}
+	removed, _ := r.RemoveListEntry(Blacklist, "0xabc")
+	if !removed {
+		t.Error("Must remove existing entry")
+	}
+	removed, _ = r.RemoveListEntry(Blacklist, "0xabc")
+	if removed {
+		t.Error("Must not remove missing entry")
+	}
+}
+
+func TestBans(t *testing.T) {
+	reset()
+
+	now := util.MakeTimestamp()
+	r.WriteBan(&Ban{IP: "10.0.0.1", Reason: "malformed", BannedAt: now - 2000, ExpiresAt: now + 60000})
+	r.WriteBan(&Ban{IP: "10.0.0.2", Reason: "client", BannedAt: now - 1000, ExpiresAt: now + 60000})
+	r.WriteBan(&Ban{IP: "10.0.0.3", Reason: "client", BannedAt: now - 90000, ExpiresAt: now - 30000})
+
+	bans, err := r.GetBans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bans) != 2 || bans[0].IP != "10.0.0.2" || bans[1].Reason != "malformed" {
+		t.Errorf("Must list unexpired bans, most recent first: %+v", bans)
+	}
+	deleted, _ := r.DeleteBan("10.0.0.2")
+	if !deleted {
+		t.Error("Must delete ban")
+	}
+	bans, _ = r.GetBans()
+	if len(bans) != 1 {
+		t.Errorf("Must drop deleted ban: %+v", bans)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	reset()
+
+	for i := 0; i < 5; i++ {
+		r.WriteAudit(&AuditEntry{Timestamp: int64(i), Actor: "ops", Action: "add", Target: Whitelist, Entry: "10.0.0.1"}, 3)
+	}
+	entries, err := r.GetAuditLog(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Timestamp != 4 || entries[2].Timestamp != 2 {
+		t.Errorf("Must keep the most recent audit entries: %+v", entries)
+	}
+	entries, _ = r.GetAuditLog(1)
+	if len(entries) != 1 || entries[0].Timestamp != 4 {
+		t.Errorf("Must limit audit entries: %+v", entries)
+	}
+}
+
 func reset() {
-	keys := r.client.Keys(r.prefix + ":*").Val()
+	keys := r.client.Keys(ctx, r.prefix+":*").Val()
 	for _, k := range keys {
-		r.client.Del(k)
+		r.client.Del(ctx, k)
 	}
 }
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+const (
+	SchemePROP  = "prop"
+	SchemePPLNS = "pplns"
+	SchemeSOLO  = "solo"
+)
+
+type RewardConfig struct {
+	// Pool-wide scheme, either prop or pplns
+	Scheme string `json:"scheme"`
+	// Number of last shares paid by a block in pplns mode
+	PPLNSWindow int64 `json:"pplnsWindow"`
+	// Let miners opt into solo mining on login
+	AllowSolo bool `json:"allowSolo"`
+}
+
+// ErrEmptyRound is returned for rounds without shares to split the reward
+// across.
+var ErrEmptyRound = errors.New("round has no shares")
+
+// RewardScheme decides which shares end up in the round of a found block
+// and how the unlocker splits the block reward across that round.
+type RewardScheme interface {
+	Name() string
+	// Rewards returns the Shannon credited to each login for a block
+	// reward in Wei, before fees.
+	Rewards(round map[string]int64, reward *big.Int) (map[string]int64, error)
+	writeShare(w roundWriter, login string, diff int64)
+	closeRound(w roundWriter, login, roundKey string)
+}
+
+// roundWriter is the bookkeeping reward schemes do on shares, queued for the
+// Redis share script or applied to the in-memory store.
+type roundWriter interface {
+	formatKey(args ...interface{}) string
+	hIncrBy(key, field string, n int64)
+	hDel(key, field string)
+	del(key string)
+	rename(from, to string)
+	lPush(key, value string)
+	lTrim(key string, start, stop int64)
+	// sumShares adds up the "diff:login" entries of list into the round hash
+	sumShares(list, roundKey string)
+}
+
+func NewRewardScheme(cfg *RewardConfig) (RewardScheme, error) {
+	switch cfg.Scheme {
+	case "", SchemePROP:
+		return propScheme{}, nil
+	case SchemePPLNS:
+		if cfg.PPLNSWindow < 1 {
+			return nil, fmt.Errorf("pplns window can't be < 1, your window is %v", cfg.PPLNSWindow)
+		}
+		return pplnsScheme{window: cfg.PPLNSWindow}, nil
+	}
+	return nil, fmt.Errorf("unknown reward scheme %q", cfg.Scheme)
+}
+
+// Pays every share submitted since the previous block.
+type propScheme struct{}
+
+func (propScheme) Name() string { return SchemePROP }
+
+func (propScheme) writeShare(w roundWriter, login string, diff int64) {
+	w.hIncrBy(w.formatKey("shares", "roundCurrent"), login, diff)
+	w.hIncrBy(w.formatKey("stats"), "roundShares", diff)
+}
+
+func (propScheme) closeRound(w roundWriter, login, roundKey string) {
+	w.hDel(w.formatKey("stats"), "roundShares")
+	w.rename(w.formatKey("shares", "roundCurrent"), roundKey)
+}
+
+func (propScheme) Rewards(round map[string]int64, reward *big.Int) (map[string]int64, error) {
+	return splitReward(round, reward)
+}
+
+// Pays the last window shares regardless of round boundaries.
+type pplnsScheme struct {
+	window int64
+}
+
+func (pplnsScheme) Name() string { return SchemePPLNS }
+
+func (s pplnsScheme) writeShare(w roundWriter, login string, diff int64) {
+	// Keep current round for miner stats, payouts use the window only
+	w.hIncrBy(w.formatKey("shares", "roundCurrent"), login, diff)
+	w.hIncrBy(w.formatKey("stats"), "roundShares", diff)
+	w.lPush(w.formatKey("shares", "pplns"), join(diff, login))
+	w.lTrim(w.formatKey("shares", "pplns"), 0, s.window-1)
+}
+
+func (pplnsScheme) closeRound(w roundWriter, login, roundKey string) {
+	w.hDel(w.formatKey("stats"), "roundShares")
+	w.del(w.formatKey("shares", "roundCurrent"))
+	w.sumShares(w.formatKey("shares", "pplns"), roundKey)
+}
+
+// The round holds the window summed up when the block was found.
+func (pplnsScheme) Rewards(round map[string]int64, reward *big.Int) (map[string]int64, error) {
+	return splitReward(round, reward)
+}
+
+// Pays the whole block to the miner who found it. Solo shares are kept out
+// of the pool round.
+type soloScheme struct{}
+
+func (soloScheme) Name() string { return SchemeSOLO }
+
+func (soloScheme) writeShare(w roundWriter, login string, diff int64) {
+	w.hIncrBy(w.formatKey("shares", "solo", login), login, diff)
+}
+
+func (soloScheme) closeRound(w roundWriter, login, roundKey string) {
+	w.rename(w.formatKey("shares", "solo", login), roundKey)
+}
+
+// The round holds the shares of the finder only, so they get it all.
+func (soloScheme) Rewards(round map[string]int64, reward *big.Int) (map[string]int64, error) {
+	return splitReward(round, reward)
+}
+
+// splitReward credits every login its part of reward in proportion to its
+// shares of round.
+func splitReward(round map[string]int64, reward *big.Int) (map[string]int64, error) {
+	total := int64(0)
+	for _, n := range round {
+		total += n
+	}
+	if total <= 0 {
+		return nil, ErrEmptyRound
+	}
+
+	rewards := make(map[string]int64)
+	wei := new(big.Rat).SetInt(reward)
+	for login, n := range round {
+		percent := big.NewRat(n, total)
+		workerReward := new(big.Rat).Mul(wei, percent)
+		rewards[login] += weiToShannonInt64(workerReward)
+	}
+	return rewards, nil
+}
+
+func weiToShannonInt64(wei *big.Rat) int64 {
+	shannon := new(big.Rat).SetInt(util.Shannon)
+	inShannon := new(big.Rat).Quo(wei, shannon)
+	value, _ := strconv.ParseInt(inShannon.FloatString(0), 10, 64)
+	return value
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestSplitReward(t *testing.T) {
+	blockReward, _ := new(big.Int).SetString("5000000000000000000", 10)
+	shares := map[string]int64{"0x0": 1000000, "0x1": 20000, "0x2": 5000, "0x3": 10, "0x4": 1}
+	expectedRewards := map[string]int64{"0x0": 4877996431, "0x1": 97559929, "0x2": 24389982, "0x3": 48780, "0x4": 4878}
+
+	rewards, err := splitReward(shares, blockReward)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedTotalAmount := int64(5000000000)
+
+	totalAmount := int64(0)
+	for login, amount := range rewards {
+		totalAmount += amount
+
+		if expectedRewards[login] != amount {
+			t.Errorf("Amount for %v must be equal to %v vs %v", login, expectedRewards[login], amount)
+		}
+	}
+	if totalAmount != expectedTotalAmount {
+		t.Errorf("Total reward must be equal to block reward in Shannon: %v vs %v", expectedTotalAmount, totalAmount)
+	}
+
+	if _, err := splitReward(map[string]int64{}, blockReward); err != ErrEmptyRound {
+		t.Errorf("Must refuse to split an empty round: %v", err)
+	}
+}
+
+func TestSchemeRewards(t *testing.T) {
+	reward := big.NewInt(3000000000)
+	for _, scheme := range []RewardScheme{propScheme{}, pplnsScheme{window: 10}, soloScheme{}} {
+		rewards, err := scheme.Rewards(map[string]int64{"x": 2, "y": 1}, reward)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rewards["x"] != 2 || rewards["y"] != 1 {
+			t.Errorf("%s must split the reward by shares: %v", scheme.Name(), rewards)
+		}
+	}
+}
+
+func TestWeiToShannonInt64(t *testing.T) {
+	wei, _ := new(big.Rat).SetString("1000000000")
+	if weiToShannonInt64(wei) != 1 {
+		t.Error("Must convert to Shannon")
+	}
+	wei, _ = new(big.Rat).SetString("1999999999")
+	if weiToShannonInt64(wei) != 2 {
+		t.Error("Must round to nearest Shannon")
+	}
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// KeySchemaVersion is the layout of the Redis keys written by this build.
+// Changing the format of a key bumps it and appends the migration that
+// upgrades existing keys to keyMigrations.
+const KeySchemaVersion = 2
+
+type keyMigration struct {
+	name    string
+	migrate func(ctx context.Context, r *RedisClient) error
+}
+
+// keyMigrations[i] upgrades keys from version i to i+1. Never edit one that
+// has been released, append a new one instead.
+var keyMigrations = []keyMigration{
+	// Keys written before versioning have the layout of version 1
+	{"stamp unversioned keys", func(ctx context.Context, r *RedisClient) error { return nil }},
+	// Credit history is read from credits:<login>, which is only written
+	// for blocks matured since version 2
+	{"index credits by login", indexCredits},
+}
+
+// indexCredits adds the rewards of blocks matured before version 2 to the
+// credit history of each miner. Fees were not recorded per block back then.
+// Credits already indexed are written again unchanged.
+func indexCredits(ctx context.Context, r *RedisClient) error {
+	const batch = 1000
+	for start := int64(0); ; start += batch {
+		blocks, err := r.client.ZRangeWithScores(ctx, r.formatKey("credits", "all"), start, start+batch-1).Result()
+		if err != nil || len(blocks) == 0 {
+			return err
+		}
+		rewards := make([]*redis.MapStringStringCmd, len(blocks))
+		_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, z := range blocks {
+				hash := strings.Split(z.Member.(string), ":")[0]
+				rewards[i] = pipe.HGetAll(ctx, r.formatKey("credits", int64(z.Score), hash))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, z := range blocks {
+				// hash:timestamp:reward
+				fields := strings.Split(z.Member.(string), ":")
+				if len(fields) < 2 {
+					return fmt.Errorf("malformed credit entry %q", z.Member)
+				}
+				block := &BlockData{Height: int64(z.Score), Hash: fields[0]}
+				ts, _ := strconv.ParseInt(fields[1], 10, 64)
+				for login, amount := range rewards[i].Val() {
+					n, _ := strconv.ParseInt(amount, 10, 64)
+					r.writeCredit(ctx, pipe, login, block, creditReward, ts, n)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// KeySchema returns the schema version of the stored keys, 0 if unversioned.
+func (r *RedisClient) KeySchema() (int, error) {
+	ctx, cancel := r.call()
+	defer cancel()
+
+	version, err := r.client.Get(ctx, r.formatKey("schema")).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return version, err
+}
+
+// CheckKeySchema refuses keys of another schema version. An empty database
+// is stamped with the current version.
+func (r *RedisClient) CheckKeySchema() error {
+	version, err := r.KeySchema()
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		ctx, cancel := r.call()
+		defer cancel()
+
+		keys, err := r.scanKeys(ctx, r.formatKey("*"))
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return r.client.SetNX(ctx, r.formatKey("schema"), KeySchemaVersion, 0).Err()
+		}
+	}
+	if version < KeySchemaVersion {
+		return fmt.Errorf("storage key schema %v is outdated, migrate it to %v", version, KeySchemaVersion)
+	}
+	if version > KeySchemaVersion {
+		return fmt.Errorf("storage key schema %v is newer than %v of this build", version, KeySchemaVersion)
+	}
+	return nil
+}
+
+// MigrateKeySchema upgrades the keys to KeySchemaVersion in place, recording
+// the version after each migration, and returns the version reached. The
+// pool must be stopped while it runs.
+func (r *RedisClient) MigrateKeySchema() (int, error) {
+	version, err := r.KeySchema()
+	if err != nil {
+		return version, err
+	}
+	if version > len(keyMigrations) {
+		return version, fmt.Errorf("storage key schema %v is newer than %v of this build", version, KeySchemaVersion)
+	}
+	// Migrations may touch every key, so they are not bound by the call timeout
+	for ; version < len(keyMigrations); version++ {
+		m := keyMigrations[version]
+		if err := m.migrate(r.ctx, r); err != nil {
+			return version, fmt.Errorf("key migration %v (%s): %v", version+1, m.name, err)
+		}
+		if err := r.client.Set(r.ctx, r.formatKey("schema"), version+1, 0).Err(); err != nil {
+			return version, err
+		}
+		log.Printf("Applied key migration %v: %s", version+1, m.name)
+	}
+	return version, nil
+}
+
+// keySchema is implemented by backends keeping keys in Redis.
+type keySchema interface {
+	CheckKeySchema() error
+	MigrateKeySchema() (int, error)
+}
+
+// CheckSchema refuses to run against keys of another schema version. SQL
+// ledgers migrate themselves when opened.
+func CheckSchema(b Backend) error {
+	if s, ok := b.(keySchema); ok {
+		return s.CheckKeySchema()
+	}
+	return nil
+}
+
+// MigrateSchema upgrades the keys of b to the current schema version.
+func MigrateSchema(b Backend) (int, error) {
+	if s, ok := b.(keySchema); ok {
+		return s.MigrateKeySchema()
+	}
+	return KeySchemaVersion, nil
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestCheckKeySchema(t *testing.T) {
+	reset()
+
+	if err := r.CheckKeySchema(); err != nil {
+		t.Fatalf("Must accept empty database: %v", err)
+	}
+	if version, _ := r.KeySchema(); version != KeySchemaVersion {
+		t.Errorf("Must stamp empty database, got %v", version)
+	}
+
+	reset()
+	r.UpdateBalance("x", 100)
+	if err := r.CheckKeySchema(); err == nil {
+		t.Error("Must refuse unversioned keys")
+	}
+	r.client.Set(ctx, r.formatKey("schema"), KeySchemaVersion+1, 0)
+	if err := r.CheckKeySchema(); err == nil {
+		t.Error("Must refuse newer schema")
+	}
+	if _, err := r.MigrateKeySchema(); err == nil {
+		t.Error("Must not migrate newer schema")
+	}
+}
+
+func TestMigrateKeySchema(t *testing.T) {
+	reset()
+	defer func(m []keyMigration) { keyMigrations = m }(keyMigrations)
+
+	keyMigrations = append(keyMigrations, keyMigration{"rename balances", func(ctx context.Context, r *RedisClient) error {
+		return r.client.Rename(ctx, r.formatKey("balances"), r.formatKey("accounts")).Err()
+	}})
+	r.client.Set(ctx, r.formatKey("balances"), "1", 0)
+
+	version, err := r.MigrateKeySchema()
+	if err != nil || version != len(keyMigrations) {
+		t.Fatalf("Must apply all migrations: %v %v", version, err)
+	}
+	if n, _ := r.client.Exists(ctx, r.formatKey("accounts")).Result(); n != 1 {
+		t.Error("Must upgrade keys in place")
+	}
+	if version, _ = r.MigrateKeySchema(); version != len(keyMigrations) {
+		t.Errorf("Must be idempotent, got %v", version)
+	}
+}
+
+func TestIndexCredits(t *testing.T) {
+	reset()
+
+	// Block matured before credits were indexed by login
+	r.client.Set(ctx, r.formatKey("schema"), 1, 0)
+	r.client.ZAdd(ctx, r.formatKey("credits", "all"), redis.Z{Score: 10, Member: "0xa:1000:5000"})
+	r.client.HSet(ctx, r.formatKey("credits", int64(10), "0xa"), "x", "4000", "y", "1000")
+	// Block matured after
+	block := &BlockData{Height: 11, Hash: "0xb"}
+	r.client.ZAdd(ctx, r.formatKey("credits", "all"), redis.Z{Score: 11, Member: "0xb:2000:5000"})
+	r.client.HSet(ctx, r.formatKey("credits", int64(11), "0xb"), "x", "5000")
+	r.client.ZAdd(ctx, r.formatKey("credits", "x"), redis.Z{Score: 2000, Member: join(block.Height, block.Hash, int64(5000))})
+
+	if _, err := r.MigrateKeySchema(); err != nil {
+		t.Fatalf("Must migrate: %v", err)
+	}
+	credits, _, err := r.GetCreditsPage("x", &PageQuery{Limit: 10})
+	if err != nil || len(credits) != 2 {
+		t.Fatalf("Must index old credits once: %+v %v", credits, err)
+	}
+	if c := credits[1]; c.Height != 10 || c.Hash != "0xa" || c.Timestamp != 1000 || c.Amount != 4000 {
+		t.Errorf("Invalid backfilled credit: %+v", c)
+	}
+	if credits, _, _ = r.GetCreditsPage("y", &PageQuery{Limit: 10}); len(credits) != 1 || credits[0].Amount != 1000 {
+		t.Errorf("Must index credits of every miner: %+v", credits)
+	}
+}
+
+func TestSchemaMigrationsMatchVersion(t *testing.T) {
+	if len(keyMigrations) != KeySchemaVersion {
+		t.Errorf("%v migrations for key schema %v", len(keyMigrations), KeySchemaVersion)
+	}
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// shareScript runs the queued writes of a share and records its PoW in one
+// step, or does nothing and returns 1 if the PoW was seen already. PoWs
+// older than ARGV[1] are pruned first. Redis doesn't roll back a script
+// failing half way, so the PoW is recorded last and a share whose writes
+// failed can be submitted again.
+//
+// ARGV[4] commands follow ARGV[2..3], the score and member of the PoW, each
+// as name, key count, arg count and args. Keys are taken from KEYS in order,
+// KEYS[1] holding the PoWs. SUMSHARES and CANDIDATE are the round closing
+// steps plain commands can't express.
+var shareScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[1])
+if redis.call('ZSCORE', KEYS[1], ARGV[3]) then
+	return 1
+end
+local k, a = 2, 5
+for _ = 1, tonumber(ARGV[4]) do
+	local name, nkeys, nargs = ARGV[a], tonumber(ARGV[a + 1]), tonumber(ARGV[a + 2])
+	local keys = {unpack(KEYS, k, k + nkeys - 1)}
+	local args = {unpack(ARGV, a + 3, a + 2 + nargs)}
+	if name == 'SUMSHARES' then
+		-- Adds the "diff:login" entries of a list to the round hash
+		for _, share in ipairs(redis.call('LRANGE', keys[1], 0, -1)) do
+			local i = string.find(share, ':', 1, true)
+			redis.call('HINCRBY', keys[2], string.sub(share, i + 1), string.sub(share, 1, i - 1))
+		end
+	elseif name == 'CANDIDATE' then
+		-- Files a candidate with the total shares of its round. Lua numbers
+		-- can't hold large totals exactly, so they are summed by INCRBY.
+		redis.call('DEL', keys[3])
+		for _, shares in ipairs(redis.call('HVALS', keys[2])) do
+			redis.call('INCRBY', keys[3], shares)
+		end
+		local total = redis.call('GET', keys[3]) or '0'
+		redis.call('DEL', keys[3])
+		redis.call('ZADD', keys[1], args[1], args[2] .. ':' .. total)
+	else
+		local call = {name}
+		for _, key in ipairs(keys) do
+			call[#call + 1] = key
+		end
+		for _, arg in ipairs(args) do
+			call[#call + 1] = arg
+		end
+		redis.call(unpack(call))
+	end
+	k, a = k + nkeys, a + 3 + nargs
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[3])
+return 0
+`)
+
+// shareBatch queues the writes of a share for shareScript.
+type shareBatch struct {
+	*RedisClient
+	keys []string
+	args []interface{}
+	n    int
+}
+
+func (b *shareBatch) queue(name string, keys []string, args ...interface{}) {
+	b.keys = append(b.keys, keys...)
+	b.args = append(b.args, name, len(keys), len(args))
+	b.args = append(b.args, args...)
+	b.n++
+}
+
+// run writes the queued batch unless the PoW of params at height exists,
+// which it reports.
+func (b *shareBatch) run(ctx context.Context, height uint64, params []string) (bool, error) {
+	keys := append([]string{b.formatKey("pow")}, b.keys...)
+	args := append([]interface{}{int64(height) - 8, height, strings.Join(params, ":"), b.n}, b.args...)
+	exist, err := shareScript.Run(ctx, b.client, keys, args...).Int()
+	return exist == 1, err
+}
+
+func (b *shareBatch) writeShare(scheme RewardScheme, ms, ts int64, login, id string, diff int64, expire time.Duration) {
+	scheme.writeShare(b, login, diff)
+	b.zAdd(b.formatKey("hashrate"), ts, join(diff, login, id, ms))
+	b.zAdd(b.formatKey("hashrate", login), ts, join(diff, id, ms))
+	b.pExpire(b.formatKey("hashrate", login), int64(expire/time.Millisecond)) // Will delete hashrates for miners that gone
+	b.hSet(b.formatKey("miners", login), "lastShare", strconv.FormatInt(ts, 10))
+}
+
+func (b *shareBatch) hIncrBy(key, field string, n int64) {
+	b.queue("HINCRBY", []string{key}, field, n)
+}
+
+func (b *shareBatch) hSet(key, field, value string) {
+	b.queue("HSET", []string{key}, field, value)
+}
+
+func (b *shareBatch) hDel(key, field string) {
+	b.queue("HDEL", []string{key}, field)
+}
+
+func (b *shareBatch) del(key string) {
+	b.queue("DEL", []string{key})
+}
+
+func (b *shareBatch) rename(from, to string) {
+	b.queue("RENAME", []string{from, to})
+}
+
+func (b *shareBatch) lPush(key, value string) {
+	b.queue("LPUSH", []string{key}, value)
+}
+
+func (b *shareBatch) lTrim(key string, start, stop int64) {
+	b.queue("LTRIM", []string{key}, start, stop)
+}
+
+func (b *shareBatch) sumShares(list, roundKey string) {
+	b.queue("SUMSHARES", []string{list, roundKey})
+}
+
+func (b *shareBatch) zAdd(key string, score int64, member string) {
+	b.queue("ZADD", []string{key}, score, member)
+}
+
+func (b *shareBatch) zIncrBy(key string, n int64, member string) {
+	b.queue("ZINCRBY", []string{key}, n, member)
+}
+
+func (b *shareBatch) pExpire(key string, ms int64) {
+	b.queue("PEXPIRE", []string{key}, ms)
+}
+
+// candidate files a block in candidates, see candidateMember.
+func (b *shareBatch) candidate(height uint64, roundKey, member string) {
+	keys := []string{b.formatKey("blocks", "candidates"), roundKey, b.formatKey("shares", "total")}
+	b.queue("CANDIDATE", keys, height, member)
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// A PoW recorded without its share lets a block close the round in between,
+// so the share lands in the wrong round. Submitters race on the same PoWs
+// while the round is watched for a PoW without its share.
+func TestWriteShareAtomic(t *testing.T) {
+	reset()
+
+	const miners = 200
+	var accepted int64
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < miners; i++ {
+				params := []string{fmt.Sprintf("0x%x", i), "0x0"}
+				exist, err := r.WriteShare(strconv.Itoa(i), "rig", params, 10, 1008, time.Minute)
+				if err == nil && !exist {
+					atomic.AddInt64(&accepted, 1)
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		var pow *redis.StringSliceCmd
+		var round *redis.MapStringStringCmd
+		r.client.TxPipelined(ctx, func(tx redis.Pipeliner) error {
+			pow = tx.ZRange(ctx, r.formatKey("pow"), 0, -1)
+			round = tx.HGetAll(ctx, r.formatKey("shares", "roundCurrent"))
+			return nil
+		})
+		for _, member := range pow.Val() {
+			i, _ := strconv.ParseInt(strings.Split(member, ":")[0], 0, 64)
+			if shares := round.Val()[strconv.FormatInt(i, 10)]; shares != "10" {
+				t.Fatalf("PoW %v recorded with %q shares", member, shares)
+			}
+		}
+	}
+
+	if accepted != miners {
+		t.Errorf("Must accept each PoW once, accepted %v", accepted)
+	}
+	stats, _ := r.CollectStats(time.Minute, 0, 0)
+	if stats.Stats.RoundShares != miners*10 || stats.MinersTotal != miners {
+		t.Errorf("Must count each share once: %+v", stats.Stats)
+	}
+}
+
+func TestWriteShareFailedWrites(t *testing.T) {
+	reset()
+
+	// Writing lastShare fails on a key of the wrong type
+	r.client.Set(ctx, r.formatKey("miners", "x"), "broken", 0)
+	params := []string{"0x1", "0x0"}
+	if _, err := r.WriteShare("x", "rig", params, 10, 1008, time.Minute); err == nil {
+		t.Fatal("Must fail on the broken key")
+	}
+
+	r.client.Del(ctx, r.formatKey("miners", "x"))
+	exist, err := r.WriteShare("x", "rig", params, 10, 1008, time.Minute)
+	if err != nil || exist {
+		t.Fatalf("Must accept the share again after its writes failed: %v %v", exist, err)
+	}
+	if exist, _ := r.WriteShare("x", "rig", params, 10, 1008, time.Minute); !exist {
+		t.Error("Must still reject duplicate PoWs")
+	}
+}
+
+func TestWriteBlockDuplicate(t *testing.T) {
+	reset()
+
+	r.WriteShare("x", "rig", []string{"0x1", "0xa"}, 10, 1008, time.Minute)
+	exist, _ := r.WriteBlock("x", "rig", []string{"0x1", "0xa"}, 10, 500, 1008, time.Minute)
+	if !exist {
+		t.Error("PoW must exist")
+	}
+	if candidates, _ := r.GetCandidates(1008); len(candidates) != 0 {
+		t.Error("Must not file a duplicate as block")
+	}
+	if shares, _ := r.GetRoundShares(1008, "0x1"); len(shares) != 0 {
+		t.Errorf("Must not close round on a duplicate: %v", shares)
+	}
+}
+
+// The pool must sustain 10k shares/s, compare shares/s against that. Run it
+// against a real Redis, scripts are much slower on an emulated one.
+func BenchmarkWriteShare(b *testing.B) {
+	reset()
+	defer reset()
+
+	var n int64
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&n, 1)
+			params := []string{fmt.Sprintf("0x%x", i), "0x0"}
+			if _, err := r.WriteShare(strconv.FormatInt(i%100, 10), "rig", params, 10, 1008, time.Minute); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "shares/s")
+}
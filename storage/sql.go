@@ -0,0 +1,896 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	_ "github.com/lib/pq"
+
+	"github.com/dominant-strategies/go-quai-stratum/util"
+)
+
+type SQLConfig struct {
+	// database/sql driver, postgres unless set
+	Driver       string `json:"driver"`
+	DSN          string `json:"dsn"`
+	MaxOpenConns int    `json:"maxOpenConns"`
+}
+
+// SQLBackend keeps blocks, credits, payments and balances in a relational
+// database. Queries are written for PostgreSQL and kept portable enough to
+// run on SQLite in tests.
+type SQLBackend struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+// Block statuses, named after the Redis sets they replace
+var blockStatuses = []string{BlocksCandidates, BlocksImmature, BlocksMatured}
+
+// migrations are applied in order, each one exactly once. Never edit an
+// applied migration, append a new one instead.
+var migrations = []string{
+	// 1: initial ledger
+	`CREATE TABLE blocks (
+		height       BIGINT  NOT NULL,
+		nonce        TEXT    NOT NULL,
+		hash         TEXT    NOT NULL,
+		status       TEXT    NOT NULL,
+		block_order  INTEGER NOT NULL DEFAULT 0,
+		uncle_height BIGINT  NOT NULL DEFAULT 0,
+		orphan       BOOLEAN NOT NULL DEFAULT FALSE,
+		found_at     BIGINT  NOT NULL,
+		difficulty   BIGINT  NOT NULL,
+		total_shares BIGINT  NOT NULL,
+		-- Wei as a decimal string
+		reward       TEXT    NOT NULL DEFAULT '0',
+		matured_at   BIGINT,
+		PRIMARY KEY (height, nonce)
+	);
+	CREATE INDEX blocks_status_height ON blocks (status, height);
+
+	CREATE TABLE round_shares (
+		height BIGINT NOT NULL,
+		nonce  TEXT   NOT NULL,
+		login  TEXT   NOT NULL,
+		shares BIGINT NOT NULL,
+		PRIMARY KEY (height, nonce, login)
+	);
+
+	CREATE TABLE immature_credits (
+		height BIGINT NOT NULL,
+		hash   TEXT   NOT NULL,
+		login  TEXT   NOT NULL,
+		amount BIGINT NOT NULL,
+		PRIMARY KEY (height, hash, login)
+	);
+
+	CREATE TABLE credits (
+		login       TEXT   NOT NULL,
+		height      BIGINT NOT NULL,
+		hash        TEXT   NOT NULL,
+		kind        TEXT   NOT NULL,
+		amount      BIGINT NOT NULL,
+		credited_at BIGINT NOT NULL,
+		PRIMARY KEY (login, height, hash, kind)
+	);
+	CREATE INDEX credits_login_credited_at ON credits (login, credited_at);
+
+	CREATE TABLE accounts (
+		login    TEXT PRIMARY KEY,
+		balance  BIGINT NOT NULL DEFAULT 0,
+		immature BIGINT NOT NULL DEFAULT 0,
+		pending  BIGINT NOT NULL DEFAULT 0,
+		paid     BIGINT NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE payments (
+		tx_hash TEXT   NOT NULL,
+		login   TEXT   NOT NULL,
+		amount  BIGINT NOT NULL,
+		paid_at BIGINT NOT NULL,
+		PRIMARY KEY (tx_hash, login)
+	);
+	CREATE INDEX payments_paid_at ON payments (paid_at);
+	CREATE INDEX payments_login_paid_at ON payments (login, paid_at);
+
+	CREATE TABLE pending_payments (
+		login      TEXT   NOT NULL,
+		amount     BIGINT NOT NULL,
+		created_at BIGINT NOT NULL,
+		PRIMARY KEY (login, amount)
+	);
+
+	CREATE TABLE payout_lock (
+		id      INTEGER PRIMARY KEY,
+		login   TEXT   NOT NULL,
+		amount  BIGINT NOT NULL,
+		tx_hash TEXT   NOT NULL DEFAULT ''
+	);
+
+	CREATE TABLE finances (
+		id                 INTEGER PRIMARY KEY,
+		balance            BIGINT NOT NULL DEFAULT 0,
+		immature           BIGINT NOT NULL DEFAULT 0,
+		pending            BIGINT NOT NULL DEFAULT 0,
+		paid               BIGINT NOT NULL DEFAULT 0,
+		pool_fee           BIGINT NOT NULL DEFAULT 0,
+		donations          BIGINT NOT NULL DEFAULT 0,
+		total_mined        BIGINT NOT NULL DEFAULT 0,
+		last_credit_height BIGINT NOT NULL DEFAULT 0,
+		last_credit_hash   TEXT   NOT NULL DEFAULT ''
+	);
+	INSERT INTO finances (id) VALUES (1);`,
+	// 2: payer owning the payouts lock
+	`ALTER TABLE payout_lock ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+	ALTER TABLE payout_lock ADD COLUMN expires_at BIGINT NOT NULL DEFAULT 0;`,
+}
+
+func NewSQLBackend(cfg *SQLConfig) (*SQLBackend, error) {
+	driver := cfg.Driver
+	if len(driver) == 0 {
+		driver = "postgres"
+	}
+	db, err := sql.Open(driver, cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	s := &SQLBackend{db: db, ctx: context.Background()}
+	if err := s.Migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// WithContext returns a copy of the backend whose queries are cancelled along
+// with ctx.
+func (s *SQLBackend) WithContext(ctx context.Context) *SQLBackend {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+func (s *SQLBackend) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLBackend) Check() error {
+	return s.db.PingContext(s.ctx)
+}
+
+// Migrate brings the schema up to date and returns the first error.
+func (s *SQLBackend) Migrate() error {
+	_, err := s.db.ExecContext(s.ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at BIGINT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	for i := version; i < len(migrations); i++ {
+		err := s.inTx(func(tx *sql.Tx) error {
+			for _, stmt := range strings.Split(migrations[i], ";") {
+				if len(strings.TrimSpace(stmt)) == 0 {
+					continue
+				}
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`, i+1, util.MakeTimestamp()/1000)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the number of applied migrations.
+func (s *SQLBackend) SchemaVersion() (int, error) {
+	var version sql.NullInt64
+	err := s.db.QueryRowContext(s.ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+	return int(version.Int64), err
+}
+
+func (s *SQLBackend) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(s.ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqlExec runs statements in order until one fails.
+type sqlExec struct {
+	tx  *sql.Tx
+	err error
+}
+
+func (e *sqlExec) exec(query string, args ...interface{}) {
+	if e.err == nil {
+		_, e.err = e.tx.Exec(query, args...)
+	}
+}
+
+// Account and finance columns are fixed names, never user input.
+func (e *sqlExec) addAccount(login, column string, amount int64) {
+	e.exec(`INSERT INTO accounts (login, `+column+`) VALUES ($1, $2)
+		ON CONFLICT (login) DO UPDATE SET `+column+` = accounts.`+column+` + excluded.`+column, login, amount)
+}
+
+func (e *sqlExec) addFinances(column string, amount int64) {
+	e.exec(`UPDATE finances SET `+column+` = `+column+` + $1 WHERE id = 1`, amount)
+}
+
+// WriteCandidate records a block found by the pool along with its round.
+// Writing the same candidate again is a no-op.
+func (s *SQLBackend) WriteCandidate(block *BlockData, shares map[string]int64) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		e := &sqlExec{tx: tx}
+		e.exec(`INSERT INTO blocks (height, nonce, hash, status, block_order, found_at, difficulty, total_shares)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (height, nonce) DO NOTHING`,
+			block.RoundHeight, block.Nonce, block.Hash, BlocksCandidates, block.Order, block.Timestamp, block.Difficulty, block.TotalShares)
+		for login, n := range shares {
+			e.exec(`INSERT INTO round_shares (height, nonce, login, shares) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (height, nonce, login) DO NOTHING`, block.RoundHeight, block.Nonce, login, n)
+		}
+		return e.err
+	})
+}
+
+func (s *SQLBackend) GetCandidates(maxHeight int64) ([]*BlockData, error) {
+	return s.queryBlocks(BlocksCandidates, ` AND height <= $2 ORDER BY height, nonce`, maxHeight)
+}
+
+func (s *SQLBackend) GetImmatureBlocks(maxHeight int64) ([]*BlockData, error) {
+	return s.queryBlocks(BlocksImmature, ` AND height <= $2 ORDER BY height, nonce`, maxHeight)
+}
+
+const blockColumns = `height, nonce, hash, block_order, uncle_height, orphan, found_at, difficulty, total_shares, reward`
+
+// queryBlocks selects blocks of status, narrowed and ordered by the rest of
+// the query, whose arguments start at $2.
+func (s *SQLBackend) queryBlocks(status, rest string, args ...interface{}) ([]*BlockData, error) {
+	rows, err := s.db.QueryContext(s.ctx, `SELECT `+blockColumns+` FROM blocks WHERE status = $1`+rest, append([]interface{}{status}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*BlockData
+	for rows.Next() {
+		block, err := scanBlock(rows, status)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, block)
+	}
+	return result, rows.Err()
+}
+
+// scanBlock reads a row of blockColumns into the fields the Redis backend
+// fills for blocks of status.
+func scanBlock(rows *sql.Rows, status string) (*BlockData, error) {
+	block := &BlockData{}
+	var reward string
+	err := rows.Scan(&block.Height, &block.Nonce, &block.Hash, &block.Order, &block.UncleHeight,
+		&block.Orphan, &block.Timestamp, &block.Difficulty, &block.TotalShares, &reward)
+	block.RoundHeight = block.Height
+	if status != BlocksCandidates {
+		block.Uncle = block.UncleHeight > 0
+		block.RewardString = reward
+		block.ImmatureReward = reward
+	}
+	return block, err
+}
+
+func (s *SQLBackend) GetRoundShares(height int64, nonce string) (map[string]int64, error) {
+	rows, err := s.db.QueryContext(s.ctx, `SELECT login, shares FROM round_shares WHERE height = $1 AND nonce = $2`, height, nonce)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]int64)
+	for rows.Next() {
+		var login string
+		var n int64
+		if err := rows.Scan(&login, &n); err != nil {
+			return nil, err
+		}
+		result[login] = n
+	}
+	return result, rows.Err()
+}
+
+// moveBlock updates the block found at fromHeight to its new status,
+// inserting it if it was never recorded as a candidate.
+func (e *sqlExec) moveBlock(block *BlockData, fromHeight int64, status string, maturedAt interface{}) {
+	if e.err != nil {
+		return
+	}
+	reward := "0"
+	if block.Reward != nil {
+		reward = block.Reward.String()
+	}
+	var res sql.Result
+	res, e.err = e.tx.Exec(`UPDATE blocks SET height = $1, hash = $2, status = $3, uncle_height = $4, orphan = $5,
+		found_at = $6, difficulty = $7, total_shares = $8, reward = $9, matured_at = $10
+		WHERE height = $11 AND nonce = $12`,
+		block.Height, block.serializeHash(), status, block.UncleHeight, block.Orphan,
+		block.Timestamp, block.Difficulty, block.TotalShares, reward, maturedAt, fromHeight, block.Nonce)
+	if e.err != nil {
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		e.exec(`INSERT INTO blocks (height, nonce, hash, status, uncle_height, orphan, found_at, difficulty, total_shares, reward, matured_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			block.Height, block.Nonce, block.serializeHash(), status, block.UncleHeight, block.Orphan,
+			block.Timestamp, block.Difficulty, block.TotalShares, reward, maturedAt)
+	}
+}
+
+func (e *sqlExec) writeImmatureBlock(block *BlockData) {
+	if block.Height != block.RoundHeight {
+		e.exec(`UPDATE round_shares SET height = $1 WHERE height = $2 AND nonce = $3`, block.Height, block.RoundHeight, block.Nonce)
+	}
+	e.moveBlock(block, block.RoundHeight, BlocksImmature, nil)
+}
+
+func (e *sqlExec) writeMaturedBlock(block *BlockData, ts int64) {
+	e.exec(`DELETE FROM round_shares WHERE height = $1 AND nonce = $2`, block.RoundHeight, block.Nonce)
+	e.moveBlock(block, block.RoundHeight, BlocksMatured, ts)
+}
+
+// releaseImmature takes back the immature credits logged for block and
+// returns their total.
+func (e *sqlExec) releaseImmature(block *BlockData) int64 {
+	if e.err != nil {
+		return 0
+	}
+	rows, err := e.tx.Query(`SELECT login, amount FROM immature_credits WHERE height = $1 AND hash = $2`, block.RoundHeight, block.Hash)
+	if err != nil {
+		e.err = err
+		return 0
+	}
+	credits := make(map[string]int64)
+	for rows.Next() {
+		var login string
+		var amount int64
+		if e.err = rows.Scan(&login, &amount); e.err != nil {
+			rows.Close()
+			return 0
+		}
+		credits[login] = amount
+	}
+	rows.Close()
+	if e.err = rows.Err(); e.err != nil {
+		return 0
+	}
+	for login, amount := range credits {
+		e.addAccount(login, "immature", amount*-1)
+	}
+	e.exec(`DELETE FROM immature_credits WHERE height = $1 AND hash = $2`, block.RoundHeight, block.Hash)
+	return sumCredits(credits)
+}
+
+func (s *SQLBackend) WriteImmatureBlock(block *BlockData, roundRewards map[string]int64) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		e := &sqlExec{tx: tx}
+		e.writeImmatureBlock(block)
+		for login, amount := range roundRewards {
+			e.addAccount(login, "immature", amount)
+			e.exec(`INSERT INTO immature_credits (height, hash, login, amount) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (height, hash, login) DO NOTHING`, block.Height, block.Hash, login, amount)
+		}
+		e.addFinances("immature", sumCredits(roundRewards))
+		return e.err
+	})
+}
+
+func (s *SQLBackend) WriteMaturedBlock(block *BlockData, roundRewards map[string]int64, fees *RoundFees) error {
+	ts := util.MakeTimestamp() / 1000
+
+	return s.inTx(func(tx *sql.Tx) error {
+		e := &sqlExec{tx: tx}
+		e.writeMaturedBlock(block, ts)
+		totalImmature := e.releaseImmature(block)
+
+		credit := func(login, kind string, amount int64) {
+			e.addAccount(login, "balance", amount)
+			e.exec(`INSERT INTO credits (login, height, hash, kind, amount, credited_at) VALUES ($1, $2, $3, $4, $5, $6)
+				ON CONFLICT (login, height, hash, kind) DO NOTHING`, login, block.Height, block.Hash, kind, amount, ts)
+		}
+		total := sumCredits(roundRewards)
+		for login, amount := range roundRewards {
+			credit(login, creditReward, amount)
+		}
+		if fees != nil {
+			for login, amount := range fees.Pool {
+				credit(login, creditPoolFee, amount)
+			}
+			for login, amount := range fees.Donations {
+				credit(login, creditDonation, amount)
+			}
+			total += fees.Total()
+			e.addFinances("pool_fee", sumCredits(fees.Pool))
+			e.addFinances("donations", sumCredits(fees.Donations))
+		}
+		e.addFinances("balance", total)
+		e.addFinances("immature", totalImmature*-1)
+		e.addFinances("total_mined", block.RewardInShannon())
+		e.exec(`UPDATE finances SET last_credit_height = $1, last_credit_hash = $2 WHERE id = 1`, block.Height, block.Hash)
+		return e.err
+	})
+}
+
+func (s *SQLBackend) WriteOrphan(block *BlockData) error {
+	ts := util.MakeTimestamp() / 1000
+
+	return s.inTx(func(tx *sql.Tx) error {
+		e := &sqlExec{tx: tx}
+		e.writeMaturedBlock(block, ts)
+		e.addFinances("immature", e.releaseImmature(block)*-1)
+		return e.err
+	})
+}
+
+func (s *SQLBackend) WritePendingOrphans(blocks []*BlockData) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		e := &sqlExec{tx: tx}
+		for _, block := range blocks {
+			e.writeImmatureBlock(block)
+		}
+		return e.err
+	})
+}
+
+// GetBlocksPage pages through blocks of status by height. Unlike Redis the
+// time filters narrow the query, so pages are always full.
+func (s *SQLBackend) GetBlocksPage(status string, q *PageQuery) ([]*BlockData, string, error) {
+	if !util.StringInSlice(status, blockStatuses) {
+		return nil, "", fmt.Errorf("unknown block status %v", status)
+	}
+	where := []string{"status = $1"}
+	args := []interface{}{status}
+	where, args = timeRange(where, args, "found_at", q)
+	var blocks []*BlockData
+	cursor, err := s.queryPage(q, `SELECT `+blockColumns+` FROM blocks`, where, args, "height", "nonce", func(rows *sql.Rows) (int64, error) {
+		block, err := scanBlock(rows, status)
+		blocks = append(blocks, block)
+		return block.Height, err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return blocks, cursor, nil
+}
+
+// GetPaymentsPage pages through payments of login or of the whole pool
+// when login is empty.
+func (s *SQLBackend) GetPaymentsPage(login string, q *PageQuery) ([]*Payment, string, error) {
+	var where []string
+	var args []interface{}
+	if len(login) > 0 {
+		where, args = []string{"login = $1"}, []interface{}{login}
+	}
+	where, args = timeRange(where, args, "paid_at", q)
+	var payments []*Payment
+	cursor, err := s.queryPage(q, `SELECT tx_hash, login, amount, paid_at FROM payments`, where, args, "paid_at", "tx_hash, login", func(rows *sql.Rows) (int64, error) {
+		payment, err := scanPayment(rows, len(login) == 0)
+		payments = append(payments, payment)
+		return payment.Timestamp, err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return payments, cursor, nil
+}
+
+// GetCreditsPage pages through matured block rewards and fees credited to
+// login.
+func (s *SQLBackend) GetCreditsPage(login string, q *PageQuery) ([]*Credit, string, error) {
+	where, args := timeRange([]string{"login = $1"}, []interface{}{login}, "credited_at", q)
+	var credits []*Credit
+	cursor, err := s.queryPage(q, `SELECT height, hash, kind, amount, credited_at FROM credits`, where, args, "credited_at", "height, hash, kind", func(rows *sql.Rows) (int64, error) {
+		credit := &Credit{}
+		err := rows.Scan(&credit.Height, &credit.Hash, &credit.Kind, &credit.Amount, &credit.Timestamp)
+		credits = append(credits, credit)
+		return credit.Timestamp, err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return credits, cursor, nil
+}
+
+// timeRange narrows where to the inclusive time range of q on column.
+func timeRange(where []string, args []interface{}, column string, q *PageQuery) ([]string, []interface{}) {
+	if q.From != 0 {
+		args = append(args, q.From)
+		where = append(where, fmt.Sprintf("%s >= $%d", column, len(args)))
+	}
+	if q.To != 0 {
+		args = append(args, q.To)
+		where = append(where, fmt.Sprintf("%s <= $%d", column, len(args)))
+	}
+	return where, args
+}
+
+// queryPage selects a page of q ordered by the score column, then by the tie
+// breaking columns, starting at the cursor. Like in scanPage the cursor holds
+// the last score handed out and how many rows with that score were passed.
+// scan reads a row and returns its score.
+func (s *SQLBackend) queryPage(q *PageQuery, query string, where []string, args []interface{}, score, ties string, scan func(rows *sql.Rows) (int64, error)) (string, error) {
+	cursor, err := parseCursor(q.Cursor)
+	if err != nil {
+		return "", err
+	}
+	op, direction := "<=", " DESC"
+	if q.Ascending {
+		op, direction = ">=", " ASC"
+	}
+	if cursor.set {
+		args = append(args, cursor.score)
+		where = append(where, fmt.Sprintf("%s %s $%d", score, op, len(args)))
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	var order []string
+	for _, column := range strings.Split(score+", "+ties, ",") {
+		order = append(order, strings.TrimSpace(column)+direction)
+	}
+	query += " ORDER BY " + strings.Join(order, ", ")
+	// Offset without a limit isn't portable, rows passed already are skipped
+	// while reading instead
+	skip := cursor.skip
+	if q.Limit > 0 {
+		args = append(args, q.Limit+skip)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	rows, err := s.db.QueryContext(s.ctx, query, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	n := int64(0)
+	for rows.Next() {
+		if skip > 0 {
+			skip--
+			continue
+		}
+		ts, err := scan(rows)
+		if err != nil {
+			return "", err
+		}
+		cursor.advance(ts)
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if q.Limit > 0 && n == q.Limit {
+		return cursor.String(), nil
+	}
+	return "", nil
+}
+
+// scanPayment reads a row of tx_hash, login, amount and paid_at. The address
+// is only set on pool wide lists, as in Redis.
+func scanPayment(rows *sql.Rows, poolWide bool) (*Payment, error) {
+	payment := &Payment{}
+	var login string
+	err := rows.Scan(&payment.Tx, &login, &payment.Amount, &payment.Timestamp)
+	if poolWide {
+		payment.Address = login
+	}
+	return payment, err
+}
+
+// getBlocks returns blocks of status highest first, the first limit of them
+// if limit is positive.
+func (s *SQLBackend) getBlocks(status string, limit int64) ([]*BlockData, error) {
+	if limit > 0 {
+		return s.queryBlocks(status, ` ORDER BY height DESC, nonce DESC LIMIT $2`, limit)
+	}
+	return s.queryBlocks(status, ` ORDER BY height DESC, nonce DESC`)
+}
+
+func (s *SQLBackend) countBlocks(status string) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(s.ctx, `SELECT COUNT(*) FROM blocks WHERE status = $1`, status).Scan(&n)
+	return n, err
+}
+
+// getMaturedBlocks returns matured blocks from minHeight to maxHeight.
+func (s *SQLBackend) getMaturedBlocks(minHeight, maxHeight int64) ([]*BlockData, error) {
+	return s.queryBlocks(BlocksMatured, ` AND height >= $2 AND height <= $3 ORDER BY height, nonce`, minHeight, maxHeight)
+}
+
+// getPayments returns payments of login, or of the whole pool when login is
+// empty, newest first. Only the first limit of them if limit is positive.
+func (s *SQLBackend) getPayments(login string, limit int64) ([]*Payment, error) {
+	query := `SELECT tx_hash, login, amount, paid_at FROM payments`
+	var args []interface{}
+	if len(login) > 0 {
+		args = append(args, login)
+		query += ` WHERE login = $1`
+	}
+	query += ` ORDER BY paid_at DESC, tx_hash DESC, login DESC`
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+	rows, err := s.db.QueryContext(s.ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*Payment
+	for rows.Next() {
+		payment, err := scanPayment(rows, len(login) == 0)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, payment)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLBackend) countPayments(login string) (int64, error) {
+	var n int64
+	var err error
+	if len(login) > 0 {
+		err = s.db.QueryRowContext(s.ctx, `SELECT COUNT(*) FROM payments WHERE login = $1`, login).Scan(&n)
+	} else {
+		err = s.db.QueryRowContext(s.ctx, `SELECT COUNT(*) FROM payments`).Scan(&n)
+	}
+	return n, err
+}
+
+// GetPayees returns every account holding or having held a balance.
+func (s *SQLBackend) GetPayees() ([]string, error) {
+	rows, err := s.db.QueryContext(s.ctx, `SELECT login FROM accounts ORDER BY login`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var login string
+		if err := rows.Scan(&login); err != nil {
+			return nil, err
+		}
+		result = append(result, login)
+	}
+	return result, rows.Err()
+}
+
+func (s *SQLBackend) GetBalance(login string) (int64, error) {
+	var balance int64
+	err := s.db.QueryRowContext(s.ctx, `SELECT balance FROM accounts WHERE login = $1`, login).Scan(&balance)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return balance, err
+}
+
+// getAccount returns the balance counters of login, zero if it has none.
+func (s *SQLBackend) getAccount(login string) (AccountCounters, error) {
+	var c AccountCounters
+	err := s.db.QueryRowContext(s.ctx, `SELECT balance, immature, pending, paid FROM accounts WHERE login = $1`, login).
+		Scan(&c.Balance, &c.Immature, &c.Pending, &c.Paid)
+	if err == sql.ErrNoRows {
+		return c, nil
+	}
+	return c, err
+}
+
+func (s *SQLBackend) GetPendingPayments() []*PendingPayment {
+	rows, err := s.db.QueryContext(s.ctx, `SELECT login, amount, created_at FROM pending_payments
+		ORDER BY created_at DESC, login DESC, amount DESC`)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var result []*PendingPayment
+	for rows.Next() {
+		payment := &PendingPayment{}
+		if err := rows.Scan(&payment.Address, &payment.Amount, &payment.Timestamp); err != nil {
+			return nil
+		}
+		result = append(result, payment)
+	}
+	return result
+}
+
+// GetLedger reads the financial records in a single transaction.
+func (s *SQLBackend) GetLedger() (*Ledger, error) {
+	l := &Ledger{Accounts: make(map[string]AccountCounters)}
+	err := s.inTx(func(tx *sql.Tx) error {
+		f := &l.Finances
+		err := tx.QueryRow(`SELECT balance, immature, pending, paid, pool_fee, donations, total_mined FROM finances WHERE id = 1`).
+			Scan(&f.Balance, &f.Immature, &f.Pending, &f.Paid, &f.PoolFee, &f.Donations, &f.TotalMined)
+		if err != nil {
+			return err
+		}
+		if err := tx.QueryRow(`SELECT COALESCE(SUM(amount), 0) FROM immature_credits`).Scan(&l.ImmatureCredits); err != nil {
+			return err
+		}
+		err = tx.QueryRow(`SELECT login, amount FROM payout_lock WHERE id = 1`).Scan(&l.LockLogin, &l.LockAmount)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		rows, err := tx.Query(`SELECT login, balance, immature, pending, paid FROM accounts`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var login string
+			var c AccountCounters
+			if err := rows.Scan(&login, &c.Balance, &c.Immature, &c.Pending, &c.Paid); err != nil {
+				return err
+			}
+			l.Accounts[login] = c
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows, err = tx.Query(`SELECT login, amount, created_at FROM pending_payments ORDER BY created_at DESC`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			payment := &PendingPayment{}
+			if err := rows.Scan(&payment.Address, &payment.Amount, &payment.Timestamp); err != nil {
+				return err
+			}
+			l.Pending = append(l.Pending, payment)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		rows, err = tx.Query(`SELECT b.height, b.hash, b.reward, COALESCE(SUM(c.amount), 0), COUNT(c.login)
+			FROM blocks b LEFT JOIN credits c ON c.height = b.height AND c.hash = b.hash AND c.kind = $1
+			WHERE b.status = $2 AND NOT b.orphan
+			GROUP BY b.height, b.hash, b.reward ORDER BY b.height`, creditReward, BlocksMatured)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			block := &LedgerBlock{}
+			var reward string
+			if err := rows.Scan(&block.Height, &block.Hash, &reward, &block.Credited, &block.Credits); err != nil {
+				return err
+			}
+			block.Reward = rewardInShannon(reward)
+			l.Blocks = append(l.Blocks, block)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (s *SQLBackend) LockPayouts(lock *PayoutsLock) error {
+	res, err := s.db.ExecContext(s.ctx, `INSERT INTO payout_lock (id, login, amount, owner, expires_at) VALUES (1, $1, $2, $3, $4)
+		ON CONFLICT (id) DO NOTHING`, lock.Login, lock.Amount, lock.Owner, lock.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("unable to acquire lock 'payout_lock'")
+	}
+	return nil
+}
+
+func (s *SQLBackend) SetPayoutsLockTx(lock *PayoutsLock, txHash string) error {
+	_, err := s.db.ExecContext(s.ctx, `INSERT INTO payout_lock (id, login, amount, tx_hash, owner, expires_at) VALUES (1, $1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET login = excluded.login, amount = excluded.amount, tx_hash = excluded.tx_hash,
+		owner = excluded.owner, expires_at = excluded.expires_at`,
+		lock.Login, lock.Amount, txHash, lock.Owner, lock.ExpiresAt)
+	return err
+}
+
+func (s *SQLBackend) GetPayoutsLock() (*PayoutsLock, error) {
+	lock := &PayoutsLock{}
+	err := s.db.QueryRowContext(s.ctx, `SELECT login, amount, tx_hash, owner, expires_at FROM payout_lock WHERE id = 1`).
+		Scan(&lock.Login, &lock.Amount, &lock.TxHash, &lock.Owner, &lock.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+func (s *SQLBackend) UnlockPayouts() error {
+	_, err := s.db.ExecContext(s.ctx, `DELETE FROM payout_lock`)
+	return err
+}
+
+func (s *SQLBackend) IsPayoutsLocked() (bool, error) {
+	lock, err := s.GetPayoutsLock()
+	return lock != nil, err
+}
+
+// Deduct miner's balance for payment
+func (s *SQLBackend) UpdateBalance(login string, amount int64) error {
+	ts := util.MakeTimestamp() / 1000
+
+	return s.inTx(func(tx *sql.Tx) error {
+		e := &sqlExec{tx: tx}
+		e.addAccount(login, "balance", amount*-1)
+		e.addAccount(login, "pending", amount)
+		e.addFinances("balance", amount*-1)
+		e.addFinances("pending", amount)
+		e.exec(`INSERT INTO pending_payments (login, amount, created_at) VALUES ($1, $2, $3)
+			ON CONFLICT (login, amount) DO UPDATE SET created_at = excluded.created_at`, login, amount, ts)
+		return e.err
+	})
+}
+
+func (s *SQLBackend) RollbackBalance(login string, amount int64) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		e := &sqlExec{tx: tx}
+		e.addAccount(login, "balance", amount)
+		e.addAccount(login, "pending", amount*-1)
+		e.addFinances("balance", amount)
+		e.addFinances("pending", amount*-1)
+		e.exec(`DELETE FROM pending_payments WHERE login = $1 AND amount = $2`, login, amount)
+		return e.err
+	})
+}
+
+func (s *SQLBackend) WritePayment(login, txHash string, amount int64) error {
+	ts := util.MakeTimestamp() / 1000
+
+	return s.inTx(func(tx *sql.Tx) error {
+		e := &sqlExec{tx: tx}
+		e.addAccount(login, "pending", amount*-1)
+		e.addAccount(login, "paid", amount)
+		e.addFinances("pending", amount*-1)
+		e.addFinances("paid", amount)
+		e.exec(`INSERT INTO payments (tx_hash, login, amount, paid_at) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (tx_hash, login) DO NOTHING`, txHash, login, amount, ts)
+		e.exec(`DELETE FROM pending_payments WHERE login = $1 AND amount = $2`, login, amount)
+		e.exec(`DELETE FROM payout_lock`)
+		return e.err
+	})
+}
+
+var _ interface {
+	BlockStore
+	BalanceStore
+} = (*SQLBackend)(nil)
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSQLMigrate(t *testing.T) {
+	cfg := &SQLConfig{Driver: "sqlite", DSN: filepath.Join(t.TempDir(), "ledger.db"), MaxOpenConns: 1}
+	ledger, err := NewSQLBackend(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger.UpdateBalance("x", 0)
+	ledger.Close()
+
+	ledger, err = NewSQLBackend(cfg)
+	if err != nil {
+		t.Fatalf("Must reopen migrated ledger: %v", err)
+	}
+	defer ledger.Close()
+	if err := ledger.Migrate(); err != nil {
+		t.Errorf("Migrate must be idempotent: %v", err)
+	}
+	if version, _ := ledger.SchemaVersion(); version != len(migrations) {
+		t.Errorf("Invalid schema version %v", version)
+	}
+	var rows int
+	ledger.db.QueryRow(`SELECT COUNT(*) FROM finances`).Scan(&rows)
+	if rows != 1 {
+		t.Errorf("Must keep a single finances row, got %v", rows)
+	}
+}
+
+func TestSQLPaymentsPage(t *testing.T) {
+	ledger := newTestLedger(t)
+	// Paid within the same second, so paging relies on the cursor skip
+	for _, login := range []string{"a", "b", "c"} {
+		ledger.UpdateBalance(login, 10)
+		ledger.WritePayment(login, "0x1", 10)
+	}
+
+	var logins []string
+	q := &PageQuery{Limit: 2}
+	for {
+		payments, cursor, err := ledger.GetPaymentsPage("", q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range payments {
+			logins = append(logins, p.Address)
+		}
+		if len(cursor) == 0 {
+			break
+		}
+		q.Cursor = cursor
+	}
+	if len(logins) != 3 || logins[0] != "c" || logins[2] != "a" {
+		t.Errorf("Must page through every payment once: %v", logins)
+	}
+}
+
+func TestSQLContext(t *testing.T) {
+	ledger := newTestLedger(t)
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := ledger.WithContext(cctx).GetBalance("x"); err != context.Canceled {
+		t.Errorf("Must cancel query along with context: %v", err)
+	}
+	if err := ledger.WithContext(cctx).UpdateBalance("x", 10); err != context.Canceled {
+		t.Errorf("Must cancel transaction along with context: %v", err)
+	}
+	if _, err := ledger.GetBalance("x"); err != nil {
+		t.Errorf("Must not cancel queries of the parent backend: %v", err)
+	}
+}
+
+func TestHybridHandOff(t *testing.T) {
+	reset()
+	r.SetRewardScheme(propScheme{})
+	h := NewHybridBackend(r, newTestLedger(t))
+
+	// Block writes stay on Redis until the unlocker picks them up
+	h.WriteBlock("x", "rig", []string{"0x2", "0xb", "1"}, 20, 500, 1009, time.Minute)
+	h.WriteSoloBlock("y", "rig", []string{"0x3", "0xc", "1"}, 30, 500, 1010, time.Minute)
+	if n, _ := r.client.ZCard(ctx, r.formatKey("blocks", "candidates")).Result(); n != 2 {
+		t.Error("Must keep candidates in Redis")
+	}
+	if shares, _ := h.ledger.GetRoundShares(1009, "0x2"); len(shares) != 0 {
+		t.Errorf("Must not write rounds to SQL on block submission: %v", shares)
+	}
+	stats, _ := h.CollectStats(time.Minute, 10, 10)
+	if stats.CandidatesTotal != 2 {
+		t.Errorf("Must list candidates waiting for hand-off: %+v", stats.Candidates)
+	}
+	candidates, err := h.GetCandidates(1010)
+	if err != nil || len(candidates) != 2 {
+		t.Fatalf("Must hand candidates off to SQL: %v %v", candidates, err)
+	}
+	if n, _ := r.client.ZCard(ctx, r.formatKey("blocks", "candidates")).Result(); n != 0 {
+		t.Error("Must drop handed off candidates from Redis")
+	}
+	if shares, _ := h.ledger.GetRoundShares(1009, "0x2"); shares["x"] != 20 {
+		t.Errorf("Must hand round off to SQL: %v", shares)
+	}
+	if n, _ := r.client.Exists(ctx, r.formatRound(1010, "0x3")).Result(); n > 0 {
+		t.Error("Must drop handed off round from Redis")
+	}
+}
@@ -0,0 +1,107 @@
+package storage
+
+import "strconv"
+
+// PoolCounters mirrors the pool "stats" hash.
+type PoolCounters struct {
+	LastBlockFound int64 `json:"lastBlockFound"`
+	RoundShares    int64 `json:"roundShares"`
+}
+
+// AccountCounters mirrors the "miners:<login>" hash, amounts in Shannon.
+type AccountCounters struct {
+	Balance     int64 `json:"balance"`
+	Immature    int64 `json:"immature"`
+	Pending     int64 `json:"pending"`
+	Paid        int64 `json:"paid"`
+	BlocksFound int64 `json:"blocksFound"`
+	LastShare   int64 `json:"lastShare"`
+}
+
+type Payment struct {
+	Tx string `json:"tx"`
+	// Only set on pool wide payment lists
+	Address   string `json:"address,omitempty"`
+	Amount    int64  `json:"amount"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type NodeState struct {
+	Name       string `json:"name"`
+	Height     string `json:"height"`
+	Difficulty string `json:"difficulty"`
+	LastBeat   string `json:"lastBeat"`
+	Blocktime  string `json:"blocktime"`
+}
+
+type PoolStats struct {
+	Stats           PoolCounters     `json:"stats"`
+	Candidates      []*BlockData     `json:"candidates"`
+	CandidatesTotal int64            `json:"candidatesTotal"`
+	Immature        []*BlockData     `json:"immature"`
+	ImmatureTotal   int64            `json:"immatureTotal"`
+	Matured         []*BlockData     `json:"matured"`
+	MaturedTotal    int64            `json:"maturedTotal"`
+	Payments        []*Payment       `json:"payments"`
+	PaymentsTotal   int64            `json:"paymentsTotal"`
+	Miners          map[string]Miner `json:"miners"`
+	MinersTotal     int              `json:"minersTotal"`
+	Hashrate        int64            `json:"hashrate"`
+}
+
+type MinerStats struct {
+	Stats         AccountCounters `json:"stats"`
+	Payments      []*Payment      `json:"payments"`
+	PaymentsTotal int64           `json:"paymentsTotal"`
+	RoundShares   int64           `json:"roundShares"`
+}
+
+type WorkersStats struct {
+	Workers         map[string]Worker `json:"workers"`
+	WorkersTotal    int               `json:"workersTotal"`
+	WorkersOnline   int64             `json:"workersOnline"`
+	WorkersOffline  int64             `json:"workersOffline"`
+	Hashrate        int64             `json:"hashrate"`
+	CurrentHashrate int64             `json:"currentHashrate"`
+	Finders         []*Finder         `json:"finders,omitempty"`
+}
+
+type WorkerStats struct {
+	CurrentHashrate int64 `json:"currentHashrate"`
+	Hashrate        int64 `json:"hashrate"`
+	LastBeat        int64 `json:"lastBeat"`
+	Offline         bool  `json:"offline"`
+	Valid           int64 `json:"valid"`
+	Stale           int64 `json:"stale"`
+	Invalid         int64 `json:"invalid"`
+	LastShare       int64 `json:"lastShare"`
+}
+
+type LuckStats struct {
+	Luck       float64 `json:"luck"`
+	UncleRate  float64 `json:"uncleRate"`
+	OrphanRate float64 `json:"orphanRate"`
+}
+
+func parseCounter(m map[string]string, key string) int64 {
+	n, _ := strconv.ParseInt(m[key], 10, 64)
+	return n
+}
+
+func convertPoolCounters(m map[string]string) PoolCounters {
+	return PoolCounters{
+		LastBlockFound: parseCounter(m, "lastBlockFound"),
+		RoundShares:    parseCounter(m, "roundShares"),
+	}
+}
+
+func convertAccountCounters(m map[string]string) AccountCounters {
+	return AccountCounters{
+		Balance:     parseCounter(m, "balance"),
+		Immature:    parseCounter(m, "immature"),
+		Pending:     parseCounter(m, "pending"),
+		Paid:        parseCounter(m, "paid"),
+		BlocksFound: parseCounter(m, "blocksFound"),
+		LastShare:   parseCounter(m, "lastShare"),
+	}
+}
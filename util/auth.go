@@ -0,0 +1,31 @@
+package util
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/dominant-strategies/go-quai/log"
+)
+
+// BearerAuth returns a middleware letting through requests sent with
+// "Authorization: Bearer <token>". An empty token is refused at startup,
+// it would let every request through.
+func BearerAuth(token string) func(http.Handler) http.Handler {
+	if len(token) == 0 {
+		log.Global.Fatal("Admin API requires a token")
+	}
+	expected := []byte("Bearer " + token)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(got, expected) != 1 {
+				w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+				w.Header().Set("Cache-Control", "no-cache")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"unauthorized"}` + "\n"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}